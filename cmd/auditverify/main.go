@@ -0,0 +1,75 @@
+// Package main реализует проверку целостности аудит-файла.
+//
+// # Назначение
+//
+// Каждая запись аудит-файла содержит порядковый номер seq, хеш предыдущей
+// записи prev_hash и собственный хеш hash. Утилита проходит файл от начала
+// до конца и сообщает о первом нарушенном звене: изменённой, удалённой или
+// вставленной записи.
+//
+// Ротированные сегменты файла (в том числе сжатые .gz) проверяются вместе
+// с текущим файлом как одна цепочка. После ротации, в том числе внешним
+// logrotate с SIGHUP, сервис начинает новый файл с якоря — имени, seq
+// и хеша последней записи предыдущего файла. Утилита находит этот файл
+// и проверяет, что якорь совпадает с его последней записью.
+//
+// Если предыдущий файл не найден, цепочка считается нарушенной. Когда
+// старые сегменты удалены по ретенции намеренно, передайте -allow-truncated:
+// проверка начнётся с самого старого из оставшихся файлов.
+//
+// Удаление записей с конца файла не обнаруживается: оставшаяся цепочка
+// цела. Чтобы заметить обрезку, сверяйте выведенные последний seq и хеш
+// с сохранёнными ранее.
+//
+// # Запуск
+//
+//	go run ./cmd/auditverify -f audit_storage.json
+//	go run ./cmd/auditverify -f audit_storage.json -allow-truncated
+//
+// Код возврата 0 — цепочка цела, 1 — цепочка нарушена, 2 — файл не удалось прочитать.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/config"
+)
+
+func main() {
+	path := flag.String("f", config.DefaultAuditFilePath, "audit file path")
+	allowTruncated := flag.Bool("allow-truncated", false, "allow the chain to start after removed segments")
+	flag.Parse()
+
+	files, truncated, err := audit.ChainFiles(*path, *allowTruncated)
+
+	var res audit.VerifyResult
+	if err == nil {
+		res, err = audit.VerifyChainFiles(files, truncated)
+	}
+
+	var chainErr *audit.ChainError
+	switch {
+	case errors.As(err, &chainErr):
+		fmt.Printf("FAIL %s: %v\n", *path, chainErr)
+		fmt.Printf("проверено записей до нарушения: %d\n", res.Records)
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "ошибка чтения %s: %v\n", *path, err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("OK %s: записей %d, последний seq %d, хеш %s\n", *path, res.Records, res.LastSeq, res.LastHash)
+	if len(files) > 1 {
+		fmt.Printf("файлов: %d, первый seq %d\n", len(files), res.FirstSeq)
+	}
+	if truncated {
+		fmt.Printf("начало цепочки не найдено, проверка начата с seq %d (-allow-truncated)\n", res.FirstSeq)
+	}
+	if res.Legacy > 0 {
+		fmt.Printf("записей без цепочки в начале файла: %d\n", res.Legacy)
+	}
+}
@@ -3,37 +3,79 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"time"
 
 	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/health"
 	"github.com/Popolzen/shortener/internal/repository"
+	"go.uber.org/zap"
 )
 
+// tracingShutdownTimeout время на отправку оставшихся span'ов при остановке
+const tracingShutdownTimeout = 5 * time.Second
+
 type App struct {
 	server    *http.Server
 	repo      repository.URLRepository
 	publisher *audit.Publisher
+	health    *health.Checker
+
+	// shutdownDelay пауза после снятия готовности, чтобы балансировщик
+	// успел перестать направлять трафик до остановки сервера
+	shutdownDelay time.Duration
+
+	// stopTracing отправляет накопленные span'ы, может быть nil
+	stopTracing func(context.Context) error
 }
 
 // Close закрывает все ресурсы
 func (a *App) Close() error {
-	log.Println("Закрываем репозиторий...")
-	if err := a.repo.Close(); err != nil {
-		log.Printf("Ошибка закрытия репозитория: %v", err)
+	// Сначала дожидаемся фоновых удалений, чтобы их события попали в аудит,
+	// затем закрываем аудит, пока соединение с БД ещё открыто
+	if s, ok := a.repo.(interface{ Shutdown() }); ok {
+		zap.S().Info("Останавливаем фоновые задачи репозитория...")
+		s.Shutdown()
 	}
 
-	log.Println("Закрываем audit publisher...")
+	zap.S().Info("Закрываем audit publisher...")
 	if err := a.publisher.Close(); err != nil {
-		log.Printf("Ошибка закрытия publisher: %v", err)
+		zap.S().Errorf("Ошибка закрытия publisher: %v", err)
+	}
+
+	zap.S().Info("Закрываем репозиторий...")
+	if err := a.repo.Close(); err != nil {
+		zap.S().Errorf("Ошибка закрытия репозитория: %v", err)
+	}
+
+	// Трассировка останавливается последней, чтобы отправить span'ы
+	// фоновых удалений и доставок аудита
+	if a.stopTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := a.stopTracing(ctx); err != nil {
+			zap.S().Errorf("Ошибка остановки трассировки: %v", err)
+		}
 	}
 
 	return nil
 }
 
-// Shutdown выполняет graceful shutdown с таймаутом
+// Shutdown выполняет graceful shutdown с таймаутом.
+// Сначала сервис перестаёт быть готовым, затем останавливается сервер.
 func (a *App) Shutdown(ctx context.Context) error {
-	log.Println("Останавливаем HTTP сервер...")
+	if a.health != nil {
+		a.health.SetShuttingDown()
+	}
+	if a.shutdownDelay > 0 {
+		zap.S().Warnf("Готовность снята, ждём %s перед остановкой сервера...", a.shutdownDelay)
+		select {
+		case <-time.After(a.shutdownDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	zap.S().Info("Останавливаем HTTP сервер...")
 	if err := a.server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("ошибка остановки сервера: %w", err)
 	}
@@ -15,16 +15,23 @@ import (
 	"github.com/Popolzen/shortener/internal/config"
 	"github.com/Popolzen/shortener/internal/db"
 	"github.com/Popolzen/shortener/internal/handler"
+	"github.com/Popolzen/shortener/internal/health"
 	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
 	"github.com/Popolzen/shortener/internal/middleware/compressor"
 	"github.com/Popolzen/shortener/internal/middleware/logger"
+	"github.com/Popolzen/shortener/internal/middleware/ratelimit"
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
 	"github.com/Popolzen/shortener/internal/middleware/subnet"
+	"github.com/Popolzen/shortener/internal/middleware/tracing"
 	"github.com/Popolzen/shortener/internal/repository"
 	"github.com/Popolzen/shortener/internal/repository/database"
 	"github.com/Popolzen/shortener/internal/repository/filestorage"
 	"github.com/Popolzen/shortener/internal/repository/memory"
 	"github.com/Popolzen/shortener/internal/service/shortener"
+	"github.com/Popolzen/shortener/internal/telemetry"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 var (
@@ -36,33 +43,59 @@ var (
 func main() {
 	printBuildInfo()
 
+	gin.SetMode(gin.ReleaseMode)
+	cfg := config.NewConfig()
+
 	// Инициализируем логгер
-	if err := logger.Init(); err != nil {
+	if err := logger.Init(logger.Options{
+		Level:              cfg.LogLevel,
+		Encoding:           cfg.LogEncoding,
+		Output:             cfg.LogOutput,
+		SamplingInitial:    cfg.LogSamplingInitial,
+		SamplingThereafter: cfg.LogSamplingThereafter,
+	}); err != nil {
 		log.Fatal("Не удалось инициализировать логгер:", err)
 	}
 	defer logger.Close()
 
-	gin.SetMode(gin.ReleaseMode)
-	cfg := config.NewConfig()
+	stopTracing, err := telemetry.Init(telemetry.Options{
+		Exporter:    cfg.TracingExporter,
+		FilePath:    cfg.TracingFile,
+		ServiceName: cfg.TracingServiceName,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		zap.S().Fatalf("Не удалось инициализировать трассировку: %v", err)
+	}
 	dbCfg := db.NewDBConfig(*cfg)
 
 	// Pprof сервер
 	if cfg.PprofAddr != "" {
 		go func() {
-			log.Printf("pprof сервер запущен на http://%s/debug/pprof/", cfg.PprofAddr)
+			zap.S().Infof("pprof сервер запущен на http://%s/debug/pprof/", cfg.PprofAddr)
 			if err := http.ListenAndServe(cfg.PprofAddr, nil); err != nil {
-				log.Printf("Ошибка запуска pprof сервера: %v", err)
+				zap.S().Errorf("Ошибка запуска pprof сервера: %v", err)
 			}
 		}()
 	}
 
+	publisher, auditQuerier := initAudit(cfg)
+	repo, dbAuditQuerier := initRepository(cfg, dbCfg, publisher)
 	app := &App{
-		publisher: initAudit(cfg),
-		repo:      initRepository(cfg, dbCfg),
+		publisher:     publisher,
+		repo:          repo,
+		health:        initHealth(cfg, repo, publisher),
+		shutdownDelay: time.Duration(cfg.ShutdownDelay) * time.Second,
+		stopTracing:   stopTracing,
+	}
+	// Выборка аудита идёт из БД, если она есть, иначе из файла
+	if dbAuditQuerier != nil {
+		auditQuerier = dbAuditQuerier
 	}
 
-	shortener := shortener.NewURLService(app.repo)
-	r := setupRouter(shortener, cfg, dbCfg, app.publisher)
+	quotas := shortener.NewQuotas(cfg.DefaultQuota(), cfg.QuotaOverrides)
+	shortener := shortener.NewURLServiceWithQuotas(app.repo, quotas)
+	r := setupRouter(shortener, cfg, app.health, repoPing(repo), app.publisher, auditQuerier)
 
 	app.server = &http.Server{
 		Addr:    cfg.GetAddress(),
@@ -73,15 +106,15 @@ func main() {
 	go func() {
 		var err error
 		if cfg.EnableHTTPS {
-			log.Printf("URL Shortener запущен на https://%s (HTTPS)", cfg.GetAddress())
+			zap.S().Infof("URL Shortener запущен на https://%s (HTTPS)", cfg.GetAddress())
 			err = app.server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
 		} else {
-			log.Printf("URL Shortener запущен на http://%s", cfg.GetAddress())
+			zap.S().Infof("URL Shortener запущен на http://%s", cfg.GetAddress())
 			err = app.server.ListenAndServe()
 		}
 
 		if err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Ошибка запуска сервера: %v", err)
+			zap.S().Fatalf("Ошибка запуска сервера: %v", err)
 		}
 	}()
 
@@ -93,19 +126,19 @@ func gracefulShutdown(app *App) {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
 	sig := <-quit
-	log.Printf("Получен сигнал %v, начинаем graceful shutdown...", sig)
+	zap.S().Infof("Получен сигнал %v, начинаем graceful shutdown...", sig)
 
 	// Контекст с таймаутом для завершения запросов
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := app.Shutdown(ctx); err != nil {
-		log.Printf("Ошибка при shutdown: %v", err)
+		zap.S().Errorf("Ошибка при shutdown: %v", err)
 	}
 	if err := app.Close(); err != nil {
-		log.Printf("Ошибка при закрытии репозитория и аудита: %v", err)
+		zap.S().Errorf("Ошибка при закрытии репозитория и аудита: %v", err)
 	}
-	log.Println("Сервис успешно остановлен")
+	zap.S().Info("Сервис успешно остановлен")
 }
 
 func printBuildInfo() {
@@ -128,9 +161,15 @@ func printBuildInfo() {
 	fmt.Printf("Build commit: %s\n", commit)
 }
 
-// initRepository инициализирует репозиторий в зависимости от конфигурации
-func initRepository(cfg *config.Config, dbCfg db.DBConfig) repository.URLRepository {
-	var repo repository.URLRepository
+// initRepository инициализирует репозиторий в зависимости от конфигурации.
+// При работе с БД аудит также пишется в таблицу audit_events, события
+// изменения ссылок проходят через outbox в транзакции с самим изменением,
+// и вторым значением возвращается выборка из audit_events.
+func initRepository(cfg *config.Config, dbCfg db.DBConfig, auditPub *audit.Publisher) (repository.URLRepository, audit.Querier) {
+	var (
+		repo    repository.URLRepository
+		querier audit.Querier
+	)
 
 	// dbCfg.DBurl = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 	// 	`localhost`, 5432, `postgres`, `123456`, `shortener`)
@@ -139,71 +178,237 @@ func initRepository(cfg *config.Config, dbCfg db.DBConfig) repository.URLReposit
 	case dbCfg.DBurl != "":
 		dbInstance, err := db.NewDataBase(*cfg, dbCfg)
 		if err != nil {
-			log.Fatal("Ошибка подключения к БД:", err)
+			zap.S().Fatalf("Ошибка подключения к БД: %v", err)
 		}
 		if err := dbInstance.Migrate(); err != nil {
-			log.Fatal("Ошибка выполнения миграций:", err)
+			zap.S().Fatalf("Ошибка выполнения миграций: %v", err)
 		}
-		repo = database.NewURLRepository(dbInstance.DB)
+		repo = database.NewURLRepositoryWithAudit(dbInstance.DB, auditPub)
 
-		log.Println("Используется БД репозиторий")
+		auditObs := database.NewAuditObserver(dbInstance.DB)
+		auditPub.Subscribe(auditObs)
+		querier = auditObs
+
+		zap.S().Info("Используется БД репозиторий")
 	case cfg.GetFilePath() != "":
 		repo = filestorage.NewURLRepository(cfg.GetFilePath())
-		log.Println("Используется файл")
+		zap.S().Info("Используется файл")
 	default:
 		repo = memory.NewURLRepository()
-		log.Println("Используется память")
+		zap.S().Info("Используется память")
 	}
 
-	return repo
+	return repo, querier
 }
 
-func initAudit(cfg *config.Config) *audit.Publisher {
-	publisher := audit.NewPublisher()
+// deleteQueueThreshold заполненность очереди удаления, при которой сервис не готов
+const deleteQueueThreshold = 0.9
+
+// initHealth регистрирует проверки готовности компонентов: хранилища,
+// очереди удаления, каталога файлового хранилища и наблюдателей аудита
+func initHealth(cfg *config.Config, repo repository.URLRepository, auditPub *audit.Publisher) *health.Checker {
+	checker := health.NewChecker(time.Duration(cfg.HealthTimeout) * time.Second)
+
+	if ping := repoPing(repo); ping != nil {
+		checker.Register("repository", ping)
+	}
+	if q, ok := repo.(interface{ DeleteQueueLoad() (int, int) }); ok {
+		checker.Register("delete_queue", health.QueueCheck(q.DeleteQueueLoad, deleteQueueThreshold))
+	}
+	if w, ok := repo.(interface{ CheckWritable() error }); ok {
+		checker.Register("filestorage", func(context.Context) error { return w.CheckWritable() })
+	}
+	checker.RegisterGroup(auditPub.Health)
+
+	return checker
+}
+
+// repoPing возвращает проверку соединения репозитория, nil — если он без БД
+func repoPing(repo repository.URLRepository) health.CheckFunc {
+	if p, ok := repo.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping
+	}
+	return nil
+}
+
+// initAudit создаёт publisher с наблюдателями из конфигурации.
+// Если аудит пишется в файл, вторым значением возвращается выборка из него.
+func initAudit(cfg *config.Config) (*audit.Publisher, audit.Querier) {
+	var querier audit.Querier
+
+	overflow, err := audit.ParseOverflowPolicy(cfg.AuditOverflow)
+	if err != nil {
+		zap.S().Warnf("%v, используется %s", err, audit.OverflowDropNew)
+		overflow = audit.OverflowDropNew
+	}
+	publisher := audit.NewPublisherWithOptions(audit.QueueOptions{
+		Size:         cfg.AuditQueueSize,
+		Overflow:     overflow,
+		CloseTimeout: time.Duration(cfg.AuditCloseTimeout) * time.Second,
+	})
 
 	// Файловый observer
 	if cfg.GetAuditFile() != "" {
-		fileObs, err := audit.NewFileObserver(cfg.GetAuditFile())
+		fileObs, err := audit.NewFileObserverWithOptions(cfg.GetAuditFile(), audit.FileOptions{
+			MaxSize:    int64(cfg.AuditMaxSizeMB) << 20,
+			Daily:      cfg.AuditRotateDaily,
+			Compress:   cfg.AuditCompress,
+			MaxBackups: cfg.AuditMaxBackups,
+			Serializer: auditSerializer(cfg, cfg.AuditFileFormat),
+		})
 		if err != nil {
-			log.Printf("Не удалось создать file observer: %v", err)
+			zap.S().Errorf("Не удалось создать file observer: %v", err)
 		} else {
-			publisher.Subscribe(fileObs)
-			log.Printf("Аудит в файл: %s", cfg.GetAuditFile())
+			publisher.SubscribeWithRules(fileObs, auditRules(cfg.GetAuditFile(), cfg.AuditFileFilter))
+			reopenOnSIGHUP(fileObs)
+			querier = fileObs
+			zap.S().Infof("Аудит в файл: %s", cfg.GetAuditFile())
 		}
 	}
 
-	// HTTP observer
-	if cfg.GetAuditURL() != "" {
-		httpObs := audit.NewHTTPObserver(cfg.GetAuditURL())
-		publisher.Subscribe(httpObs)
-		log.Printf("Аудит на сервер: %s", cfg.GetAuditURL())
+	// HTTP observers
+	for i, url := range cfg.GetAuditURLs() {
+		// У каждого адреса свой dead-letter файл
+		deadLetter := cfg.AuditDeadLetter
+		if deadLetter != "" && i > 0 {
+			deadLetter = fmt.Sprintf("%s.%d", deadLetter, i)
+		}
+		httpObs := audit.NewHTTPObserverWithOptions(url, audit.HTTPOptions{
+			BatchSize:      cfg.AuditBatchSize,
+			MaxRetries:     cfg.AuditMaxRetries,
+			DeadLetterPath: deadLetter,
+			Secret:         []byte(cfg.AuditSecret),
+			Serializer:     auditSerializer(cfg, cfg.AuditHTTPFormat),
+		})
+		publisher.SubscribeWithRules(httpObs, auditRules(url, cfg.AuditFilterFor(url)))
+		zap.S().Infof("Аудит на сервер: %s", url)
 	}
 
-	return publisher
+	return publisher, querier
+}
+
+// auditRules компилирует правила наблюдателя. Некорректные правила
+// отключаются целиком, чтобы не потерять события из-за ошибки в конфигурации.
+func auditRules(sink string, f audit.Filter) *audit.Rules {
+	rules, err := audit.NewRules(f)
+	if err != nil {
+		zap.S().Warnf("Правила аудита для %s не применены: %v", sink, err)
+		return nil
+	}
+	return rules
+}
+
+// auditSerializer возвращает сериализатор аудита для формата из конфигурации
+func auditSerializer(cfg *config.Config, format string) audit.Serializer {
+	f, err := audit.ParseFormat(format)
+	if err != nil {
+		zap.S().Warnf("%v, используется %s", err, audit.FormatLegacy)
+		f = audit.FormatLegacy
+	}
+	source := cfg.AuditSource
+	if source == "" {
+		source = cfg.BaseURL
+	}
+	return audit.NewSerializer(f, source)
+}
+
+// reopenOnSIGHUP переоткрывает аудит-файл по SIGHUP после внешнего logrotate
+func reopenOnSIGHUP(fileObs *audit.FileObserver) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := fileObs.Reopen(); err != nil {
+				zap.S().Errorf("Не удалось переоткрыть аудит-файл: %v", err)
+				continue
+			}
+			zap.S().Info("Аудит-файл переоткрыт")
+		}
+	}()
 }
 
 // setupRouter настраивает роуты и middleware
-func setupRouter(shortener shortener.URLService, cfg *config.Config, dbCfg db.DBConfig, auditPub *audit.Publisher) *gin.Engine {
+func setupRouter(shortener shortener.URLService, cfg *config.Config, checker *health.Checker, ping health.CheckFunc, auditPub *audit.Publisher, auditQuerier audit.Querier) *gin.Engine {
+
+	// Вместо логгера и recovery gin — общий zap-логгер
+	r := gin.New()
+	// Адрес клиента определяет clientip, встроенному разбору заголовков gin не доверяем
+	r.SetTrustedProxies(nil)
+	r.Use(requestid.Middleware())
+	r.Use(logger.Recovery())
+	r.Use(clientip.Middleware(newClientIPResolver(cfg)))
+
+	// Пробы оркестратора не логируем и не ограничиваем
+	r.GET("/healthz", handler.LivenessHandler(checker))
+	r.GET("/readyz", handler.ReadinessHandler(checker))
 
-	r := gin.Default()
+	r.Use(logger.RequestLogger())
+	r.Use(tracing.Middleware())
 
 	internal := r.Group("/api/internal")
-	internal.Use(subnet.TrustedSubnetMiddleware(cfg.TrustedSubnet))
+	internal.Use(subnet.TrustedSubnetMiddleware(cfg.TrustedSubnets()))
 	{
-		internal.GET("/stats", handler.StatsHandler(shortener))
+		internal.GET("/stats", handler.StatsHandler(shortener, auditPub))
+		internal.GET("/audit", handler.AuditQueryHandler(auditQuerier))
+		internal.GET("/quotas/:user_id", handler.GetQuotaHandler(shortener))
+		internal.PUT("/quotas/:user_id", handler.SetQuotaHandler(shortener))
+		internal.DELETE("/quotas/:user_id", handler.DeleteQuotaHandler(shortener))
+		internal.GET("/log/level", logger.LevelHandler())
+		internal.PUT("/log/level", logger.LevelHandler())
 	}
 
-	r.Use(logger.RequestLogger())
-	r.Use(compressor.Compresser())
-	r.Use(auth.AuthMiddleware(cfg))
+	r.Use(compressor.CompresserWithOptions(compressor.Options{
+		MinSize:             cfg.CompressionMinSize,
+		ContentTypes:        cfg.CompressionTypes,
+		MaxDecompressedSize: cfg.DecompressionMaxBytes,
+	}))
+
+	limiter := newRateLimiter(cfg)
+	limit := func(class ratelimit.Class) gin.HandlerFunc {
+		return ratelimit.Middleware(limiter, class)
+	}
+
+	// Редирект не создаёт новых пользователей и не выставляет куку сессии.
+	// Ссылки с паролем пускает gate, пароль из формы принимает POST /{id}.
+	gate := handler.NewLinkGate(cfg, limiter)
+	r.GET("/:id", auth.OptionalAuthMiddleware(cfg), limit(ratelimit.ClassRedirect), handler.GetHandler(shortener, auditPub, gate))
+	r.POST("/:id", limit(ratelimit.ClassRedirect), handler.UnlockLinkHandler(shortener, gate))
 
-	r.POST("/", handler.PostHandler(shortener, cfg, auditPub))
-	r.POST("/api/shorten", handler.PostHandlerJSON(shortener, cfg, auditPub))
-	r.POST("/api/shorten/batch", handler.BatchHandler(shortener, cfg))
-	r.GET("/:id", handler.GetHandler(shortener, auditPub))
-	r.GET("/api/user/urls", handler.GetUserURLsHandler(shortener, cfg))
-	r.DELETE("/api/user/urls", handler.DeleteURLsHandler(shortener))
-	r.GET("/ping", handler.PingHandler(dbCfg))
+	authed := r.Group("/")
+	authed.Use(auth.AuthMiddleware(cfg))
+	{
+		authed.POST("/", limit(ratelimit.ClassShorten), handler.PostHandler(shortener, cfg, auditPub))
+		authed.POST("/api/shorten", limit(ratelimit.ClassShorten), handler.PostHandlerJSON(shortener, cfg, auditPub))
+		authed.POST("/api/shorten/batch", limit(ratelimit.ClassBatch), handler.BatchHandler(shortener, cfg, auditPub))
+		authed.GET("/api/user/urls", handler.GetUserURLsHandler(shortener, cfg))
+		authed.DELETE("/api/user/urls", limit(ratelimit.ClassDelete), handler.DeleteURLsHandler(shortener, auditPub))
+		authed.PATCH("/api/user/urls/:id", handler.UpdateLinkHandler(shortener, cfg, auditPub))
+		authed.GET("/api/user/urls/:id/history", handler.LinkHistoryHandler(shortener))
+		authed.GET("/api/user/tags", handler.GetUserTagsHandler(shortener))
+	}
+	r.GET("/ping", handler.PingHandler(ping))
 
 	return r
 }
+
+// newRateLimiter создаёт ограничитель частоты запросов по бюджетам из конфигурации
+func newRateLimiter(cfg *config.Config) *ratelimit.Limiter {
+	return ratelimit.NewLimiter(map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.ClassShorten:  {Rate: cfg.RateLimitShorten, Burst: cfg.RateLimitShortenBurst},
+		ratelimit.ClassBatch:    {Rate: cfg.RateLimitBatch, Burst: cfg.RateLimitBatchBurst},
+		ratelimit.ClassRedirect: {Rate: cfg.RateLimitRedirect, Burst: cfg.RateLimitRedirectBurst},
+		ratelimit.ClassDelete:   {Rate: cfg.RateLimitDelete, Burst: cfg.RateLimitDeleteBurst},
+		ratelimit.ClassPassword: {Rate: cfg.RateLimitPassword, Burst: cfg.RateLimitPasswordBurst},
+	}, cfg.RateLimitMaxKeys)
+}
+
+// newClientIPResolver создаёт резолвер адреса клиента. При ошибке в списке
+// доверенных прокси заголовкам не доверяем совсем.
+func newClientIPResolver(cfg *config.Config) *clientip.Resolver {
+	resolver, err := clientip.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		zap.S().Warnf("%v, X-Forwarded-For и X-Real-IP игнорируются", err)
+		resolver, _ = clientip.NewResolver(nil)
+	}
+	return resolver
+}
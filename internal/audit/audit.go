@@ -1,24 +1,72 @@
+// Package audit публикует события аудита наблюдателям: в файл и на удалённый сервер.
+//
+// # Подпись доставок
+//
+// Если HTTPObserver настроен с общим секретом, каждый POST подписывается
+// HMAC-SHA256 от строки "<timestamp>.<body>", где timestamp — unix-время
+// в секундах, а body — тело запроса без изменений. Время передаётся
+// в заголовке X-Audit-Timestamp, подпись — в заголовке X-Audit-Signature
+// в виде "sha256=<hex>". Повторные попытки подписываются заново.
+//
+// Получатель пересчитывает подпись тем же секретом, сравнивает её
+// за постоянное время и отклоняет доставки, время которых отличается
+// от текущего больше чем на окно допуска, — это защищает от повторов.
+// Готовая проверка доступна в Verifier.
+//
+// # Форматы
+//
+// По умолчанию события пишутся как есть (FormatLegacy). FileObserver
+// и HTTPObserver также поддерживают конверт CloudEvents 1.0:
+// структурированный режим, где батч отправляется как
+// application/cloudevents-batch+json, и бинарный режим только для HTTP,
+// где каждое событие отправляется отдельным запросом с атрибутами
+// в заголовках ce-*. Атрибут id берётся из Event.ID и не меняется при
+// повторных доставках, type задаётся EventType по действию.
 package audit
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Action тип действия аудита
 type Action string
 
 const (
-	ActionShorten Action = "shorten"
-	ActionFollow  Action = "follow"
+	ActionShorten         Action = "shorten"
+	ActionFollow          Action = "follow"
+	ActionBatchShorten    Action = "batch_shorten"    // ссылка создана пакетным запросом
+	ActionDeleteRequested Action = "delete_requested" // пользователь запросил удаление
+	ActionDeleteApplied   Action = "delete_applied"   // удаление фактически применено в хранилище
+	ActionUpdate          Action = "update"           // владелец изменил ссылку
+	ActionStatsRead       Action = "stats_read"       // чтение внутренней статистики
 )
 
-// Event структура события аудита
+// Event структура события аудита.
+//
+// Поля, добавленные после ts/action/user_id/url, необязательны и опускаются
+// в JSON, если пусты, поэтому существующие потребители не ломаются.
 type Event struct {
+	ID        string `json:"id,omitempty"` // назначается Publisher и не меняется при повторах
 	Timestamp int64  `json:"ts"`
 	Action    Action `json:"action"`
 	UserID    string `json:"user_id,omitempty"`
 	URL       string `json:"url"`
+	ShortCode string `json:"short_code,omitempty"`
+	ClientIP  string `json:"client_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// SpanContext span запроса, породившего событие. Не сериализуется,
+	// поэтому у событий из outbox и dead-letter его нет.
+	SpanContext trace.SpanContext `json:"-"`
 }
 
 // NewEvent создаёт новое событие аудита
@@ -31,43 +79,277 @@ func NewEvent(action Action, userID, url string) Event {
 	}
 }
 
+// TraceContext возвращает контекст со span'ом запроса, породившего событие,
+// чтобы span доставки попал в трассу запроса
+func (e Event) TraceContext() context.Context {
+	return trace.ContextWithSpanContext(context.Background(), e.SpanContext)
+}
+
+// eventLinks возвращает ссылки на различные span'ы запросов событий
+func eventLinks(events []Event) []trace.Link {
+	links := make([]trace.Link, 0, 1)
+	seen := make(map[trace.SpanID]bool, 1)
+	for _, e := range events {
+		if !e.SpanContext.IsValid() || seen[e.SpanContext.SpanID()] {
+			continue
+		}
+		seen[e.SpanContext.SpanID()] = true
+		links = append(links, trace.Link{SpanContext: e.SpanContext})
+	}
+	return links
+}
+
+// Observer получатель событий аудита
 type Observer interface {
 	Notify(event Event)
 	Close() error
 }
 
+// Deliverer наблюдатель, подтверждающий доставку событий.
+// DeliverBatch возвращает управление, когда события сохранены или
+// отправлены, и число событий с начала батча, доставку которых
+// удалось подтвердить, а при неполной доставке — ошибку.
+type Deliverer interface {
+	DeliverBatch(ctx context.Context, events []Event) (int, error)
+}
+
+// ErrPublisherClosed издатель закрыт и событий не принимает
+var ErrPublisherClosed = errors.New("audit: издатель закрыт")
+
+const (
+	// DefaultQueueSize размер очереди наблюдателя по умолчанию
+	DefaultQueueSize = 1024
+	// DefaultCloseTimeout время на доставку оставшихся событий при закрытии
+	DefaultCloseTimeout = 5 * time.Second
+)
+
+// QueueOptions параметры очередей наблюдателей
+type QueueOptions struct {
+	Size         int
+	Overflow     OverflowPolicy
+	CloseTimeout time.Duration
+}
+
+// Publisher рассылает события наблюдателям.
+//
+// Каждый наблюдатель получает собственную ограниченную очередь и воркер,
+// поэтому медленный наблюдатель не задерживает запросы и других наблюдателей.
 type Publisher struct {
-	mu          sync.Mutex
-	subscribers []Observer
+	mu     sync.RWMutex
+	queues []*observerQueue
+	opts   QueueOptions
+	closed atomic.Bool
+	// closing закрывается в начале Close и освобождает издателей,
+	// заблокированных на полной очереди при OverflowBlock
+	closing chan struct{}
 }
 
+// NewPublisher создаёт издателя с параметрами очередей по умолчанию
 func NewPublisher() *Publisher {
-	return &Publisher{}
+	return NewPublisherWithOptions(QueueOptions{})
+}
+
+// NewPublisherWithOptions создаёт издателя с заданными параметрами очередей
+func NewPublisherWithOptions(opts QueueOptions) *Publisher {
+	if opts.Size <= 0 {
+		opts.Size = DefaultQueueSize
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = OverflowDropNew
+	}
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = DefaultCloseTimeout
+	}
+	return &Publisher{opts: opts, closing: make(chan struct{})}
 }
 
+// Subscribe подписывает наблюдателя и запускает для него воркер
 func (p *Publisher) Subscribe(o Observer) {
+	p.SubscribeWithRules(o, nil)
+}
+
+// SubscribeWithRules подписывает наблюдателя, который получает только
+// события, прошедшие rules. Правила применяются до постановки в очередь.
+func (p *Publisher) SubscribeWithRules(o Observer, rules *Rules) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.subscribers = append(p.subscribers, o)
+	q := newObserverQueue(o, rules, p.opts.Size, p.opts.Overflow)
+	q.name = p.uniqueName(observerName(o))
+	p.queues = append(p.queues, q)
+}
+
+// observerName имя наблюдателя: String(), если наблюдатель его
+// реализует, иначе тип
+func observerName(o Observer) string {
+	if s, ok := o.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", o)
+}
+
+// uniqueName добавляет к имени номер, если оно уже занято.
+// Вызывается под p.mu.
+func (p *Publisher) uniqueName(name string) string {
+	unique := name
+	for n := 2; p.queue(unique) != nil; n++ {
+		unique = fmt.Sprintf("%s#%d", name, n)
+	}
+	return unique
+}
+
+// queue возвращает очередь наблюдателя с именем name. Вызывается под p.mu.
+func (p *Publisher) queue(name string) *observerQueue {
+	for _, q := range p.queues {
+		if q.name == name {
+			return q
+		}
+	}
+	return nil
 }
 
+// Publish ставит событие в очереди всех наблюдателей, не дожидаясь доставки.
+// Событию без ID назначается случайный UUID, общий для всех наблюдателей,
+// чтобы получатели могли отбрасывать повторные доставки.
 func (p *Publisher) Publish(event Event) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	for _, s := range p.subscribers {
-		s.Notify(event)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed.Load() {
+		return
+	}
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	for _, q := range p.queues {
+		q.enqueue(event, p.closing)
 	}
 }
 
-// Close закрывает всех наблюдателей
+// Sinks возвращает имена наблюдателей в порядке подписки.
+// Имя берётся из String() наблюдателя и не меняется между запусками
+// с той же конфигурацией, поэтому outbox relay хранит по нему,
+// каким наблюдателям событие уже доставлено.
+func (p *Publisher) Sinks() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	names := make([]string, 0, len(p.queues))
+	for _, q := range p.queues {
+		names = append(names, q.name)
+	}
+	return names
+}
+
+// DeliverTo доставляет батч наблюдателю sink синхронно, минуя очередь,
+// и возвращает число событий с начала батча, доставку которых он
+// подтвердил. Используется outbox relay: строка outbox помечается
+// отправленной, когда событие подтвердили все наблюдатели, а при
+// повторе его получают только те, кто ещё не подтвердил.
+//
+// События, не прошедшие правила наблюдателя, считаются доставленными.
+// Наблюдатель без Deliverer получает события через очередь, как при
+// Publish, и они считаются доставленными сразу. ID событиям не
+// назначается: outbox хранит их уже с ID.
+func (p *Publisher) DeliverTo(ctx context.Context, sink string, events []Event) (int, error) {
+	p.mu.RLock()
+	if p.closed.Load() {
+		p.mu.RUnlock()
+		return 0, ErrPublisherClosed
+	}
+	q := p.queue(sink)
+	if q == nil {
+		p.mu.RUnlock()
+		return 0, fmt.Errorf("audit: наблюдатель %q не подписан", sink)
+	}
+	deliverer, ok := q.observer.(Deliverer)
+	if !ok {
+		for _, event := range events {
+			q.enqueue(event, p.closing)
+		}
+		p.mu.RUnlock()
+		return len(events), nil
+	}
+	// Доставка может ждать повторов: блокировка не должна задерживать Close
+	p.mu.RUnlock()
+
+	allowed := make([]Event, 0, len(events))
+	index := make([]int, 0, len(events)) // позиция разрешённого события в events
+	for i, event := range events {
+		if !q.rules.Allow(event) {
+			q.filtered.Add(1)
+			continue
+		}
+		allowed = append(allowed, event)
+		index = append(index, i)
+	}
+	if len(allowed) == 0 {
+		return len(events), nil
+	}
+
+	n, err := deliverer.DeliverBatch(ctx, allowed)
+	if n >= len(allowed) {
+		return len(events), nil
+	}
+	if err == nil {
+		err = fmt.Errorf("подтверждено %d из %d событий", n, len(allowed))
+	}
+	// Отфильтрованные события до первого недоставленного тоже доставлены
+	return index[n], fmt.Errorf("audit: %s: %w", sink, err)
+}
+
+// Stats возвращает состояние очередей наблюдателей
+func (p *Publisher) Stats() []QueueStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	stats := make([]QueueStats, 0, len(p.queues))
+	for _, q := range p.queues {
+		stats = append(stats, q.stats())
+	}
+	return stats
+}
+
+// Close дожидается доставки оставшихся событий в пределах CloseTimeout
+// и закрывает всех наблюдателей. Повторный вызов ничего не делает.
 func (p *Publisher) Close() error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	// Издатели, ждущие места в очереди, держат RLock: сначала отпускаем их,
+	// иначе Close ждал бы блокировку дольше CloseTimeout
+	close(p.closing)
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for _, obs := range p.subscribers {
-		if err := obs.Close(); err != nil {
-			return err
+
+	for _, q := range p.queues {
+		close(q.events)
+	}
+
+	var errs []error
+	deadline := time.NewTimer(p.opts.CloseTimeout)
+	defer deadline.Stop()
+	expired := false
+	for _, q := range p.queues {
+		// Дедлайн общий на все очереди
+		if !expired {
+			select {
+			case <-q.done:
+				continue
+			case <-deadline.C:
+				expired = true
+			}
+		}
+		select {
+		case <-q.done:
+		default:
+			q.discard()
+			errs = append(errs, fmt.Errorf("audit: %T не успел доставить события за %s", q.observer, p.opts.CloseTimeout))
 		}
 	}
-	return nil
+
+	for _, q := range p.queues {
+		if err := q.observer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
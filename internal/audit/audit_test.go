@@ -1,17 +1,26 @@
 package audit
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestNewEvent(t *testing.T) {
@@ -99,6 +108,252 @@ func (m *mockObserver) Close() error {
 	return nil
 }
 
+// blockingObserver блокирует Notify до закрытия release
+type blockingObserver struct {
+	mockObserver
+	release chan struct{}
+}
+
+func (b *blockingObserver) Notify(event Event) {
+	<-b.release
+	b.mockObserver.Notify(event)
+}
+
+func TestPublisher_PublishDoesNotBlockOnSlowObserver(t *testing.T) {
+	pub := NewPublisher()
+	slow := &blockingObserver{release: make(chan struct{})}
+	pub.Subscribe(slow)
+
+	start := time.Now()
+	for range 10 {
+		pub.Publish(NewEvent(ActionFollow, "user", "https://slow.com"))
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	close(slow.release)
+	require.NoError(t, pub.Close())
+	assert.Len(t, slow.events, 10)
+}
+
+func TestPublisher_OverflowPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      OverflowPolicy
+		wantDropped uint64
+		wantURLs    []string
+	}{
+		{
+			name:        "drop-new",
+			policy:      OverflowDropNew,
+			wantDropped: 2,
+			wantURLs:    []string{"https://0.com", "https://1.com", "https://2.com"},
+		},
+		{
+			name:        "drop-oldest",
+			policy:      OverflowDropOldest,
+			wantDropped: 2,
+			wantURLs:    []string{"https://0.com", "https://3.com", "https://4.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := NewPublisherWithOptions(QueueOptions{Size: 2, Overflow: tt.policy})
+			slow := &blockingObserver{release: make(chan struct{})}
+			pub.Subscribe(slow)
+
+			// Первое событие забирает воркер и блокируется в Notify
+			pub.Publish(NewEvent(ActionFollow, "user", "https://0.com"))
+			require.Eventually(t, func() bool { return pub.Stats()[0].Queued == 0 }, time.Second, time.Millisecond)
+
+			for i := 1; i < 5; i++ {
+				pub.Publish(NewEvent(ActionFollow, "user", "https://"+strconv.Itoa(i)+".com"))
+			}
+
+			stats := pub.Stats()
+			require.Len(t, stats, 1)
+			assert.Equal(t, tt.wantDropped, stats[0].Dropped)
+			assert.Equal(t, 2, stats[0].Capacity)
+
+			close(slow.release)
+			require.NoError(t, pub.Close())
+
+			urls := make([]string, 0, len(slow.events))
+			for _, e := range slow.events {
+				urls = append(urls, e.URL)
+			}
+			assert.Equal(t, tt.wantURLs, urls)
+		})
+	}
+}
+
+func TestPublisher_OverflowBlock(t *testing.T) {
+	pub := NewPublisherWithOptions(QueueOptions{Size: 1, Overflow: OverflowBlock})
+	slow := &blockingObserver{release: make(chan struct{})}
+	pub.Subscribe(slow)
+
+	published := make(chan struct{})
+	go func() {
+		for range 3 {
+			pub.Publish(NewEvent(ActionFollow, "user", "https://block.com"))
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("Publish не должен был завершиться при заполненной очереди")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(slow.release)
+	<-published
+	require.NoError(t, pub.Close())
+	assert.Len(t, slow.events, 3)
+	assert.Zero(t, pub.Stats()[0].Dropped)
+}
+
+func TestPublisher_CloseDeadline(t *testing.T) {
+	pub := NewPublisherWithOptions(QueueOptions{Size: 10, CloseTimeout: 50 * time.Millisecond})
+	slow := &blockingObserver{release: make(chan struct{})}
+	defer close(slow.release)
+	pub.Subscribe(slow)
+
+	for range 3 {
+		pub.Publish(NewEvent(ActionFollow, "user", "https://stuck.com"))
+	}
+
+	start := time.Now()
+	err := pub.Close()
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.True(t, slow.closed)
+	assert.Equal(t, uint64(2), pub.Stats()[0].Dropped)
+}
+
+func TestPublisher_CloseReleasesBlockedPublishers(t *testing.T) {
+	pub := NewPublisherWithOptions(QueueOptions{Size: 1, Overflow: OverflowBlock, CloseTimeout: 50 * time.Millisecond})
+	slow := &blockingObserver{release: make(chan struct{})}
+	defer close(slow.release)
+	pub.Subscribe(slow)
+
+	published := make(chan struct{})
+	go func() {
+		for range 3 {
+			pub.Publish(NewEvent(ActionFollow, "user", "https://block.com"))
+		}
+		close(published)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- pub.Close() }()
+
+	select {
+	case err := <-closed:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close не должен ждать заблокированных издателей")
+	}
+	<-published
+	assert.NotZero(t, pub.Stats()[0].Dropped)
+}
+
+// deliveringObserver подтверждает доставку не больше limit событий
+// за вызов, если limit задан, и тогда возвращает err
+type deliveringObserver struct {
+	mockObserver
+	limit int
+	err   error
+}
+
+func (d *deliveringObserver) DeliverBatch(_ context.Context, events []Event) (int, error) {
+	n := len(events)
+	if d.err != nil {
+		n = min(n, d.limit)
+	}
+	for _, event := range events[:n] {
+		d.mockObserver.Notify(event)
+	}
+	if n < len(events) {
+		return n, d.err
+	}
+	return n, nil
+}
+
+func TestPublisher_DeliverTo(t *testing.T) {
+	pub := NewPublisher()
+	ok := &deliveringObserver{}
+	queued := &mockObserver{}
+	pub.Subscribe(ok)
+	pub.Subscribe(queued)
+	rules, err := NewRules(Filter{DenyActions: []Action{ActionFollow}})
+	require.NoError(t, err)
+	partial := &deliveringObserver{limit: 1, err: errors.New("недоступен")}
+	pub.SubscribeWithRules(partial, rules)
+
+	sinks := pub.Sinks()
+	require.Len(t, sinks, 3)
+	assert.NotEqual(t, sinks[0], sinks[2], "имена наблюдателей одного типа различаются")
+
+	events := []Event{
+		{ID: "1", Action: ActionShorten, URL: "https://one.com"},
+		{ID: "2", Action: ActionFollow, URL: "https://two.com"},
+		{ID: "3", Action: ActionShorten, URL: "https://three.com"},
+		{ID: "4", Action: ActionShorten, URL: "https://four.com"},
+	}
+
+	// Подтверждающий наблюдатель получил события до возврата DeliverTo
+	n, err := pub.DeliverTo(context.Background(), sinks[0], events)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, events, ok.events)
+
+	n, err = pub.DeliverTo(context.Background(), sinks[1], events)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	// Подтверждено первое событие, отфильтрованное второе тоже считается
+	// доставленным, третье — нет
+	n, err = pub.DeliverTo(context.Background(), sinks[2], events)
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []Event{events[0]}, partial.events)
+
+	_, err = pub.DeliverTo(context.Background(), "unknown", events)
+	assert.Error(t, err)
+
+	require.NoError(t, pub.Close())
+	assert.Len(t, queued.events, 4)
+	_, err = pub.DeliverTo(context.Background(), sinks[0], events)
+	assert.ErrorIs(t, err, ErrPublisherClosed)
+}
+
+func TestPublisher_CloseIdempotent(t *testing.T) {
+	pub := NewPublisher()
+	pub.Subscribe(&mockObserver{})
+
+	require.NoError(t, pub.Close())
+	require.NoError(t, pub.Close())
+
+	// Публикация после закрытия игнорируется
+	pub.Publish(NewEvent(ActionFollow, "user", "https://late.com"))
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	p, err := ParseOverflowPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, OverflowDropNew, p)
+
+	p, err = ParseOverflowPolicy("drop-oldest")
+	require.NoError(t, err)
+	assert.Equal(t, OverflowDropOldest, p)
+
+	_, err = ParseOverflowPolicy("drop-all")
+	assert.Error(t, err)
+}
+
 // === FileObserver tests ===
 
 func TestFileObserver_Notify(t *testing.T) {
@@ -154,10 +409,691 @@ func TestFileObserver_InvalidPath(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// === Hash chain tests ===
+
+// writeChain пишет n событий через FileObserver и возвращает строки файла
+func writeChain(t *testing.T, path string, n int) []string {
+	t.Helper()
+	obs, err := NewFileObserver(path)
+	require.NoError(t, err)
+	for i := range n {
+		obs.Notify(NewEvent(ActionShorten, "user", "https://"+strconv.Itoa(i)+".com"))
+	}
+	require.NoError(t, obs.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+}
+
+func TestFileObserver_ChainsRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lines := writeChain(t, path, 3)
+	require.Len(t, lines, 3)
+
+	var prev ChainedRecord
+	for i, line := range lines {
+		var rec ChainedRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		assert.Equal(t, uint64(i+1), rec.Seq)
+		if i == 0 {
+			assert.Equal(t, GenesisHash, rec.PrevHash)
+		} else {
+			assert.Equal(t, prev.Hash, rec.PrevHash)
+		}
+		prev = rec
+	}
+
+	res, err := VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Records)
+	assert.Equal(t, uint64(3), res.LastSeq)
+	assert.Equal(t, prev.Hash, res.LastHash)
+}
+
+func TestFileObserver_ResumesChainAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeChain(t, path, 2)
+	lines := writeChain(t, path, 2)
+	require.Len(t, lines, 4)
+
+	res, err := VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 4, res.Records)
+}
+
+func TestVerifyChain_DetectsTampering(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(lines []string) []string
+		wantLine int
+	}{
+		{
+			name: "изменено поле события",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "https://1.com", "https://evil.com", 1)
+				return lines
+			},
+			wantLine: 2,
+		},
+		{
+			name: "удалена запись из середины",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			wantLine: 2,
+		},
+		{
+			name: "записи переставлены",
+			tamper: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			wantLine: 2,
+		},
+		{
+			name: "пересчитан хеш изменённой записи",
+			tamper: func(lines []string) []string {
+				var rec ChainedRecord
+				json.Unmarshal([]byte(lines[0]), &rec)
+				rec.URL = "https://evil.com"
+				rec.Hash, _ = rec.ComputeHash()
+				data, _ := json.Marshal(rec)
+				lines[0] = string(data)
+				return lines
+			},
+			wantLine: 2,
+		},
+		{
+			name: "добавлено неизвестное поле",
+			tamper: func(lines []string) []string {
+				lines[2] = strings.Replace(lines[2], `{`, `{"note":"forged",`, 1)
+				return lines
+			},
+			wantLine: 3,
+		},
+		{
+			name: "битая строка",
+			tamper: func(lines []string) []string {
+				lines[3] = lines[3][:10]
+				return lines
+			},
+			wantLine: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			lines := tt.tamper(writeChain(t, path, 4))
+
+			_, err := VerifyChain(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+
+			var chainErr *ChainError
+			require.ErrorAs(t, err, &chainErr)
+			assert.Equal(t, tt.wantLine, chainErr.Line)
+		})
+	}
+}
+
+func TestVerifyChain_LegacyPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	legacy, _ := json.Marshal(NewEvent(ActionFollow, "", "https://old.com"))
+	require.NoError(t, os.WriteFile(path, append(legacy, '\n'), 0644))
+
+	writeChain(t, path, 2)
+
+	res, err := VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Legacy)
+	assert.Equal(t, 2, res.Records)
+}
+
+func TestFileObserver_TornLastRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lines := writeChain(t, path, 2)
+
+	// Имитируем обрыв записи при падении процесса
+	torn := lines[0] + "\n" + lines[1] + "\n" + `{"seq":3,"prev`
+	require.NoError(t, os.WriteFile(path, []byte(torn), 0644))
+
+	// Оборванная строка отрезается, цепочка продолжается с seq 3
+	lines = writeChain(t, path, 1)
+	require.Len(t, lines, 3)
+
+	var rec ChainedRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &rec))
+	assert.Equal(t, uint64(3), rec.Seq)
+
+	res, err := VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Records)
+	assert.Equal(t, uint64(3), res.LastSeq)
+
+	// Файл из одной оборванной строки начинается заново
+	require.NoError(t, os.WriteFile(path, []byte(`{"seq":1,"prev`), 0644))
+	lines = writeChain(t, path, 1)
+	require.Len(t, lines, 1)
+	res, err = VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Records)
+}
+
+func TestFileObserver_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	obs, err := NewFileObserverWithOptions(path, FileOptions{MaxSize: 1024})
+	require.NoError(t, err)
+
+	// Конкурентная запись во время ротаций не должна терять строки
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 25 {
+				obs.Notify(NewEvent(ActionShorten, "user-"+strconv.Itoa(w), "https://"+strconv.Itoa(i)+".com"))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, obs.Close())
+
+	segments, err := ListSegments(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(1024))
+	}
+
+	res, err := VerifyChainFiles(append(segments, path), false)
+	require.NoError(t, err)
+	assert.Equal(t, 100, res.Records)
+	assert.Equal(t, uint64(100), res.LastSeq)
+}
+
+func TestFileObserver_RotatesDaily(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	obs, err := NewFileObserverWithOptions(path, FileOptions{Daily: true})
+	require.NoError(t, err)
+
+	day := time.Date(2024, 3, 10, 23, 59, 0, 0, time.Local)
+	obs.now = func() time.Time { return day }
+	obs.day = dayOf(day)
+
+	obs.Notify(NewEvent(ActionShorten, "user", "https://1.com"))
+	obs.Notify(NewEvent(ActionShorten, "user", "https://2.com"))
+
+	day = day.Add(2 * time.Minute)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://3.com"))
+	require.NoError(t, obs.Close())
+
+	segments, err := ListSegments(path)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	assert.True(t, strings.HasSuffix(segments[0], ".0000000002"))
+
+	res, err := VerifyChainFiles(append(segments, path), false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Records)
+}
+
+func TestFileObserver_CompressAndRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	obs, err := NewFileObserverWithOptions(path, FileOptions{MaxSize: 512, Compress: true, MaxBackups: 2})
+	require.NoError(t, err)
+
+	for i := range 30 {
+		obs.Notify(NewEvent(ActionShorten, "user", "https://"+strconv.Itoa(i)+".com"))
+	}
+	require.NoError(t, obs.Close())
+
+	segments, err := ListSegments(path)
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	for _, segment := range segments {
+		assert.True(t, strings.HasSuffix(segment, ".gz"), segment)
+	}
+
+	// Старые сегменты удалены: без allowTruncated это нарушение
+	_, _, err = ChainFiles(path, false)
+	var chainErr *ChainError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, segments[0], chainErr.File)
+
+	_, err = VerifyChainFiles(append(segments, path), false)
+	require.Error(t, err)
+
+	files, truncated, err := ChainFiles(path, true)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, append(segments, path), files)
+
+	res, err := VerifyChainFiles(files, truncated)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(30), res.LastSeq)
+	assert.Greater(t, res.FirstSeq, uint64(1))
+	assert.Equal(t, int(res.LastSeq-res.FirstSeq+1), res.Records)
+}
+
+func TestFileObserver_ResumesFromSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	obs, err := NewFileObserverWithOptions(path, FileOptions{MaxSize: 1})
+	require.NoError(t, err)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://1.com"))
+	obs.Notify(NewEvent(ActionShorten, "user", "https://2.com"))
+	require.NoError(t, obs.Close())
+
+	segments, err := ListSegments(path)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// Ротация перед остановкой: текущего файла нет, цепочка продолжается из сегмента
+	require.NoError(t, os.Rename(path, segmentName(path, time.Now().Add(time.Second), 2)))
+
+	lines := writeChain(t, path, 1)
+	var rec ChainedRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, uint64(3), rec.Seq)
+
+	segments, err = ListSegments(path)
+	require.NoError(t, err)
+	res, err := VerifyChainFiles(append(segments, path), false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Records)
+}
+
+func TestFileObserver_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	obs, err := NewFileObserver(path)
+	require.NoError(t, err)
+
+	obs.Notify(NewEvent(ActionShorten, "user", "https://1.com"))
+
+	// Внешний logrotate переносит файл, затем присылает SIGHUP
+	moved := filepath.Join(dir, "audit.log.1")
+	require.NoError(t, os.Rename(path, moved))
+	require.NoError(t, obs.Reopen())
+
+	obs.Notify(NewEvent(ActionShorten, "user", "https://2.com"))
+	require.NoError(t, obs.Close())
+
+	files, truncated, err := ChainFiles(path, false)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, []string{moved, path}, files)
+
+	res, err := VerifyChainFiles(files, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Records)
+	assert.False(t, res.Anchored)
+
+	// Новый файл начинается с якоря и проверяется отдельно
+	res, err = VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.True(t, res.Anchored)
+	assert.Equal(t, 1, res.Records)
+	assert.Equal(t, uint64(2), res.FirstSeq)
+
+	// Перезапуск после переоткрытия продолжает цепочку с якоря
+	obs, err = NewFileObserver(path)
+	require.NoError(t, err)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://3.com"))
+	require.NoError(t, obs.Close())
+	res, err = VerifyChainFiles([]string{moved, path}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Records)
+
+	page, err := obs.Query(Query{})
+	require.NoError(t, err)
+	assert.Len(t, page.Events, 2)
+}
+
+func TestFileObserver_ReopenAnchorOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	writeChain(t, path, 2)
+
+	obs, err := NewFileObserver(path)
+	require.NoError(t, err)
+	moved := filepath.Join(dir, "audit.log.1")
+	require.NoError(t, os.Rename(path, moved))
+	require.NoError(t, obs.Reopen())
+	require.NoError(t, obs.Close())
+
+	// Рестарт до первой записи: цепочка продолжается с якоря
+	lines := writeChain(t, path, 1)
+	require.Len(t, lines, 2)
+	var rec ChainedRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, uint64(3), rec.Seq)
+
+	// Якорь, не совпадающий с перенесённым файлом, — нарушение
+	other := filepath.Join(dir, "other.log")
+	writeChain(t, other, 1)
+	_, err = VerifyChainFiles([]string{other, path}, false)
+	var chainErr *ChainError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, path, chainErr.File)
+	assert.Equal(t, 1, chainErr.Line)
+}
+
+func TestFileObserver_ReopenFailureKeepsWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	obs, err := NewFileObserver(path)
+	require.NoError(t, err)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://1.com"))
+
+	// На месте файла оказался каталог: открыть новый файл не удаётся
+	moved := filepath.Join(dir, "audit.log.1")
+	require.NoError(t, os.Rename(path, moved))
+	require.NoError(t, os.Mkdir(path, 0755))
+	require.Error(t, obs.Reopen())
+
+	// Запись продолжается в перенесённый файл
+	obs.Notify(NewEvent(ActionShorten, "user", "https://2.com"))
+	require.NoError(t, obs.Health(context.Background()))
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, obs.Reopen())
+	obs.Notify(NewEvent(ActionShorten, "user", "https://3.com"))
+	require.NoError(t, obs.Close())
+
+	files, _, err := ChainFiles(path, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{moved, path}, files)
+	res, err := VerifyChainFiles(files, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Records)
+}
+
+func TestFileObserver_RotateFailureKeepsWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	obs, err := NewFileObserverWithOptions(path, FileOptions{MaxSize: 1})
+	require.NoError(t, err)
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	obs.now = func() time.Time { return now }
+
+	obs.Notify(NewEvent(ActionShorten, "user", "https://1.com"))
+
+	// Имя сегмента занято непустым каталогом: переименовать файл не удаётся
+	segment := segmentName(path, now, 1)
+	require.NoError(t, os.MkdirAll(filepath.Join(segment, "busy"), 0755))
+	obs.Notify(NewEvent(ActionShorten, "user", "https://2.com"))
+	require.NoError(t, obs.Health(context.Background()))
+	require.NoError(t, obs.Close())
+
+	res, err := VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Records)
+}
+
+func TestChainFiles_MissingPrevious(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	obs, err := NewFileObserver(path)
+	require.NoError(t, err)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://1.com"))
+
+	moved := filepath.Join(dir, "audit.log.1")
+	require.NoError(t, os.Rename(path, moved))
+	require.NoError(t, obs.Reopen())
+	obs.Notify(NewEvent(ActionShorten, "user", "https://2.com"))
+	require.NoError(t, obs.Close())
+
+	// Перенесённый файл удалён: якорь ссылается на отсутствующий файл
+	require.NoError(t, os.Remove(moved))
+	_, _, err = ChainFiles(path, false)
+	var chainErr *ChainError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, path, chainErr.File)
+	assert.Contains(t, chainErr.Reason, "audit.log.1")
+
+	files, truncated, err := ChainFiles(path, true)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, []string{path}, files)
+}
+
+func TestChainFiles_ForgedAnchor(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	obs, err := NewFileObserver(path)
+	require.NoError(t, err)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://1.com"))
+	obs.Notify(NewEvent(ActionShorten, "user", "https://2.com"))
+	require.NoError(t, obs.Close())
+
+	// Головные записи заменены якорем, который не совпадает ни с одним файлом
+	moved := filepath.Join(dir, "audit.log.1")
+	writeChain(t, moved, 1)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	forged := `{"anchor_seq":1,"anchor_hash":"` + strings.Repeat("0", 64) + `","anchor_prev":"audit.log.1"}` + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(forged+lines[1]), 0644))
+
+	_, _, err = ChainFiles(path, false)
+	var chainErr *ChainError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, path, chainErr.File)
+}
+
+func TestChainFiles_RenumberedByLogrotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	obs, err := NewFileObserver(path)
+	require.NoError(t, err)
+
+	for i := 1; i <= 2; i++ {
+		obs.Notify(NewEvent(ActionShorten, "user", "https://"+strconv.Itoa(i)+".com"))
+		if i > 1 {
+			require.NoError(t, os.Rename(path+".1", path+".2"))
+		}
+		require.NoError(t, os.Rename(path, path+".1"))
+		require.NoError(t, obs.Reopen())
+	}
+	obs.Notify(NewEvent(ActionShorten, "user", "https://3.com"))
+	require.NoError(t, obs.Close())
+
+	// Первый якорь называет audit.log.1, но файл перенумерован в audit.log.2
+	files, truncated, err := ChainFiles(path, false)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, []string{path + ".2", path + ".1", path}, files)
+
+	res, err := VerifyChainFiles(files, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Records)
+	assert.Equal(t, uint64(1), res.FirstSeq)
+}
+
+func TestQuery_Match(t *testing.T) {
+	event := Event{Timestamp: 100, Action: ActionShorten, UserID: "u1", URL: "https://example.com/page"}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  bool
+	}{
+		{name: "пустой фильтр", query: Query{}, want: true},
+		{name: "совпадает пользователь", query: Query{UserID: "u1"}, want: true},
+		{name: "другой пользователь", query: Query{UserID: "u2"}, want: false},
+		{name: "другое действие", query: Query{Action: ActionFollow}, want: false},
+		{name: "подстрока URL", query: Query{URL: "example.com"}, want: true},
+		{name: "нет подстроки URL", query: Query{URL: "other"}, want: false},
+		{name: "границы времени включительно", query: Query{From: 100, To: 100}, want: true},
+		{name: "раньше нижней границы", query: Query{From: 101}, want: false},
+		{name: "позже верхней границы", query: Query{To: 99}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.query.Match(event))
+		})
+	}
+}
+
+func TestFileObserver_Query(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Запись до включения цепочки в выборку не попадает
+	legacy, _ := json.Marshal(NewEvent(ActionShorten, "u1", "https://old.com"))
+	require.NoError(t, os.WriteFile(path, append(legacy, '\n'), 0644))
+
+	obs, err := NewFileObserverWithOptions(path, FileOptions{MaxSize: 512, Compress: true})
+	require.NoError(t, err)
+	defer obs.Close()
+
+	for i := range 20 {
+		user := "u" + strconv.Itoa(i%2)
+		obs.Notify(Event{Timestamp: int64(i), Action: ActionShorten, UserID: user, URL: "https://" + strconv.Itoa(i) + ".com"})
+	}
+
+	segments, err := ListSegments(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+
+	// Постраничный обход через сегменты и текущий файл
+	var got []Event
+	q := Query{UserID: "u1", Limit: 3}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10)
+		page, err := obs.Query(q)
+		require.NoError(t, err)
+		got = append(got, page.Events...)
+		if page.Next == 0 {
+			break
+		}
+		q.After = page.Next
+	}
+	require.Len(t, got, 10)
+	for i, e := range got {
+		assert.Equal(t, "u1", e.UserID)
+		assert.Equal(t, int64(2*i+1), e.Timestamp)
+	}
+
+	page, err := obs.Query(Query{URL: "://1", From: 10, To: 11})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 2)
+	assert.Equal(t, "https://10.com", page.Events[0].URL)
+	assert.Equal(t, "https://11.com", page.Events[1].URL)
+	assert.Zero(t, page.Next)
+}
+
+func TestQuery_PageSize(t *testing.T) {
+	assert.Equal(t, DefaultQueryLimit, Query{}.PageSize())
+	assert.Equal(t, 5, Query{Limit: 5}.PageSize())
+	assert.Equal(t, MaxQueryLimit, Query{Limit: MaxQueryLimit + 1}.PageSize())
+}
+
 // === HTTPObserver tests ===
 
+// fastHTTPOptions параметры доставки с короткими задержками для тестов
+func fastHTTPOptions() HTTPOptions {
+	return HTTPOptions{
+		BatchSize:     10,
+		FlushInterval: 20 * time.Millisecond,
+		MaxRetries:    3,
+		BaseBackoff:   time.Millisecond,
+		MaxBackoff:    5 * time.Millisecond,
+		Timeout:       time.Second,
+	}
+}
+
+// auditServer тестовый сервер аудита, отвечающий статусами из status
+type auditServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received []Event
+	attempts int
+	status   func(attempt int) int
+}
+
+func newAuditServer(t *testing.T, status func(attempt int) int) *auditServer {
+	t.Helper()
+	s := &auditServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.attempts++
+		code := s.status(s.attempts)
+		if code == http.StatusOK {
+			var events []Event
+			body, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &events); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.received = append(s.received, events...)
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *auditServer) events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.received...)
+}
+
+func TestHTTPObserver_DeliverBatch(t *testing.T) {
+	// Первый батч исчерпывает все повторы на втором сообщении, затем
+	// сервер принимает всё
+	server := newAuditServer(t, func(attempt int) int {
+		if attempt >= 2 && attempt <= 5 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	opts := fastHTTPOptions()
+	opts.BatchSize = 2
+	opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.jsonl")
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+	defer obs.Close()
+
+	events := []Event{
+		{ID: "1", Action: ActionShorten, URL: "https://one.com"},
+		{ID: "2", Action: ActionShorten, URL: "https://two.com"},
+		{ID: "3", Action: ActionShorten, URL: "https://three.com"},
+	}
+	n, err := obs.DeliverBatch(context.Background(), events)
+	assert.Error(t, err)
+	assert.Equal(t, 2, n, "подтверждено первое сообщение из BatchSize событий")
+	// Недоставленные события повторит вызывающий, dead-letter не нужен
+	assert.Zero(t, obs.DeadLetterCount())
+
+	n, err = obs.DeliverBatch(context.Background(), events[n:])
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, events, server.events())
+}
+
+func TestHTTPObserver_DeliverBatchStopsBeforeDeadline(t *testing.T) {
+	server := newAuditServer(t, func(int) int { return http.StatusServiceUnavailable })
+	opts := fastHTTPOptions()
+	opts.MaxRetries = 100
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+	defer obs.Close()
+
+	// Следующая попытка не укладывается в дедлайн: повторов нет
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout/2)
+	defer cancel()
+	n, err := obs.DeliverBatch(ctx, []Event{{ID: "1", Action: ActionShorten}})
+	assert.Error(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, 1, server.attempts)
+}
+
 func TestHTTPObserver_Notify(t *testing.T) {
-	var received Event
+	var received []Event
 	var receivedContentType string
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -171,27 +1107,172 @@ func TestHTTPObserver_Notify(t *testing.T) {
 	obs := NewHTTPObserver(server.URL)
 	event := NewEvent(ActionShorten, "user-http", "https://http-test.com")
 	obs.Notify(event)
+	require.NoError(t, obs.Close()) // Close отправляет остаток батча
 
 	assert.Equal(t, "application/json", receivedContentType)
-	assert.Equal(t, event.URL, received.URL)
-	assert.Equal(t, event.UserID, received.UserID)
+	require.Len(t, received, 1)
+	assert.Equal(t, event.URL, received[0].URL)
+	assert.Equal(t, event.UserID, received[0].UserID)
 }
 
-func TestHTTPObserver_ServerError(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
+func TestHTTPObserver_Batching(t *testing.T) {
+	server := newAuditServer(t, func(int) int { return http.StatusOK })
+
+	opts := fastHTTPOptions()
+	opts.BatchSize = 3
+	opts.FlushInterval = time.Hour
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+
+	for range 7 {
+		obs.Notify(NewEvent(ActionFollow, "user", "https://batch.com"))
+	}
+	// Два полных батча ушли сразу, остаток ждёт таймера или Close
+	assert.Len(t, server.events(), 6)
+	assert.Equal(t, 2, server.attempts)
+
+	require.NoError(t, obs.Close())
+	assert.Len(t, server.events(), 7)
+	assert.Equal(t, 3, server.attempts)
+}
+
+func TestHTTPObserver_FlushInterval(t *testing.T) {
+	server := newAuditServer(t, func(int) int { return http.StatusOK })
+
+	obs := NewHTTPObserverWithOptions(server.URL, fastHTTPOptions())
+	defer obs.Close()
+
+	obs.Notify(NewEvent(ActionFollow, "user", "https://tick.com"))
+	assert.Eventually(t, func() bool { return len(server.events()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestHTTPObserver_RetriesIntermittentFailures(t *testing.T) {
+	// Каждая вторая попытка падает с 503
+	server := newAuditServer(t, func(attempt int) int {
+		if attempt%2 == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	opts := fastHTTPOptions()
+	opts.BatchSize = 2
+	opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.ndjson")
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+
+	for i := range 6 {
+		obs.Notify(NewEvent(ActionFollow, "user", "https://"+strconv.Itoa(i)+".com"))
+	}
+	require.NoError(t, obs.Close())
+
+	events := server.events()
+	require.Len(t, events, 6)
+	for i, e := range events {
+		assert.Equal(t, "https://"+strconv.Itoa(i)+".com", e.URL)
+	}
+	assert.Equal(t, 6, server.attempts)
+	assert.Zero(t, obs.DeadLetterCount())
+}
+
+func TestHTTPObserver_DeadLetterAndReplay(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	server := newAuditServer(t, func(int) int {
+		if down.Load() {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	})
+
+	opts := fastHTTPOptions()
+	opts.BatchSize = 2
+	opts.FlushInterval = time.Hour
+	opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.ndjson")
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+
+	obs.Notify(NewEvent(ActionShorten, "user", "https://a.com"))
+	obs.Notify(NewEvent(ActionShorten, "user", "https://b.com"))
+
+	// 1 попытка + 3 повтора, затем батч уходит в dead-letter
+	assert.Equal(t, 4, server.attempts)
+	assert.Equal(t, 2, obs.DeadLetterCount())
+	spooled, err := readDeadLetter(opts.DeadLetterPath)
+	require.NoError(t, err)
+	require.Len(t, spooled, 2)
+	assert.Equal(t, "https://a.com", spooled[0].URL)
+
+	// Сервер восстановился: следующий успешный батч вытягивает dead-letter
+	down.Store(false)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://c.com"))
+	obs.Notify(NewEvent(ActionShorten, "user", "https://d.com"))
+	require.NoError(t, obs.Close())
+
+	var urls []string
+	for _, e := range server.events() {
+		urls = append(urls, e.URL)
+	}
+	assert.ElementsMatch(t, []string{"https://a.com", "https://b.com", "https://c.com", "https://d.com"}, urls)
+	assert.Zero(t, obs.DeadLetterCount())
+
+	content, err := os.ReadFile(opts.DeadLetterPath)
+	require.NoError(t, err)
+	assert.Empty(t, content)
+}
+
+func TestHTTPObserver_ReplayOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	require.NoError(t, writeDeadLetter(path, []Event{
+		NewEvent(ActionFollow, "user", "https://old-1.com"),
+		NewEvent(ActionFollow, "user", "https://old-2.com"),
 	}))
-	defer server.Close()
 
-	obs := NewHTTPObserver(server.URL)
-	// Не должно паниковать
+	server := newAuditServer(t, func(int) int { return http.StatusOK })
+	opts := fastHTTPOptions()
+	opts.DeadLetterPath = path
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+	defer obs.Close()
+
+	assert.Equal(t, 2, obs.DeadLetterCount())
+	assert.Eventually(t, func() bool { return obs.DeadLetterCount() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Len(t, server.events(), 2)
+}
+
+func TestHTTPObserver_ClientErrorNotRetried(t *testing.T) {
+	server := newAuditServer(t, func(int) int { return http.StatusBadRequest })
+
+	opts := fastHTTPOptions()
+	opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.ndjson")
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+
 	obs.Notify(NewEvent(ActionFollow, "user", "https://test.com"))
+	require.NoError(t, obs.Close())
+
+	assert.Equal(t, 1, server.attempts)
+	assert.Zero(t, obs.DeadLetterCount())
 }
 
 func TestHTTPObserver_ConnectionError(t *testing.T) {
-	obs := NewHTTPObserver("http://localhost:99999") // несуществующий порт
+	opts := fastHTTPOptions()
+	opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.ndjson")
+	obs := NewHTTPObserverWithOptions("http://localhost:99999", opts) // несуществующий порт
+
 	// Не должно паниковать
 	obs.Notify(NewEvent(ActionFollow, "user", "https://test.com"))
+	require.NoError(t, obs.Close())
+
+	assert.Equal(t, 1, obs.DeadLetterCount())
+}
+
+func TestHTTPObserver_BackoffBounds(t *testing.T) {
+	obs := &HTTPObserver{opts: HTTPOptions{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for range 20 {
+			d := obs.backoff(attempt)
+			assert.GreaterOrEqual(t, d, want/2)
+			assert.LessOrEqual(t, d, want)
+		}
+	}
 }
 
 func TestHTTPObserver_Close(t *testing.T) {
@@ -200,6 +1281,107 @@ func TestHTTPObserver_Close(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// === Signature tests ===
+
+func TestHTTPObserver_SignsDeliveries(t *testing.T) {
+	secret := []byte("shared-secret")
+	verifier := NewVerifier(secret)
+
+	var verifyErr error
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, verifyErr = verifier.VerifyRequest(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	opts := fastHTTPOptions()
+	opts.Secret = secret
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+	obs.Notify(NewEvent(ActionShorten, "user", "https://signed.com"))
+	require.NoError(t, obs.Close())
+
+	require.Equal(t, 1, calls)
+	assert.NoError(t, verifyErr)
+}
+
+func TestHTTPObserver_NoSignatureWithoutSecret(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	obs := NewHTTPObserverWithOptions(server.URL, fastHTTPOptions())
+	obs.Notify(NewEvent(ActionShorten, "user", "https://plain.com"))
+	require.NoError(t, obs.Close())
+
+	assert.Empty(t, header.Get(SignatureHeader))
+	assert.Empty(t, header.Get(TimestampHeader))
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`[{"ts":1,"action":"follow","url":"https://x.com"}]`)
+	signedAt := time.Unix(1_700_000_000, 0)
+
+	headers := func(ts int64, sig string) http.Header {
+		h := http.Header{}
+		if ts != 0 {
+			h.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+		}
+		if sig != "" {
+			h.Set(SignatureHeader, sig)
+		}
+		return h
+	}
+	valid := Sign(secret, signedAt.Unix(), body)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		now     time.Time
+		wantErr error
+	}{
+		{name: "валидная подпись", header: headers(signedAt.Unix(), valid), body: body, now: signedAt.Add(time.Minute)},
+		{name: "нет заголовков", header: headers(0, ""), body: body, now: signedAt, wantErr: ErrSignatureMissing},
+		{name: "изменённое тело", header: headers(signedAt.Unix(), valid), body: []byte(`[]`), now: signedAt, wantErr: ErrSignatureInvalid},
+		{name: "подмена времени", header: headers(signedAt.Unix()+1, valid), body: body, now: signedAt, wantErr: ErrSignatureInvalid},
+		{name: "другой секрет", header: headers(signedAt.Unix(), Sign([]byte("other"), signedAt.Unix(), body)), body: body, now: signedAt, wantErr: ErrSignatureInvalid},
+		{name: "неизвестная схема", header: headers(signedAt.Unix(), "md5=abc"), body: body, now: signedAt, wantErr: ErrSignatureInvalid},
+		{name: "повтор старой доставки", header: headers(signedAt.Unix(), valid), body: body, now: signedAt.Add(10 * time.Minute), wantErr: ErrSignatureExpired},
+		{name: "время из будущего", header: headers(signedAt.Unix(), valid), body: body, now: signedAt.Add(-10 * time.Minute), wantErr: ErrSignatureExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(secret)
+			v.Now = func() time.Time { return tt.now }
+
+			err := v.Verify(tt.header, tt.body)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestVerifier_ZeroToleranceSkipsTimeCheck(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte("[]")
+	header := http.Header{}
+	header.Set(TimestampHeader, "1")
+	header.Set(SignatureHeader, Sign(secret, 1, body))
+
+	v := &Verifier{Secret: secret}
+	assert.NoError(t, v.Verify(header, body))
+}
+
 // === Event JSON serialization ===
 
 func TestEvent_JSONFormat(t *testing.T) {
@@ -230,3 +1412,132 @@ func TestEvent_JSONOmitEmptyUserID(t *testing.T) {
 
 	assert.NotContains(t, string(data), "user_id")
 }
+
+func TestEvent_JSONOptionalFields(t *testing.T) {
+	event := Event{
+		Timestamp: 1234567890,
+		Action:    ActionBatchShorten,
+		UserID:    "user-json",
+		URL:       "https://json.com",
+		ShortCode: "abc123",
+		ClientIP:  "10.0.0.1",
+		UserAgent: "curl/8.0",
+		RequestID: "req-1",
+	}
+
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	expected := `{"ts":1234567890,"action":"batch_shorten","user_id":"user-json","url":"https://json.com",` +
+		`"short_code":"abc123","client_ip":"10.0.0.1","user_agent":"curl/8.0","request_id":"req-1"}`
+	assert.JSONEq(t, expected, string(data))
+}
+
+func TestPublisher_Health(t *testing.T) {
+	failing := true
+	server := newAuditServer(t, func(int) int {
+		if failing {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	opts := fastHTTPOptions()
+	opts.BatchSize = 1
+	opts.MaxRetries = 0
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+
+	pub := NewPublisher()
+	pub.Subscribe(obs)
+	pub.Subscribe(&mockObserver{})
+	defer pub.Close()
+
+	name := "audit[0] http " + server.URL
+	results := pub.Health(context.Background())
+	require.Len(t, results, 2)
+	assert.NoError(t, results[name])
+	assert.Contains(t, results, "audit[1] *audit.mockObserver")
+
+	pub.Publish(NewEvent(ActionFollow, "user", "https://down.com"))
+	assert.Eventually(t, func() bool {
+		return pub.Health(context.Background())[name] != nil
+	}, time.Second, 5*time.Millisecond)
+
+	// Успешная доставка возвращает наблюдателя в строй
+	server.mu.Lock()
+	failing = false
+	server.mu.Unlock()
+	pub.Publish(NewEvent(ActionFollow, "user", "https://up.com"))
+	assert.Eventually(t, func() bool {
+		return pub.Health(context.Background())[name] == nil
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestPublisher_HealthQueueFull(t *testing.T) {
+	pub := NewPublisherWithOptions(QueueOptions{Size: 1})
+	slow := &blockingObserver{release: make(chan struct{})}
+	pub.Subscribe(slow)
+
+	// Первое событие забирает воркер, остальные заполняют очередь
+	for range 3 {
+		pub.Publish(NewEvent(ActionFollow, "user", "https://slow.com"))
+	}
+	assert.Eventually(t, func() bool {
+		return pub.Health(context.Background())["audit[0] *audit.blockingObserver"] != nil
+	}, time.Second, 5*time.Millisecond)
+
+	close(slow.release)
+	require.NoError(t, pub.Close())
+}
+
+// recordSpans подменяет глобальный провайдер трассировки на записывающий
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestHTTPObserver_PropagatesTraceContext(t *testing.T) {
+	rec := recordSpans(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Событие из запроса со своим span'ом
+	_, reqSpan := otel.Tracer("test").Start(context.Background(), "request")
+	event := NewEvent(ActionShorten, "user", "https://traced.com")
+	event.SpanContext = reqSpan.SpanContext()
+	reqSpan.End()
+
+	obs := NewHTTPObserverWithOptions(server.URL, fastHTTPOptions())
+	obs.Notify(event)
+	require.NoError(t, obs.Close())
+
+	var deliver, post sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		switch s.Name() {
+		case "audit.http.deliver":
+			deliver = s
+		case "POST":
+			post = s
+		}
+	}
+	require.NotNil(t, deliver)
+	require.NotNil(t, post)
+
+	// Доставка батча ссылается на span запроса, POST — её потомок
+	require.Len(t, deliver.Links(), 1)
+	assert.Equal(t, reqSpan.SpanContext().SpanID(), deliver.Links()[0].SpanContext.SpanID())
+	assert.Equal(t, deliver.SpanContext().SpanID(), post.Parent().SpanID())
+
+	// Получатель видит контекст span'а POST
+	want := "00-" + post.SpanContext().TraceID().String() + "-" + post.SpanContext().SpanID().String() + "-01"
+	assert.Equal(t, want, traceparent)
+}
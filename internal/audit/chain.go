@@ -0,0 +1,374 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// GenesisHash значение prev_hash у первой записи цепочки
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// tailWindow сколько байт с конца файла читается для восстановления цепочки
+const tailWindow = 64 * 1024
+
+// ChainedRecord запись аудит-файла, связанная с предыдущей записью хешем.
+//
+// Поля события встраиваются в запись, поэтому потребители, читающие
+// строки как Event, продолжают работать.
+type ChainedRecord struct {
+	Seq      uint64 `json:"seq"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash,omitempty"`
+	Event
+}
+
+// chainPayload часть записи, по которой считался хеш до LineHash
+type chainPayload struct {
+	Seq      uint64 `json:"seq"`
+	PrevHash string `json:"prev_hash"`
+	Event
+}
+
+// ComputeHash считает SHA-256 по полям seq, prev_hash и события.
+//
+// Так хешировались записи до LineHash: атрибуты конверта CloudEvents и
+// неизвестные поля в хеш не входили. Для строк LegacySerializer без
+// лишних полей результат совпадает с LineHash. Используется только для
+// проверки старых файлов в формате CloudEvents.
+func (r ChainedRecord) ComputeHash() (string, error) {
+	data, err := json.Marshal(chainPayload{Seq: r.Seq, PrevHash: r.PrevHash, Event: r.Event})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// LineHash считает SHA-256 строки аудит-файла без члена "hash".
+//
+// В хеш входит вся сохранённая строка: поля события, атрибуты конверта
+// CloudEvents и поля, неизвестные этой версии, поэтому правка любого из
+// них обнаруживается при проверке.
+func LineHash(line []byte, hash string) (string, error) {
+	member := []byte(`,"hash":"` + hash + `"`)
+	i := bytes.Index(line, member)
+	if hash == "" || i < 0 {
+		return "", errors.New("в записи нет поля hash")
+	}
+	unsealed := make([]byte, 0, len(line)-len(member))
+	unsealed = append(unsealed, line[:i]...)
+	unsealed = append(unsealed, line[i+len(member):]...)
+	return sumHex(unsealed), nil
+}
+
+func sumHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainAnchor строка-якорь в начале файла, продолжающего цепочку другого
+// файла после ротации или внешнего logrotate: имя предыдущего файла,
+// seq и хеш его последней записи. Якорь связывает файлы в цепочку:
+// ChainFiles идёт по якорям назад до первого файла.
+type chainAnchor struct {
+	Seq  uint64 `json:"anchor_seq"`
+	Hash string `json:"anchor_hash"`
+	Prev string `json:"anchor_prev,omitempty"` // имя предыдущего файла в том же каталоге
+}
+
+// state возвращает позицию цепочки, на которой стоит якорь
+func (a chainAnchor) state() chainState {
+	return chainState{seq: a.Seq, lastHash: a.Hash}
+}
+
+// decodeAnchor разбирает строку-якорь. Для обычных записей возвращает false.
+func decodeAnchor(line []byte) (chainAnchor, bool) {
+	if !bytes.HasPrefix(line, []byte(`{"anchor_seq":`)) {
+		return chainAnchor{}, false
+	}
+	var a chainAnchor
+	if err := json.Unmarshal(line, &a); err != nil || a.Hash == "" {
+		return chainAnchor{}, false
+	}
+	return a, true
+}
+
+// chainState позиция цепочки: номер и хеш последней записи
+type chainState struct {
+	seq      uint64
+	lastHash string
+	// lineHashed в цепочке уже встречалась запись с LineHash: записи
+	// по старой схеме ComputeHash дальше не принимаются
+	lineHashed bool
+}
+
+// next создаёт следующую запись цепочки для события и кодирует её строку.
+// Хеш считается по строке без поля hash, после чего строка кодируется
+// повторно уже с хешем.
+func (s *chainState) next(event Event, serializer Serializer) (ChainedRecord, []byte, error) {
+	rec := ChainedRecord{Seq: s.seq + 1, PrevHash: s.lastHash, Event: event}
+	unsealed, err := serializer.EncodeRecord(rec)
+	if err != nil {
+		return ChainedRecord{}, nil, err
+	}
+	rec.Hash = sumHex(unsealed)
+
+	line, err := serializer.EncodeRecord(rec)
+	if err != nil {
+		return ChainedRecord{}, nil, err
+	}
+	// Сериализатор обязан писать hash сразу после prev_hash, иначе
+	// проверка не восстановит строку, по которой считался хеш
+	if hash, err := LineHash(line, rec.Hash); err != nil || hash != rec.Hash {
+		return ChainedRecord{}, nil, fmt.Errorf("%T: поле hash не на своём месте", serializer)
+	}
+	return rec, line, nil
+}
+
+// advance сдвигает цепочку на записанную запись
+func (s *chainState) advance(rec ChainedRecord) {
+	s.seq = rec.Seq
+	s.lastHash = rec.Hash
+}
+
+// ChainError описывает первое нарушение цепочки
+type ChainError struct {
+	File   string // файл, в котором найдено нарушение, если проверялось несколько
+	Line   int    // номер строки в файле, начиная с 1
+	Seq    uint64 // seq записи, если её удалось разобрать
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("цепочка аудита нарушена в %s, строка %d (seq %d): %s", e.File, e.Line, e.Seq, e.Reason)
+	}
+	return fmt.Sprintf("цепочка аудита нарушена в строке %d (seq %d): %s", e.Line, e.Seq, e.Reason)
+}
+
+// VerifyResult итог проверки цепочки
+type VerifyResult struct {
+	Records  int    // число проверенных записей цепочки
+	Legacy   int    // число записей без хеша в начале файла (до включения цепочки)
+	Anchored bool   // проверка начата с якоря, а не с GenesisHash
+	FirstSeq uint64 // seq первой проверенной записи
+	LastSeq  uint64 // seq последней записи
+	LastHash string // хеш последней записи
+}
+
+// VerifyChain проходит по аудит-файлу и возвращает *ChainError
+// для первой записи, у которой не сходится хеш, seq или ссылка на предыдущую.
+//
+// Записи без поля hash в начале файла считаются написанными до включения
+// цепочки и пропускаются. После первой связанной записи все остальные
+// обязаны продолжать цепочку, а сама она должна начинаться с GenesisHash.
+//
+// Файл, начатый после ротации, начинается с якоря — имени, seq и хеша
+// последней записи предыдущего файла. Такой файл проверяется отдельно
+// с якоря, а вслед за предыдущим файлом якорь обязан совпасть с его
+// последней записью. Сам якорь не подписан: чтобы проверить, что
+// предыдущий файл на месте, используйте ChainFiles и VerifyChainFiles.
+//
+// Удаление записей с конца файла не обнаруживается: оставшаяся цепочка
+// цела. Чтобы заметить обрезку, сравнивайте LastSeq и LastHash со
+// значениями, сохранёнными вне файла.
+//
+// Хеш записи сверяется с LineHash её строки. Записи, захешированные
+// по старой схеме ComputeHash, принимаются только до первой записи
+// с LineHash — так проверяются файлы, начатые прежними версиями.
+func VerifyChain(r io.Reader) (VerifyResult, error) {
+	var res VerifyResult
+	_, err := verifyChainFrom(r, chainState{lastHash: GenesisHash}, false, true, &res)
+	return res, err
+}
+
+// verifyChainFrom проверяет цепочку, начиная с заданного состояния.
+//
+// При anchor первая связанная запись принимается как начало цепочки без
+// сверки seq и prev_hash — так проверяется хвост после удалённых сегментов.
+// При leadingAnchor цепочку может начать якорь, иначе якорь в начале
+// проверки — нарушение: файл, который он продолжает, не проверен.
+func verifyChainFrom(r io.Reader, state chainState, anchor, leadingAnchor bool, res *VerifyResult) (chainState, error) {
+	started := state.seq > 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		if a, ok := decodeAnchor(raw); ok {
+			anchor := a.state()
+			if started && (anchor.seq != state.seq || anchor.lastHash != state.lastHash) {
+				return state, &ChainError{Line: line, Seq: anchor.seq, Reason: "якорь не совпадает с последней записью цепочки"}
+			}
+			if !started && !leadingAnchor {
+				return state, &ChainError{Line: line, Seq: anchor.seq, Reason: "цепочка начинается с якоря, продолжаемый файл не проверен"}
+			}
+			if !started {
+				anchor.lineHashed = state.lineHashed
+				state = anchor
+				res.Anchored = true
+				res.LastSeq, res.LastHash = state.seq, state.lastHash
+			}
+			started = true
+			continue
+		}
+
+		rec, err := decodeRecord(raw)
+		if err != nil {
+			return state, &ChainError{Line: line, Reason: "запись не разбирается: " + err.Error()}
+		}
+		if rec.Hash == "" && !started {
+			res.Legacy++
+			continue
+		}
+
+		if !started && anchor {
+			state = chainState{seq: rec.Seq - 1, lastHash: rec.PrevHash}
+		}
+		started = true
+
+		if rec.Seq != state.seq+1 {
+			return state, &ChainError{Line: line, Seq: rec.Seq, Reason: fmt.Sprintf("ожидался seq %d", state.seq+1)}
+		}
+		if rec.PrevHash != state.lastHash {
+			return state, &ChainError{Line: line, Seq: rec.Seq, Reason: "prev_hash не совпадает с хешем предыдущей записи"}
+		}
+		hash, err := LineHash(raw, rec.Hash)
+		if err != nil {
+			return state, &ChainError{Line: line, Seq: rec.Seq, Reason: err.Error()}
+		}
+		switch {
+		case hash == rec.Hash:
+			state.lineHashed = true
+		case state.lineHashed || !legacyHashMatches(rec):
+			return state, &ChainError{Line: line, Seq: rec.Seq, Reason: "хеш записи не совпадает с содержимым"}
+		}
+
+		state.advance(rec)
+		if res.Records == 0 {
+			res.FirstSeq = rec.Seq
+		}
+		res.Records++
+		res.LastSeq = state.seq
+		res.LastHash = state.lastHash
+	}
+	return state, scanner.Err()
+}
+
+// legacyHashMatches сообщает, что запись захеширована по старой схеме ComputeHash
+func legacyHashMatches(rec ChainedRecord) bool {
+	hash, err := rec.ComputeHash()
+	return err == nil && hash == rec.Hash
+}
+
+// VerifyChainFile проверяет цепочку в файле по пути path
+func VerifyChainFile(path string) (VerifyResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer file.Close()
+	return VerifyChain(file)
+}
+
+// VerifyChainFiles проверяет одну цепочку, разложенную по нескольким файлам
+// в порядке записи, например ротированные сегменты и текущий файл.
+// Сегменты с расширением .gz читаются через gzip.
+//
+// Список файлов строит ChainFiles. При allowAnchor цепочка может начинаться
+// не с GenesisHash, в том числе с якоря, — это нужно, когда самые старые
+// сегменты удалены по ретенции. FirstSeq в результате показывает, с какой
+// записи начинается проверенная часть. Без allowAnchor якорь в начале
+// первого файла — нарушение.
+func VerifyChainFiles(paths []string, allowAnchor bool) (VerifyResult, error) {
+	var res VerifyResult
+	state := chainState{lastHash: GenesisHash}
+	for i, path := range paths {
+		r, err := openSegment(path)
+		if err != nil {
+			return res, err
+		}
+		state, err = verifyChainFrom(r, state, allowAnchor && i == 0, allowAnchor && i == 0, &res)
+		r.Close()
+
+		var chainErr *ChainError
+		if errors.As(err, &chainErr) {
+			chainErr.File = path
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// resumeChain восстанавливает состояние цепочки по последней записи файла.
+//
+// Читается только хвост файла. Возвращает также длину файла до конца
+// последней полной строки: если процесс упал посреди записи, оборванная
+// строка в цепочку не входит и должна быть отрезана.
+func resumeChain(file *os.File) (chainState, int64, error) {
+	state := chainState{lastHash: GenesisHash}
+
+	info, err := file.Stat()
+	if err != nil {
+		return state, 0, err
+	}
+	complete, err := lastLineEnd(file, info.Size())
+	if err != nil || complete == 0 {
+		return state, 0, err
+	}
+
+	offset := max(complete-tailWindow, 0)
+	buf := make([]byte, complete-offset)
+	if _, err := file.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return state, 0, err
+	}
+
+	lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte{'\n'})
+	for i := len(lines) - 1; i >= 0; i-- {
+		if anchor, ok := decodeAnchor(lines[i]); ok {
+			state = anchor.state()
+			break
+		}
+		rec, err := decodeRecord(lines[i])
+		if err != nil || rec.Hash == "" {
+			continue
+		}
+		state.advance(rec)
+		break
+	}
+	return state, complete, nil
+}
+
+// lastLineEnd возвращает длину файла до конца последней строки,
+// завершённой переводом строки, читая файл с конца окнами tailWindow
+func lastLineEnd(file *os.File, size int64) (int64, error) {
+	buf := make([]byte, min(size, tailWindow))
+	for end := size; end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
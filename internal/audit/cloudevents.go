@@ -0,0 +1,224 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Format формат сериализации событий аудита
+type Format string
+
+const (
+	// FormatLegacy исходный формат: объект Event, в HTTP — JSON массив
+	FormatLegacy Format = "legacy"
+	// FormatCloudEvents CloudEvents 1.0 в структурированном режиме
+	FormatCloudEvents Format = "cloudevents"
+	// FormatCloudEventsBinary CloudEvents 1.0 в бинарном режиме: атрибуты
+	// в заголовках ce-*, в теле только данные. Только для HTTP, в файле
+	// используется структурированный режим.
+	FormatCloudEventsBinary Format = "cloudevents-binary"
+)
+
+// ParseFormat разбирает формат из конфигурации; пустая строка — FormatLegacy
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return FormatLegacy, nil
+	case FormatLegacy, FormatCloudEvents, FormatCloudEventsBinary:
+		return f, nil
+	default:
+		return "", fmt.Errorf("audit: неизвестный формат %q", s)
+	}
+}
+
+const (
+	// CloudEventsSpecVersion версия спецификации CloudEvents
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsTypePrefix префикс атрибута type для действий аудита
+	CloudEventsTypePrefix = "com.github.popolzen.shortener."
+	// DefaultCloudEventsSource атрибут source, если он не задан
+	DefaultCloudEventsSource = "/shortener"
+
+	contentTypeJSON  = "application/json"
+	contentTypeBatch = "application/cloudevents-batch+json"
+)
+
+// eventTypes соответствие действий аудита атрибуту type CloudEvents
+var eventTypes = map[Action]string{
+	ActionShorten:         "link.created",
+	ActionBatchShorten:    "link.batch_created",
+	ActionFollow:          "link.followed",
+	ActionDeleteRequested: "link.delete_requested",
+	ActionDeleteApplied:   "link.deleted",
+	ActionUpdate:          "link.updated",
+	ActionStatsRead:       "stats.read",
+}
+
+// EventType возвращает атрибут type CloudEvents для действия.
+// Для действий без явного соответствия используется само имя действия.
+func EventType(action Action) string {
+	if t, ok := eventTypes[action]; ok {
+		return CloudEventsTypePrefix + t
+	}
+	return CloudEventsTypePrefix + string(action)
+}
+
+// CloudEvent событие аудита в конверте CloudEvents 1.0 (структурированный режим).
+//
+// Поля seq, prevhash и hash — расширения, которыми строка аудит-файла
+// связывается в цепочку; в HTTP доставках они не заполняются.
+type CloudEvent struct {
+	SpecVersion     string `json:"specversion"`
+	ID              string `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Subject         string `json:"subject,omitempty"`
+	Time            string `json:"time,omitempty"`
+	DataContentType string `json:"datacontenttype,omitempty"`
+	Seq             uint64 `json:"seq,omitempty"`
+	PrevHash        string `json:"prevhash,omitempty"`
+	Hash            string `json:"hash,omitempty"`
+	Data            Event  `json:"data"`
+}
+
+// Message одно HTTP-сообщение доставки
+type Message struct {
+	Header http.Header
+	Body   []byte
+	Events int // число событий в сообщении
+}
+
+// Serializer кодирует события аудита для файла и HTTP доставки
+type Serializer interface {
+	// EncodeRecord кодирует запись аудит-файла в одну строку без перевода строки
+	EncodeRecord(rec ChainedRecord) ([]byte, error)
+	// EncodeBatch кодирует батч событий в одно или несколько HTTP-сообщений
+	EncodeBatch(events []Event) ([]Message, error)
+}
+
+// NewSerializer возвращает сериализатор для формата. Source используется
+// как атрибут source CloudEvents, пустой — DefaultCloudEventsSource.
+func NewSerializer(format Format, source string) Serializer {
+	if source == "" {
+		source = DefaultCloudEventsSource
+	}
+	switch format {
+	case FormatCloudEvents:
+		return CloudEventsSerializer{Source: source}
+	case FormatCloudEventsBinary:
+		return CloudEventsSerializer{Source: source, Binary: true}
+	default:
+		return LegacySerializer{}
+	}
+}
+
+// LegacySerializer пишет Event как есть: строку ChainedRecord в файл
+// и JSON массив событий в HTTP
+type LegacySerializer struct{}
+
+func (LegacySerializer) EncodeRecord(rec ChainedRecord) ([]byte, error) {
+	return json.Marshal(rec)
+}
+
+func (LegacySerializer) EncodeBatch(events []Event) ([]Message, error) {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return nil, err
+	}
+	return []Message{{Header: jsonHeader(contentTypeJSON), Body: body, Events: len(events)}}, nil
+}
+
+// CloudEventsSerializer пишет события в конверте CloudEvents 1.0.
+//
+// В структурированном режиме батч отправляется одним сообщением
+// application/cloudevents-batch+json. В бинарном режиме каждое событие
+// отправляется отдельным сообщением с атрибутами в заголовках ce-*.
+type CloudEventsSerializer struct {
+	Source string
+	Binary bool
+}
+
+// envelope оборачивает событие в CloudEvent
+func (s CloudEventsSerializer) envelope(e Event) CloudEvent {
+	ce := CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              e.ID,
+		Source:          s.Source,
+		Type:            EventType(e.Action),
+		Subject:         e.ShortCode,
+		DataContentType: contentTypeJSON,
+		Data:            e,
+	}
+	if e.Timestamp != 0 {
+		ce.Time = time.Unix(e.Timestamp, 0).UTC().Format(time.RFC3339)
+	}
+	return ce
+}
+
+func (s CloudEventsSerializer) EncodeRecord(rec ChainedRecord) ([]byte, error) {
+	ce := s.envelope(rec.Event)
+	ce.Seq, ce.PrevHash, ce.Hash = rec.Seq, rec.PrevHash, rec.Hash
+	return json.Marshal(ce)
+}
+
+func (s CloudEventsSerializer) EncodeBatch(events []Event) ([]Message, error) {
+	if !s.Binary {
+		envelopes := make([]CloudEvent, len(events))
+		for i, e := range events {
+			envelopes[i] = s.envelope(e)
+		}
+		body, err := json.Marshal(envelopes)
+		if err != nil {
+			return nil, err
+		}
+		return []Message{{Header: jsonHeader(contentTypeBatch), Body: body, Events: len(events)}}, nil
+	}
+
+	messages := make([]Message, 0, len(events))
+	for _, e := range events {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		ce := s.envelope(e)
+		header := jsonHeader(contentTypeJSON)
+		header.Set("ce-specversion", ce.SpecVersion)
+		header.Set("ce-id", ce.ID)
+		header.Set("ce-source", ce.Source)
+		header.Set("ce-type", ce.Type)
+		if ce.Subject != "" {
+			header.Set("ce-subject", ce.Subject)
+		}
+		if ce.Time != "" {
+			header.Set("ce-time", ce.Time)
+		}
+		messages = append(messages, Message{Header: header, Body: body, Events: 1})
+	}
+	return messages, nil
+}
+
+// jsonHeader возвращает заголовки с заданным Content-Type
+func jsonHeader(contentType string) http.Header {
+	h := make(http.Header)
+	h.Set("Content-Type", contentType)
+	return h
+}
+
+// decodeRecord разбирает строку аудит-файла в любом из форматов.
+// Строки без цепочки возвращаются с пустым Hash.
+func decodeRecord(line []byte) (ChainedRecord, error) {
+	if !bytes.Contains(line, []byte(`"specversion"`)) {
+		var rec ChainedRecord
+		err := json.Unmarshal(line, &rec)
+		return rec, err
+	}
+
+	var ce CloudEvent
+	if err := json.Unmarshal(line, &ce); err != nil {
+		return ChainedRecord{}, err
+	}
+	return ChainedRecord{Seq: ce.Seq, PrevHash: ce.PrevHash, Hash: ce.Hash, Event: ce.Data}, nil
+}
@@ -0,0 +1,309 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{in: "", want: FormatLegacy},
+		{in: "legacy", want: FormatLegacy},
+		{in: "cloudevents", want: FormatCloudEvents},
+		{in: "cloudevents-binary", want: FormatCloudEventsBinary},
+		{in: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFormat(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEventType(t *testing.T) {
+	assert.Equal(t, "com.github.popolzen.shortener.link.created", EventType(ActionShorten))
+	assert.Equal(t, "com.github.popolzen.shortener.link.deleted", EventType(ActionDeleteApplied))
+	assert.Equal(t, "com.github.popolzen.shortener.custom", EventType(Action("custom")))
+
+	// У каждого действия свой тип
+	seen := make(map[string]Action)
+	for action := range eventTypes {
+		typ := EventType(action)
+		assert.NotContains(t, seen, typ, "тип %s у %s и %s", typ, action, seen[typ])
+		seen[typ] = action
+	}
+}
+
+func TestPublisher_AssignsStableID(t *testing.T) {
+	pub := NewPublisher()
+	first, second := &mockObserver{}, &mockObserver{}
+	pub.Subscribe(first)
+	pub.Subscribe(second)
+
+	pub.Publish(NewEvent(ActionShorten, "user", "https://a.com"))
+	preset := NewEvent(ActionShorten, "user", "https://b.com")
+	preset.ID = "fixed-id"
+	pub.Publish(preset)
+	require.NoError(t, pub.Close())
+
+	require.Len(t, first.events, 2)
+	require.Len(t, second.events, 2)
+	assert.NotEmpty(t, first.events[0].ID)
+	assert.Equal(t, first.events[0].ID, second.events[0].ID)
+	assert.Equal(t, "fixed-id", first.events[1].ID)
+}
+
+func testCloudEvent() Event {
+	return Event{
+		ID:        "evt-1",
+		Timestamp: 1700000000,
+		Action:    ActionShorten,
+		UserID:    "user-1",
+		URL:       "https://example.com",
+		ShortCode: "abc123",
+	}
+}
+
+func TestCloudEventsSerializer_Structured(t *testing.T) {
+	s := NewSerializer(FormatCloudEvents, "https://short.example")
+
+	messages, err := s.EncodeBatch([]Event{testCloudEvent(), testCloudEvent()})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "application/cloudevents-batch+json", messages[0].Header.Get("Content-Type"))
+	assert.Equal(t, 2, messages[0].Events)
+
+	var envelopes []map[string]any
+	require.NoError(t, json.Unmarshal(messages[0].Body, &envelopes))
+	require.Len(t, envelopes, 2)
+	ce := envelopes[0]
+	assert.Equal(t, "1.0", ce["specversion"])
+	assert.Equal(t, "evt-1", ce["id"])
+	assert.Equal(t, "https://short.example", ce["source"])
+	assert.Equal(t, "com.github.popolzen.shortener.link.created", ce["type"])
+	assert.Equal(t, "abc123", ce["subject"])
+	assert.Equal(t, "2023-11-14T22:13:20Z", ce["time"])
+	assert.Equal(t, "application/json", ce["datacontenttype"])
+	assert.NotContains(t, ce, "seq")
+
+	data := ce["data"].(map[string]any)
+	assert.Equal(t, "https://example.com", data["url"])
+}
+
+func TestCloudEventsSerializer_Binary(t *testing.T) {
+	s := NewSerializer(FormatCloudEventsBinary, "")
+
+	messages, err := s.EncodeBatch([]Event{testCloudEvent(), testCloudEvent()})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	msg := messages[0]
+	assert.Equal(t, 1, msg.Events)
+	assert.Equal(t, "application/json", msg.Header.Get("Content-Type"))
+	assert.Equal(t, "1.0", msg.Header.Get("ce-specversion"))
+	assert.Equal(t, "evt-1", msg.Header.Get("ce-id"))
+	assert.Equal(t, DefaultCloudEventsSource, msg.Header.Get("ce-source"))
+	assert.Equal(t, "com.github.popolzen.shortener.link.created", msg.Header.Get("ce-type"))
+	assert.Equal(t, "abc123", msg.Header.Get("ce-subject"))
+	assert.Equal(t, "2023-11-14T22:13:20Z", msg.Header.Get("ce-time"))
+
+	var data Event
+	require.NoError(t, json.Unmarshal(msg.Body, &data))
+	assert.Equal(t, testCloudEvent(), data)
+}
+
+func TestHTTPObserver_CloudEventsBinary(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+		bodies  []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	opts := fastHTTPOptions()
+	opts.Serializer = NewSerializer(FormatCloudEventsBinary, "test")
+	opts.Secret = []byte("secret")
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+
+	first := testCloudEvent()
+	second := testCloudEvent()
+	second.ID = "evt-2"
+	obs.Notify(first)
+	obs.Notify(second)
+	require.NoError(t, obs.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, headers, 2)
+	assert.Equal(t, "evt-1", headers[0].Get("ce-id"))
+	assert.Equal(t, "evt-2", headers[1].Get("ce-id"))
+
+	// Подпись считается по телу каждого запроса
+	v := NewVerifier([]byte("secret"))
+	for i := range headers {
+		assert.NoError(t, v.Verify(headers[i], []byte(bodies[i])))
+	}
+}
+
+func TestHTTPObserver_DeadLetterKeepsID(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		down = true
+		ids  []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ids = append(ids, r.Header.Get("ce-id"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	opts := fastHTTPOptions()
+	opts.MaxRetries = -1
+	opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.ndjson")
+	opts.Serializer = NewSerializer(FormatCloudEventsBinary, "")
+	obs := NewHTTPObserverWithOptions(server.URL, opts)
+	obs.Notify(testCloudEvent())
+	require.Eventually(t, func() bool { return obs.DeadLetterCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	require.Eventually(t, func() bool { return obs.DeadLetterCount() == 0 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, obs.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"evt-1"}, ids)
+}
+
+func TestVerifyChain_CloudEventsLegacyHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	serializer := CloudEventsSerializer{Source: "test"}
+
+	// Файл, начатый версией, которая хешировала только поля события
+	var content []byte
+	state := chainState{lastHash: GenesisHash}
+	for range 2 {
+		rec := ChainedRecord{Seq: state.seq + 1, PrevHash: state.lastHash, Event: testCloudEvent()}
+		rec.Hash, _ = rec.ComputeHash()
+		data, err := serializer.EncodeRecord(rec)
+		require.NoError(t, err)
+		content = append(append(content, data...), '\n')
+		state.advance(rec)
+	}
+	require.NoError(t, os.WriteFile(path, content, 0644))
+
+	obs, err := NewFileObserverWithOptions(path, FileOptions{Serializer: serializer})
+	require.NoError(t, err)
+	obs.Notify(testCloudEvent())
+	obs.Notify(testCloudEvent())
+	require.NoError(t, obs.Close())
+
+	res, err := VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 4, res.Records)
+
+	// После записи с хешем строки старая схема уже не принимается
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	all := strings.Split(strings.TrimRight(string(written), "\n"), "\n")
+	var ce CloudEvent
+	require.NoError(t, json.Unmarshal([]byte(all[3]), &ce))
+	rec := ChainedRecord{Seq: ce.Seq, PrevHash: ce.PrevHash, Event: ce.Data}
+	rec.Hash, _ = rec.ComputeHash()
+	forged, err := serializer.EncodeRecord(rec)
+	require.NoError(t, err)
+
+	_, err = VerifyChain(strings.NewReader(strings.Join(append(all[:3], string(forged)), "\n") + "\n"))
+	var chainErr *ChainError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, 4, chainErr.Line)
+}
+
+func TestFileObserver_CloudEventsFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	opts := FileOptions{Serializer: NewSerializer(FormatCloudEvents, "test")}
+
+	obs, err := NewFileObserverWithOptions(path, opts)
+	require.NoError(t, err)
+	obs.Notify(testCloudEvent())
+	require.NoError(t, obs.Close())
+
+	// После перезапуска цепочка продолжается с записи в формате CloudEvents
+	obs, err = NewFileObserverWithOptions(path, opts)
+	require.NoError(t, err)
+	obs.Notify(testCloudEvent())
+
+	page, err := obs.Query(Query{UserID: "user-1"})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 2)
+	assert.Equal(t, testCloudEvent(), page.Events[0])
+	require.NoError(t, obs.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	var ce CloudEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &ce))
+	assert.Equal(t, "1.0", ce.SpecVersion)
+	assert.Equal(t, uint64(2), ce.Seq)
+	assert.NotEmpty(t, ce.PrevHash)
+
+	res, err := VerifyChainFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Records)
+
+	// Правка данных внутри конверта обнаруживается
+	tampered := strings.Replace(string(content), "https://example.com", "https://evil.com", 1)
+	_, err = VerifyChain(strings.NewReader(tampered))
+	var chainErr *ChainError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, 1, chainErr.Line)
+
+	// Атрибуты конверта тоже входят в хеш
+	for _, attr := range []string{`"source":"test"`, `"type":"` + EventType(ActionShorten) + `"`} {
+		require.Contains(t, lines[1], attr)
+		tampered := lines[0] + "\n" + strings.Replace(lines[1], attr, attr[:len(attr)-1]+`-evil"`, 1) + "\n"
+		_, err = VerifyChain(strings.NewReader(tampered))
+		require.ErrorAs(t, err, &chainErr, attr)
+		assert.Equal(t, 2, chainErr.Line)
+	}
+}
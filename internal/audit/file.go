@@ -1,47 +1,350 @@
 package audit
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
-// FileObserver наблюдатель, пишущий в файл
+// FileOptions параметры ротации аудит-файла
+type FileOptions struct {
+	MaxSize    int64 // ротация по достижении размера в байтах, 0 — без ограничения
+	Daily      bool  // ротация при смене календарного дня
+	Compress   bool  // сжимать ротированные сегменты gzip
+	MaxBackups int   // сколько сегментов хранить, 0 — все
+
+	// Serializer формат строк файла, nil — LegacySerializer
+	Serializer Serializer
+}
+
+// FileObserver наблюдатель, пишущий в файл.
+//
+// Каждая строка файла — ChainedRecord: событие с порядковым номером
+// и хешем предыдущей записи. Правку или удаление строк можно обнаружить
+// через VerifyChain. При открытии существующего файла цепочка продолжается
+// с его последней записи, а если файл пуст — с последнего сегмента.
+// Строка, оборванная при падении процесса, при открытии отрезается.
+//
+// Файл ротируется по размеру или по дню: текущий файл переименовывается
+// в сегмент с меткой времени, и запись продолжается в новый файл с той же
+// цепочкой, начинающийся с якоря на сегмент. Reopen переоткрывает файл
+// после внешнего logrotate.
 type FileObserver struct {
-	file *os.File
-	mu   sync.Mutex
+	path  string
+	opts  FileOptions
+	file  *os.File
+	mu    sync.Mutex
+	chain chainState
+	size  int64
+	day   string
+
+	// now позволяет подменять время в тестах
+	now func() time.Time
+
+	// background отслеживает сжатие сегментов, чтобы Close его дождался,
+	// bgMu не даёт сжатию и удалению старых сегментов идти одновременно
+	background sync.WaitGroup
+	bgMu       sync.Mutex
+
+	// lastErr ошибка последней записи, errMu отделён от mu,
+	// чтобы проверка исправности не ждала записи
+	errMu   sync.Mutex
+	lastErr error
 }
 
 // NewFileObserver создаёт наблюдателя для записи в файл
 func NewFileObserver(path string) (*FileObserver, error) {
-	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return NewFileObserverWithOptions(path, FileOptions{})
+}
+
+// NewFileObserverWithOptions создаёт наблюдателя с ротацией файла
+func NewFileObserverWithOptions(path string, opts FileOptions) (*FileObserver, error) {
+	if opts.Serializer == nil {
+		opts.Serializer = LegacySerializer{}
+	}
+	f := &FileObserver{path: path, opts: opts, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	state, complete, err := resumeChain(f.file)
 	if err != nil {
+		f.file.Close()
 		return nil, err
 	}
-	return &FileObserver{file: file}, nil
+	if complete < f.size {
+		// Запись оборвалась при падении процесса. Оборванная строка не вошла
+		// в цепочку, а оставленная в файле навсегда ломала бы проверку,
+		// поэтому файл обрезается до последней полной строки.
+		zap.S().Warnf("audit file: последняя запись %s оборвана, отброшено %d байт", path, f.size-complete)
+		if err := f.file.Truncate(complete); err != nil {
+			f.file.Close()
+			return nil, err
+		}
+		f.size = complete
+	}
+	if state.seq == 0 {
+		if state, err = resumeFromSegments(path); err != nil {
+			zap.S().Errorf("audit file: не удалось продолжить цепочку из сегментов: %v", err)
+		}
+	}
+	f.chain = state
+
+	return f, nil
+}
+
+// open открывает файл по пути f.path и запоминает его размер и день.
+// При ошибке прежний f.file остаётся открытым.
+func (f *FileObserver) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.day = dayOf(info.ModTime())
+	if info.Size() == 0 {
+		f.day = dayOf(f.now())
+	}
+	return nil
+}
+
+// write пишет данные и учитывает размер файла
+func (f *FileObserver) write(data []byte) error {
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return err
 }
 
 // Notify записывает событие в файл
 func (f *FileObserver) Notify(event Event) {
+	if err := f.writeEvent(event); err != nil {
+		zap.S().Errorf("audit file: %v", err)
+	}
+}
+
+// DeliverBatch записывает события в файл по порядку и возвращает
+// число записанных до первой ошибки
+func (f *FileObserver) DeliverBatch(_ context.Context, events []Event) (int, error) {
+	for i, event := range events {
+		if err := f.writeEvent(event); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// writeEvent добавляет событие в цепочку и записывает его строку
+func (f *FileObserver) writeEvent(event Event) (err error) {
+	_, span := telemetry.Start(event.TraceContext(), "audit.file.write",
+		trace.WithAttributes(attribute.String("audit.action", string(event.Action))))
+	defer telemetry.End(span, &err)
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	data, err := json.Marshal(event)
+	rec, data, err := f.chain.next(event, f.opts.Serializer)
 	if err != nil {
-		log.Printf("audit file: ошибка сериализации: %v", err)
+		return fmt.Errorf("ошибка сериализации: %w", err)
+	}
+	data = append(data, '\n')
+
+	if f.needsRotation(len(data)) {
+		if err := f.rotate(); err != nil {
+			zap.S().Errorf("audit file: ошибка ротации: %v", err)
+		}
+	}
+
+	err = f.write(data)
+	f.setLastErr(err)
+	if err != nil {
+		return fmt.Errorf("ошибка записи: %w", err)
+	}
+	f.chain.advance(rec)
+	return nil
+}
+
+// setLastErr запоминает результат последней записи
+func (f *FileObserver) setLastErr(err error) {
+	f.errMu.Lock()
+	defer f.errMu.Unlock()
+	f.lastErr = err
+}
+
+// Health сообщает об ошибке, если последняя запись не удалась
+func (f *FileObserver) Health(context.Context) error {
+	f.errMu.Lock()
+	defer f.errMu.Unlock()
+	if f.lastErr != nil {
+		return fmt.Errorf("последняя запись не удалась: %w", f.lastErr)
+	}
+	return nil
+}
+
+// String возвращает описание наблюдателя
+func (f *FileObserver) String() string {
+	return "file " + f.path
+}
+
+// needsRotation сообщает, нужно ли ротировать файл перед записью n байт
+func (f *FileObserver) needsRotation(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+int64(n) > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.Daily && dayOf(f.now()) != f.day
+}
+
+// rotate переименовывает текущий файл в сегмент и открывает новый.
+// Вызывается под f.mu, поэтому конкурентные Notify ждут и строки не теряются.
+//
+// Прежний файл закрывается только после открытия нового: если ротация
+// не удалась, запись продолжается в прежний файл.
+func (f *FileObserver) rotate() error {
+	old := f.file
+	segment := segmentName(f.path, f.now(), f.chain.seq)
+	if err := os.Rename(f.path, segment); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		if renameErr := os.Rename(segment, f.path); renameErr != nil {
+			return fmt.Errorf("%w; возврат имени файла: %v", err, renameErr)
+		}
+		return err
+	}
+	if err := old.Close(); err != nil {
+		zap.S().Errorf("audit file: ошибка закрытия сегмента %s: %v", segment, err)
+	}
+	if f.chain.seq > 0 {
+		if err := f.writeAnchor(filepath.Base(segment)); err != nil {
+			return err
+		}
+	}
+
+	f.background.Add(1)
+	go func() {
+		defer f.background.Done()
+		f.bgMu.Lock()
+		defer f.bgMu.Unlock()
+
+		// Сегмент мог быть уже удалён по ретенции после более поздней ротации
+		if f.opts.Compress {
+			if err := compressSegment(segment); err != nil && !errors.Is(err, os.ErrNotExist) {
+				zap.S().Errorf("audit file: ошибка сжатия %s: %v", segment, err)
+			}
+		}
+		f.prune()
+	}()
+	return nil
+}
+
+// prune удаляет самые старые сегменты сверх MaxBackups
+func (f *FileObserver) prune() {
+	if f.opts.MaxBackups <= 0 {
+		return
+	}
+	segments, err := ListSegments(f.path)
+	if err != nil {
+		zap.S().Errorf("audit file: ошибка чтения сегментов: %v", err)
 		return
 	}
+	for len(segments) > f.opts.MaxBackups {
+		if err := os.Remove(segments[0]); err != nil {
+			zap.S().Errorf("audit file: ошибка удаления сегмента %s: %v", segments[0], err)
+		}
+		segments = segments[1:]
+	}
+}
 
-	data = append(data, '\n')
-	if _, err := f.file.Write(data); err != nil {
-		log.Printf("audit file: ошибка записи: %v", err)
+// Reopen закрывает и заново открывает файл по тому же пути.
+// Используется после того, как внешний logrotate переместил файл.
+//
+// Если новый файл пуст, первой строкой в него пишется якорь с именем
+// перенесённого файла, seq и хешем последней записи — по нему ChainFiles
+// находит перенесённый файл. Файл, перенесённый в другой каталог,
+// не найти, и проверка такой цепочки требует allowTruncated.
+//
+// Если новый файл открыть не удалось, запись продолжается в перенесённый.
+func (f *FileObserver) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev := f.file
+	old, statErr := prev.Stat()
+	if err := f.open(); err != nil {
+		return err
+	}
+	if err := prev.Close(); err != nil {
+		zap.S().Errorf("audit file: ошибка закрытия перенесённого файла: %v", err)
+	}
+	if f.size > 0 || f.chain.seq == 0 {
+		return nil
+	}
+
+	moved := ""
+	if statErr == nil {
+		moved = movedName(f.path, old)
+	}
+	if moved == "" {
+		zap.S().Warnf("audit file: перенесённый файл %s не найден в том же каталоге, якорь без ссылки на него", f.path)
+	}
+	return f.writeAnchor(moved)
+}
+
+// writeAnchor начинает новый файл якорем на последнюю запись цепочки,
+// унесённую в файл prev. Вызывается под f.mu.
+func (f *FileObserver) writeAnchor(prev string) error {
+	data, err := json.Marshal(chainAnchor{Seq: f.chain.seq, Hash: f.chain.lastHash, Prev: prev})
+	if err != nil {
+		return err
+	}
+	err = f.write(append(data, '\n'))
+	f.setLastErr(err)
+	return err
+}
+
+// movedName возвращает имя файла в каталоге path, которым стал файл old
+// после переноса, или пустую строку, если в каталоге его нет
+func movedName(path string, old os.FileInfo) string {
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == filepath.Base(path) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, e.Name()))
+		if err == nil && os.SameFile(old, info) {
+			return e.Name()
+		}
 	}
+	return ""
 }
 
-// Close закрывает файл
+// Close закрывает файл и дожидается сжатия сегментов
 func (f *FileObserver) Close() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return f.file.Close()
+	err := f.file.Close()
+	f.background.Wait()
+	return err
 }
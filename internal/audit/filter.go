@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+)
+
+// SampleAll ключ Filter.Sample для действий без собственной доли
+const SampleAll Action = "*"
+
+// Filter правила отбора событий для одного наблюдателя.
+//
+// Событие доходит до наблюдателя, если проходит все заданные условия.
+// Пустой Filter пропускает всё. Правила загружаются из JSON конфигурации:
+//
+//	{
+//	  "deny_actions": ["stats_read"],
+//	  "url_patterns": ["^https://(www\\.)?example\\.com/"],
+//	  "sample": {"follow": 0.01}
+//	}
+type Filter struct {
+	AllowActions []Action `json:"allow_actions,omitempty"` // только эти действия, пусто — все
+	DenyActions  []Action `json:"deny_actions,omitempty"`  // кроме этих действий
+	UserIDs      []string `json:"user_ids,omitempty"`      // только события этих пользователей
+	URLPatterns  []string `json:"url_patterns,omitempty"`  // регулярные выражения, достаточно совпадения с одним
+
+	// Sample доля событий действия, которая доходит до наблюдателя, от 0 до 1.
+	// Ключ SampleAll задаёт долю для остальных действий. Без записи — 1.
+	Sample map[Action]float64 `json:"sample,omitempty"`
+}
+
+// Rules скомпилированный Filter
+type Rules struct {
+	filter      Filter
+	urlPatterns []*regexp.Regexp
+}
+
+// NewRules проверяет и компилирует правила фильтра
+func NewRules(f Filter) (*Rules, error) {
+	r := &Rules{filter: f}
+	for _, p := range f.URLPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("audit: некорректный шаблон URL %q: %w", p, err)
+		}
+		r.urlPatterns = append(r.urlPatterns, re)
+	}
+	for action, rate := range f.Sample {
+		if math.IsNaN(rate) || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("audit: доля сэмплирования %s должна быть от 0 до 1, получено %v", action, rate)
+		}
+	}
+	return r, nil
+}
+
+// Allow сообщает, должно ли событие дойти до наблюдателя.
+// nil Rules пропускает все события.
+func (r *Rules) Allow(e Event) bool {
+	if r == nil {
+		return true
+	}
+	f := r.filter
+
+	if len(f.AllowActions) > 0 && !slices.Contains(f.AllowActions, e.Action) {
+		return false
+	}
+	if slices.Contains(f.DenyActions, e.Action) {
+		return false
+	}
+	if len(f.UserIDs) > 0 && !slices.Contains(f.UserIDs, e.UserID) {
+		return false
+	}
+	if len(r.urlPatterns) > 0 && !slices.ContainsFunc(r.urlPatterns, func(re *regexp.Regexp) bool {
+		return re.MatchString(e.URL)
+	}) {
+		return false
+	}
+	return sampled(e, r.sampleRate(e.Action))
+}
+
+// sampleRate возвращает долю сэмплирования для действия
+func (r *Rules) sampleRate(action Action) float64 {
+	if rate, ok := r.filter.Sample[action]; ok {
+		return rate
+	}
+	if rate, ok := r.filter.Sample[SampleAll]; ok {
+		return rate
+	}
+	return 1
+}
+
+// sampled решает, попадает ли событие в выборку с долей rate.
+//
+// Решение детерминировано по ID события: одно и то же событие получает
+// одинаковый ответ у всех наблюдателей с одинаковой долей, а повторная
+// доставка не меняет решения.
+func sampled(e Event, rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+	sum := sha256.Sum256([]byte(e.ID))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/float64(math.MaxUint64) < rate
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRules_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr bool
+	}{
+		{name: "пустой фильтр", filter: Filter{}},
+		{name: "корректные правила", filter: Filter{URLPatterns: []string{`^https://`}, Sample: map[Action]float64{ActionFollow: 0.01}}},
+		{name: "битый шаблон", filter: Filter{URLPatterns: []string{`(`}}, wantErr: true},
+		{name: "доля больше 1", filter: Filter{Sample: map[Action]float64{ActionFollow: 2}}, wantErr: true},
+		{name: "отрицательная доля", filter: Filter{Sample: map[Action]float64{SampleAll: -0.5}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRules(tt.filter)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRules_Allow(t *testing.T) {
+	event := Event{ID: "id-1", Action: ActionShorten, UserID: "u1", URL: "https://example.com/page"}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "пустой фильтр", filter: Filter{}, want: true},
+		{name: "действие в allow", filter: Filter{AllowActions: []Action{ActionShorten, ActionFollow}}, want: true},
+		{name: "действие не в allow", filter: Filter{AllowActions: []Action{ActionFollow}}, want: false},
+		{name: "действие в deny", filter: Filter{DenyActions: []Action{ActionShorten}}, want: false},
+		{name: "deny сильнее allow", filter: Filter{AllowActions: []Action{ActionShorten}, DenyActions: []Action{ActionShorten}}, want: false},
+		{name: "пользователь в списке", filter: Filter{UserIDs: []string{"u1"}}, want: true},
+		{name: "пользователь не в списке", filter: Filter{UserIDs: []string{"u2"}}, want: false},
+		{name: "URL подходит под один из шаблонов", filter: Filter{URLPatterns: []string{`other\.org`, `^https://example\.com/`}}, want: true},
+		{name: "URL не подходит", filter: Filter{URLPatterns: []string{`other\.org`}}, want: false},
+		{name: "нулевая доля", filter: Filter{Sample: map[Action]float64{ActionShorten: 0}}, want: false},
+		{name: "доля для другого действия", filter: Filter{Sample: map[Action]float64{ActionFollow: 0}}, want: true},
+		{name: "общая доля", filter: Filter{Sample: map[Action]float64{SampleAll: 0}}, want: false},
+		{name: "своя доля важнее общей", filter: Filter{Sample: map[Action]float64{SampleAll: 0, ActionShorten: 1}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := NewRules(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rules.Allow(event))
+		})
+	}
+}
+
+func TestRules_NilAllowsAll(t *testing.T) {
+	var rules *Rules
+	assert.True(t, rules.Allow(Event{Action: ActionFollow}))
+}
+
+func TestRules_SamplingRate(t *testing.T) {
+	rules, err := NewRules(Filter{Sample: map[Action]float64{ActionFollow: 0.01}})
+	require.NoError(t, err)
+
+	const n = 50000
+	passed := 0
+	for i := range n {
+		if rules.Allow(Event{ID: "event-" + strconv.Itoa(i), Action: ActionFollow}) {
+			passed++
+		}
+	}
+	assert.InDelta(t, 0.01, float64(passed)/n, 0.003)
+
+	// Решение по одному и тому же событию не меняется
+	e := Event{ID: "stable", Action: ActionFollow}
+	first := rules.Allow(e)
+	for range 10 {
+		assert.Equal(t, first, rules.Allow(e))
+	}
+}
+
+func TestFilter_FromJSON(t *testing.T) {
+	var f Filter
+	data := `{"deny_actions":["stats_read"],"url_patterns":["^https://"],"sample":{"follow":0.01,"*":0.5}}`
+	require.NoError(t, json.Unmarshal([]byte(data), &f))
+
+	assert.Equal(t, []Action{ActionStatsRead}, f.DenyActions)
+	assert.Equal(t, 0.01, f.Sample[ActionFollow])
+	assert.Equal(t, 0.5, f.Sample[SampleAll])
+}
+
+func TestPublisher_PerObserverRules(t *testing.T) {
+	pub := NewPublisher()
+	file, http := &mockObserver{}, &mockObserver{}
+
+	rules, err := NewRules(Filter{Sample: map[Action]float64{ActionFollow: 0}})
+	require.NoError(t, err)
+	pub.Subscribe(file)
+	pub.SubscribeWithRules(http, rules)
+
+	for range 5 {
+		pub.Publish(NewEvent(ActionFollow, "", "https://example.com"))
+	}
+	pub.Publish(NewEvent(ActionShorten, "u1", "https://example.com"))
+
+	stats := pub.Stats()
+	require.NoError(t, pub.Close())
+
+	assert.Len(t, file.events, 6)
+	require.Len(t, http.events, 1)
+	assert.Equal(t, ActionShorten, http.events[0].Action)
+	assert.Equal(t, uint64(0), stats[0].Filtered)
+	assert.Equal(t, uint64(5), stats[1].Filtered)
+}
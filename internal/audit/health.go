@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+)
+
+// HealthChecker реализуют наблюдатели, которые умеют сообщать о своей исправности
+type HealthChecker interface {
+	Health(ctx context.Context) error
+}
+
+// Health проверяет всех наблюдателей и возвращает ошибку по имени наблюдателя.
+//
+// Наблюдатель неисправен, если его очередь заполнена или его собственная
+// проверка вернула ошибку. Имя включает порядковый номер подписки, чтобы
+// различать наблюдателей одного типа.
+func (p *Publisher) Health(ctx context.Context) map[string]error {
+	p.mu.RLock()
+	queues := append([]*observerQueue(nil), p.queues...)
+	p.mu.RUnlock()
+
+	results := make(map[string]error, len(queues))
+	for i, q := range queues {
+		name := fmt.Sprintf("audit[%d] %s", i, describe(q.observer))
+		if len(q.events) >= cap(q.events) {
+			results[name] = fmt.Errorf("очередь заполнена: %d событий", cap(q.events))
+			continue
+		}
+		if hc, ok := q.observer.(HealthChecker); ok {
+			results[name] = hc.Health(ctx)
+			continue
+		}
+		results[name] = nil
+	}
+	return results
+}
+
+// describe возвращает описание наблюдателя для отчётов
+func describe(o Observer) string {
+	if s, ok := o.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", o)
+}
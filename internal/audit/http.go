@@ -1,50 +1,505 @@
 package audit
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"sync"
 	"time"
+
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultHTTPBatchSize     = 100
+	DefaultHTTPFlushInterval = time.Second
+	DefaultHTTPMaxRetries    = 5
+	DefaultHTTPBaseBackoff   = 100 * time.Millisecond
+	DefaultHTTPMaxBackoff    = 10 * time.Second
+	DefaultHTTPTimeout       = 5 * time.Second
 )
 
-// HTTPObserver наблюдатель, отправляющий на удалённый сервер
+// HTTPOptions параметры доставки событий на удалённый сервер
+type HTTPOptions struct {
+	BatchSize      int           // максимальное число событий в одном POST
+	FlushInterval  time.Duration // как часто отправлять неполный батч
+	MaxRetries     int           // число повторов после первой неудачной попытки, < 0 — без повторов
+	BaseBackoff    time.Duration // начальная задержка между повторами
+	MaxBackoff     time.Duration // верхняя граница задержки
+	Timeout        time.Duration // таймаут одного запроса
+	DeadLetterPath string        // NDJSON файл для недоставленных батчей, пусто — не сохранять
+	Secret         []byte        // общий секрет для подписи доставок, пусто — без подписи
+	Serializer     Serializer    // формат тела и заголовков, nil — LegacySerializer
+}
+
+// errPermanent ошибка доставки, которую бессмысленно повторять
+var errPermanent = errors.New("audit http: постоянная ошибка доставки")
+
+// HTTPObserver наблюдатель, отправляющий на удалённый сервер.
+//
+// События копятся в батч и отправляются по заполнении батча или по таймеру
+// в формате, заданном Serializer. При 5xx и сетевых ошибках батч повторяется с экспоненциальной
+// задержкой и джиттером, а после исчерпания попыток сохраняется в dead-letter
+// файл. Содержимое файла отправляется повторно, как только сервер снова
+// принимает события.
 type HTTPObserver struct {
 	url    string
 	client *http.Client
+	opts   HTTPOptions
+
+	mu         sync.Mutex
+	batch      []Event
+	deadLetter int // число событий в dead-letter файле
+
+	// lastErr ошибка последней доставки. Защищена отдельным мьютексом,
+	// чтобы проверка исправности не ждала повторов под mu.
+	errMu   sync.Mutex
+	lastErr error
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewHTTPObserver создаёт наблюдателя для отправки на HTTP endpoint
 func NewHTTPObserver(url string) *HTTPObserver {
-	return &HTTPObserver{
+	return NewHTTPObserverWithOptions(url, HTTPOptions{})
+}
+
+// NewHTTPObserverWithOptions создаёт наблюдателя с заданными параметрами доставки
+func NewHTTPObserverWithOptions(url string, opts HTTPOptions) *HTTPObserver {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultHTTPBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultHTTPFlushInterval
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	} else if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultHTTPMaxRetries
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = DefaultHTTPBaseBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultHTTPMaxBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultHTTPTimeout
+	}
+	if opts.Serializer == nil {
+		opts.Serializer = LegacySerializer{}
+	}
+
+	h := &HTTPObserver{
 		url: url,
 		client: &http.Client{
-			Timeout: 5 * time.Second,
+			Timeout: opts.Timeout,
 		},
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if opts.DeadLetterPath != "" {
+		// Остаток с прошлого запуска будет отправлен при первой возможности
+		events, err := readDeadLetter(opts.DeadLetterPath)
+		if err != nil {
+			zap.S().Errorf("audit http: ошибка чтения dead-letter файла: %v", err)
+		}
+		h.deadLetter = len(events)
 	}
+
+	go h.run()
+	return h
 }
 
-// Notify отправляет событие на удалённый сервер
+// Notify добавляет событие в батч и отправляет батч, если он заполнен
 func (h *HTTPObserver) Notify(event Event) {
-	data, err := json.Marshal(event)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.batch = append(h.batch, event)
+	if len(h.batch) >= h.opts.BatchSize {
+		h.flushLocked()
+	}
+}
+
+// DeliverBatch отправляет события сразу, минуя батч наблюдателя,
+// сообщениями не больше BatchSize с теми же повторами и возвращает
+// число событий, принятых сервером до первой неудачи.
+//
+// Недоставленные события не попадают в dead-letter: их повторит
+// вызывающий. Сообщения, отвергнутые сервером с 4xx, отбрасываются,
+// как и при Notify, и считаются доставленными.
+func (h *HTTPObserver) DeliverBatch(ctx context.Context, events []Event) (int, error) {
+	ctx, span := telemetry.Start(ctx, "audit.http.deliver",
+		trace.WithNewRoot(),
+		trace.WithLinks(eventLinks(events)...),
+		trace.WithAttributes(
+			attribute.String("audit.url", h.url),
+			attribute.Int("audit.events", len(events)),
+		),
+	)
+	n, err := h.deliverBatch(ctx, events)
+	telemetry.End(span, &err)
+	h.setLastErr(err)
+	if errors.Is(err, errPermanent) {
+		return n, nil
+	}
+	return n, err
+}
+
+// deliverBatch отправляет события сообщениями по BatchSize.
+// Отвергнутые сообщения пропускаются, их ошибка возвращается,
+// только если остальные доставлены.
+func (h *HTTPObserver) deliverBatch(ctx context.Context, events []Event) (int, error) {
+	n := 0
+	var rejected error
+	for n < len(events) {
+		chunk := events[n:min(n+h.opts.BatchSize, len(events))]
+		messages, err := h.opts.Serializer.EncodeBatch(chunk)
+		if err != nil {
+			rejected = fmt.Errorf("%w: ошибка сериализации: %v", errPermanent, err)
+			zap.S().Errorf("audit http: %d событий отброшено: %v", len(chunk), rejected)
+			n += len(chunk)
+			continue
+		}
+		for _, msg := range messages {
+			err := h.deliverMessage(ctx, msg)
+			switch {
+			case errors.Is(err, errPermanent):
+				rejected = err
+				zap.S().Errorf("audit http: %d событий отброшено: %v", msg.Events, err)
+			case err != nil:
+				return n, err
+			}
+			n += msg.Events
+		}
+	}
+	return n, rejected
+}
+
+// run периодически отправляет неполный батч и повторяет dead-letter
+func (h *HTTPObserver) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.mu.Lock()
+			if len(h.batch) > 0 {
+				h.flushLocked()
+			} else if h.deadLetter > 0 {
+				h.replayLocked()
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// flushLocked отправляет текущий батч. Вызывается под h.mu.
+func (h *HTTPObserver) flushLocked() {
+	if len(h.batch) == 0 {
+		return
+	}
+	events := h.batch
+	h.batch = nil
+
+	ctx, span := telemetry.Start(context.Background(), "audit.http.deliver",
+		trace.WithNewRoot(),
+		trace.WithLinks(eventLinks(events)...),
+		trace.WithAttributes(
+			attribute.String("audit.url", h.url),
+			attribute.Int("audit.events", len(events)),
+		),
+	)
+	err := h.deliver(ctx, events)
+	telemetry.End(span, &err)
+	h.setLastErr(err)
+	switch {
+	case err == nil:
+		if h.deadLetter > 0 {
+			h.replayLocked()
+		}
+	case errors.Is(err, errPermanent):
+		zap.S().Errorf("audit http: батч из %d событий отброшен: %v", len(events), err)
+	default:
+		zap.S().Errorf("audit http: не удалось доставить %d событий: %v", len(events), err)
+		h.spoolLocked(events)
+	}
+}
+
+// deliver отправляет события с повторами при временных ошибках.
+// Если сериализатор разбил батч на несколько сообщений и одно из них
+// не доставлено, батч целиком уходит в dead-letter: получатель
+// отбрасывает уже принятые события по их ID.
+func (h *HTTPObserver) deliver(ctx context.Context, events []Event) error {
+	messages, err := h.opts.Serializer.EncodeBatch(events)
+	if err != nil {
+		return fmt.Errorf("%w: ошибка сериализации: %v", errPermanent, err)
+	}
+
+	for _, msg := range messages {
+		if err := h.deliverMessage(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverMessage отправляет одно сообщение с повторами. Каждая попытка
+// ограничена Timeout; если следующая не успеет до дедлайна ctx, повторов
+// больше нет, и вызывающий сразу получает ошибку.
+func (h *HTTPObserver) deliverMessage(ctx context.Context, msg Message) error {
+	for attempt := 0; ; attempt++ {
+		err := h.post(ctx, msg, attempt)
+		if err == nil || errors.Is(err, errPermanent) || attempt >= h.opts.MaxRetries {
+			return err
+		}
+		wait := h.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait+h.opts.Timeout {
+			return err
+		}
+
+		select {
+		case <-h.stop:
+			// При закрытии не ждём, остаток уйдёт в dead-letter
+			return err
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// post выполняет одну попытку отправки в собственном span'е.
+// Контекст трассировки передаётся получателю в заголовке traceparent.
+func (h *HTTPObserver) post(ctx context.Context, msg Message, attempt int) (err error) {
+	ctx, span := telemetry.Start(ctx, "POST",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodPost,
+			semconv.URLFull(h.url),
+			semconv.HTTPRequestResendCount(attempt),
+			attribute.Int("audit.events", msg.Events),
+		),
+	)
+	defer telemetry.End(span, &err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(msg.Body))
 	if err != nil {
-		log.Printf("audit http: ошибка сериализации: %v", err)
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	for key, values := range msg.Header {
+		req.Header[key] = values
+	}
+	telemetry.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	// Каждая попытка подписывается заново, чтобы повтор не устаревал
+	if len(h.opts.Secret) > 0 {
+		signRequest(req, h.opts.Secret, msg.Body, time.Now())
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки: %w", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("сервер вернул %d", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("%w: сервер вернул %d", errPermanent, resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff возвращает задержку перед повтором с номером attempt.
+// Используется экспоненциальный рост с джиттером в диапазоне [d/2, d).
+func (h *HTTPObserver) backoff(attempt int) time.Duration {
+	d := h.opts.BaseBackoff << attempt
+	if d <= 0 || d > h.opts.MaxBackoff {
+		d = h.opts.MaxBackoff
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// spoolLocked дописывает недоставленные события в dead-letter файл
+func (h *HTTPObserver) spoolLocked(events []Event) {
+	if h.opts.DeadLetterPath == "" {
+		zap.S().Errorf("audit http: dead-letter не настроен, %d событий потеряно", len(events))
 		return
 	}
 
-	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(data))
+	file, err := os.OpenFile(h.opts.DeadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Printf("audit http: ошибка отправки: %v", err)
+		zap.S().Errorf("audit http: ошибка открытия dead-letter файла: %v", err)
 		return
 	}
-	defer resp.Body.Close()
+	defer file.Close()
 
-	if resp.StatusCode >= 400 {
-		log.Printf("audit http: сервер вернул %d", resp.StatusCode)
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			zap.S().Errorf("audit http: ошибка записи в dead-letter: %v", err)
+			return
+		}
+		h.deadLetter++
+	}
+	if err := w.Flush(); err != nil {
+		zap.S().Errorf("audit http: ошибка записи в dead-letter: %v", err)
 	}
 }
 
-// Close для HTTP ничего не делает
+// replayLocked отправляет события из dead-letter файла батчами по одной попытке.
+// Недоставленный остаток остаётся в файле.
+func (h *HTTPObserver) replayLocked() {
+	events, err := readDeadLetter(h.opts.DeadLetterPath)
+	if err != nil {
+		zap.S().Errorf("audit http: ошибка чтения dead-letter файла: %v", err)
+		return
+	}
+
+	ctx, span := telemetry.Start(context.Background(), "audit.http.replay",
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("audit.url", h.url),
+			attribute.Int("audit.events", len(events)),
+		),
+	)
+	defer span.End()
+
+	sent := 0
+replay:
+	for sent < len(events) {
+		end := min(sent+h.opts.BatchSize, len(events))
+		messages, err := h.opts.Serializer.EncodeBatch(events[sent:end])
+		if err != nil {
+			break
+		}
+		for _, msg := range messages {
+			if err := h.post(ctx, msg, 0); err != nil && !errors.Is(err, errPermanent) {
+				break replay
+			}
+			sent += msg.Events
+		}
+	}
+	if sent == 0 {
+		return
+	}
+
+	if err := writeDeadLetter(h.opts.DeadLetterPath, events[sent:]); err != nil {
+		zap.S().Errorf("audit http: ошибка перезаписи dead-letter файла: %v", err)
+		return
+	}
+	h.deadLetter = len(events) - sent
+	zap.S().Infof("audit http: из dead-letter доставлено %d событий, осталось %d", sent, h.deadLetter)
+}
+
+// setLastErr запоминает результат последней доставки
+func (h *HTTPObserver) setLastErr(err error) {
+	h.errMu.Lock()
+	defer h.errMu.Unlock()
+	h.lastErr = err
+}
+
+// Health сообщает об ошибке, если последняя доставка не удалась
+func (h *HTTPObserver) Health(context.Context) error {
+	h.errMu.Lock()
+	defer h.errMu.Unlock()
+	if h.lastErr != nil {
+		return fmt.Errorf("последняя доставка не удалась: %w", h.lastErr)
+	}
+	return nil
+}
+
+// String возвращает описание наблюдателя
+func (h *HTTPObserver) String() string {
+	return "http " + h.url
+}
+
+// DeadLetterCount возвращает число событий, ожидающих повторной доставки
+func (h *HTTPObserver) DeadLetterCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.deadLetter
+}
+
+// Close отправляет остаток батча и останавливает фоновую доставку.
+// То, что не удалось отправить, сохраняется в dead-letter файл.
 func (h *HTTPObserver) Close() error {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+		<-h.done
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.flushLocked()
+	})
 	return nil
 }
+
+// readDeadLetter читает события из NDJSON файла
+func readDeadLetter(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			zap.S().Warnf("audit http: пропущена битая строка dead-letter: %v", err)
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// writeDeadLetter атомарно перезаписывает NDJSON файл оставшимися событиями
+func writeDeadLetter(path string, events []Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultQueryLimit размер страницы выборки по умолчанию
+	DefaultQueryLimit = 100
+	// MaxQueryLimit максимальный размер страницы выборки
+	MaxQueryLimit = 1000
+)
+
+// Query фильтр выборки событий аудита.
+//
+// Пустые поля не ограничивают выборку. События возвращаются в порядке
+// записи, начиная со следующего после курсора After.
+type Query struct {
+	UserID string
+	Action Action
+	URL    string // подстрока URL
+	From   int64  // unix-время, включительно; 0 — без нижней границы
+	To     int64  // unix-время, включительно; 0 — без верхней границы
+	After  uint64 // курсор: номер последнего события предыдущей страницы
+	Limit  int
+}
+
+// Match сообщает, подходит ли событие под фильтр (без учёта курсора)
+func (q Query) Match(e Event) bool {
+	switch {
+	case q.UserID != "" && e.UserID != q.UserID:
+		return false
+	case q.Action != "" && e.Action != q.Action:
+		return false
+	case q.URL != "" && !strings.Contains(e.URL, q.URL):
+		return false
+	case q.From != 0 && e.Timestamp < q.From:
+		return false
+	case q.To != 0 && e.Timestamp > q.To:
+		return false
+	}
+	return true
+}
+
+// PageSize возвращает размер страницы в допустимых пределах
+func (q Query) PageSize() int {
+	if q.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	return min(q.Limit, MaxQueryLimit)
+}
+
+// Page страница результатов выборки
+type Page struct {
+	Events []Event
+	// Next курсор следующей страницы, 0 — страниц больше нет
+	Next uint64
+}
+
+// Querier хранилище аудита, поддерживающее выборку событий
+type Querier interface {
+	Query(q Query) (Page, error)
+}
+
+// Query выбирает события из ротированных сегментов и текущего файла.
+//
+// Курсором служит seq записи. Записи без цепочки (до её включения)
+// в выборку не попадают, поскольку у них нет номера.
+func (f *FileObserver) Query(q Query) (Page, error) {
+	readers, err := f.openForQuery(q.After)
+	if err != nil {
+		return Page{}, err
+	}
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+
+	limit := q.PageSize()
+	var page Page
+	for _, r := range readers {
+		// Берём на одну запись больше, чтобы знать, есть ли следующая страница
+		more, err := scanRecords(r, q, limit+1, &page)
+		if err != nil {
+			return Page{}, err
+		}
+		if more {
+			break
+		}
+	}
+
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+	} else {
+		page.Next = 0
+	}
+	return page, nil
+}
+
+// openForQuery открывает сегменты, которые могут содержать записи после seq after,
+// и текущий файл. Файлы открываются под f.mu, чтобы ротация не сдвинула их
+// посреди выборки; чтение идёт уже без блокировки.
+func (f *FileObserver) openForQuery(after uint64) ([]io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	segments, err := ListSegments(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var readers []io.ReadCloser
+	closeAll := func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}
+	for _, segment := range segments {
+		if last, ok := segmentLastSeq(segment); ok && last <= after {
+			continue
+		}
+		r, err := openSegment(segment)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		readers = append(readers, r)
+	}
+
+	current, err := os.Open(f.path)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	// Читаем только то, что записано к этому моменту
+	readers = append(readers, &limitedFile{Reader: io.LimitReader(current, f.size), file: current})
+	return readers, nil
+}
+
+// scanRecords добавляет в page подходящие записи из r, пока их не станет limit.
+// Возвращает true, если лимит достигнут.
+func scanRecords(r io.Reader, q Query, limit int, page *Page) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		rec, err := decodeRecord(scanner.Bytes())
+		if err != nil || rec.Hash == "" {
+			continue
+		}
+		if rec.Seq <= q.After || !q.Match(rec.Event) {
+			continue
+		}
+		page.Events = append(page.Events, rec.Event)
+		if len(page.Events) == limit {
+			return true, nil
+		}
+		page.Next = rec.Seq
+	}
+	return false, scanner.Err()
+}
+
+// segmentLastSeq извлекает seq последней записи из имени сегмента
+func segmentLastSeq(segment string) (uint64, bool) {
+	name := strings.TrimSuffix(segment, ".gz")
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(name[i+1:], 10, 64)
+	return seq, err == nil
+}
+
+// limitedFile ограничивает чтение файла и закрывает его
+type limitedFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (l *limitedFile) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// OverflowPolicy определяет поведение очереди наблюдателя при переполнении
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest вытесняет самое старое событие из очереди
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNew отбрасывает новое событие
+	OverflowDropNew OverflowPolicy = "drop-new"
+	// OverflowBlock блокирует публикацию до освобождения места
+	OverflowBlock OverflowPolicy = "block"
+)
+
+// ParseOverflowPolicy разбирает строковое значение политики переполнения.
+// Пустая строка соответствует OverflowDropNew.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case "":
+		return OverflowDropNew, nil
+	case OverflowDropOldest, OverflowDropNew, OverflowBlock:
+		return p, nil
+	default:
+		return "", fmt.Errorf("неизвестная политика переполнения: %q", s)
+	}
+}
+
+// QueueStats статистика очереди одного наблюдателя
+type QueueStats struct {
+	Observer string `json:"observer"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+	Filtered uint64 `json:"filtered"` // не прошли правила наблюдателя
+}
+
+// observerQueue ограниченная очередь событий с собственным воркером
+type observerQueue struct {
+	observer Observer
+	name     string // уникальное среди наблюдателей издателя, см. Publisher.Sinks
+	rules    *Rules
+	policy   OverflowPolicy
+	events   chan Event
+	done     chan struct{}
+	abort    chan struct{}
+	dropped  atomic.Uint64
+	filtered atomic.Uint64
+
+	// mu защищает вытеснение при OverflowDropOldest, чтобы два издателя
+	// не вытесняли события одновременно
+	mu sync.Mutex
+}
+
+func newObserverQueue(o Observer, rules *Rules, size int, policy OverflowPolicy) *observerQueue {
+	q := &observerQueue{
+		observer: o,
+		rules:    rules,
+		policy:   policy,
+		events:   make(chan Event, size),
+		done:     make(chan struct{}),
+		abort:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// run доставляет события наблюдателю, пока очередь не закрыта и не опустошена
+func (q *observerQueue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.abort:
+			return
+		case event, ok := <-q.events:
+			if !ok {
+				return
+			}
+			q.observer.Notify(event)
+		}
+	}
+}
+
+// enqueue кладёт событие в очередь согласно политике переполнения.
+// События, не прошедшие правила наблюдателя, в очередь не попадают.
+// При OverflowBlock ожидание места прерывается закрытием closing,
+// событие тогда считается отброшенным.
+func (q *observerQueue) enqueue(event Event, closing <-chan struct{}) {
+	if !q.rules.Allow(event) {
+		q.filtered.Add(1)
+		return
+	}
+
+	switch q.policy {
+	case OverflowBlock:
+		select {
+		case q.events <- event:
+		case <-closing:
+			q.dropped.Add(1)
+		}
+		return
+	case OverflowDropOldest:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for {
+			select {
+			case q.events <- event:
+				return
+			default:
+			}
+			select {
+			case <-q.events:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.events <- event:
+		default:
+			q.dropped.Add(1)
+		}
+	}
+}
+
+func (q *observerQueue) stats() QueueStats {
+	return QueueStats{
+		Observer: fmt.Sprintf("%T", q.observer),
+		Queued:   len(q.events),
+		Capacity: cap(q.events),
+		Dropped:  q.dropped.Load(),
+		Filtered: q.filtered.Load(),
+	}
+}
+
+// discard останавливает воркер и отбрасывает недоставленные события
+// после истечения дедлайна
+func (q *observerQueue) discard() {
+	close(q.abort)
+	if n := len(q.events); n > 0 {
+		q.dropped.Add(uint64(n))
+		zap.S().Warnf("audit: %T не успел доставить %d событий до закрытия", q.observer, n)
+	}
+}
@@ -0,0 +1,296 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// segmentSuffix суффикс ротированного сегмента: время ротации и seq последней записи
+var segmentSuffix = regexp.MustCompile(`^\.\d{8}T\d{6}\.\d{10,}(\.gz)?$`)
+
+// segmentName возвращает имя сегмента для файла path.
+// Имена сортируются лексикографически в порядке ротации.
+func segmentName(path string, t time.Time, lastSeq uint64) string {
+	return fmt.Sprintf("%s.%s.%010d", path, t.UTC().Format("20060102T150405"), lastSeq)
+}
+
+// dayOf возвращает календарный день t в локальной зоне
+func dayOf(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// ListSegments возвращает ротированные сегменты файла path от старых к новым
+func ListSegments(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	entries, err := os.ReadDir(filepath.Clean(dir + "."))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, base) {
+			continue
+		}
+		if segmentSuffix.MatchString(strings.TrimPrefix(name, base)) {
+			names[name] = true
+		}
+	}
+
+	var segments []string
+	for name := range names {
+		// Пока идёт сжатие, сегмент лежит в обоих видах — берём готовый .gz
+		if names[name+".gz"] {
+			continue
+		}
+		segments = append(segments, dir+name)
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// compressSegment сжимает сегмент в path.gz и удаляет исходный файл
+func compressSegment(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// openSegment открывает сегмент, распаковывая .gz
+func openSegment(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: zr, file: file}, nil
+}
+
+// gzipReadCloser закрывает и gzip.Reader, и файл под ним
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// resumeFromSegments восстанавливает цепочку по последней записи
+// самого нового сегмента. Используется, когда текущий файл пуст после ротации.
+func resumeFromSegments(path string) (chainState, error) {
+	segments, err := ListSegments(path)
+	if err != nil || len(segments) == 0 {
+		return chainState{lastHash: GenesisHash}, err
+	}
+	return fileState(segments[len(segments)-1])
+}
+
+// fileState читает файл целиком, распаковывая .gz, и возвращает позицию
+// цепочки после его последней записи или якоря
+func fileState(path string) (chainState, error) {
+	state := chainState{lastHash: GenesisHash}
+
+	r, err := openSegment(path)
+	if err != nil {
+		return state, err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if anchor, ok := decodeAnchor(scanner.Bytes()); ok {
+			state = anchor.state()
+			continue
+		}
+		rec, err := decodeRecord(scanner.Bytes())
+		if err != nil || rec.Hash == "" {
+			continue
+		}
+		state.advance(rec)
+	}
+	return state, scanner.Err()
+}
+
+// fileHead возвращает первую непустую строку файла, распаковывая .gz
+func fileHead(path string) ([]byte, error) {
+	r, err := openSegment(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			return bytes.Clone(line), nil
+		}
+	}
+	return nil, scanner.Err()
+}
+
+// ChainFiles восстанавливает список файлов цепочки, которая заканчивается
+// файлом path, от первого файла к path.
+//
+// Файл, начинающийся с якоря, продолжает файл, имя которого записано
+// в якоре; он ищется в каталоге path, в том числе сжатым в .gz. Если файл
+// с этим именем не заканчивается позицией якоря — например, внешний
+// logrotate перенумеровал файлы, — подходящий ищется среди остальных
+// файлов с тем же префиксом. Файлы прежних версий без якоря продолжают
+// предыдущий сегмент из ListSegments. Цепочка начинается с файла, первая
+// запись которого ссылается на GenesisHash.
+//
+// Если предыдущий файл не найден, возвращается *ChainError. При
+// allowTruncated вместо ошибки возвращается найденная часть цепочки
+// и truncated = true — так проверяются файлы, старые сегменты которых
+// удалены по ретенции.
+func ChainFiles(path string, allowTruncated bool) (files []string, truncated bool, err error) {
+	segments, err := ListSegments(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	files = []string{path}
+	seen := map[string]bool{path: true}
+	for cur := path; ; {
+		prev, missing, err := previousFile(path, cur, segments, seen)
+		switch {
+		case err != nil:
+			return nil, false, err
+		case prev != "":
+			files = append([]string{prev}, files...)
+			seen[prev] = true
+			cur = prev
+		case missing == "":
+			return files, false, nil
+		case allowTruncated:
+			return files, true, nil
+		default:
+			return nil, false, &ChainError{File: cur, Line: 1, Reason: missing}
+		}
+	}
+}
+
+// previousFile возвращает файл, который продолжает cur. Пустое имя
+// с пустой причиной missing означает, что cur начинает цепочку.
+func previousFile(path, cur string, segments []string, seen map[string]bool) (prev, missing string, err error) {
+	head, err := fileHead(cur)
+	if err != nil {
+		return "", "", err
+	}
+
+	if anchor, ok := decodeAnchor(head); ok {
+		if prev := findAnchored(path, anchor, seen); prev != "" {
+			return prev, "", nil
+		}
+		if anchor.Prev == "" {
+			return "", "якорь не указывает предыдущий файл", nil
+		}
+		return "", fmt.Sprintf("файл %s, который продолжает якорь, не найден", anchor.Prev), nil
+	}
+	if len(head) > 0 {
+		// Нечитаемую первую строку покажет проверка цепочки
+		if rec, err := decodeRecord(head); err != nil || rec.Hash == "" || rec.PrevHash == GenesisHash {
+			return "", "", nil
+		}
+	}
+
+	// Файлы прежних версий без якоря продолжают предыдущий сегмент
+	i := len(segments)
+	if cur != path {
+		i = slices.Index(segments, cur)
+	}
+	switch {
+	case i > 0:
+		return segments[i-1], "", nil
+	case len(head) == 0:
+		return "", "", nil
+	default:
+		return "", "предыдущий сегмент не найден", nil
+	}
+}
+
+// findAnchored ищет в каталоге path файл, последняя запись которого
+// совпадает с якорем: сначала по имени из якоря, затем среди остальных
+// файлов с префиксом path
+func findAnchored(path string, anchor chainAnchor, seen map[string]bool) string {
+	dir, base := filepath.Split(path)
+	var candidates []string
+	if anchor.Prev != "" {
+		name := dir + filepath.Base(anchor.Prev)
+		candidates = append(candidates, name, name+".gz")
+	}
+	if entries, err := os.ReadDir(filepath.Clean(dir + ".")); err == nil {
+		for _, e := range entries {
+			name := e.Name()
+			if !e.IsDir() && strings.HasPrefix(name, base) && !strings.HasSuffix(name, ".tmp") {
+				candidates = append(candidates, dir+name)
+			}
+		}
+	}
+
+	tried := make(map[string]bool, len(candidates))
+	for _, candidate := range candidates {
+		if seen[candidate] || tried[candidate] {
+			continue
+		}
+		tried[candidate] = true
+		state, err := fileState(candidate)
+		if err == nil && state.seq == anchor.Seq && state.lastHash == anchor.Hash {
+			return candidate
+		}
+	}
+	return ""
+}
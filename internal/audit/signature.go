@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader заголовок с подписью доставки
+	SignatureHeader = "X-Audit-Signature"
+	// TimestampHeader заголовок с временем подписи (unix, секунды)
+	TimestampHeader = "X-Audit-Timestamp"
+	// signaturePrefix префикс схемы подписи в SignatureHeader
+	signaturePrefix = "sha256="
+
+	// DefaultSignatureTolerance допустимое расхождение времени подписи
+	DefaultSignatureTolerance = 5 * time.Minute
+)
+
+var (
+	ErrSignatureMissing = errors.New("audit: подпись отсутствует")
+	ErrSignatureInvalid = errors.New("audit: неверная подпись")
+	ErrSignatureExpired = errors.New("audit: подпись вне допустимого окна времени")
+)
+
+// Sign вычисляет подпись тела доставки для заданного времени.
+//
+// Подписывается строка "<timestamp>.<body>", где timestamp — unix-время
+// в секундах. Результат имеет вид "sha256=<hex>".
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest выставляет заголовки подписи у исходящего запроса
+func signRequest(req *http.Request, secret []byte, body []byte, now time.Time) {
+	ts := now.Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, ts, body))
+}
+
+// Verifier проверяет подпись доставок аудита на стороне получателя.
+//
+// Пример использования:
+//
+//	v := audit.NewVerifier([]byte(secret))
+//	http.HandleFunc("/audit", func(w http.ResponseWriter, r *http.Request) {
+//	    body, err := v.VerifyRequest(r)
+//	    if err != nil {
+//	        w.WriteHeader(http.StatusUnauthorized)
+//	        return
+//	    }
+//	    // body содержит проверенный JSON массив событий
+//	})
+type Verifier struct {
+	Secret []byte
+	// Tolerance максимальный возраст подписи; 0 — проверка времени отключена
+	Tolerance time.Duration
+	// Now источник текущего времени, по умолчанию time.Now
+	Now func() time.Time
+}
+
+// NewVerifier создаёт Verifier с окном DefaultSignatureTolerance
+func NewVerifier(secret []byte) *Verifier {
+	return &Verifier{Secret: secret, Tolerance: DefaultSignatureTolerance}
+}
+
+// Verify проверяет подпись тела по значениям заголовков.
+func (v *Verifier) Verify(header http.Header, body []byte) error {
+	signature := header.Get(SignatureHeader)
+	timestamp := header.Get(TimestampHeader)
+	if signature == "" || timestamp == "" {
+		return ErrSignatureMissing
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: некорректное время %q", ErrSignatureInvalid, timestamp)
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return fmt.Errorf("%w: неизвестная схема", ErrSignatureInvalid)
+	}
+
+	expected := Sign(v.Secret, ts, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrSignatureInvalid
+	}
+
+	if v.Tolerance > 0 {
+		now := time.Now
+		if v.Now != nil {
+			now = v.Now
+		}
+		age := now().Sub(time.Unix(ts, 0))
+		if age > v.Tolerance || age < -v.Tolerance {
+			return ErrSignatureExpired
+		}
+	}
+	return nil
+}
+
+// VerifyRequest читает тело запроса и проверяет его подпись.
+// Тело запроса остаётся доступным для повторного чтения.
+func (v *Verifier) VerifyRequest(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("audit: ошибка чтения тела: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := v.Verify(r.Header, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
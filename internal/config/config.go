@@ -5,7 +5,11 @@ import (
 	"flag"
 	"log"
 	"os"
+	"slices"
+	"strings"
 
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/model"
 	"github.com/caarlos0/env"
 )
 
@@ -15,6 +19,29 @@ const (
 	DefaultFilePath      = "storage.json"
 	DefaultAuditFilePath = "audit_storage.json"
 	DefaultPprofAddr     = "localhost:6060"
+
+	DefaultSessionCookieName = "user_id"
+	DefaultSessionMaxAge     = 3600 * 24 * 30
+	DefaultSessionSameSite   = "lax"
+
+	DefaultLinkAccessMaxAge = 15 * 60
+
+	DefaultRateLimitPassword      = 10
+	DefaultRateLimitPasswordBurst = 5
+
+	DefaultQuotaMaxBodyBytes = 1 << 20
+
+	DefaultLogLevel              = "info"
+	DefaultLogEncoding           = "json"
+	DefaultLogSamplingInitial    = 100
+	DefaultLogSamplingThereafter = 100
+
+	DefaultTracingExporter    = "none"
+	DefaultTracingFile        = "traces.json"
+	DefaultTracingServiceName = "shortener"
+
+	DefaultCompressionMinSize    = 1024
+	DefaultDecompressionMaxBytes = 10 << 20
 )
 
 // Config содержит конфигурацию приложения
@@ -30,7 +57,93 @@ type Config struct {
 	EnableHTTPS   bool   `json:"enable_https" env:"ENABLE_HTTPS"`
 	CertFile      string `env:"CERT_FILE"`
 	KeyFile       string `env:"KEY_FILE"`
-	TrustedSubnet string `json:"trusted_subnet" env:"TRUSTED_SUBNET"`
+	TrustedSubnet string `json:"trusted_subnet" env:"TRUSTED_SUBNET"` // подсети IPv4 и IPv6 через запятую
+
+	// Адреса и подсети прокси, которым доверяем X-Forwarded-For и X-Real-IP
+	TrustedProxies []string `json:"trusted_proxies" env:"TRUSTED_PROXIES"` // через запятую
+
+	// Очереди наблюдателей аудита
+	AuditQueueSize    int    `json:"audit_queue_size" env:"AUDIT_QUEUE_SIZE"`
+	AuditOverflow     string `json:"audit_overflow" env:"AUDIT_OVERFLOW"`           // drop-oldest, drop-new или block
+	AuditCloseTimeout int    `json:"audit_close_timeout" env:"AUDIT_CLOSE_TIMEOUT"` // в секундах
+
+	// Доставка аудита на удалённый сервер
+	AuditBatchSize  int    `json:"audit_batch_size" env:"AUDIT_BATCH_SIZE"`
+	AuditMaxRetries int    `json:"audit_max_retries" env:"AUDIT_MAX_RETRIES"`
+	AuditDeadLetter string `json:"audit_dead_letter" env:"AUDIT_DEAD_LETTER"` // NDJSON файл недоставленных событий
+	AuditSecret     string `env:"AUDIT_SECRET"`                               // секрет подписи доставок
+
+	// Ротация аудит-файла
+	AuditMaxSizeMB   int  `json:"audit_max_size_mb" env:"AUDIT_MAX_SIZE_MB"` // 0 — без ротации по размеру
+	AuditRotateDaily bool `json:"audit_rotate_daily" env:"AUDIT_ROTATE_DAILY"`
+	AuditCompress    bool `json:"audit_compress" env:"AUDIT_COMPRESS"`
+	AuditMaxBackups  int  `json:"audit_max_backups" env:"AUDIT_MAX_BACKUPS"` // 0 — хранить все сегменты
+
+	// Формат событий аудита: legacy, cloudevents или cloudevents-binary (только HTTP)
+	AuditFileFormat string `json:"audit_file_format" env:"AUDIT_FILE_FORMAT"`
+	AuditHTTPFormat string `json:"audit_http_format" env:"AUDIT_HTTP_FORMAT"`
+	AuditSource     string `json:"audit_source" env:"AUDIT_SOURCE"` // атрибут source CloudEvents, по умолчанию BaseURL
+
+	// Дополнительные адреса аудита и правила отбора событий для наблюдателей.
+	// Правила задаются только в JSON конфигурации.
+	AuditURLs       []string                `json:"audit_urls" env:"AUDIT_URLS"` // через запятую
+	AuditFileFilter audit.Filter            `json:"audit_file_filter"`
+	AuditHTTPFilter audit.Filter            `json:"audit_http_filter"` // для адресов без своих правил
+	AuditURLFilters map[string]audit.Filter `json:"audit_url_filters"` // правила по адресу
+
+	// Ограничение частоты запросов по IP и пользователю: запросов в минуту
+	// и ёмкость корзины (0 — равна лимиту). Лимит 0 отключает ограничение.
+	RateLimitShorten       int `json:"rate_limit_shorten" env:"RATE_LIMIT_SHORTEN"`
+	RateLimitShortenBurst  int `json:"rate_limit_shorten_burst" env:"RATE_LIMIT_SHORTEN_BURST"`
+	RateLimitBatch         int `json:"rate_limit_batch" env:"RATE_LIMIT_BATCH"`
+	RateLimitBatchBurst    int `json:"rate_limit_batch_burst" env:"RATE_LIMIT_BATCH_BURST"`
+	RateLimitRedirect      int `json:"rate_limit_redirect" env:"RATE_LIMIT_REDIRECT"`
+	RateLimitRedirectBurst int `json:"rate_limit_redirect_burst" env:"RATE_LIMIT_REDIRECT_BURST"`
+	RateLimitDelete        int `json:"rate_limit_delete" env:"RATE_LIMIT_DELETE"`
+	RateLimitDeleteBurst   int `json:"rate_limit_delete_burst" env:"RATE_LIMIT_DELETE_BURST"`
+	RateLimitPassword      int `json:"rate_limit_password" env:"RATE_LIMIT_PASSWORD"` // неверных паролей ссылок с одного IP
+	RateLimitPasswordBurst int `json:"rate_limit_password_burst" env:"RATE_LIMIT_PASSWORD_BURST"`
+	RateLimitMaxKeys       int `json:"rate_limit_max_keys" env:"RATE_LIMIT_MAX_KEYS"` // число хранимых корзин, 0 — 100000
+
+	// Квоты пользователей, 0 — без ограничения. Переопределения для отдельных
+	// пользователей задаются только в JSON конфигурации.
+	QuotaMaxLinks     int                    `json:"quota_max_links" env:"QUOTA_MAX_LINKS"`
+	QuotaMaxBatch     int                    `json:"quota_max_batch" env:"QUOTA_MAX_BATCH"`
+	QuotaMaxBodyBytes int64                  `json:"quota_max_body_bytes" env:"QUOTA_MAX_BODY_BYTES"`
+	QuotaOverrides    map[string]model.Quota `json:"quota_overrides"` // по идентификатору пользователя
+
+	// Проверки готовности и остановка
+	HealthTimeout int `json:"health_timeout" env:"HEALTH_TIMEOUT"` // время на проверки /readyz в секундах, 0 — 2
+	ShutdownDelay int `json:"shutdown_delay" env:"SHUTDOWN_DELAY"` // пауза между снятием готовности и остановкой сервера в секундах
+
+	// Логирование. Уровень можно поменять на лету через /api/internal/log/level.
+	LogLevel              string   `json:"log_level" env:"LOG_LEVEL"`       // debug, info, warn или error
+	LogEncoding           string   `json:"log_encoding" env:"LOG_ENCODING"` // json или console
+	LogOutput             []string `json:"log_output" env:"LOG_OUTPUT"`     // stdout, stderr или пути к файлам через запятую
+	LogSamplingInitial    int      `json:"log_sampling_initial" env:"LOG_SAMPLING_INITIAL"`
+	LogSamplingThereafter int      `json:"log_sampling_thereafter" env:"LOG_SAMPLING_THEREAFTER"` // 0 — без сэмплирования
+
+	// Трассировка OpenTelemetry
+	TracingExporter    string  `json:"tracing_exporter" env:"TRACING_EXPORTER"` // none, stdout или file
+	TracingFile        string  `json:"tracing_file" env:"TRACING_FILE"`         // файл span'ов для экспортёра file
+	TracingServiceName string  `json:"tracing_service_name" env:"TRACING_SERVICE_NAME"`
+	TracingSampleRatio float64 `json:"tracing_sample_ratio" env:"TRACING_SAMPLE_RATIO"` // доля трасс от 0 до 1, 0 — все
+
+	// Сжатие ответов и распаковка запросов
+	CompressionMinSize    int      `json:"compression_min_size" env:"COMPRESSION_MIN_SIZE"`       // меньшие ответы не сжимаются
+	CompressionTypes      []string `json:"compression_types" env:"COMPRESSION_TYPES"`             // сжимаемые типы через запятую, "text/*" — все подтипы
+	DecompressionMaxBytes int64    `json:"decompression_max_bytes" env:"DECOMPRESSION_MAX_BYTES"` // предел распакованного тела запроса
+
+	// Политика сессионной куки
+	SessionCookieName   string `json:"session_cookie_name" env:"SESSION_COOKIE_NAME"`
+	SessionCookieDomain string `json:"session_cookie_domain" env:"SESSION_COOKIE_DOMAIN"`
+	SessionMaxAge       int    `json:"session_max_age" env:"SESSION_MAX_AGE"` // время жизни в секундах, 0 — без ограничения
+	SessionSliding      bool   `json:"session_sliding" env:"SESSION_SLIDING"` // true — продлевать куку на каждом запросе
+	SessionSecure       bool   `json:"session_secure" env:"SESSION_SECURE"`
+	SessionSameSite     string `json:"session_same_site" env:"SESSION_SAME_SITE"` // lax, strict или none
+
+	// Время жизни куки доступа к ссылке с паролем в секундах
+	LinkAccessMaxAge int `json:"link_access_max_age" env:"LINK_ACCESS_MAX_AGE"`
 }
 
 func NewConfig() *Config {
@@ -40,6 +153,30 @@ func NewConfig() *Config {
 		FilePath:   DefaultFilePath,
 		PprofAddr:  DefaultPprofAddr,
 		AuditFile:  DefaultAuditFilePath,
+
+		SessionCookieName: DefaultSessionCookieName,
+		SessionMaxAge:     DefaultSessionMaxAge,
+		SessionSliding:    true,
+		SessionSameSite:   DefaultSessionSameSite,
+
+		LinkAccessMaxAge: DefaultLinkAccessMaxAge,
+
+		RateLimitPassword:      DefaultRateLimitPassword,
+		RateLimitPasswordBurst: DefaultRateLimitPasswordBurst,
+
+		QuotaMaxBodyBytes: DefaultQuotaMaxBodyBytes,
+
+		LogLevel:              DefaultLogLevel,
+		LogEncoding:           DefaultLogEncoding,
+		LogSamplingInitial:    DefaultLogSamplingInitial,
+		LogSamplingThereafter: DefaultLogSamplingThereafter,
+
+		TracingExporter:    DefaultTracingExporter,
+		TracingFile:        DefaultTracingFile,
+		TracingServiceName: DefaultTracingServiceName,
+
+		CompressionMinSize:    DefaultCompressionMinSize,
+		DecompressionMaxBytes: DefaultDecompressionMaxBytes,
 	}
 
 	configFile := getConfigPath()
@@ -110,3 +247,48 @@ func (c Config) GetAuditFile() string {
 func (c Config) GetAuditURL() string {
 	return c.AuditURL
 }
+
+// GetAuditURLs возвращает все адреса аудита: AuditURL и AuditURLs без повторов
+func (c Config) GetAuditURLs() []string {
+	var urls []string
+	for _, u := range append([]string{c.AuditURL}, c.AuditURLs...) {
+		if u != "" && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// AuditFilterFor возвращает правила отбора для адреса аудита
+func (c Config) AuditFilterFor(url string) audit.Filter {
+	if f, ok := c.AuditURLFilters[url]; ok {
+		return f
+	}
+	return c.AuditHTTPFilter
+}
+
+// TrustedSubnets возвращает доверенные подсети из TrustedSubnet
+func (c Config) TrustedSubnets() []string {
+	var subnets []string
+	for _, s := range strings.Split(c.TrustedSubnet, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			subnets = append(subnets, s)
+		}
+	}
+	return subnets
+}
+
+// DefaultQuota возвращает квоты пользователей по умолчанию
+func (c Config) DefaultQuota() model.Quota {
+	return model.Quota{
+		MaxLinks:     c.QuotaMaxLinks,
+		MaxBatch:     c.QuotaMaxBatch,
+		MaxBodyBytes: c.QuotaMaxBodyBytes,
+	}
+}
+
+// CookieSecure сообщает, нужно ли выставлять атрибут Secure у сессионной куки.
+// При включённом HTTPS атрибут выставляется всегда.
+func (c Config) CookieSecure() bool {
+	return c.SessionSecure || c.EnableHTTPS
+}
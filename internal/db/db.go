@@ -3,7 +3,6 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"log"
 
 	"github.com/Popolzen/shortener/internal/config"
 	migration "github.com/Popolzen/shortener/migrations"
@@ -23,7 +22,6 @@ type Database struct {
 
 // NewDBConfig создает новую конфигурацию БД
 func NewDBConfig(c config.Config) DBConfig {
-	fmt.Println("Строка коннекта", c.DBurl)
 	return DBConfig{
 		DBurl: c.DBurl,
 	}
@@ -45,15 +43,12 @@ func NewDataBase(c config.Config, dbConf DBConfig) (*Database, error) {
 func (d *DBConfig) PingDB() error {
 	db, err := sql.Open("pgx", d.DBurl)
 	if err != nil {
-		log.Fatal("Ошибка при создании подключения:", err)
-		return err
+		return fmt.Errorf("ошибка при создании подключения: %w", err)
 	}
 
 	defer db.Close()
-	err = db.Ping()
-	if err != nil {
-		log.Fatal("Ошибка при подключении к БД:", err)
-		return err
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("ошибка при подключении к БД: %w", err)
 	}
 
 	return nil
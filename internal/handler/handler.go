@@ -3,30 +3,46 @@
 // Пакет предоставляет функции-обработчики для:
 //   - создания коротких ссылок (текстовый и JSON форматы)
 //   - получения оригинальных URL по коротким ссылкам
+//   - изменения настроек перенаправления ссылки
+//   - доступа к ссылкам, защищённым паролем
 //   - пакетного создания коротких ссылок
 //   - получения истории URL пользователя
 //   - асинхронного удаления URL
-//   - проверки доступности базы данных
+//   - проверки доступности базы данных, живости и готовности сервиса
+//   - выборки событий аудита
+//
+// Ошибки возвращаются в формате application/problem+json (RFC 7807) через
+// пакет problem; текстом — только если клиент предпочёл text/plain.
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Popolzen/shortener/internal/audit"
 	"github.com/Popolzen/shortener/internal/config"
-	"github.com/Popolzen/shortener/internal/db"
+	"github.com/Popolzen/shortener/internal/health"
 	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
 	"github.com/Popolzen/shortener/internal/model"
-	"github.com/Popolzen/shortener/internal/repository/database"
+	"github.com/Popolzen/shortener/internal/problem"
 	"github.com/Popolzen/shortener/internal/service/shortener"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+// errNoUserID middleware аутентификации не положил пользователя в контекст
+var errNoUserID = errors.New("нет идентификатора пользователя в контексте")
+
 // getUserID извлекает идентификатор пользователя из контекста запроса.
 //
 // Функция используется в хендлерах для получения userID, установленного
@@ -44,6 +60,17 @@ func getUserID(c *gin.Context) (string, bool) {
 	return uid, ok
 }
 
+// newAuditEvent создаёт событие аудита с данными о клиенте из запроса.
+func newAuditEvent(c *gin.Context, action audit.Action, userID, longURL, shortCode string) audit.Event {
+	event := audit.NewEvent(action, userID, longURL)
+	event.ShortCode = shortCode
+	event.ClientIP = clientip.FromContext(c)
+	event.UserAgent = c.Request.UserAgent()
+	event.RequestID = requestid.Get(c)
+	event.SpanContext = trace.SpanContextFromContext(c.Request.Context())
+	return event
+}
+
 // PostHandler создает обработчик для сокращения URL в текстовом формате.
 //
 // Эндпоинт: POST /
@@ -55,7 +82,9 @@ func getUserID(c *gin.Context) (string, bool) {
 // Коды ответа:
 //   - 201: URL успешно сокращен, возвращается короткая ссылка
 //   - 400: некорректное тело запроса
+//   - 403: превышена квота ссылок пользователя
 //   - 409: URL уже существует, возвращается существующая короткая ссылка
+//   - 413: тело запроса больше квоты пользователя
 //   - 500: внутренняя ошибка сервера
 //
 // Пример запроса:
@@ -73,21 +102,22 @@ func getUserID(c *gin.Context) (string, bool) {
 //	http://localhost:8080/abc123
 func PostHandler(urlService shortener.URLService, cfg *config.Config, auditPub *audit.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Читаем тело запроса
-		body, err := io.ReadAll(c.Request.Body)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Неправильное тело запроса")
+		userID, ok := getUserID(c)
+		if !ok {
+			problem.Write(c, errNoUserID)
 			return
 		}
 
-		userID, ok := getUserID(c)
-		if !ok {
-			c.AbortWithStatus(http.StatusInternalServerError)
+		// Читаем тело запроса
+		body, err := io.ReadAll(limitBody(c, urlService, userID))
+		if err != nil {
+			writeBodyError(c, err, urlService, userID)
 			return
 		}
 
 		longURL := string(body)
-		shortURL, err := urlService.Shorten(longURL, userID)
+		event := newAuditEvent(c, audit.ActionShorten, userID, longURL, "")
+		shortURL, err := urlService.ShortenAudited(c.Request.Context(), longURL, userID, model.LinkOptions{}, model.LinkMeta{}, event, auditPub)
 
 		if fullShortURL, isConflict := handleConflictError(err, cfg.BaseURL); isConflict {
 			c.Header("Content-Type", "text/plain")
@@ -96,7 +126,7 @@ func PostHandler(urlService shortener.URLService, cfg *config.Config, auditPub *
 			return
 		}
 		if err != nil {
-			c.String(http.StatusBadRequest, "Не удалось сгенерить короткую ссылку")
+			problem.Write(c, err)
 			return
 		}
 
@@ -104,8 +134,6 @@ func PostHandler(urlService shortener.URLService, cfg *config.Config, auditPub *
 		c.Header("Content-Type", "text/plain")
 		c.Header("Content-Length", strconv.Itoa(len(fullShortURL)))
 		c.String(http.StatusCreated, fullShortURL)
-
-		auditPub.Publish(audit.NewEvent(audit.ActionShorten, userID, longURL))
 	}
 
 }
@@ -114,69 +142,235 @@ func PostHandler(urlService shortener.URLService, cfg *config.Config, auditPub *
 //
 // Эндпоинт: GET /{id}
 //
-// Принимает идентификатор короткой ссылки и перенаправляет на оригинальный URL.
+// Принимает идентификатор короткой ссылки и перенаправляет на оригинальный URL
+// с кодом, заданным ссылке. Если у ссылки включён query_passthrough,
+// параметры запроса добавляются к оригинальному URL.
+//
+// Владелец может изменить ссылку, поэтому перенаправление отдаётся
+// с Cache-Control: private, no-cache — браузеры и прокси не кэшируют
+// даже 301 и 308, и изменение действует со следующего перехода.
+//
+// Ссылка с паролем перенаправляет только при куке доступа от
+// UnlockLinkHandler или верном пароле в заголовке X-Link-Password,
+// иначе отвечает HTML-формой ввода пароля, которая отправляется на POST /{id}.
 //
 // Коды ответа:
-//   - 307: перенаправление на оригинальный URL
+//   - 200: форма ввода пароля для ссылки с паролем
+//   - 301, 302, 307, 308: перенаправление на оригинальный URL, по умолчанию 307
+//   - 401: неверный пароль в X-Link-Password
 //   - 404: короткая ссылка не найдена
 //   - 410: ссылка была удалена пользователем
+//   - 429: слишком много неверных паролей с этого IP
 //
 // Пример запроса:
 //
-//	GET /abc123 HTTP/1.1
+//	GET /abc123?utm_source=ads HTTP/1.1
 //
 // Пример ответа:
 //
-//	HTTP/1.1 307 Temporary Redirect
-//	Location: https://example.com
-func GetHandler(urlService shortener.URLService, auditPub *audit.Publisher) gin.HandlerFunc {
+//	HTTP/1.1 308 Permanent Redirect
+//	Location: https://example.com/?utm_source=ads
+//	Cache-Control: private, no-cache
+func GetHandler(urlService shortener.URLService, auditPub *audit.Publisher, gate *LinkGate) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		shortURL := strings.TrimPrefix(c.Request.URL.Path, "/")
-		longURL, err := urlService.GetLongURL(shortURL)
+		link, err := urlService.GetLink(c.Request.Context(), shortURL)
 		if err != nil {
-			if errors.Is(err, model.ErrURLDeleted) { // ссылка deleted
-				c.Status(http.StatusGone) // 410
-				return
-			}
-			c.String(http.StatusNotFound, "Не нашли ссылку")
+			problem.Write(c, err)
+			return
+		}
+		if link.Protected() && !gate.allow(c, urlService, link) {
 			return
 		}
+		urlService.RecordClick(c.Request.Context(), shortURL)
 
-		c.Header("Location", longURL)
+		c.Header("Location", shortener.RedirectTarget(link, c.Request.URL.Query()))
+		c.Header("Cache-Control", "private, no-cache")
 		c.Header("Content-Type", "text/plain")
-		c.Status(http.StatusTemporaryRedirect)
+		c.Status(shortener.RedirectCode(link.LinkOptions))
 
 		userID, _ := getUserID(c)
-		auditPub.Publish(audit.NewEvent(audit.ActionFollow, userID, longURL))
+		auditPub.Publish(newAuditEvent(c, audit.ActionFollow, userID, link.OriginalURL, shortURL))
+	}
+}
+
+// UpdateLinkHandler создает обработчик изменения ссылки пользователя.
+//
+// Эндпоинт: PATCH /api/user/urls/{id}
+// Content-Type: application/json
+//
+// Меняет только переданные поля: original_url, redirect_code (301, 302,
+// 307 или 308), query_passthrough, title, note и tags. Список tags заменяет
+// все метки ссылки, пустой список их удаляет. Изменение URL или настроек
+// сохраняется новой версией ссылки, изменение только описания версию
+// не создаёт. Поле password задаёт новый пароль ссылки, пустая строка
+// снимает пароль; пароль в историю не попадает и версию не создаёт.
+// Каждое изменение публикуется в аудит действием update.
+// Возвращает ссылку после изменения с номером версии и действующим кодом
+// перенаправления.
+//
+// Коды ответа:
+//   - 200: ссылка изменена
+//   - 400: некорректный JSON, пустой URL, код перенаправления, описание или пароль, нет изменяемых полей
+//   - 404: ссылки нет или она принадлежит другому пользователю
+//   - 409: новый оригинальный URL уже сокращён
+//   - 410: ссылка удалена
+//   - 413: тело запроса больше квоты пользователя
+//   - 500: внутренняя ошибка сервера
+//
+// Пример запроса:
+//
+//	PATCH /api/user/urls/abc123 HTTP/1.1
+//	Content-Type: application/json
+//
+//	{
+//	  "original_url": "https://example.com/new-landing",
+//	  "redirect_code": 308,
+//	  "tags": ["landing"]
+//	}
+//
+// Пример ответа:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//
+//	{
+//	  "short_url": "http://localhost:8080/abc123",
+//	  "original_url": "https://example.com/new-landing",
+//	  "version": 2,
+//	  "redirect_code": 308,
+//	  "query_passthrough": false,
+//	  "tags": ["landing"]
+//	}
+func UpdateLinkHandler(urlService shortener.URLService, cfg *config.Config, auditPub *audit.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := getUserID(c)
+		if !ok {
+			problem.Write(c, errNoUserID)
+			return
+		}
+
+		var update model.LinkUpdate
+		if err := json.NewDecoder(limitBody(c, urlService, userID)).Decode(&update); err != nil {
+			writeBodyError(c, err, urlService, userID)
+			return
+		}
+
+		event := newAuditEvent(c, audit.ActionUpdate, userID, "", c.Param("id"))
+		link, err := urlService.UpdateLinkAudited(c.Request.Context(), userID, c.Param("id"), update, event, auditPub)
+		if err != nil {
+			problem.Write(c, err)
+			return
+		}
+
+		link.ShortURL = cfg.BaseURL + "/" + link.ShortURL
+		link.RedirectCode = shortener.RedirectCode(link.LinkOptions)
+		c.JSON(http.StatusOK, link)
 	}
 }
 
-// GetUserURLsHandler создает обработчик для получения всех URL пользователя.
+// LinkHistoryHandler создает обработчик истории изменений ссылки пользователя.
+//
+// Эндпоинт: GET /api/user/urls/{id}/history
+//
+// Возвращает все версии ссылки от первой к последней, последняя — текущая.
+// История удалённой ссылки тоже доступна владельцу.
+//
+// Коды ответа:
+//   - 200: успешно, возвращается JSON массив версий
+//   - 404: ссылки нет или она принадлежит другому пользователю
+//   - 500: внутренняя ошибка сервера
+//
+// Пример ответа:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//
+//	[
+//	  {
+//	    "version": 1,
+//	    "original_url": "https://example.com",
+//	    "created_at": "2025-03-01T10:00:00Z",
+//	    "redirect_code": 0,
+//	    "query_passthrough": false
+//	  },
+//	  {
+//	    "version": 2,
+//	    "original_url": "https://example.com/new-landing",
+//	    "created_at": "2025-04-12T08:30:00Z",
+//	    "redirect_code": 308,
+//	    "query_passthrough": false
+//	  }
+//	]
+func LinkHistoryHandler(urlService shortener.URLService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := getUserID(c)
+		if !ok {
+			problem.Write(c, errNoUserID)
+			return
+		}
+
+		versions, err := urlService.GetLinkHistory(c.Request.Context(), userID, c.Param("id"))
+		if err != nil {
+			problem.Write(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+// GetUserURLsHandler создает обработчик для получения ссылок пользователя.
 //
 // Эндпоинт: GET /api/user/urls
 //
-// Возвращает список всех сокращенных URL, созданных текущим пользователем.
-// Требуется валидная cookie аутентификации.
+// Возвращает страницу сокращенных URL, созданных текущим пользователем.
+// Требуется валидная cookie аутентификации. Если есть следующая страница,
+// ответ содержит заголовок Link с rel="next" — тот же запрос с курсором.
+//
+// Параметры запроса (все необязательны):
+//   - limit: размер страницы, по умолчанию 100, не больше 1000
+//   - cursor: курсор из заголовка Link предыдущей страницы
+//   - sort: created_at (по умолчанию) или clicks
+//   - order: desc (по умолчанию) или asc
+//   - url: подстрока оригинального URL без учёта регистра
+//   - tag: метка ссылки без учёта регистра
+//   - deleted: true — только удалённые, false — только активные
+//   - from, to: границы времени создания включительно, RFC 3339 или unix-время в секундах
 //
 // Коды ответа:
 //   - 200: успешно, возвращается JSON массив с URL
-//   - 204: у пользователя нет сохраненных URL
+//   - 204: под запрос не попала ни одна ссылка
+//   - 400: некорректный параметр или курсор от другой сортировки
 //   - 401: невалидная cookie аутентификации
 //   - 500: внутренняя ошибка сервера
 //
+// Пример запроса:
+//
+//	GET /api/user/urls?limit=2&sort=clicks&url=example HTTP/1.1
+//
 // Пример ответа:
 //
 //	HTTP/1.1 200 OK
 //	Content-Type: application/json
+//	Link: <http://localhost:8080/api/user/urls?cursor=eyJzIjoiY2xpY2tz...&limit=2&sort=clicks&url=example>; rel="next"
 //
 //	[
 //	  {
 //	    "short_url": "http://localhost:8080/abc123",
-//	    "original_url": "https://example.com"
+//	    "original_url": "https://example.com",
+//	    "created_at": "2025-03-01T10:00:00Z",
+//	    "clicks": 42,
+//	    "is_deleted": false,
+//	    "title": "Главная",
+//	    "tags": ["docs", "work"]
 //	  },
 //	  {
 //	    "short_url": "http://localhost:8080/def456",
-//	    "original_url": "https://google.com"
+//	    "original_url": "https://example.org",
+//	    "created_at": "2025-02-11T08:15:00Z",
+//	    "clicks": 7,
+//	    "is_deleted": false,
+//	    "protected": true
 //	  }
 //	]
 func GetUserURLsHandler(urlService shortener.URLService, cfg *config.Config) gin.HandlerFunc {
@@ -188,46 +382,145 @@ func GetUserURLsHandler(urlService shortener.URLService, cfg *config.Config) gin
 
 		// Если была кука, но она невалидная - 401
 		if hadCookie.(bool) && !cookieWasValid.(bool) {
-			c.AbortWithStatus(http.StatusUnauthorized)
+			problem.Write(c, problem.New(problem.CodeUnauthorized, http.StatusUnauthorized, "Невалидная кука сессии"))
 			return
 		}
 
 		// Получаем userID из контекста
 		userID, ok := getUserID(c)
 		if !ok {
-			c.AbortWithStatus(http.StatusInternalServerError)
+			problem.Write(c, errNoUserID)
+			return
+		}
+
+		q, err := parseUserURLsQuery(c)
+		if err != nil {
+			problem.Write(c, err)
 			return
 		}
 
 		// Получаем отформатированные URL через сервис
-		urls, err := urlService.GetFormattedUserURLs(userID, cfg.BaseURL)
+		page, err := urlService.GetFormattedUserURLs(c.Request.Context(), userID, cfg.BaseURL, q)
 		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
+			problem.Write(c, err)
 			return
 		}
 
 		// Если URL нет - возвращаем 204 No Content
-		if len(urls) == 0 {
+		if len(page.URLs) == 0 {
 			c.Status(http.StatusNoContent)
 			return
 		}
 
+		if page.NextCursor != "" {
+			next := c.Request.URL.Query()
+			next.Set("cursor", page.NextCursor)
+			c.Header("Link", "<"+cfg.BaseURL+c.Request.URL.Path+"?"+next.Encode()+`>; rel="next"`)
+		}
+
 		// Возвращаем список URL
-		c.JSON(http.StatusOK, urls)
+		c.JSON(http.StatusOK, page.URLs)
 	}
 }
 
+// GetUserTagsHandler создает обработчик списка меток пользователя.
+//
+// Эндпоинт: GET /api/user/tags
+//
+// Возвращает метки активных ссылок пользователя с числом ссылок,
+// по убыванию числа, при равенстве — по алфавиту. Если меток нет,
+// возвращается пустой массив.
+//
+// Коды ответа:
+//   - 200: успешно, возвращается JSON массив меток
+//   - 500: внутренняя ошибка сервера
+//
+// Пример ответа:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//
+//	[
+//	  {"tag": "work", "count": 12},
+//	  {"tag": "docs", "count": 3}
+//	]
+func GetUserTagsHandler(urlService shortener.URLService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := getUserID(c)
+		if !ok {
+			problem.Write(c, errNoUserID)
+			return
+		}
+
+		tags, err := urlService.GetUserTags(c.Request.Context(), userID)
+		if err != nil {
+			problem.Write(c, err)
+			return
+		}
+		if tags == nil {
+			tags = []model.TagCount{}
+		}
+		c.JSON(http.StatusOK, tags)
+	}
+}
+
+// parseUserURLsQuery разбирает параметры выборки ссылок пользователя.
+// Возвращает *model.ValidationError с именем параметра.
+func parseUserURLsQuery(c *gin.Context) (model.URLListQuery, error) {
+	q := model.URLListQuery{
+		Sort:   model.URLSort(c.Query("sort")),
+		Cursor: c.Query("cursor"),
+		URL:    c.Query("url"),
+		Tag:    c.Query("tag"),
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+		q.Desc = true
+	case "asc":
+	default:
+		return q, &model.ValidationError{Field: "order", Message: "ожидается asc или desc"}
+	}
+
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, &model.ValidationError{Field: "limit", Message: "ожидается положительное число"}
+		}
+	}
+	if v := c.Query("deleted"); v != "" {
+		deleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, &model.ValidationError{Field: "deleted", Message: "ожидается true или false"}
+		}
+		q.Deleted = &deleted
+	}
+	if q.CreatedFrom, err = parseQueryTime(c.Query("from")); err != nil {
+		return q, &model.ValidationError{Field: "from", Message: "ожидается время в RFC 3339 или unix-секундах"}
+	}
+	if q.CreatedTo, err = parseQueryTime(c.Query("to")); err != nil {
+		return q, &model.ValidationError{Field: "to", Message: "ожидается время в RFC 3339 или unix-секундах"}
+	}
+	return q, nil
+}
+
 // PostHandlerJSON создает обработчик для сокращения URL в JSON формате.
 //
 // Эндпоинт: POST /api/shorten
 // Content-Type: application/json
 //
 // Принимает JSON с оригинальным URL и возвращает JSON с короткой ссылкой.
+// Необязательные поля redirect_code (301, 302, 307 или 308, по умолчанию 307)
+// и query_passthrough задают настройки перенаправления, title, note и tags —
+// описание ссылки для владельца. Поле password закрывает ссылку паролем:
+// вместо перенаправления GET /{id} покажет форму ввода пароля.
 //
 // Коды ответа:
 //   - 201: URL успешно сокращен
-//   - 400: некорректный JSON в теле запроса
+//   - 400: некорректный JSON в теле запроса, описание или пароль
+//   - 403: превышена квота ссылок пользователя
 //   - 409: URL уже существует
+//   - 413: тело запроса больше квоты пользователя
 //   - 500: внутренняя ошибка сервера
 //
 // Пример запроса:
@@ -236,7 +529,10 @@ func GetUserURLsHandler(urlService shortener.URLService, cfg *config.Config) gin
 //	Content-Type: application/json
 //
 //	{
-//	  "url": "https://example.com"
+//	  "url": "https://example.com",
+//	  "redirect_code": 301,
+//	  "title": "Документация",
+//	  "tags": ["docs", "work"]
 //	}
 //
 // Пример ответа:
@@ -251,18 +547,26 @@ func PostHandlerJSON(urlService shortener.URLService, cfg *config.Config, auditP
 	return func(c *gin.Context) {
 		var request model.URL
 
-		if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
-			c.String(http.StatusBadRequest, "Неправильное тело запроса")
+		userID, ok := getUserID(c)
+		if !ok {
+			problem.Write(c, errNoUserID)
 			return
 		}
 
-		userID, ok := getUserID(c)
-		if !ok {
-			c.AbortWithStatus(http.StatusInternalServerError)
+		if err := json.NewDecoder(limitBody(c, urlService, userID)).Decode(&request); err != nil {
+			writeBodyError(c, err, urlService, userID)
 			return
 		}
 
-		shortURL, err := urlService.Shorten(request.URL, userID)
+		passwordHash, err := shortener.HashPassword(request.Password)
+		if err != nil {
+			problem.Write(c, err)
+			return
+		}
+		opts := model.LinkOptions{RedirectCode: request.RedirectCode, QueryPassthrough: request.QueryPassthrough, PasswordHash: passwordHash}
+		meta := model.LinkMeta{Title: request.Title, Note: request.Note, Tags: request.Tags}
+		event := newAuditEvent(c, audit.ActionShorten, userID, request.URL, "")
+		shortURL, err := urlService.ShortenAudited(c.Request.Context(), request.URL, userID, opts, meta, event, auditPub)
 
 		// Проверяем, является ли ошибка конфликтом URL
 		if fullShortURL, isConflict := handleConflictError(err, cfg.BaseURL); isConflict {
@@ -273,9 +577,8 @@ func PostHandlerJSON(urlService shortener.URLService, cfg *config.Config, auditP
 			c.JSON(http.StatusConflict, response)
 			return
 		}
-
 		if err != nil {
-			c.String(http.StatusBadRequest, "Не удалось сгенерить короткую ссылку")
+			problem.Write(c, err)
 			return
 		}
 
@@ -287,8 +590,6 @@ func PostHandlerJSON(urlService shortener.URLService, cfg *config.Config, auditP
 		c.Header("Content-Type", "application/json")
 		c.JSON(http.StatusCreated, response)
 		c.Header("Content-Length", strconv.Itoa(len(fullShortURL)))
-
-		auditPub.Publish(audit.NewEvent(audit.ActionShorten, userID, request.URL))
 	}
 
 }
@@ -297,11 +598,12 @@ func PostHandlerJSON(urlService shortener.URLService, cfg *config.Config, auditP
 //
 // Эндпоинт: GET /ping
 //
-// Выполняет проверку подключения к базе данных.
+// Выполняет проверку подключения к базе данных через пул репозитория.
+// Если сервис работает без БД, ping равен nil и ответ всегда 500.
 //
 // Коды ответа:
 //   - 200: база данных доступна
-//   - 500: база данных недоступна
+//   - 500: база данных недоступна или не настроена
 //
 // Пример запроса:
 //
@@ -310,13 +612,18 @@ func PostHandlerJSON(urlService shortener.URLService, cfg *config.Config, auditP
 // Пример ответа:
 //
 //	HTTP/1.1 200 OK
-func PingHandler(dbconf db.DBConfig) gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		err := dbconf.PingDB()
-		if err != nil {
-			ctx.Status(http.StatusInternalServerError)
+func PingHandler(ping health.CheckFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ping == nil {
+			c.Status(http.StatusInternalServerError)
+			return
 		}
-		ctx.Status(http.StatusOK)
+		if err := ping(c.Request.Context()); err != nil {
+			zap.S().Errorf("Ошибка проверки БД: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusOK)
 	}
 }
 
@@ -326,11 +633,14 @@ func PingHandler(dbconf db.DBConfig) gin.HandlerFunc {
 // Content-Type: application/json
 //
 // Принимает массив URL для сокращения и возвращает массив результатов.
-// Каждый элемент связан через correlation_id.
+// Каждый элемент связан через correlation_id и может задать redirect_code,
+// query_passthrough, title, note, tags и password, как в POST /api/shorten.
 //
 // Коды ответа:
 //   - 201: все URL успешно сокращены
-//   - 400: некорректный JSON в теле запроса
+//   - 400: некорректный JSON в теле запроса или описание в пакете
+//   - 403: пакет превысит квоту ссылок пользователя
+//   - 413: пакет или тело запроса больше квоты пользователя
 //   - 500: внутренняя ошибка сервера
 //
 // Пример запроса:
@@ -364,34 +674,34 @@ func PingHandler(dbconf db.DBConfig) gin.HandlerFunc {
 //	    "short_url": "http://localhost:8080/def456"
 //	  }
 //	]
-func BatchHandler(urlService shortener.URLService, cfg *config.Config) gin.HandlerFunc {
+func BatchHandler(urlService shortener.URLService, cfg *config.Config, auditPub *audit.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var requestBatch []model.URLBatchRequest
 		var responseBatch []model.URLBatchResponse
 
-		if err := json.NewDecoder(c.Request.Body).Decode(&requestBatch); err != nil {
-			c.String(http.StatusBadRequest, "Неправильное тело запроса")
+		userID, ok := getUserID(c)
+		if !ok {
+			problem.Write(c, errNoUserID)
 			return
 		}
 
-		userID, ok := getUserID(c)
-		if !ok {
-			c.AbortWithStatus(http.StatusInternalServerError)
+		if err := json.NewDecoder(limitBody(c, urlService, userID)).Decode(&requestBatch); err != nil {
+			writeBodyError(c, err, urlService, userID)
 			return
 		}
 
-		responseBatch, err := shortenBatch(requestBatch, urlService, cfg.GetBaseURL(), userID)
+		event := newAuditEvent(c, audit.ActionBatchShorten, userID, "", "")
+		responseBatch, err := shortenBatch(c.Request.Context(), requestBatch, urlService, cfg.GetBaseURL(), userID, event, auditPub)
 
 		if err != nil {
-			c.String(http.StatusBadRequest, "Не удалось сгенерить короткую ссылку")
+			problem.Write(c, err)
 			return
 		}
 
 		c.Header("Content-Type", "application/json")
 		c.JSON(http.StatusCreated, responseBatch)
 		c.Header("Content-Length", strconv.Itoa(len(responseBatch)))
-
 	}
 }
 
@@ -401,12 +711,16 @@ func BatchHandler(urlService shortener.URLService, cfg *config.Config) gin.Handl
 // Content-Type: application/json
 //
 // Принимает массив идентификаторов коротких ссылок для удаления.
-// Удаление происходит асинхронно в фоновом режиме.
+// Удаление происходит асинхронно в фоновом режиме. Событие аудита
+// delete_requested публикуется только для принятых к удалению ссылок.
 //
 // Коды ответа:
 //   - 202: запрос принят, удаление будет выполнено асинхронно
 //   - 400: некорректный JSON в теле запроса
+//   - 413: тело запроса больше квоты пользователя
 //   - 500: внутренняя ошибка сервера
+//   - 503: очередь удаления переполнена, часть ссылок не принята;
+//     запрос можно повторить целиком
 //
 // Пример запроса:
 //
@@ -418,23 +732,32 @@ func BatchHandler(urlService shortener.URLService, cfg *config.Config) gin.Handl
 // Пример ответа:
 //
 //	HTTP/1.1 202 Accepted
-func DeleteURLsHandler(urlService shortener.URLService) gin.HandlerFunc {
+func DeleteURLsHandler(urlService shortener.URLService, auditPub *audit.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, ok := getUserID(c)
 		if !ok {
-			c.AbortWithStatus(http.StatusInternalServerError)
+			problem.Write(c, errNoUserID)
 			return
 		}
 
 		var shortURLs []string
-		if err := json.NewDecoder(c.Request.Body).Decode(&shortURLs); err != nil {
-			c.String(http.StatusBadRequest, "Неправильное тело запроса")
+		if err := json.NewDecoder(limitBody(c, urlService, userID)).Decode(&shortURLs); err != nil {
+			writeBodyError(c, err, urlService, userID)
 			return
 		}
 
 		// Вызываем метод repository для асинхронного удаления
-		urlService.DeleteURLsAsync(userID, shortURLs)
+		accepted, err := urlService.DeleteURLsAsync(c.Request.Context(), userID, shortURLs)
 
+		// Факт удаления публикует репозиторий событием delete_applied
+		for _, shortURL := range accepted {
+			auditPub.Publish(newAuditEvent(c, audit.ActionDeleteRequested, userID, "", shortURL))
+		}
+
+		if err != nil {
+			problem.Write(c, err)
+			return
+		}
 		c.Status(http.StatusAccepted)
 	}
 }
@@ -443,14 +766,29 @@ func DeleteURLsHandler(urlService shortener.URLService) gin.HandlerFunc {
 //
 // Принимает массив запросов и возвращает массив ответов,
 // где каждый элемент связан через correlation_id.
-func shortenBatch(req []model.URLBatchRequest, urlService shortener.URLService, baseURL string, userID string) ([]model.URLBatchResponse, error) {
-	response := make([]model.URLBatchResponse, 0, len(req))
-	for _, request := range req {
-		shortURL, err := urlService.Shorten(request.OriginalURL, userID)
+// Для каждой ссылки публикуется копия event с её URL и коротким кодом.
+func shortenBatch(ctx context.Context, req []model.URLBatchRequest, urlService shortener.URLService, baseURL string, userID string, event audit.Event, auditPub *audit.Publisher) ([]model.URLBatchResponse, error) {
+	links := make([]model.Link, 0, len(req))
+	for i, request := range req {
+		passwordHash, err := shortener.HashPassword(request.Password)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("элемент %d: %w", i, err)
 		}
-		response = append(response, model.URLBatchResponse{CorrelationID: request.CorrelationID, ShortURL: baseURL + "/" + shortURL})
+		links = append(links, model.Link{
+			OriginalURL: request.OriginalURL,
+			LinkOptions: model.LinkOptions{RedirectCode: request.RedirectCode, QueryPassthrough: request.QueryPassthrough, PasswordHash: passwordHash},
+			LinkMeta:    model.LinkMeta{Title: request.Title, Note: request.Note, Tags: request.Tags},
+		})
+	}
+
+	shortURLs, err := urlService.ShortenBatch(ctx, links, userID, event, auditPub)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]model.URLBatchResponse, 0, len(req))
+	for i, request := range req {
+		response = append(response, model.URLBatchResponse{CorrelationID: request.CorrelationID, ShortURL: baseURL + "/" + shortURLs[i]})
 	}
 	return response, nil
 }
@@ -464,7 +802,7 @@ func shortenBatch(req []model.URLBatchRequest, urlService shortener.URLService,
 //   - string: полный URL существующей короткой ссылки
 //   - bool: true если это конфликт, иначе false
 func handleConflictError(err error, baseURL string) (string, bool) {
-	var conflictErr database.ErrURLConflictError
+	var conflictErr model.ErrURLConflictError
 	if errors.As(err, &conflictErr) {
 		return baseURL + "/" + conflictErr.ExistingShortURL, true
 	}
@@ -480,7 +818,8 @@ func handleConflictError(err error, baseURL string) (string, bool) {
 //   - users: количество пользователей в сервисе
 //
 // Доступ к эндпоинту ограничен через middleware TrustedSubnetMiddleware.
-// Проверяется, что IP из заголовка X-Real-IP входит в доверенную подсеть.
+// Проверяется, что IP клиента входит в одну из доверенных подсетей; X-Real-IP
+// учитывается только от доверенного прокси.
 //
 // Коды ответа:
 //   - 200: статистика успешно получена
@@ -501,11 +840,11 @@ func handleConflictError(err error, baseURL string) (string, bool) {
 //	  "urls": 12345,
 //	  "users": 678
 //	}
-func StatsHandler(urlService shortener.URLService) gin.HandlerFunc {
+func StatsHandler(urlService shortener.URLService, auditPub *audit.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		urls, users, err := urlService.GetStats()
+		urls, users, err := urlService.GetStats(c.Request.Context())
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Ошибка получения статистики")
+			problem.Write(c, fmt.Errorf("получение статистики: %w", err))
 			return
 		}
 
@@ -515,5 +854,127 @@ func StatsHandler(urlService shortener.URLService) gin.HandlerFunc {
 		}
 
 		c.JSON(http.StatusOK, stats)
+
+		auditPub.Publish(newAuditEvent(c, audit.ActionStatsRead, "", "", ""))
+	}
+}
+
+// auditPageResponse ответ выборки аудита
+type auditPageResponse struct {
+	Events     []audit.Event `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// AuditQueryHandler создает обработчик выборки событий аудита.
+//
+// Эндпоинт: GET /api/internal/audit
+// Доступ: только из доверенной подсети.
+//
+// Параметры запроса (все необязательны):
+//   - user_id: идентификатор пользователя
+//   - action: тип действия (shorten, follow, ...)
+//   - url: подстрока оригинального URL
+//   - from, to: границы времени включительно, RFC 3339 или unix-время в секундах
+//   - cursor: next_cursor из предыдущего ответа
+//   - limit: размер страницы, по умолчанию 100, не больше 1000
+//
+// Коды ответа:
+//   - 200: страница событий в порядке записи
+//   - 400: некорректный параметр
+//   - 500: ошибка чтения хранилища
+//   - 501: аудит не пишется ни в файл, ни в БД
+//
+// Пример запроса:
+//
+//	GET /api/internal/audit?action=shorten&url=example.com&limit=2 HTTP/1.1
+//
+// Пример ответа:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//
+//	{
+//	  "events": [
+//	    {"ts": 1700000000, "action": "shorten", "user_id": "...", "url": "https://example.com/a"},
+//	    {"ts": 1700000042, "action": "shorten", "user_id": "...", "url": "https://example.com/b"}
+//	  ],
+//	  "next_cursor": "17"
+//	}
+func AuditQueryHandler(querier audit.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if querier == nil {
+			problem.Write(c, problem.NotImplemented("Хранилище аудита не настроено"))
+			return
+		}
+
+		q, err := parseAuditQuery(c)
+		if err != nil {
+			problem.Write(c, problem.BadRequest(err.Error()))
+			return
+		}
+
+		page, err := querier.Query(q)
+		if err != nil {
+			problem.Write(c, fmt.Errorf("чтение аудита: %w", err))
+			return
+		}
+
+		resp := auditPageResponse{Events: page.Events}
+		if resp.Events == nil {
+			resp.Events = []audit.Event{}
+		}
+		if page.Next != 0 {
+			resp.NextCursor = strconv.FormatUint(page.Next, 10)
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// parseAuditQuery разбирает параметры запроса выборки аудита
+func parseAuditQuery(c *gin.Context) (audit.Query, error) {
+	q := audit.Query{
+		UserID: c.Query("user_id"),
+		Action: audit.Action(c.Query("action")),
+		URL:    c.Query("url"),
+	}
+
+	var err error
+	if q.From, err = parseAuditTime(c.Query("from")); err != nil {
+		return q, fmt.Errorf("некорректный from: %w", err)
+	}
+	if q.To, err = parseAuditTime(c.Query("to")); err != nil {
+		return q, fmt.Errorf("некорректный to: %w", err)
+	}
+	if v := c.Query("cursor"); v != "" {
+		if q.After, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return q, errors.New("некорректный cursor")
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, errors.New("некорректный limit")
+		}
+	}
+	return q, nil
+}
+
+// parseAuditTime принимает время в RFC 3339 или unix-секундах, пустая строка — 0
+func parseAuditTime(v string) (int64, error) {
+	t, err := parseQueryTime(v)
+	if err != nil || t.IsZero() {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// parseQueryTime принимает время в RFC 3339 или unix-секундах,
+// пустая строка — нулевое время
+func parseQueryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
 	}
+	return time.Parse(time.RFC3339, v)
 }
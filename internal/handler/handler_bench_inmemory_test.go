@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http/httptest"
@@ -94,10 +95,10 @@ func BenchmarkGetHandlerInMemory(b *testing.B) {
 	service := shortener.NewURLService(repo)
 	auditPub := &audit.Publisher{}
 
-	router.GET("/:id", GetHandler(service, auditPub))
+	router.GET("/:id", GetHandler(service, auditPub, NewLinkGate(&config.Config{}, nil)))
 
 	// Создаём одну ссылку
-	shortURL, _ := service.Shorten("https://benchmark.example.com", "test-user-123")
+	shortURL, _ := service.Shorten(context.Background(), "https://benchmark.example.com", "test-user-123")
 
 	req := httptest.NewRequest("GET", "/"+shortURL, nil)
 	w := httptest.NewRecorder()
@@ -116,7 +117,7 @@ func BenchmarkBatchHandlerInMemory(b *testing.B) {
 	service := shortener.NewURLService(repo)
 	cfg := &config.Config{BaseURL: "http://localhost:8080"}
 
-	router.POST("/api/shorten/batch", BatchHandler(service, cfg))
+	router.POST("/api/shorten/batch", BatchHandler(service, cfg, audit.NewPublisher()))
 
 	sizes := []int{10, 50, 100}
 
@@ -158,7 +159,7 @@ func BenchmarkGetUserURLsHandlerInMemory(b *testing.B) {
 	// Создаём 100 URL для пользователя
 	userID := "test-user-123"
 	for i := 0; i < 100; i++ {
-		_, _ = service.Shorten("https://example.com/user/"+strconv.Itoa(i), userID)
+		_, _ = service.Shorten(context.Background(), "https://example.com/user/"+strconv.Itoa(i), userID)
 	}
 
 	req := httptest.NewRequest("GET", "/api/user/urls", nil)
@@ -197,7 +198,7 @@ func BenchmarkShortenBatchInMemory(b *testing.B) {
 					}
 				}
 
-				_, err := shortenBatch(reqs, service, baseURL, userID)
+				_, err := shortenBatch(context.Background(), reqs, service, baseURL, userID, audit.Event{}, nil)
 				if err != nil {
 					b.Fatalf("shortenBatch failed: %v", err)
 				}
@@ -69,10 +69,32 @@ func setupBenchDB(b *testing.B) {
 				long_url TEXT UNIQUE NOT NULL,
 				short_url VARCHAR(20) UNIQUE NOT NULL,
 				created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-				is_deleted BOOL DEFAULT FALSE
+				is_deleted BOOL DEFAULT FALSE,
+				redirect_code SMALLINT NOT NULL DEFAULT 0,
+				query_passthrough BOOL NOT NULL DEFAULT FALSE,
+				version INT NOT NULL DEFAULT 1,
+				clicks BIGINT NOT NULL DEFAULT 0,
+				title TEXT NOT NULL DEFAULT '',
+				note TEXT NOT NULL DEFAULT '',
+				password_hash TEXT NOT NULL DEFAULT ''
 			);
 			CREATE UNIQUE INDEX IF NOT EXISTS idx_shortened_urls_short_url ON shortened_urls(short_url);
 			CREATE INDEX IF NOT EXISTS idx_shortened_urls_user_id ON shortened_urls(user_id);
+			CREATE TABLE IF NOT EXISTS link_history (
+				id BIGSERIAL PRIMARY KEY,
+				short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+				version INT NOT NULL,
+				long_url TEXT NOT NULL,
+				redirect_code SMALLINT NOT NULL DEFAULT 0,
+				query_passthrough BOOL NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				UNIQUE (short_url, version)
+			);
+			CREATE TABLE IF NOT EXISTS link_tags (
+				short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+				tag VARCHAR(64) NOT NULL,
+				PRIMARY KEY (short_url, tag)
+			);
 		`)
 		if err != nil {
 			b.Fatalf("Failed to create schema: %v", err)
@@ -152,7 +174,7 @@ func BenchmarkBatchHandler(b *testing.B) {
 	service := shortener.NewURLService(repo)
 	cfg := &config.Config{BaseURL: "http://localhost:8080"}
 
-	router.POST("/api/shorten/batch", BatchHandler(service, cfg))
+	router.POST("/api/shorten/batch", BatchHandler(service, cfg, audit.NewPublisher()))
 
 	sizes := []struct {
 		name string
@@ -193,10 +215,10 @@ func BenchmarkGetHandler(b *testing.B) {
 	service := shortener.NewURLService(repo)
 	auditPub := &audit.Publisher{}
 
-	router.GET("/:id", GetHandler(service, auditPub))
+	router.GET("/:id", GetHandler(service, auditPub, NewLinkGate(&config.Config{}, nil)))
 
 	// Создаём одну ссылку
-	shortURL, _ := service.Shorten("https://benchmark.example.com", "550e8400-e29b-41d4-a716-446655440000")
+	shortURL, _ := service.Shorten(context.Background(), "https://benchmark.example.com", "550e8400-e29b-41d4-a716-446655440000")
 
 	req := httptest.NewRequest("GET", "/"+shortURL, nil)
 	w := httptest.NewRecorder()
@@ -218,7 +240,7 @@ func BenchmarkGetUserURLsHandler(b *testing.B) {
 
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 	for i := 0; i < 100; i++ {
-		_, _ = service.Shorten("https://example.com/user/"+strconv.Itoa(i), userID)
+		_, _ = service.Shorten(context.Background(), "https://example.com/user/"+strconv.Itoa(i), userID)
 	}
 
 	req := httptest.NewRequest("GET", "/api/user/urls", nil)
@@ -268,7 +290,7 @@ func BenchmarkShortenBatch_RealDB(b *testing.B) {
 					counter++
 				}
 
-				_, err := shortenBatch(reqs, svc, baseURL, userID)
+				_, err := shortenBatch(context.Background(), reqs, svc, baseURL, userID, audit.Event{}, nil)
 				if err != nil {
 					b.Fatalf("shortenBatch failed: %v", err)
 				}
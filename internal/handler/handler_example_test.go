@@ -90,9 +90,10 @@ func ExampleGetHandler() {
 	urlService := shortener.NewURLService(mockRepo)
 
 	// Настраиваем mock: возвращаем оригинальный URL
-	mockRepo.EXPECT().Get("abc123").Return("https://example.com", nil)
+	mockRepo.EXPECT().Get(gomock.Any(), "abc123").Return(model.Link{OriginalURL: "https://example.com"}, nil)
+	mockRepo.EXPECT().RecordClick(gomock.Any(), "abc123")
 
-	router.GET("/:id", handler.GetHandler(urlService, pub))
+	router.GET("/:id", handler.GetHandler(urlService, pub, handler.NewLinkGate(&config.Config{}, nil)))
 
 	// Создаем запрос для получения URL
 	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
@@ -147,7 +148,7 @@ func ExampleBatchHandler() {
 	router, urlService := setupTestRouter()
 	cfg := &config.Config{BaseURL: "http://localhost:8080"}
 
-	router.POST("/api/shorten/batch", handler.BatchHandler(urlService, cfg))
+	router.POST("/api/shorten/batch", handler.BatchHandler(urlService, cfg, audit.NewPublisher()))
 
 	// Создаем батч запрос
 	batch := []map[string]string{
@@ -178,7 +179,7 @@ func ExampleBatchHandler() {
 	// Has correlation_id: true
 }
 
-// ExampleGetUserURLsHandler демонстрирует получение всех URL пользователя
+// ExampleGetUserURLsHandler демонстрирует получение ссылок пользователя
 func ExampleGetUserURLsHandler() {
 	ctrl := gomock.NewController(nil)
 	defer ctrl.Finish()
@@ -188,7 +189,7 @@ func ExampleGetUserURLsHandler() {
 	urlService := shortener.NewURLService(mockRepo)
 
 	// Настраиваем mock: возвращаем список URL пользователя
-	mockRepo.EXPECT().GetUserURLs("example-user-123").Return([]model.URLPair{
+	mockRepo.EXPECT().GetUserURLs(gomock.Any(), "example-user-123", gomock.Any()).Return([]model.UserURL{
 		{ShortURL: "abc123", OriginalURL: "https://example1.com"},
 		{ShortURL: "def456", OriginalURL: "https://example2.com"},
 	}, nil)
@@ -225,9 +226,10 @@ func ExampleDeleteURLsHandler() {
 	urlService := shortener.NewURLService(mockRepo)
 
 	// Настраиваем mock: ожидаем вызов DeleteURLs
-	mockRepo.EXPECT().DeleteURLs("example-user-123", []string{"url1", "url2", "url3"})
+	mockRepo.EXPECT().DeleteURLs(gomock.Any(), "example-user-123", []string{"url1", "url2", "url3"}).
+		Return([]string{"url1", "url2", "url3"}, nil)
 
-	router.DELETE("/api/user/urls", handler.DeleteURLsHandler(urlService))
+	router.DELETE("/api/user/urls", handler.DeleteURLsHandler(urlService, audit.NewPublisher()))
 
 	// Создаем запрос на удаление
 	urlsToDelete := []string{"url1", "url2", "url3"}
@@ -2,15 +2,19 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Popolzen/shortener/internal/audit"
 	"github.com/Popolzen/shortener/internal/config"
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
 	"github.com/Popolzen/shortener/internal/model"
 	"github.com/Popolzen/shortener/internal/repository/mocks"
 	"github.com/Popolzen/shortener/internal/service/shortener"
@@ -47,10 +51,11 @@ func TestGetHandler_Success(t *testing.T) {
 
 	pub := audit.NewPublisher()
 	router, repo := setupTestRouter(ctrl)
-	repo.EXPECT().Get("abc123").Return("https://example.com", nil)
+	repo.EXPECT().Get(gomock.Any(), "abc123").Return(model.Link{OriginalURL: "https://example.com"}, nil)
+	repo.EXPECT().RecordClick(gomock.Any(), "abc123")
 
 	urlService := shortener.NewURLService(repo)
-	router.GET("/:id", GetHandler(urlService, pub))
+	router.GET("/:id", GetHandler(urlService, pub, NewLinkGate(testConfig(), nil)))
 
 	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
 	w := httptest.NewRecorder()
@@ -66,10 +71,10 @@ func TestGetHandler_NotFound(t *testing.T) {
 
 	pub := audit.NewPublisher()
 	router, repo := setupTestRouter(ctrl)
-	repo.EXPECT().Get("notfound").Return("", errors.New("not found"))
+	repo.EXPECT().Get(gomock.Any(), "notfound").Return(model.Link{}, model.ErrURLNotFound)
 
 	urlService := shortener.NewURLService(repo)
-	router.GET("/:id", GetHandler(urlService, pub))
+	router.GET("/:id", GetHandler(urlService, pub, NewLinkGate(testConfig(), nil)))
 
 	req := httptest.NewRequest(http.MethodGet, "/notfound", nil)
 	w := httptest.NewRecorder()
@@ -84,10 +89,10 @@ func TestGetHandler_Deleted(t *testing.T) {
 
 	pub := audit.NewPublisher()
 	router, repo := setupTestRouter(ctrl)
-	repo.EXPECT().Get("deleted").Return("", model.ErrURLDeleted)
+	repo.EXPECT().Get(gomock.Any(), "deleted").Return(model.Link{}, model.ErrURLDeleted)
 
 	urlService := shortener.NewURLService(repo)
-	router.GET("/:id", GetHandler(urlService, pub))
+	router.GET("/:id", GetHandler(urlService, pub, NewLinkGate(testConfig(), nil)))
 
 	req := httptest.NewRequest(http.MethodGet, "/deleted", nil)
 	w := httptest.NewRecorder()
@@ -96,6 +101,59 @@ func TestGetHandler_Deleted(t *testing.T) {
 	assert.Equal(t, http.StatusGone, w.Code)
 }
 
+func TestGetHandler_LinkOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         model.LinkOptions
+		path         string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "код по умолчанию, параметры отбрасываются",
+			path:         "/abc123?utm_source=ads",
+			wantCode:     http.StatusTemporaryRedirect,
+			wantLocation: "https://example.com/landing",
+		},
+		{
+			name:         "постоянное перенаправление",
+			opts:         model.LinkOptions{RedirectCode: http.StatusMovedPermanently},
+			path:         "/abc123",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://example.com/landing",
+		},
+		{
+			name:         "передача параметров запроса",
+			opts:         model.LinkOptions{RedirectCode: http.StatusPermanentRedirect, QueryPassthrough: true},
+			path:         "/abc123?utm_source=ads",
+			wantCode:     http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/landing?utm_source=ads",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			router, repo := setupTestRouter(ctrl)
+			repo.EXPECT().Get(gomock.Any(), "abc123").Return(model.Link{
+				ShortURL:    "abc123",
+				OriginalURL: "https://example.com/landing",
+				LinkOptions: tt.opts,
+			}, nil)
+			repo.EXPECT().RecordClick(gomock.Any(), "abc123")
+			router.GET("/:id", GetHandler(shortener.NewURLService(repo), audit.NewPublisher(), NewLinkGate(testConfig(), nil)))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+			assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
 // === PostHandler ===
 
 func TestPostHandler_Success(t *testing.T) {
@@ -105,8 +163,8 @@ func TestPostHandler_Success(t *testing.T) {
 	pub := audit.NewPublisher()
 	router, repo := setupTestRouter(ctrl)
 
-	repo.EXPECT().Get(gomock.Any()).Return("", errors.New("not found"))
-	repo.EXPECT().Store(gomock.Any(), "https://example.com", "test-user-123").Return(nil)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found"))
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "test-user-123", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	urlService := shortener.NewURLService(repo)
 	router.POST("/", PostHandler(urlService, testConfig(), pub))
@@ -119,6 +177,25 @@ func TestPostHandler_Success(t *testing.T) {
 	assert.True(t, strings.HasPrefix(w.Body.String(), "http://localhost:8080/"))
 }
 
+func TestPostHandler_TrailingNewline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+
+	// Тело text/plain сохраняется как есть, как и раньше
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found"))
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com\n", "test-user-123", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+	router.POST("/", PostHandler(shortener.NewURLService(repo), testConfig(), audit.NewPublisher()))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com\n"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
 func TestPostHandler_StoreError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -126,8 +203,8 @@ func TestPostHandler_StoreError(t *testing.T) {
 	pub := audit.NewPublisher()
 	router, repo := setupTestRouter(ctrl)
 
-	repo.EXPECT().Get(gomock.Any()).Return("", errors.New("not found"))
-	repo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("db error"))
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found"))
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("db error"))
 
 	urlService := shortener.NewURLService(repo)
 	router.POST("/", PostHandler(urlService, testConfig(), pub))
@@ -136,7 +213,27 @@ func TestPostHandler_StoreError(t *testing.T) {
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	// Причина ошибки хранилища клиенту не раскрывается
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "db error")
+}
+
+func TestGetHandler_NotFoundPlainText(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().Get(gomock.Any(), "notfound").Return(model.Link{}, model.ErrURLNotFound)
+	router.GET("/:id", GetHandler(shortener.NewURLService(repo), audit.NewPublisher(), NewLinkGate(testConfig(), nil)))
+
+	req := httptest.NewRequest(http.MethodGet, "/notfound", nil)
+	req.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Короткая ссылка не найдена", w.Body.String())
 }
 
 // === PostHandlerJSON ===
@@ -148,8 +245,8 @@ func TestPostHandlerJSON_Success(t *testing.T) {
 	pub := audit.NewPublisher()
 	router, repo := setupTestRouter(ctrl)
 
-	repo.EXPECT().Get(gomock.Any()).Return("", errors.New("not found"))
-	repo.EXPECT().Store(gomock.Any(), "https://example.com", "test-user-123").Return(nil)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found"))
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "test-user-123", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	urlService := shortener.NewURLService(repo)
 	router.POST("/api/shorten", PostHandlerJSON(urlService, testConfig(), pub))
@@ -184,6 +281,101 @@ func TestPostHandlerJSON_InvalidJSON(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func TestPostHandlerJSON_LinkOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+
+	opts := model.LinkOptions{RedirectCode: http.StatusPermanentRedirect, QueryPassthrough: true}
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "test-user-123", opts, model.LinkMeta{}, gomock.Any()).Return(nil)
+	router.POST("/api/shorten", PostHandlerJSON(shortener.NewURLService(repo), testConfig(), audit.NewPublisher()))
+
+	body := `{"url":"https://example.com","redirect_code":308,"query_passthrough":true}`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestPostHandlerJSON_LinkMeta(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+
+	meta := model.LinkMeta{Title: "Документация", Note: "для команды", Tags: []string{"docs", "work"}}
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "test-user-123", model.LinkOptions{}, meta, gomock.Any()).Return(nil)
+	router.POST("/api/shorten", PostHandlerJSON(shortener.NewURLService(repo), testConfig(), audit.NewPublisher()))
+
+	body := `{"url":"https://example.com","title":"Документация","note":"для команды","tags":["Work","docs"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestPostHandlerJSON_InvalidTags(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+	router.POST("/api/shorten", PostHandlerJSON(shortener.NewURLService(repo), testConfig(), audit.NewPublisher()))
+
+	body := `{"url":"https://example.com","tags":["docs",""]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "tags")
+}
+
+func TestPostHandlerJSON_Password(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+
+	var stored model.LinkOptions
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "test-user-123", gomock.Any(), model.LinkMeta{}, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _, _, _ string, opts model.LinkOptions, _ model.LinkMeta, _ int) error {
+			stored = opts
+			return nil
+		})
+	service := shortener.NewURLService(repo)
+	router.POST("/api/shorten", PostHandlerJSON(service, testConfig(), audit.NewPublisher()))
+
+	body := `{"url":"https://example.com","password":"s3cret"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.True(t, stored.Protected())
+	assert.NotContains(t, stored.PasswordHash, "s3cret")
+	assert.True(t, service.CheckLinkPassword(context.Background(), model.Link{LinkOptions: stored}, "s3cret"))
+
+	body = `{"url":"https://example.com","password":"` + strings.Repeat("p", shortener.MaxPasswordLength+1) + `"}`
+	req = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "password")
+}
+
+func TestPostHandlerJSON_InvalidRedirectCode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+	router.POST("/api/shorten", PostHandlerJSON(shortener.NewURLService(repo), testConfig(), audit.NewPublisher()))
+
+	body := `{"url":"https://example.com","redirect_code":303}`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "redirect_code")
+}
+
 // === BatchHandler ===
 
 func TestBatchHandler_Success(t *testing.T) {
@@ -192,12 +384,12 @@ func TestBatchHandler_Success(t *testing.T) {
 
 	router, repo := setupTestRouter(ctrl)
 
-	repo.EXPECT().Get(gomock.Any()).Return("", errors.New("not found")).Times(2)
-	repo.EXPECT().Store(gomock.Any(), "https://one.com", "test-user-123").Return(nil)
-	repo.EXPECT().Store(gomock.Any(), "https://two.com", "test-user-123").Return(nil)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found")).Times(2)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://one.com", "test-user-123", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://two.com", "test-user-123", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	urlService := shortener.NewURLService(repo)
-	router.POST("/api/shorten/batch", BatchHandler(urlService, testConfig()))
+	router.POST("/api/shorten/batch", BatchHandler(urlService, testConfig(), audit.NewPublisher()))
 
 	batch := []model.URLBatchRequest{
 		{CorrelationID: "1", OriginalURL: "https://one.com"},
@@ -224,7 +416,7 @@ func TestBatchHandler_InvalidJSON(t *testing.T) {
 	router, _ := setupTestRouter(ctrl)
 
 	urlService := shortener.NewURLService(nil)
-	router.POST("/api/shorten/batch", BatchHandler(urlService, testConfig()))
+	router.POST("/api/shorten/batch", BatchHandler(urlService, testConfig(), audit.NewPublisher()))
 
 	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader("invalid"))
 	w := httptest.NewRecorder()
@@ -240,7 +432,7 @@ func TestBatchHandler_EmptyBatch(t *testing.T) {
 	router, _ := setupTestRouter(ctrl)
 
 	urlService := shortener.NewURLService(nil)
-	router.POST("/api/shorten/batch", BatchHandler(urlService, testConfig()))
+	router.POST("/api/shorten/batch", BatchHandler(urlService, testConfig(), audit.NewPublisher()))
 
 	body, _ := json.Marshal([]model.URLBatchRequest{})
 	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewReader(body))
@@ -262,7 +454,7 @@ func TestGetUserURLsHandler_Success(t *testing.T) {
 
 	router, repo := setupTestRouter(ctrl)
 
-	repo.EXPECT().GetUserURLs("test-user-123").Return([]model.URLPair{
+	repo.EXPECT().GetUserURLs(gomock.Any(), "test-user-123", gomock.Any()).Return([]model.UserURL{
 		{ShortURL: "abc", OriginalURL: "https://example.com"},
 	}, nil)
 
@@ -287,7 +479,7 @@ func TestGetUserURLsHandler_Empty(t *testing.T) {
 
 	router, repo := setupTestRouter(ctrl)
 
-	repo.EXPECT().GetUserURLs("test-user-123").Return([]model.URLPair{}, nil)
+	repo.EXPECT().GetUserURLs(gomock.Any(), "test-user-123", gomock.Any()).Return([]model.UserURL{}, nil)
 
 	urlService := shortener.NewURLService(repo)
 	router.GET("/api/user/urls", GetUserURLsHandler(urlService, testConfig()))
@@ -299,6 +491,99 @@ func TestGetUserURLsHandler_Empty(t *testing.T) {
 	assert.Equal(t, http.StatusNoContent, w.Code)
 }
 
+func TestGetUserURLsHandler_NextPageLink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+
+	var got model.URLListQuery
+	repo.EXPECT().GetUserURLs(gomock.Any(), "test-user-123", gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, q model.URLListQuery) ([]model.UserURL, error) {
+			got = q
+			return []model.UserURL{
+				{ShortURL: "aaa", OriginalURL: "https://example.com/a", Clicks: 1},
+				{ShortURL: "bbb", OriginalURL: "https://example.com/b", Clicks: 2},
+				{ShortURL: "ccc", OriginalURL: "https://example.com/c", Clicks: 3},
+			}, nil
+		})
+	router.GET("/api/user/urls", GetUserURLsHandler(shortener.NewURLService(repo), testConfig()))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls?limit=2&sort=clicks&order=asc&url=Example&tag=Docs&deleted=false&from=2025-01-01T00:00:00Z", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, got.Limit, "сервис запрашивает на одну ссылку больше")
+	assert.Equal(t, model.SortClicks, got.Sort)
+	assert.False(t, got.Desc)
+	assert.Equal(t, "Example", got.URL)
+	assert.Equal(t, "docs", got.Tag)
+	require.NotNil(t, got.Deleted)
+	assert.False(t, *got.Deleted)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), got.CreatedFrom.UTC())
+
+	var urls []model.UserURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+	require.Len(t, urls, 2)
+	assert.Equal(t, "http://localhost:8080/bbb", urls[1].ShortURL)
+
+	link := w.Header().Get("Link")
+	require.True(t, strings.HasPrefix(link, "<http://localhost:8080/api/user/urls?"), link)
+	require.True(t, strings.HasSuffix(link, `>; rel="next"`), link)
+	next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	require.NoError(t, err)
+	assert.NotEmpty(t, next.Query().Get("cursor"))
+	assert.Equal(t, "2", next.Query().Get("limit"))
+	assert.Equal(t, "Example", next.Query().Get("url"))
+
+	// Следующая страница: курсор разбирается и передаётся в репозиторий
+	repo.EXPECT().GetUserURLs(gomock.Any(), "test-user-123", gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, q model.URLListQuery) ([]model.UserURL, error) {
+			got = q
+			return []model.UserURL{{ShortURL: "ccc", OriginalURL: "https://example.com/c", Clicks: 3}}, nil
+		})
+	req = httptest.NewRequest(http.MethodGet, next.RequestURI(), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Link"))
+	require.NotNil(t, got.After)
+	assert.Equal(t, model.URLCursor{Clicks: 2, ShortURL: "bbb"}, *got.After)
+}
+
+func TestGetUserURLsHandler_InvalidQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantField string
+	}{
+		{name: "отрицательный limit", query: "limit=-1", wantField: "limit"},
+		{name: "нечисловой limit", query: "limit=abc", wantField: "limit"},
+		{name: "неизвестная сортировка", query: "sort=title", wantField: "sort"},
+		{name: "неизвестный порядок", query: "order=up", wantField: "order"},
+		{name: "некорректный deleted", query: "deleted=maybe", wantField: "deleted"},
+		{name: "некорректный from", query: "from=yesterday", wantField: "from"},
+		{name: "некорректный курсор", query: "cursor=%21%21%21", wantField: "cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			router, repo := setupTestRouter(ctrl)
+			router.GET("/api/user/urls", GetUserURLsHandler(shortener.NewURLService(repo), testConfig()))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.wantField, body["field"])
+		})
+	}
+}
+
 // === DeleteURLsHandler ===
 
 func TestDeleteURLsHandler_Success(t *testing.T) {
@@ -307,10 +592,10 @@ func TestDeleteURLsHandler_Success(t *testing.T) {
 
 	router, repo := setupTestRouter(ctrl)
 
-	repo.EXPECT().DeleteURLs("test-user-123", []string{"abc", "def"})
+	repo.EXPECT().DeleteURLs(gomock.Any(), "test-user-123", []string{"abc", "def"}).Return([]string{"abc", "def"}, nil)
 
 	urlService := shortener.NewURLService(repo)
-	router.DELETE("/api/user/urls", DeleteURLsHandler(urlService))
+	router.DELETE("/api/user/urls", DeleteURLsHandler(urlService, audit.NewPublisher()))
 
 	body, _ := json.Marshal([]string{"abc", "def"})
 	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewReader(body))
@@ -327,7 +612,7 @@ func TestDeleteURLsHandler_InvalidJSON(t *testing.T) {
 	router, _ := setupTestRouter(ctrl)
 
 	urlService := shortener.NewURLService(nil)
-	router.DELETE("/api/user/urls", DeleteURLsHandler(urlService))
+	router.DELETE("/api/user/urls", DeleteURLsHandler(urlService, audit.NewPublisher()))
 
 	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader("invalid"))
 	w := httptest.NewRecorder()
@@ -335,3 +620,536 @@ func TestDeleteURLsHandler_InvalidJSON(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+// === UpdateLinkHandler ===
+
+func TestUpdateLinkHandler(t *testing.T) {
+	code := http.StatusMovedPermanently
+	passthrough := true
+	newURL := "https://example.com/new-landing"
+	title := "Лендинг"
+
+	tests := []struct {
+		name       string
+		body       string
+		setup      func(repo *mocks.MockURLRepository)
+		wantStatus int
+		wantLink   *model.Link
+	}{
+		{
+			name: "изменение кода",
+			body: `{"redirect_code":301}`,
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().UpdateLink(gomock.Any(), "test-user-123", "abc123", model.LinkUpdate{RedirectCode: &code}).
+					Return(model.Link{ShortURL: "abc123", OriginalURL: "https://example.com", LinkOptions: model.LinkOptions{RedirectCode: code}}, nil)
+			},
+			wantStatus: http.StatusOK,
+			wantLink: &model.Link{
+				ShortURL:    "http://localhost:8080/abc123",
+				OriginalURL: "https://example.com",
+				LinkOptions: model.LinkOptions{RedirectCode: code},
+			},
+		},
+		{
+			name: "только passthrough, код по умолчанию",
+			body: `{"query_passthrough":true}`,
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().UpdateLink(gomock.Any(), "test-user-123", "abc123", model.LinkUpdate{QueryPassthrough: &passthrough}).
+					Return(model.Link{ShortURL: "abc123", OriginalURL: "https://example.com", LinkOptions: model.LinkOptions{QueryPassthrough: true}}, nil)
+			},
+			wantStatus: http.StatusOK,
+			wantLink: &model.Link{
+				ShortURL:    "http://localhost:8080/abc123",
+				OriginalURL: "https://example.com",
+				LinkOptions: model.LinkOptions{RedirectCode: shortener.DefaultRedirectCode, QueryPassthrough: true},
+			},
+		},
+		{
+			name: "изменение оригинального URL",
+			body: `{"original_url":"https://example.com/new-landing"}`,
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().UpdateLink(gomock.Any(), "test-user-123", "abc123", model.LinkUpdate{OriginalURL: &newURL}).
+					Return(model.Link{ShortURL: "abc123", OriginalURL: newURL, Version: 2}, nil)
+			},
+			wantStatus: http.StatusOK,
+			wantLink: &model.Link{
+				ShortURL:    "http://localhost:8080/abc123",
+				OriginalURL: newURL,
+				Version:     2,
+				LinkOptions: model.LinkOptions{RedirectCode: shortener.DefaultRedirectCode},
+			},
+		},
+		{
+			name: "изменение описания",
+			body: `{"title":" Лендинг ","tags":["Promo","promo"]}`,
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().UpdateLink(gomock.Any(), "test-user-123", "abc123", model.LinkUpdate{Title: &title, Tags: &[]string{"promo"}}).
+					Return(model.Link{ShortURL: "abc123", OriginalURL: "https://example.com", Version: 1,
+						LinkMeta: model.LinkMeta{Title: title, Tags: []string{"promo"}}}, nil)
+			},
+			wantStatus: http.StatusOK,
+			wantLink: &model.Link{
+				ShortURL:    "http://localhost:8080/abc123",
+				OriginalURL: "https://example.com",
+				Version:     1,
+				LinkOptions: model.LinkOptions{RedirectCode: shortener.DefaultRedirectCode},
+				LinkMeta:    model.LinkMeta{Title: title, Tags: []string{"promo"}},
+			},
+		},
+		{
+			name:       "слишком длинное название",
+			body:       `{"title":"` + strings.Repeat("a", shortener.MaxTitleLength+1) + `"}`,
+			setup:      func(repo *mocks.MockURLRepository) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "пустой оригинальный URL",
+			body:       `{"original_url":""}`,
+			setup:      func(repo *mocks.MockURLRepository) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "нет изменяемых полей",
+			body:       `{}`,
+			setup:      func(repo *mocks.MockURLRepository) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "новый URL уже сокращён",
+			body: `{"original_url":"https://example.com/new-landing"}`,
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().UpdateLink(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(model.Link{}, model.ErrURLConflictError{ExistingShortURL: "xyz789"})
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "недопустимый код",
+			body:       `{"redirect_code":200}`,
+			setup:      func(repo *mocks.MockURLRepository) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "некорректный JSON",
+			body:       `{`,
+			setup:      func(repo *mocks.MockURLRepository) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "чужая или несуществующая ссылка",
+			body: `{"redirect_code":301}`,
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().UpdateLink(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "удалённая ссылка",
+			body: `{"redirect_code":301}`,
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().UpdateLink(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLDeleted)
+			},
+			wantStatus: http.StatusGone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			router, repo := setupTestRouter(ctrl)
+			tt.setup(repo)
+			router.PATCH("/api/user/urls/:id", UpdateLinkHandler(shortener.NewURLService(repo), testConfig(), audit.NewPublisher()))
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/abc123", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantLink == nil {
+				return
+			}
+			var got model.Link
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, *tt.wantLink, got)
+		})
+	}
+}
+
+func TestLinkHistoryHandler(t *testing.T) {
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	versions := []model.LinkVersion{
+		{Version: 1, OriginalURL: "https://example.com", CreatedAt: created},
+		{Version: 2, OriginalURL: "https://example.com/new-landing", CreatedAt: created.Add(time.Hour),
+			LinkOptions: model.LinkOptions{RedirectCode: http.StatusPermanentRedirect}},
+	}
+
+	tests := []struct {
+		name       string
+		setup      func(repo *mocks.MockURLRepository)
+		wantStatus int
+		want       []model.LinkVersion
+	}{
+		{
+			name: "версии ссылки",
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().GetLinkHistory(gomock.Any(), "test-user-123", "abc123").Return(versions, nil)
+			},
+			wantStatus: http.StatusOK,
+			want:       versions,
+		},
+		{
+			name: "чужая или несуществующая ссылка",
+			setup: func(repo *mocks.MockURLRepository) {
+				repo.EXPECT().GetLinkHistory(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, model.ErrURLNotFound)
+			},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			router, repo := setupTestRouter(ctrl)
+			tt.setup(repo)
+			router.GET("/api/user/urls/:id/history", LinkHistoryHandler(shortener.NewURLService(repo)))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls/abc123/history", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.want == nil {
+				return
+			}
+			var got []model.LinkVersion
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetUserTagsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		tags       []model.TagCount
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "метки с числом ссылок",
+			tags:       []model.TagCount{{Tag: "work", Count: 2}, {Tag: "docs", Count: 1}},
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"tag":"work","count":2},{"tag":"docs","count":1}]`,
+		},
+		{
+			name:       "меток нет",
+			wantStatus: http.StatusOK,
+			wantBody:   `[]`,
+		},
+		{
+			name:       "ошибка хранилища",
+			err:        errors.New("db error"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			router, repo := setupTestRouter(ctrl)
+			repo.EXPECT().GetUserTags(gomock.Any(), "test-user-123").Return(tt.tags, tt.err)
+			router.GET("/api/user/tags", GetUserTagsHandler(shortener.NewURLService(repo)))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/tags", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+// === Audit ===
+
+// auditRecorder запоминает события аудита, доставленные через Publisher
+type auditRecorder struct {
+	events []audit.Event
+}
+
+func (r *auditRecorder) Notify(event audit.Event) { r.events = append(r.events, event) }
+
+func (r *auditRecorder) Close() error { return nil }
+
+func newAuditRecorder() (*audit.Publisher, *auditRecorder) {
+	pub := audit.NewPublisher()
+	rec := &auditRecorder{}
+	pub.Subscribe(rec)
+	return pub, rec
+}
+
+func TestBatchHandler_PublishesAudit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found")).Times(2)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any(), "test-user-123", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	pub, rec := newAuditRecorder()
+	router.POST("/api/shorten/batch", BatchHandler(shortener.NewURLService(repo), testConfig(), pub))
+
+	body, _ := json.Marshal([]model.URLBatchRequest{
+		{CorrelationID: "1", OriginalURL: "https://one.com"},
+		{CorrelationID: "2", OriginalURL: "https://two.com"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "batch-client")
+	req.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, pub.Close())
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var response []model.URLBatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	require.Len(t, rec.events, 2)
+	for i, event := range rec.events {
+		assert.Equal(t, audit.ActionBatchShorten, event.Action)
+		assert.Equal(t, "test-user-123", event.UserID)
+		assert.Equal(t, "http://localhost:8080/"+event.ShortCode, response[i].ShortURL)
+		assert.Equal(t, "batch-client", event.UserAgent)
+		assert.Equal(t, "req-1", event.RequestID)
+		assert.NotEmpty(t, event.ClientIP)
+	}
+	assert.Equal(t, "https://one.com", rec.events[0].URL)
+	assert.Equal(t, "https://two.com", rec.events[1].URL)
+}
+
+func TestUpdateLinkHandler_PublishesAudit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+	newURL := "https://example.com/new-landing"
+	repo.EXPECT().UpdateLink(gomock.Any(), "test-user-123", "abc123", model.LinkUpdate{OriginalURL: &newURL}).
+		Return(model.Link{ShortURL: "abc123", OriginalURL: newURL, Version: 2}, nil)
+
+	pub, rec := newAuditRecorder()
+	router.PATCH("/api/user/urls/:id", UpdateLinkHandler(shortener.NewURLService(repo), testConfig(), pub))
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/abc123", strings.NewReader(`{"original_url":"https://example.com/new-landing"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, pub.Close())
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, rec.events, 1)
+	assert.Equal(t, audit.ActionUpdate, rec.events[0].Action)
+	assert.Equal(t, newURL, rec.events[0].URL)
+	assert.Equal(t, "abc123", rec.events[0].ShortCode)
+	assert.Equal(t, "test-user-123", rec.events[0].UserID)
+}
+
+func TestDeleteURLsHandler_PublishesAudit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().DeleteURLs(gomock.Any(), "test-user-123", []string{"abc", "def"}).Return([]string{"abc", "def"}, nil)
+
+	pub, rec := newAuditRecorder()
+	router.DELETE("/api/user/urls", DeleteURLsHandler(shortener.NewURLService(repo), pub))
+
+	body, _ := json.Marshal([]string{"abc", "def"})
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, pub.Close())
+
+	require.Len(t, rec.events, 2)
+	assert.Equal(t, audit.ActionDeleteRequested, rec.events[0].Action)
+	assert.Equal(t, "abc", rec.events[0].ShortCode)
+	assert.Equal(t, "def", rec.events[1].ShortCode)
+}
+
+func TestDeleteURLsHandler_QueueFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().DeleteURLs(gomock.Any(), "test-user-123", []string{"abc", "def"}).
+		Return([]string{"abc"}, model.ErrDeleteQueueFull)
+
+	pub, rec := newAuditRecorder()
+	router.DELETE("/api/user/urls", DeleteURLsHandler(shortener.NewURLService(repo), pub))
+
+	body, _ := json.Marshal([]string{"abc", "def"})
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, pub.Close())
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"unavailable"`)
+
+	// В аудит попадает только принятая ссылка
+	require.Len(t, rec.events, 1)
+	assert.Equal(t, "abc", rec.events[0].ShortCode)
+}
+
+func TestDeleteURLsHandler_PropagatesRequestID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+	router.Use(requestid.Middleware())
+
+	var gotRequestID string
+	repo.EXPECT().DeleteURLs(gomock.Any(), "test-user-123", []string{"abc"}).
+		DoAndReturn(func(ctx context.Context, _ string, ids []string) ([]string, error) {
+			gotRequestID = requestid.FromContext(ctx)
+			return ids, nil
+		})
+
+	pub, rec := newAuditRecorder()
+	router.DELETE("/api/user/urls", DeleteURLsHandler(shortener.NewURLService(repo), pub))
+
+	body, _ := json.Marshal([]string{"abc"})
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewReader(body))
+	req.Header.Set(requestid.Header, "req-del-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, pub.Close())
+
+	assert.Equal(t, "req-del-1", w.Header().Get(requestid.Header))
+	assert.Equal(t, "req-del-1", gotRequestID)
+	require.Len(t, rec.events, 1)
+	assert.Equal(t, "req-del-1", rec.events[0].RequestID)
+}
+
+func TestStatsHandler_PublishesAudit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().GetStats(gomock.Any()).Return(10, 2, nil)
+
+	pub, rec := newAuditRecorder()
+	router.GET("/api/internal/stats", StatsHandler(shortener.NewURLService(repo), pub))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, pub.Close())
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, rec.events, 1)
+	assert.Equal(t, audit.ActionStatsRead, rec.events[0].Action)
+}
+
+func TestGetHandler_PublishesShortCode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().Get(gomock.Any(), "abc123").Return(model.Link{OriginalURL: "https://example.com"}, nil)
+	repo.EXPECT().RecordClick(gomock.Any(), "abc123")
+
+	pub, rec := newAuditRecorder()
+	router.GET("/:id", GetHandler(shortener.NewURLService(repo), pub, NewLinkGate(testConfig(), nil)))
+
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, pub.Close())
+
+	require.Len(t, rec.events, 1)
+	assert.Equal(t, audit.ActionFollow, rec.events[0].Action)
+	assert.Equal(t, "abc123", rec.events[0].ShortCode)
+	assert.Equal(t, "https://example.com", rec.events[0].URL)
+}
+
+// === AuditQueryHandler ===
+
+// fakeQuerier запоминает запрос и возвращает заданную страницу
+type fakeQuerier struct {
+	got  audit.Query
+	page audit.Page
+	err  error
+}
+
+func (f *fakeQuerier) Query(q audit.Query) (audit.Page, error) {
+	f.got = q
+	return f.page, f.err
+}
+
+func doAuditQuery(querier audit.Querier, query string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/internal/audit", AuditQueryHandler(querier))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/audit"+query, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuditQueryHandler_Filters(t *testing.T) {
+	q := &fakeQuerier{page: audit.Page{
+		Events: []audit.Event{{Timestamp: 1700000000, Action: audit.ActionShorten, UserID: "u1", URL: "https://example.com"}},
+		Next:   42,
+	}}
+
+	w := doAuditQuery(q, "?user_id=u1&action=shorten&url=example&from=2023-11-14T22:13:20Z&to=1700000100&cursor=7&limit=10")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, audit.Query{
+		UserID: "u1",
+		Action: audit.ActionShorten,
+		URL:    "example",
+		From:   1700000000,
+		To:     1700000100,
+		After:  7,
+		Limit:  10,
+	}, q.got)
+
+	var resp struct {
+		Events     []audit.Event `json:"events"`
+		NextCursor string        `json:"next_cursor"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, q.page.Events, resp.Events)
+	assert.Equal(t, "42", resp.NextCursor)
+}
+
+func TestAuditQueryHandler_EmptyPage(t *testing.T) {
+	w := doAuditQuery(&fakeQuerier{}, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"events":[]}`, w.Body.String())
+}
+
+func TestAuditQueryHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		querier  audit.Querier
+		query    string
+		wantCode int
+	}{
+		{name: "хранилище не настроено", querier: nil, wantCode: http.StatusNotImplemented},
+		{name: "некорректный from", querier: &fakeQuerier{}, query: "?from=yesterday", wantCode: http.StatusBadRequest},
+		{name: "некорректный cursor", querier: &fakeQuerier{}, query: "?cursor=abc", wantCode: http.StatusBadRequest},
+		{name: "нулевой limit", querier: &fakeQuerier{}, query: "?limit=0", wantCode: http.StatusBadRequest},
+		{name: "ошибка хранилища", querier: &fakeQuerier{err: errors.New("boom")}, wantCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doAuditQuery(tt.querier, tt.query)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
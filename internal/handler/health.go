@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/Popolzen/shortener/internal/health"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// LivenessHandler создает обработчик проверки живости.
+//
+// Эндпоинт: GET /healthz
+//
+// Не проверяет зависимости: отвечает, пока процесс обрабатывает запросы.
+// Предназначен для livenessProbe — перезапуск не поможет, если недоступна БД.
+//
+// Коды ответа:
+//   - 200: процесс жив
+//
+// Пример ответа:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//
+//	{"status":"up"}
+func LivenessHandler(h *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, h.Live())
+	}
+}
+
+// ReadinessHandler создает обработчик проверки готовности.
+//
+// Эндпоинт: GET /readyz
+//
+// Запускает проверки всех зарегистрированных компонентов и возвращает
+// состояние каждого. После начала graceful shutdown сервис не готов.
+//
+// Коды ответа:
+//   - 200: все компоненты исправны
+//   - 503: хотя бы один компонент неисправен или сервис останавливается
+//
+// Пример ответа:
+//
+//	HTTP/1.1 503 Service Unavailable
+//	Content-Type: application/json
+//
+//	{
+//	  "status": "down",
+//	  "components": {
+//	    "repository": {"status": "up"},
+//	    "delete_queue": {"status": "down", "error": "очередь заполнена: 950 из 1000"}
+//	  }
+//	}
+func ReadinessHandler(h *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := h.Ready(c.Request.Context())
+		status := http.StatusOK
+		if report.Status != health.StatusUp {
+			status = http.StatusServiceUnavailable
+			zap.S().Warnf("Сервис не готов: %+v", report.Components)
+		}
+		c.JSON(status, report)
+	}
+}
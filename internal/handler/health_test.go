@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/health"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		repoErr      error
+		shuttingDown bool
+		wantCode     int
+		wantStatus   health.Status
+	}{
+		{name: "готов", wantCode: http.StatusOK, wantStatus: health.StatusUp},
+		{name: "хранилище недоступно", repoErr: errors.New("нет соединения"), wantCode: http.StatusServiceUnavailable, wantStatus: health.StatusDown},
+		{name: "идёт остановка", shuttingDown: true, wantCode: http.StatusServiceUnavailable, wantStatus: health.StatusDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker(0)
+			checker.Register("repository", func(context.Context) error { return tt.repoErr })
+			if tt.shuttingDown {
+				checker.SetShuttingDown()
+			}
+
+			router := gin.New()
+			router.GET("/readyz", ReadinessHandler(checker))
+			router.GET("/healthz", LivenessHandler(checker))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+
+			var report health.Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			assert.Equal(t, tt.wantStatus, report.Status)
+			assert.Contains(t, report.Components, "repository")
+
+			// Живость не зависит от компонентов
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestPingHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		ping     health.CheckFunc
+		wantCode int
+	}{
+		{name: "БД доступна", ping: func(context.Context) error { return nil }, wantCode: http.StatusOK},
+		{name: "БД недоступна", ping: func(context.Context) error { return errors.New("нет соединения") }, wantCode: http.StatusInternalServerError},
+		{name: "БД не настроена", wantCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/ping", PingHandler(tt.ping))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/Popolzen/shortener/internal/config"
+	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
+	"github.com/Popolzen/shortener/internal/middleware/ratelimit"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/problem"
+	"github.com/Popolzen/shortener/internal/service/shortener"
+	"github.com/gin-gonic/gin"
+)
+
+// PasswordHeader заголовок, в котором API-клиенты передают пароль ссылки
+// вместо формы
+const PasswordHeader = "X-Link-Password"
+
+// maxPasswordFormBytes предел тела формы ввода пароля
+const maxPasswordFormBytes = 4 << 10
+
+// passwordForm страница ввода пароля. Пустой action отправляет форму
+// на тот же адрес вместе с параметрами запроса.
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Ссылка защищена паролем</title>
+</head>
+<body>
+<form method="post" action="">
+<p><label for="password">Ссылка защищена паролем</label></p>
+{{if .}}<p role="alert">{{.}}</p>
+{{end}}<p><input id="password" name="password" type="password" autocomplete="current-password" required autofocus>
+<button type="submit">Открыть</button></p>
+</form>
+</body>
+</html>
+`))
+
+// LinkGate пускает к ссылкам с паролем: по куке доступа, выданной после
+// верного пароля, или по паролю в заголовке X-Link-Password.
+//
+// Неверные пароли расходуют бюджет ratelimit.ClassPassword клиентского IP,
+// верные бюджет не тратят. Жетон списывается до проверки и возвращается
+// после верного пароля, поэтому параллельные попытки не обходят бюджет.
+// Пока бюджет исчерпан, пароль не проверяется вовсе, поэтому перебор
+// не продвигается даже при угаданном пароле.
+type LinkGate struct {
+	cfg     *config.Config
+	limiter *ratelimit.Limiter
+}
+
+// NewLinkGate создаёт проверку доступа к ссылкам с паролем.
+// limiter может быть nil — тогда неверные попытки не ограничиваются.
+//
+// Пример использования:
+//
+//	gate := handler.NewLinkGate(cfg, limiter)
+//	r.GET("/:id", handler.GetHandler(service, auditPub, gate))
+//	r.POST("/:id", handler.UnlockLinkHandler(service, gate))
+func NewLinkGate(cfg *config.Config, limiter *ratelimit.Limiter) *LinkGate {
+	return &LinkGate{cfg: cfg, limiter: limiter}
+}
+
+// allow сообщает, можно ли перенаправить по ссылке с паролем. Если нельзя,
+// ответ уже записан: форма ввода пароля или ошибка неверного пароля
+// из заголовка.
+func (g *LinkGate) allow(c *gin.Context, urlService shortener.URLService, link model.Link) bool {
+	if auth.HasLinkAccess(c, g.cfg, link.ShortURL, link.PasswordHash) {
+		return true
+	}
+	password := c.GetHeader(PasswordHeader)
+	if password == "" {
+		writePasswordForm(c, http.StatusOK, "")
+		return false
+	}
+	if err := g.check(c, urlService, link, password); err != nil {
+		problem.Write(c, err)
+		return false
+	}
+	return true
+}
+
+// check проверяет пароль ссылки с учётом бюджета неверных попыток.
+// Возвращает *problem.Error с кодом unauthorized или rate_limited.
+func (g *LinkGate) check(c *gin.Context, urlService shortener.URLService, link model.Link, password string) error {
+	key := "ip:" + clientip.FromContext(c)
+	if res := g.limiter.Allow(ratelimit.ClassPassword, key); !res.Allowed {
+		ratelimit.WriteHeaders(c, res)
+		return problem.New(problem.CodeRateLimited, http.StatusTooManyRequests, "Слишком много неверных паролей, попробуйте позже")
+	}
+	if password != "" && urlService.CheckLinkPassword(c.Request.Context(), link, password) {
+		g.limiter.Refund(ratelimit.ClassPassword, key)
+		return nil
+	}
+	return problem.New(problem.CodeUnauthorized, http.StatusUnauthorized, "Неверный пароль ссылки")
+}
+
+// writePasswordForm отвечает страницей ввода пароля с сообщением message
+func writePasswordForm(c *gin.Context, status int, message string) {
+	// Форма зависит от куки доступа, кешировать её нельзя
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	passwordForm.Execute(c.Writer, message)
+}
+
+// UnlockLinkHandler создает обработчик ввода пароля ссылки.
+//
+// Эндпоинт: POST /{id}
+// Content-Type: application/x-www-form-urlencoded
+//
+// Принимает пароль из поля формы password или из заголовка X-Link-Password.
+// Верный пароль выставляет подписанную куку доступа на путь /{id} сроком
+// LinkAccessMaxAge и перенаправляет 303 на тот же адрес с параметрами
+// запроса, где GET /{id} уже перенаправит на оригинальный URL. Ссылка без
+// пароля перенаправляется так же без проверки.
+//
+// На неверный пароль или исчерпанный бюджет попыток форма отвечает
+// страницей ввода с сообщением, остальные клиенты — ошибкой problem+json.
+//
+// Коды ответа:
+//   - 303: пароль верный, перенаправление на GET /{id}
+//   - 401: неверный пароль
+//   - 404: ссылка не найдена
+//   - 410: ссылка удалена
+//   - 429: слишком много неверных паролей с этого IP
+//   - 500: внутренняя ошибка сервера
+//
+// Пример запроса:
+//
+//	POST /abc123 HTTP/1.1
+//	Content-Type: application/x-www-form-urlencoded
+//
+//	password=s3cret
+//
+// Пример ответа:
+//
+//	HTTP/1.1 303 See Other
+//	Location: /abc123
+//	Set-Cookie: link_access=...; Path=/abc123; Max-Age=900; HttpOnly; SameSite=Lax
+func UnlockLinkHandler(urlService shortener.URLService, gate *LinkGate) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, err := urlService.GetLink(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			problem.Write(c, err)
+			return
+		}
+
+		if link.Protected() {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPasswordFormBytes)
+			password := c.PostForm("password")
+			if password == "" {
+				password = c.GetHeader(PasswordHeader)
+			}
+
+			if err := gate.check(c, urlService, link, password); err != nil {
+				var p *problem.Error
+				if c.ContentType() == gin.MIMEPOSTForm && errors.As(err, &p) {
+					writePasswordForm(c, p.Status, p.Detail)
+					return
+				}
+				problem.Write(c, err)
+				return
+			}
+			auth.SetLinkAccess(c, gate.cfg, link.ShortURL, link.PasswordHash)
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusSeeOther, c.Request.URL.RequestURI())
+	}
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/Popolzen/shortener/internal/middleware/ratelimit"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/service/shortener"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// setupProtectedRouter настраивает GET и POST /{id} для ссылки abc123 с паролем s3cret
+func setupProtectedRouter(t *testing.T, limiter *ratelimit.Limiter) *gin.Engine {
+	t.Helper()
+	hash, err := shortener.HashPassword("s3cret")
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().Get(gomock.Any(), "abc123").Return(model.Link{
+		ShortURL:    "abc123",
+		OriginalURL: "https://docs.internal/report",
+		LinkOptions: model.LinkOptions{PasswordHash: hash},
+	}, nil).AnyTimes()
+	repo.EXPECT().RecordClick(gomock.Any(), "abc123").AnyTimes()
+
+	cfg := testConfig()
+	cfg.SecretKey = "secret"
+	service := shortener.NewURLService(repo)
+	gate := NewLinkGate(cfg, limiter)
+	router.GET("/:id", GetHandler(service, audit.NewPublisher(), gate))
+	router.POST("/:id", UnlockLinkHandler(service, gate))
+	return router
+}
+
+func postPassword(router *gin.Engine, path, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetHandler_PasswordProtected(t *testing.T) {
+	router := setupProtectedRouter(t, nil)
+
+	tests := []struct {
+		name         string
+		header       string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{name: "без пароля — форма", wantCode: http.StatusOK, wantBody: `name="password"`},
+		{name: "верный пароль в заголовке", header: "s3cret", wantCode: http.StatusTemporaryRedirect, wantLocation: "https://docs.internal/report"},
+		{name: "неверный пароль в заголовке", header: "wrong", wantCode: http.StatusUnauthorized, wantBody: "unauthorized"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+			if tt.header != "" {
+				req.Header.Set(PasswordHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}
+
+func TestUnlockLinkHandler(t *testing.T) {
+	router := setupProtectedRouter(t, nil)
+
+	t.Run("неверный пароль показывает форму с ошибкой", func(t *testing.T) {
+		w := postPassword(router, "/abc123", "wrong")
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), "Неверный пароль ссылки")
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("верный пароль выдаёт куку и перенаправляет", func(t *testing.T) {
+		w := postPassword(router, "/abc123?utm_source=mail", "s3cret")
+
+		require.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/abc123?utm_source=mail", w.Header().Get("Location"))
+
+		var cookie *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == auth.LinkAccessCookie {
+				cookie = c
+			}
+		}
+		require.NotNil(t, cookie)
+		assert.Equal(t, "/abc123", cookie.Path)
+		assert.True(t, cookie.HttpOnly)
+
+		// С кукой GET перенаправляет на оригинальный URL
+		req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		req.AddCookie(cookie)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "https://docs.internal/report", w.Header().Get("Location"))
+	})
+
+	t.Run("пароль в заголовке без формы", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/abc123", nil)
+		req.Header.Set(PasswordHeader, "wrong")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/problem+json")
+	})
+}
+
+func TestUnlockLinkHandler_RateLimitsWrongPasswords(t *testing.T) {
+	limiter := ratelimit.NewLimiter(map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.ClassPassword: {Rate: 2},
+	}, 0)
+	router := setupProtectedRouter(t, limiter)
+
+	// Верный пароль бюджет не расходует
+	for range 3 {
+		assert.Equal(t, http.StatusSeeOther, postPassword(router, "/abc123", "s3cret").Code)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, postPassword(router, "/abc123", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, postPassword(router, "/abc123", "wrong").Code)
+
+	// Бюджет исчерпан: даже верный пароль не проверяется
+	w := postPassword(router, "/abc123", "s3cret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Empty(t, w.Result().Cookies())
+
+	// Заголовок API-клиента делит тот же бюджет
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	req.Header.Set(PasswordHeader, "s3cret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestUnlockLinkHandler_ConcurrentWrongPasswords(t *testing.T) {
+	limiter := ratelimit.NewLimiter(map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.ClassPassword: {Rate: 2},
+	}, 0)
+	router := setupProtectedRouter(t, limiter)
+
+	// Параллельные попытки не проходят проверку раньше, чем расходуют бюджет
+	codes := make([]int, 10)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = postPassword(router, "/abc123", "wrong").Code
+		}()
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusUnauthorized: 2, http.StatusTooManyRequests: 8}, counts)
+}
+
+func TestUnlockLinkHandler_OpenLink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().Get(gomock.Any(), "abc123").Return(model.Link{ShortURL: "abc123", OriginalURL: "https://example.com"}, nil)
+	router.POST("/:id", UnlockLinkHandler(shortener.NewURLService(repo), NewLinkGate(testConfig(), nil)))
+
+	w := postPassword(router, "/abc123", "")
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/abc123", w.Header().Get("Location"))
+	assert.Empty(t, w.Result().Cookies())
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/problem"
+	"github.com/Popolzen/shortener/internal/service/shortener"
+	"github.com/gin-gonic/gin"
+)
+
+// quotaResponse квоты пользователя для администратора
+type quotaResponse struct {
+	UserID      string       `json:"user_id"`
+	Quota       model.Quota  `json:"quota"`              // действующие квоты
+	Override    *model.Quota `json:"override,omitempty"` // переопределение, если задано
+	ActiveLinks int          `json:"active_links"`
+}
+
+// limitBody ограничивает тело запроса квотой пользователя
+func limitBody(c *gin.Context, urlService shortener.URLService, userID string) io.Reader {
+	if limit := urlService.BodyLimit(userID); limit > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+	return c.Request.Body
+}
+
+// writeBodyError отвечает на ошибку чтения тела запроса: 413 с квотой
+// пользователя, если тело больше неё, иначе 400. Ошибки распаковки тела
+// из compressor уже несут свой статус и отдаются как есть.
+func writeBodyError(c *gin.Context, err error, urlService shortener.URLService, userID string) {
+	var (
+		pe          *problem.Error
+		maxBytesErr *http.MaxBytesError
+	)
+	if errors.As(err, &pe) {
+		problem.Write(c, pe)
+		return
+	}
+	if errors.As(err, &maxBytesErr) {
+		problem.Write(c, urlService.BodyQuotaError(userID))
+		return
+	}
+	problem.Write(c, problem.BadRequest("Неправильное тело запроса"))
+}
+
+// GetQuotaHandler создает обработчик просмотра квот пользователя.
+//
+// Эндпоинт: GET /api/internal/quotas/{user_id}
+//
+// Доступ ограничен через middleware TrustedSubnetMiddleware.
+//
+// Коды ответа:
+//   - 200: квоты и число активных ссылок пользователя
+//   - 500: ошибка подсчёта ссылок
+//
+// Пример ответа:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//
+//	{
+//	  "user_id": "550e8400-e29b-41d4-a716-446655440000",
+//	  "quota": {"max_links": 1000, "max_batch": 100, "max_body_bytes": 1048576},
+//	  "override": {"max_links": 1000, "max_batch": 0, "max_body_bytes": 0},
+//	  "active_links": 42
+//	}
+func GetQuotaHandler(urlService shortener.URLService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("user_id")
+
+		active, err := urlService.CountUserURLs(c.Request.Context(), userID)
+		if err != nil {
+			problem.Write(c, fmt.Errorf("подсчёт ссылок пользователя: %w", err))
+			return
+		}
+
+		resp := quotaResponse{UserID: userID, Quota: urlService.Quota(userID), ActiveLinks: active}
+		if o, ok := urlService.Quotas().Override(userID); ok {
+			resp.Override = &o
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// SetQuotaHandler создает обработчик переопределения квот пользователя.
+//
+// Эндпоинт: PUT /api/internal/quotas/{user_id}
+// Content-Type: application/json
+//
+// Поле 0 берётся из квоты по умолчанию, отрицательное снимает ограничение.
+// Переопределения хранятся в памяти процесса; постоянные задаются в
+// quota_overrides конфигурации.
+//
+// Коды ответа:
+//   - 200: переопределение сохранено, возвращаются действующие квоты
+//   - 400: некорректный JSON в теле запроса
+//   - 501: квоты не настроены
+//
+// Пример запроса:
+//
+//	PUT /api/internal/quotas/550e8400-e29b-41d4-a716-446655440000 HTTP/1.1
+//	Content-Type: application/json
+//
+//	{"max_links": 1000}
+func SetQuotaHandler(urlService shortener.URLService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		quotas := urlService.Quotas()
+		if quotas == nil {
+			problem.Write(c, problem.NotImplemented("Квоты не настроены"))
+			return
+		}
+
+		var o model.Quota
+		if err := c.ShouldBindJSON(&o); err != nil {
+			problem.Write(c, problem.BadRequest("Неправильное тело запроса"))
+			return
+		}
+
+		userID := c.Param("user_id")
+		quotas.SetOverride(userID, o)
+		c.JSON(http.StatusOK, quotaResponse{UserID: userID, Quota: quotas.For(userID), Override: &o})
+	}
+}
+
+// DeleteQuotaHandler создает обработчик сброса квот пользователя к значениям по умолчанию.
+//
+// Эндпоинт: DELETE /api/internal/quotas/{user_id}
+//
+// Коды ответа:
+//   - 204: переопределение удалено
+//   - 501: квоты не настроены
+func DeleteQuotaHandler(urlService shortener.URLService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		quotas := urlService.Quotas()
+		if quotas == nil {
+			problem.Write(c, problem.NotImplemented("Квоты не настроены"))
+			return
+		}
+		quotas.DeleteOverride(c.Param("user_id"))
+		c.Status(http.StatusNoContent)
+	}
+}
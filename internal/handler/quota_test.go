@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/service/shortener"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPostHandler_QuotaErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		quota     model.Quota
+		body      string
+		active    int
+		wantCode  int
+		wantQuota string
+	}{
+		{name: "лимит ссылок исчерпан", quota: model.Quota{MaxLinks: 2}, body: "https://example.com", active: 2, wantCode: http.StatusForbidden, wantQuota: "links"},
+		{name: "тело больше квоты", quota: model.Quota{MaxBodyBytes: 10}, body: "https://example.com/long", wantCode: http.StatusRequestEntityTooLarge, wantQuota: "body_bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			router, repo := setupTestRouter(ctrl)
+			if tt.quota.MaxLinks > 0 {
+				repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+				repo.EXPECT().Store(gomock.Any(), gomock.Any(), tt.body, "test-user-123", gomock.Any(), gomock.Any(), tt.quota.MaxLinks).
+					Return(model.NewLinkQuotaError(tt.quota.MaxLinks, tt.active, 1))
+			}
+
+			urlService := shortener.NewURLServiceWithQuotas(repo, shortener.NewQuotas(tt.quota, nil))
+			router.POST("/", PostHandler(urlService, testConfig(), audit.NewPublisher()))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			var resp map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, "quota_exceeded", resp["code"])
+			assert.Equal(t, tt.wantQuota, resp["quota"])
+		})
+	}
+}
+
+func TestBatchHandler_BatchQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+
+	// Пакет отклоняется до обращения к хранилищу
+	urlService := shortener.NewURLServiceWithQuotas(repo, shortener.NewQuotas(model.Quota{MaxBatch: 1}, nil))
+	router.POST("/api/shorten/batch", BatchHandler(urlService, testConfig(), audit.NewPublisher()))
+
+	body, _ := json.Marshal([]model.URLBatchRequest{
+		{CorrelationID: "1", OriginalURL: "https://one.com"},
+		{CorrelationID: "2", OriginalURL: "https://two.com"},
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	var resp map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "quota_exceeded", resp["code"])
+	assert.Equal(t, "batch", resp["quota"])
+	assert.EqualValues(t, 1, resp["limit"])
+	assert.EqualValues(t, 2, resp["requested"])
+}
+
+func TestQuotaHandlers_Override(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, repo := setupTestRouter(ctrl)
+	repo.EXPECT().CountUserURLs(gomock.Any(), "u1").Return(3, nil).Times(2)
+
+	quotas := shortener.NewQuotas(model.Quota{MaxLinks: 10, MaxBatch: 5}, nil)
+	urlService := shortener.NewURLServiceWithQuotas(repo, quotas)
+	router.GET("/quotas/:user_id", GetQuotaHandler(urlService))
+	router.PUT("/quotas/:user_id", SetQuotaHandler(urlService))
+	router.DELETE("/quotas/:user_id", DeleteQuotaHandler(urlService))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/quotas/u1", strings.NewReader(`{"max_links": 100, "max_batch": -1}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/quotas/u1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp quotaResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, model.Quota{MaxLinks: 100, MaxBatch: -1}, resp.Quota)
+	assert.NotNil(t, resp.Override)
+	assert.Equal(t, 3, resp.ActiveLinks)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/quotas/u1", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/quotas/u1", nil))
+	var reset quotaResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reset))
+	assert.Equal(t, model.Quota{MaxLinks: 10, MaxBatch: 5}, reset.Quota)
+	assert.Nil(t, reset.Override)
+}
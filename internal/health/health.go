@@ -0,0 +1,194 @@
+// Package health собирает проверки живости и готовности сервиса.
+//
+// Живость (/healthz) говорит только о том, что процесс отвечает на запросы.
+// Готовность (/readyz) запускает зарегистрированные проверки компонентов:
+// хранилища, наблюдателей аудита, очереди удаления, диска. Сервис не готов,
+// если не прошла хотя бы одна проверка или начался graceful shutdown —
+// балансировщик перестаёт направлять трафик, пока сервер дорабатывает
+// текущие запросы.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout время на выполнение всех проверок готовности
+const DefaultTimeout = 2 * time.Second
+
+// Status состояние сервиса или компонента
+type Status string
+
+// Состояния
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// ErrShuttingDown возвращается проверкой готовности после начала остановки
+var ErrShuttingDown = errors.New("сервис останавливается")
+
+// CheckFunc проверка одного компонента, nil — компонент исправен
+type CheckFunc func(ctx context.Context) error
+
+// GroupFunc проверка набора однотипных компонентов, например всех
+// наблюдателей аудита. Возвращает ошибку по имени компонента.
+type GroupFunc func(ctx context.Context) map[string]error
+
+// Component результат проверки одного компонента
+type Component struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report результат проверки сервиса
+type Report struct {
+	Status     Status               `json:"status"`
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+// Checker реестр проверок. Безопасен для конкурентного использования.
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks map[string]CheckFunc
+	groups []GroupFunc
+
+	shuttingDown atomic.Bool
+}
+
+// NewChecker создаёт реестр проверок с общим таймаутом, 0 — DefaultTimeout
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout, checks: make(map[string]CheckFunc)}
+}
+
+// Register добавляет проверку компонента готовности
+func (h *Checker) Register(name string, check CheckFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// RegisterGroup добавляет проверку набора компонентов готовности
+func (h *Checker) RegisterGroup(group GroupFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.groups = append(h.groups, group)
+}
+
+// Check выполняет одну зарегистрированную проверку.
+// Для незарегистрированного имени возвращает ошибку.
+func (h *Checker) Check(ctx context.Context, name string) error {
+	h.mu.RLock()
+	check, ok := h.checks[name]
+	h.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("проверка %s не зарегистрирована", name)
+	}
+	return check(ctx)
+}
+
+// SetShuttingDown переводит сервис в неготовое состояние.
+// Вызывается в начале graceful shutdown.
+func (h *Checker) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Live возвращает отчёт о живости: процесс отвечает, проверки не запускаются
+func (h *Checker) Live() Report {
+	return Report{Status: StatusUp}
+}
+
+// Ready параллельно запускает все проверки и возвращает отчёт о готовности
+func (h *Checker) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	h.mu.RLock()
+	checks := make(map[string]CheckFunc, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	groups := append([]GroupFunc(nil), h.groups...)
+	h.mu.RUnlock()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]error, len(checks))
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := run(ctx, check)
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}()
+	}
+	for _, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs := group(ctx)
+			mu.Lock()
+			for name, err := range errs {
+				results[name] = err
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if h.shuttingDown.Load() {
+		results["shutdown"] = ErrShuttingDown
+	}
+	return report(results)
+}
+
+// run выполняет проверку, не дольше ctx
+func run(ctx context.Context, check CheckFunc) error {
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("проверка не завершилась: %w", ctx.Err())
+	}
+}
+
+// report собирает отчёт по результатам проверок
+func report(results map[string]error) Report {
+	r := Report{Status: StatusUp, Components: make(map[string]Component, len(results))}
+	for name, err := range results {
+		c := Component{Status: StatusUp}
+		if err != nil {
+			c = Component{Status: StatusDown, Error: err.Error()}
+			r.Status = StatusDown
+		}
+		r.Components[name] = c
+	}
+	return r
+}
+
+// QueueCheck проверяет, что очередь заполнена меньше чем на threshold (от 0 до 1).
+// load возвращает текущую длину и ёмкость очереди.
+func QueueCheck(load func() (queued, capacity int), threshold float64) CheckFunc {
+	return func(context.Context) error {
+		queued, capacity := load()
+		if capacity > 0 && float64(queued) >= threshold*float64(capacity) {
+			return fmt.Errorf("очередь заполнена: %d из %d", queued, capacity)
+		}
+		return nil
+	}
+}
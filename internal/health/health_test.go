@@ -0,0 +1,139 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_Ready(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]CheckFunc
+		group      GroupFunc
+		wantStatus Status
+		wantDown   []string
+	}{
+		{
+			name:       "без проверок",
+			wantStatus: StatusUp,
+		},
+		{
+			name: "все исправны",
+			checks: map[string]CheckFunc{
+				"repository": func(context.Context) error { return nil },
+			},
+			group: func(context.Context) map[string]error {
+				return map[string]error{"audit[0] file": nil}
+			},
+			wantStatus: StatusUp,
+		},
+		{
+			name: "упала проверка",
+			checks: map[string]CheckFunc{
+				"repository":   func(context.Context) error { return errors.New("нет соединения") },
+				"delete_queue": func(context.Context) error { return nil },
+			},
+			wantStatus: StatusDown,
+			wantDown:   []string{"repository"},
+		},
+		{
+			name: "упал компонент группы",
+			group: func(context.Context) map[string]error {
+				return map[string]error{"audit[0] file": nil, "audit[1] http": errors.New("503")}
+			},
+			wantStatus: StatusDown,
+			wantDown:   []string{"audit[1] http"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewChecker(0)
+			for name, check := range tt.checks {
+				h.Register(name, check)
+			}
+			if tt.group != nil {
+				h.RegisterGroup(tt.group)
+			}
+
+			report := h.Ready(context.Background())
+			assert.Equal(t, tt.wantStatus, report.Status)
+
+			var down []string
+			for name, c := range report.Components {
+				if c.Status == StatusDown {
+					assert.NotEmpty(t, c.Error)
+					down = append(down, name)
+				}
+			}
+			assert.ElementsMatch(t, tt.wantDown, down)
+		})
+	}
+}
+
+func TestChecker_ReadyTimeout(t *testing.T) {
+	h := NewChecker(20 * time.Millisecond)
+	h.Register("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	start := time.Now()
+	report := h.Ready(context.Background())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Contains(t, report.Components["slow"].Error, "не завершилась")
+}
+
+func TestChecker_ShuttingDown(t *testing.T) {
+	h := NewChecker(0)
+	h.Register("repository", func(context.Context) error { return nil })
+	require.Equal(t, StatusUp, h.Ready(context.Background()).Status)
+
+	h.SetShuttingDown()
+
+	report := h.Ready(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, ErrShuttingDown.Error(), report.Components["shutdown"].Error)
+	// Живость не зависит от остановки
+	assert.Equal(t, StatusUp, h.Live().Status)
+}
+
+func TestChecker_Check(t *testing.T) {
+	h := NewChecker(0)
+	h.Register("repository", func(context.Context) error { return errors.New("нет соединения") })
+
+	assert.EqualError(t, h.Check(context.Background(), "repository"), "нет соединения")
+	assert.Error(t, h.Check(context.Background(), "unknown"))
+}
+
+func TestQueueCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		queued   int
+		capacity int
+		wantErr  bool
+	}{
+		{name: "пустая очередь", queued: 0, capacity: 10},
+		{name: "ниже порога", queued: 8, capacity: 10},
+		{name: "на пороге", queued: 9, capacity: 10, wantErr: true},
+		{name: "без буфера", queued: 0, capacity: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := QueueCheck(func() (int, int) { return tt.queued, tt.capacity }, 0.9)
+			err := check(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Popolzen/shortener/internal/config"
 	"github.com/gin-gonic/gin"
@@ -17,71 +20,156 @@ const UserIDKey ctxKey = "user_id"
 const CookieValidKey ctxKey = "cookie_was_valid"
 const HadCookieKey ctxKey = "had_cookie"
 
-// validateCookie валидирует подписанную куки и возвращает userID, если валидна.
-func validateCookie(cookieValue string, cfg *config.Config) (string, bool) {
-	parts := strings.Split(cookieValue, ".")
-	if len(parts) != 2 {
-		return "", false
+// session описывает содержимое сессионной куки.
+type session struct {
+	userID   string
+	issuedAt time.Time
+	legacy   bool // кука старого формата userID.signature без времени выдачи
+}
+
+// now позволяет подменять время в тестах.
+var now = time.Now
+
+// cookieName возвращает имя сессионной куки с учётом значения по умолчанию.
+func cookieName(cfg *config.Config) string {
+	if cfg.SessionCookieName == "" {
+		return config.DefaultSessionCookieName
 	}
-	userID, signature := parts[0], parts[1]
+	return cfg.SessionCookieName
+}
 
+// sameSite переводит строковое значение из конфига в http.SameSite.
+func sameSite(cfg *config.Config) http.SameSite {
+	switch strings.ToLower(cfg.SessionSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// sign вычисляет HMAC-SHA256 от payload.
+func sign(payload string, cfg *config.Config) []byte {
 	mac := hmac.New(sha256.New, []byte(cfg.SecretKey))
-	mac.Write([]byte(userID))
-	expectedSignature := mac.Sum(nil)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// validateCookie валидирует подписанную куки и возвращает сессию, если она валидна и не истекла.
+func validateCookie(cookieValue string, cfg *config.Config) (session, bool) {
+	parts := strings.Split(cookieValue, ".")
+
+	var s session
+	var payload, signature string
+	switch len(parts) {
+	case 2:
+		s.userID, signature = parts[0], parts[1]
+		s.issuedAt = now()
+		s.legacy = true
+		payload = s.userID
+	case 3:
+		issued, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return session{}, false
+		}
+		s.userID, s.issuedAt, signature = parts[0], time.Unix(issued, 0), parts[2]
+		payload = parts[0] + "." + parts[1]
+	default:
+		return session{}, false
+	}
 
 	// Декодируем полученную подпись из base64
 	receivedSignature, err := base64.StdEncoding.DecodeString(signature)
 	if err != nil {
-		return "", false
+		return session{}, false
 	}
 
 	// Сравниваем байты HMAC
-	return userID, hmac.Equal(receivedSignature, expectedSignature)
+	if !hmac.Equal(receivedSignature, sign(payload, cfg)) {
+		return session{}, false
+	}
+
+	if cfg.SessionMaxAge > 0 && now().Sub(s.issuedAt) > time.Duration(cfg.SessionMaxAge)*time.Second {
+		return session{}, false
+	}
+
+	return s, true
 }
 
-// signUserID подписывает UserID с использованием HMAC-SHA256
-func signUserID(userID string, cfg *config.Config) string {
-	mac := hmac.New(sha256.New, []byte(cfg.SecretKey))
-	mac.Write([]byte(userID))
-	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
-	return userID + "." + signature
+// signUserID подписывает UserID и время выдачи с использованием HMAC-SHA256
+func signUserID(userID string, issuedAt time.Time, cfg *config.Config) string {
+	payload := userID + "." + strconv.FormatInt(issuedAt.Unix(), 10)
+	signature := base64.StdEncoding.EncodeToString(sign(payload, cfg))
+	return payload + "." + signature
 }
 
-// getOrCreateUserID извлекает userID из куки, если валидна, или генерирует новый.
-func getOrCreateUserID(c *gin.Context, cfg *config.Config) (string, bool, bool) {
-	var userID string
-	var isValid bool
-	var hadCookie bool
-
-	cookie, err := c.Cookie("user_id")
-	hadCookie = (err == nil && cookie != "")
-
-	if !hadCookie {
-		userID = uuid.New().String()
-		isValid = false
-	} else {
-		userID, isValid = validateCookie(cookie, cfg)
-		if !isValid {
-			userID = uuid.New().String()
+// getOrCreateUserID извлекает сессию из куки, если валидна, или создаёт новую.
+func getOrCreateUserID(c *gin.Context, cfg *config.Config) (session, bool, bool) {
+	cookie, err := c.Cookie(cookieName(cfg))
+	hadCookie := err == nil && cookie != ""
+
+	if hadCookie {
+		if s, ok := validateCookie(cookie, cfg); ok {
+			return s, true, true
 		}
 	}
 
-	return userID, isValid, hadCookie
+	return session{userID: uuid.New().String(), issuedAt: now()}, false, hadCookie
 }
 
-// setSignedCookie подписывает userID и устанавливает куки в ответе.
-func setSignedCookie(c *gin.Context, userID string, cfg *config.Config) {
-	signedValue := signUserID(userID, cfg)
-	c.SetCookie("user_id", signedValue, 3600*24*30, "/", "", false, true)
+// setSignedCookie подписывает userID и устанавливает куки в ответе согласно политике сессии.
+// SessionMaxAge равный 0 даёт сессионную куку без атрибута Max-Age.
+func setSignedCookie(c *gin.Context, s session, cfg *config.Config) {
+	mode := sameSite(cfg)
+	// Браузеры отбрасывают SameSite=None без Secure
+	secure := cfg.CookieSecure() || mode == http.SameSiteNoneMode
+
+	c.SetSameSite(mode)
+	c.SetCookie(cookieName(cfg), signUserID(s.userID, s.issuedAt, cfg), cfg.SessionMaxAge, "/", cfg.SessionCookieDomain, secure, true)
 }
 
 // AuthMiddleware - middleware для обработки аутентификации пользователя через куки.
+//
+// При скользящем сроке жизни (SessionSliding) кука переиздаётся на каждом запросе
+// и её срок отсчитывается заново. При абсолютном сроке кука выставляется только
+// при выдаче новой идентичности, а истёкшая сессия заменяется новой.
 func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID, isValid, hadCookie := getOrCreateUserID(c, cfg)
-		setSignedCookie(c, userID, cfg)
+		s, isValid, hadCookie := getOrCreateUserID(c, cfg)
+
+		// Куку старого формата переиздаём, чтобы в ней появилось время выдачи
+		if !isValid || s.legacy || cfg.SessionSliding {
+			s.issuedAt = now()
+			setSignedCookie(c, s, cfg)
+		}
+
+		c.Set(string(UserIDKey), s.userID)
+		c.Set(string(CookieValidKey), isValid)
+		c.Set(string(HadCookieKey), hadCookie)
+
+		c.Next()
+	}
+}
+
+// OptionalAuthMiddleware - middleware, которое только читает сессионную куку.
+//
+// Если кука валидна, userID кладётся в контекст. Новая идентичность не создаётся
+// и кука в ответе не выставляется — используется на путях вроде редиректа GET /{id}.
+func OptionalAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cookie, err := c.Cookie(cookieName(cfg))
+		hadCookie := err == nil && cookie != ""
+
+		isValid := false
+		if hadCookie {
+			if s, ok := validateCookie(cookie, cfg); ok {
+				isValid = true
+				c.Set(string(UserIDKey), s.userID)
+			}
+		}
 
-		c.Set(string(UserIDKey), userID)
 		c.Set(string(CookieValidKey), isValid)
 		c.Set(string(HadCookieKey), hadCookie)
 
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		SecretKey:         "secret",
+		SessionCookieName: config.DefaultSessionCookieName,
+		SessionMaxAge:     3600,
+		SessionSliding:    true,
+		SessionSameSite:   config.DefaultSessionSameSite,
+	}
+}
+
+func setupRouter(mw gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(mw)
+	r.GET("/test", func(c *gin.Context) {
+		uid, _ := c.Get(string(UserIDKey))
+		c.String(http.StatusOK, "%v", uid)
+	})
+	return r
+}
+
+// freezeTime подменяет текущее время на at до конца теста.
+func freezeTime(t *testing.T, at time.Time) {
+	t.Helper()
+	prev := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = prev })
+}
+
+func doRequest(r *gin.Engine, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func responseCookie(t *testing.T, w *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func signedCookie(cfg *config.Config, userID string, issuedAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:  cookieName(cfg),
+		Value: url.QueryEscape(signUserID(userID, issuedAt, cfg)),
+	}
+}
+
+func TestAuthMiddleware_NewSessionAttributes(t *testing.T) {
+	tests := []struct {
+		name         string
+		mutate       func(cfg *config.Config)
+		wantSecure   bool
+		wantSameSite http.SameSite
+		wantMaxAge   int
+		wantDomain   string
+		wantName     string
+	}{
+		{
+			name:         "по умолчанию",
+			mutate:       func(cfg *config.Config) {},
+			wantSameSite: http.SameSiteLaxMode,
+			wantMaxAge:   3600,
+			wantName:     "user_id",
+		},
+		{
+			name:         "HTTPS включает Secure",
+			mutate:       func(cfg *config.Config) { cfg.EnableHTTPS = true },
+			wantSecure:   true,
+			wantSameSite: http.SameSiteLaxMode,
+			wantMaxAge:   3600,
+			wantName:     "user_id",
+		},
+		{
+			name:         "явный Secure без HTTPS",
+			mutate:       func(cfg *config.Config) { cfg.SessionSecure = true },
+			wantSecure:   true,
+			wantSameSite: http.SameSiteLaxMode,
+			wantMaxAge:   3600,
+			wantName:     "user_id",
+		},
+		{
+			name:         "SameSite strict",
+			mutate:       func(cfg *config.Config) { cfg.SessionSameSite = "Strict" },
+			wantSameSite: http.SameSiteStrictMode,
+			wantMaxAge:   3600,
+			wantName:     "user_id",
+		},
+		{
+			name:         "SameSite none требует Secure",
+			mutate:       func(cfg *config.Config) { cfg.SessionSameSite = "none" },
+			wantSecure:   true,
+			wantSameSite: http.SameSiteNoneMode,
+			wantMaxAge:   3600,
+			wantName:     "user_id",
+		},
+		{
+			name: "имя и домен куки",
+			mutate: func(cfg *config.Config) {
+				cfg.SessionCookieName = "sid"
+				cfg.SessionCookieDomain = "example.com"
+			},
+			wantSameSite: http.SameSiteLaxMode,
+			wantMaxAge:   3600,
+			wantDomain:   "example.com",
+			wantName:     "sid",
+		},
+		{
+			name:         "сессионная кука без срока",
+			mutate:       func(cfg *config.Config) { cfg.SessionMaxAge = 0 },
+			wantSameSite: http.SameSiteLaxMode,
+			wantMaxAge:   0,
+			wantName:     "user_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			tt.mutate(cfg)
+
+			w := doRequest(setupRouter(AuthMiddleware(cfg)), nil)
+
+			cookie := responseCookie(t, w, tt.wantName)
+			require.NotNil(t, cookie)
+			assert.Equal(t, tt.wantSecure, cookie.Secure)
+			assert.Equal(t, tt.wantSameSite, cookie.SameSite)
+			assert.Equal(t, tt.wantMaxAge, cookie.MaxAge)
+			assert.Equal(t, tt.wantDomain, cookie.Domain)
+			assert.True(t, cookie.HttpOnly)
+		})
+	}
+}
+
+func TestAuthMiddleware_ExpiryPolicies(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name        string
+		sliding     bool
+		age         time.Duration
+		wantSameID  bool
+		wantReissue bool
+	}{
+		{name: "скользящая, свежая кука продлевается", sliding: true, age: 10 * time.Minute, wantSameID: true, wantReissue: true},
+		{name: "скользящая, истёкшая кука заменяется", sliding: true, age: 2 * time.Hour, wantSameID: false, wantReissue: true},
+		{name: "абсолютная, свежая кука не переиздаётся", sliding: false, age: 10 * time.Minute, wantSameID: true, wantReissue: false},
+		{name: "абсолютная, истёкшая кука заменяется", sliding: false, age: 2 * time.Hour, wantSameID: false, wantReissue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.SessionSliding = tt.sliding
+			freezeTime(t, start.Add(tt.age))
+
+			w := doRequest(setupRouter(AuthMiddleware(cfg)), signedCookie(cfg, "user-1", start))
+
+			assert.Equal(t, tt.wantSameID, w.Body.String() == "user-1")
+
+			cookie := responseCookie(t, w, cookieName(cfg))
+			if !tt.wantReissue {
+				assert.Nil(t, cookie)
+				return
+			}
+			require.NotNil(t, cookie)
+
+			value, err := url.QueryUnescape(cookie.Value)
+			require.NoError(t, err)
+			s, ok := validateCookie(value, cfg)
+			require.True(t, ok)
+			assert.Equal(t, start.Add(tt.age).Unix(), s.issuedAt.Unix())
+		})
+	}
+}
+
+func TestAuthMiddleware_InvalidSignature(t *testing.T) {
+	cfg := testConfig()
+	other := testConfig()
+	other.SecretKey = "other"
+
+	var cookieValid, hadCookie any
+	r := gin.New()
+	r.Use(AuthMiddleware(cfg))
+	r.GET("/test", func(c *gin.Context) {
+		cookieValid, _ = c.Get(string(CookieValidKey))
+		hadCookie, _ = c.Get(string(HadCookieKey))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.AddCookie(signedCookie(other, "user-1", time.Now()))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, true, hadCookie)
+	assert.Equal(t, false, cookieValid)
+	assert.NotNil(t, responseCookie(t, w, cookieName(cfg)))
+}
+
+func TestAuthMiddleware_LegacyCookieUpgraded(t *testing.T) {
+	cfg := testConfig()
+	cfg.SessionSliding = false
+
+	legacy := "user-1." + base64Sign("user-1", cfg)
+	w := doRequest(setupRouter(AuthMiddleware(cfg)), &http.Cookie{Name: cookieName(cfg), Value: url.QueryEscape(legacy)})
+
+	assert.Equal(t, "user-1", w.Body.String())
+
+	cookie := responseCookie(t, w, cookieName(cfg))
+	require.NotNil(t, cookie)
+	value, err := url.QueryUnescape(cookie.Value)
+	require.NoError(t, err)
+	s, ok := validateCookie(value, cfg)
+	require.True(t, ok)
+	assert.False(t, s.legacy)
+	assert.Equal(t, "user-1", s.userID)
+}
+
+func TestOptionalAuthMiddleware(t *testing.T) {
+	cfg := testConfig()
+
+	t.Run("без куки пользователь не создаётся", func(t *testing.T) {
+		w := doRequest(setupRouter(OptionalAuthMiddleware(cfg)), nil)
+
+		assert.Equal(t, "<nil>", w.Body.String())
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("валидная кука читается без переиздания", func(t *testing.T) {
+		w := doRequest(setupRouter(OptionalAuthMiddleware(cfg)), signedCookie(cfg, "user-1", time.Now()))
+
+		assert.Equal(t, "user-1", w.Body.String())
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("невалидная кука игнорируется", func(t *testing.T) {
+		w := doRequest(setupRouter(OptionalAuthMiddleware(cfg)), &http.Cookie{Name: cookieName(cfg), Value: "broken"})
+
+		assert.Equal(t, "<nil>", w.Body.String())
+		assert.Empty(t, w.Result().Cookies())
+	})
+}
+
+func TestLinkAccess(t *testing.T) {
+	cfg := testConfig()
+	issued := time.Unix(1700000000, 0)
+	freezeTime(t, issued)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:id", func(c *gin.Context) {
+		SetLinkAccess(c, cfg, c.Param("id"), "hash-1")
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+
+	cookie := responseCookie(t, w, LinkAccessCookie)
+	assert.Equal(t, "/abc123", cookie.Path)
+	assert.Equal(t, config.DefaultLinkAccessMaxAge, cookie.MaxAge)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	hasAccess := func(cookie *http.Cookie, shortURL, passwordHash string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/"+shortURL, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		return HasLinkAccess(c, cfg, shortURL, passwordHash)
+	}
+
+	tests := []struct {
+		name         string
+		cookie       *http.Cookie
+		shortURL     string
+		passwordHash string
+		at           time.Time
+		want         bool
+	}{
+		{name: "та же ссылка и пароль", cookie: cookie, shortURL: "abc123", passwordHash: "hash-1", at: issued, want: true},
+		{name: "без куки", shortURL: "abc123", passwordHash: "hash-1", at: issued},
+		{name: "другая ссылка", cookie: cookie, shortURL: "def456", passwordHash: "hash-1", at: issued},
+		{name: "пароль сменился", cookie: cookie, shortURL: "abc123", passwordHash: "hash-2", at: issued},
+		{name: "кука истекла", cookie: cookie, shortURL: "abc123", passwordHash: "hash-1", at: issued.Add(time.Duration(config.DefaultLinkAccessMaxAge) * time.Second)},
+		{name: "подделанный срок", cookie: &http.Cookie{Name: LinkAccessCookie, Value: "9999999999." + url.QueryEscape(base64Sign("x", cfg))}, shortURL: "abc123", passwordHash: "hash-1", at: issued},
+		{name: "мусор в куке", cookie: &http.Cookie{Name: LinkAccessCookie, Value: "broken"}, shortURL: "abc123", passwordHash: "hash-1", at: issued},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			freezeTime(t, tt.at)
+			assert.Equal(t, tt.want, hasAccess(tt.cookie, tt.shortURL, tt.passwordHash))
+		})
+	}
+}
+
+func base64Sign(payload string, cfg *config.Config) string {
+	return base64.StdEncoding.EncodeToString(sign(payload, cfg))
+}
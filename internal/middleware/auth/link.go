@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// LinkAccessCookie имя куки доступа к ссылке с паролем. Кука выставляется
+// с путём /{id}, поэтому куки разных ссылок не пересекаются.
+const LinkAccessCookie = "link_access"
+
+// linkAccessMaxAge возвращает время жизни куки доступа с учётом значения по умолчанию.
+func linkAccessMaxAge(cfg *config.Config) int {
+	if cfg.LinkAccessMaxAge <= 0 {
+		return config.DefaultLinkAccessMaxAge
+	}
+	return cfg.LinkAccessMaxAge
+}
+
+// linkAccessPayload возвращает подписываемые данные куки доступа.
+// Хеш пароля входит в подпись, но не в куку: после смены пароля
+// выданные куки перестают подходить.
+func linkAccessPayload(shortURL, expires, passwordHash string) string {
+	return "link." + shortURL + "." + expires + "." + passwordHash
+}
+
+// SetLinkAccess выставляет подписанную куку доступа к ссылке после
+// верного пароля. Кука живёт LinkAccessMaxAge секунд и отправляется
+// браузером только на /{shortURL}.
+func SetLinkAccess(c *gin.Context, cfg *config.Config, shortURL, passwordHash string) {
+	maxAge := linkAccessMaxAge(cfg)
+	expires := strconv.FormatInt(now().Add(time.Duration(maxAge)*time.Second).Unix(), 10)
+	signature := base64.StdEncoding.EncodeToString(sign(linkAccessPayload(shortURL, expires, passwordHash), cfg))
+
+	// Strict не подходит: переход по ссылке из письма или чата был бы без куки
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(LinkAccessCookie, expires+"."+signature, maxAge, "/"+shortURL, cfg.SessionCookieDomain, cfg.CookieSecure(), true)
+}
+
+// HasLinkAccess сообщает, есть ли в запросе действующая кука доступа
+// к ссылке с этим хешем пароля.
+func HasLinkAccess(c *gin.Context, cfg *config.Config, shortURL, passwordHash string) bool {
+	cookie, err := c.Cookie(LinkAccessCookie)
+	if err != nil {
+		return false
+	}
+	expires, signature, ok := strings.Cut(cookie, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !now().Before(time.Unix(exp, 0)) {
+		return false
+	}
+	receivedSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(receivedSignature, sign(linkAccessPayload(shortURL, expires, passwordHash), cfg))
+}
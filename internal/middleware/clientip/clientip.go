@@ -0,0 +1,157 @@
+// Package clientip определяет IP-адрес клиента с учётом доверенных прокси.
+//
+// Заголовкам X-Forwarded-For и X-Real-IP верим только тогда, когда
+// непосредственный собеседник (RemoteAddr) — доверенный прокси. Иначе
+// адресом клиента считается RemoteAddr: любой клиент может прислать
+// произвольный X-Real-IP.
+//
+// Middleware кладёт адрес в контекст gin, и его используют логирование,
+// аудит, ограничение частоты и проверка доверенной подсети.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ctxKey string
+
+// ClientIPKey ключ адреса клиента в контексте gin
+const ClientIPKey ctxKey = "client_ip"
+
+// Resolver определяет адрес клиента по запросу
+type Resolver struct {
+	proxies []netip.Prefix
+}
+
+// NewResolver создаёт резолвер с доверенными прокси.
+//
+// Каждый элемент — адрес (10.0.0.1, ::1) или подсеть в CIDR (10.0.0.0/8, fd00::/8).
+// Пустой список означает, что заголовкам не доверяем никогда.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	prefixes, err := ParsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("clientip: доверенные прокси: %w", err)
+	}
+	return &Resolver{proxies: prefixes}, nil
+}
+
+// ParsePrefixes разбирает список адресов и подсетей IPv4 и IPv6.
+// Одиночный адрес становится подсетью из одного адреса, пустые элементы пропускаются.
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.Contains(v, "/") {
+			p, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("некорректный CIDR %q: %w", v, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный адрес %q: %w", v, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Contains сообщает, входит ли адрес хотя бы в одну из подсетей
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve возвращает адрес клиента.
+//
+// Если RemoteAddr — доверенный прокси, X-Forwarded-For просматривается
+// справа налево, пропуская доверенные прокси; первый недоверенный адрес
+// и есть клиент. Без X-Forwarded-For используется X-Real-IP.
+// Некорректные заголовки игнорируются.
+func (r *Resolver) Resolve(req *http.Request) netip.Addr {
+	peer := remoteAddr(req)
+	if !peer.IsValid() || r == nil || !Contains(r.proxies, peer) {
+		return peer
+	}
+
+	if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Цепочка испорчена: дальше неё не доверяем
+				break
+			}
+			client = addr.Unmap()
+			if !Contains(r.proxies, client) {
+				break
+			}
+		}
+		return client
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(req.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap()
+	}
+	return peer
+}
+
+// remoteAddr разбирает RemoteAddr запроса
+func remoteAddr(req *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// Middleware определяет адрес клиента и кладёт его в контекст.
+// Должен стоять первым, до логирования и проверки подсети.
+func Middleware(r *Resolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(string(ClientIPKey), r.Resolve(c.Request))
+		c.Next()
+	}
+}
+
+// Addr возвращает адрес клиента из контекста.
+// Без Middleware адресом считается RemoteAddr.
+func Addr(c *gin.Context) netip.Addr {
+	if v, ok := c.Get(string(ClientIPKey)); ok {
+		if addr, ok := v.(netip.Addr); ok {
+			return addr
+		}
+	}
+	return remoteAddr(c.Request)
+}
+
+// FromContext возвращает адрес клиента строкой, пустой — если он неизвестен
+func FromContext(c *gin.Context) string {
+	addr := Addr(c)
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
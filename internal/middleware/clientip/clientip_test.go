@@ -0,0 +1,76 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "fd00::1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{name: "без прокси", remoteAddr: "203.0.113.5:1234", want: "203.0.113.5"},
+		{name: "X-Real-IP от недоверенного адреса", remoteAddr: "203.0.113.5:1234", realIP: "10.0.0.1", want: "203.0.113.5"},
+		{name: "X-Forwarded-For от недоверенного адреса", remoteAddr: "203.0.113.5:1234", xff: []string{"10.0.0.1"}, want: "203.0.113.5"},
+		{name: "X-Real-IP от доверенного прокси", remoteAddr: "10.1.2.3:80", realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "цепочка прокси", remoteAddr: "10.1.2.3:80", xff: []string{"1.1.1.1, 198.51.100.7", "10.0.0.9"}, want: "198.51.100.7"},
+		{name: "X-Forwarded-For важнее X-Real-IP", remoteAddr: "10.1.2.3:80", xff: []string{"198.51.100.7"}, realIP: "1.1.1.1", want: "198.51.100.7"},
+		{name: "все адреса цепочки доверенные", remoteAddr: "10.1.2.3:80", xff: []string{"10.0.0.5, 10.0.0.6"}, want: "10.0.0.5"},
+		{name: "мусор в цепочке", remoteAddr: "10.1.2.3:80", xff: []string{"1.1.1.1, garbage"}, want: "10.1.2.3"},
+		{name: "IPv6 прокси", remoteAddr: "[fd00::1]:443", xff: []string{"2001:db8::42"}, want: "2001:db8::42"},
+		{name: "IPv4 в IPv6 форме", remoteAddr: "[::ffff:10.1.2.3]:80", realIP: "198.51.100.7", want: "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, resolver.Resolve(req).String())
+		})
+	}
+}
+
+func TestNewResolver_InvalidProxy(t *testing.T) {
+	_, err := NewResolver([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = NewResolver([]string{"proxy.local"})
+	assert.Error(t, err)
+}
+
+func TestMiddleware_SetsContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	resolver, err := NewResolver([]string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	var got string
+	r := gin.New()
+	r.Use(Middleware(resolver))
+	r.GET("/", func(c *gin.Context) {
+		got = FromContext(c)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	req.Header.Set("X-Real-IP", "192.0.2.10")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "192.0.2.10", got)
+}
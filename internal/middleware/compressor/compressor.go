@@ -1,63 +1,325 @@
+// Package compressor сжимает ответы и распаковывает тела запросов.
+//
+// Кодирование ответа выбирается по Accept-Encoding с учётом q среди zstd, br
+// и gzip. Сжимаются только ответы подходящих типов не меньше MinSize байт:
+// на маленьких телах заголовки сжатия съедают выигрыш. Encoder'ы берутся
+// из пулов, чтобы не выделять окна сжатия на каждый запрос.
+//
+// Тела запросов принимаются в тех же трёх кодированиях. Размер распакованного
+// тела ограничен MaxDecompressedSize, чтобы маленький архив не развернулся
+// в гигабайты.
 package compressor
 
 import (
 	"compress/gzip"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
+	"github.com/Popolzen/shortener/internal/problem"
+	"github.com/andybalholm/brotli"
 	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
 )
 
-type gzipWriter struct {
+// Значения Options по умолчанию
+const (
+	DefaultMinSize             = 1024
+	DefaultMaxDecompressedSize = 10 << 20
+)
+
+// DefaultContentTypes типы ответов, которые сжимаются по умолчанию
+var DefaultContentTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"text/html",
+}
+
+// Options параметры сжатия
+type Options struct {
+	MinSize             int      // минимальный размер сжимаемого ответа, 0 — DefaultMinSize
+	ContentTypes        []string // сжимаемые типы, "text/*" — все подтипы, пусто — DefaultContentTypes
+	MaxDecompressedSize int64    // предел распакованного тела запроса, 0 — DefaultMaxDecompressedSize
+}
+
+func (o Options) withDefaults() Options {
+	if o.MinSize <= 0 {
+		o.MinSize = DefaultMinSize
+	}
+	if len(o.ContentTypes) == 0 {
+		o.ContentTypes = DefaultContentTypes
+	}
+	if o.MaxDecompressedSize <= 0 {
+		o.MaxDecompressedSize = DefaultMaxDecompressedSize
+	}
+	return o
+}
+
+// compressible сообщает, что ответ с заголовком Content-Type contentType
+// входит в список сжимаемых
+func (o Options) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range o.ContentTypes {
+		t = strings.ToLower(t)
+		if prefix, ok := strings.CutSuffix(t, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+			continue
+		}
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Состояния compressWriter
+const (
+	statePending     = iota // тело копится в буфере, решение не принято
+	statePassthrough        // тело пишется без сжатия
+	stateCompressing        // тело пишется через encoder
+)
+
+// compressWriter откладывает решение о сжатии, пока не наберётся MinSize
+// байт тела или обработчик не вызовет Flush
+type compressWriter struct {
 	gin.ResponseWriter
-	writer     *gzip.Writer
-	compressed bool
+	opts     *Options
+	encoding string // выбранное кодирование, пусто — клиент не принимает сжатие
+	state    int
+	buf      []byte
+	enc      *pooledEncoder
+}
+
+// eligible проверяет заголовки ответа и ставит Vary, если тело зависит
+// от Accept-Encoding
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	status := w.ResponseWriter.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if !w.opts.compressible(h.Get("Content-Type")) {
+		return false
+	}
+	h.Add("Vary", "Accept-Encoding")
+	return w.encoding != ""
+}
+
+// start принимает решение о сжатии и сбрасывает буфер
+func (w *compressWriter) start(compress bool) error {
+	buf := w.buf
+	w.buf = nil
+
+	if !compress {
+		w.state = statePassthrough
+		if len(buf) == 0 {
+			return nil
+		}
+		_, err := w.ResponseWriter.Write(buf)
+		return err
+	}
+
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	w.enc = encoders[w.encoding].Get()
+	w.enc.encoder.Reset(w.ResponseWriter)
+	w.state = stateCompressing
+	_, err := w.enc.Write(buf)
+	return err
 }
 
-func (g *gzipWriter) Write(b []byte) (int, error) {
-	contentType := g.Header().Get("Content-Type")
-	if strings.Contains(contentType, "application/json") || strings.Contains(contentType, "text/html") {
-		if !g.compressed {
-			g.Header().Set("Content-Encoding", "gzip")
-			g.compressed = true
+func (w *compressWriter) Write(b []byte) (int, error) {
+	switch w.state {
+	case statePassthrough:
+		return w.ResponseWriter.Write(b)
+	case stateCompressing:
+		return w.enc.Write(b)
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if len(w.buf) == 0 && !w.eligible() {
+		if err := w.start(false); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.opts.MinSize {
+		if err := w.start(true); err != nil {
+			return 0, err
 		}
-		return g.writer.Write(b)
 	}
-	return g.ResponseWriter.Write(b)
+	return len(b), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written учитывает тело, которое ещё лежит в буфере
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+// Flush отправляет накопленное клиенту. Потоковый ответ сжимается,
+// не дожидаясь MinSize.
+func (w *compressWriter) Flush() {
+	if w.state == statePending && len(w.buf) > 0 {
+		_ = w.start(true)
+	}
+	if w.state == stateCompressing {
+		_ = w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish дописывает ответ после обработчика и возвращает encoder в пул
+func (w *compressWriter) finish() error {
+	switch w.state {
+	case statePending:
+		// Тело меньше MinSize: сжатие не окупится
+		return w.start(false)
+	case stateCompressing:
+		err := w.enc.Close()
+		encoders[w.encoding].Put(w.enc)
+		w.enc = nil
+		return err
+	}
+	return nil
+}
+
+// decodedBody распакованное тело запроса с пределом размера
+type decodedBody struct {
+	r         io.Reader
+	close     func() error
+	limit     int64
+	remaining int64
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, tooLarge(b.limit)
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n = int(b.remaining)
+	b.remaining = -1
+	return n, tooLarge(b.limit)
 }
 
-func (g *gzipWriter) Close() error {
-	if g.compressed {
-		return g.writer.Close()
+func (b *decodedBody) Close() error {
+	return b.close()
+}
+
+// tooLarge ошибка превышения предела распакованного тела
+func tooLarge(limit int64) error {
+	e := problem.New(problem.CodePayloadTooLarge, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("Распакованное тело запроса больше %d байт", limit))
+	e.Extensions = map[string]any{"limit": limit}
+	return e
+}
+
+// decodeRequest подменяет тело запроса распакованным по Content-Encoding
+func decodeRequest(r *http.Request, limit int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	body := r.Body
+	decoded := &decodedBody{close: body.Close, limit: limit, remaining: limit}
+	switch encoding {
+	case EncodingGzip, "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return problem.BadRequest("Не удалось распаковать данные")
+		}
+		decoded.r = zr
+		decoded.close = func() error {
+			zr.Close()
+			return body.Close()
+		}
+	case EncodingBrotli:
+		decoded.r = brotli.NewReader(body)
+	case EncodingZstd:
+		zr, err := zstd.NewReader(body,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(limit)),
+		)
+		if err != nil {
+			return problem.BadRequest("Не удалось распаковать данные")
+		}
+		decoded.r = zr
+		decoded.close = func() error {
+			zr.Close()
+			return body.Close()
+		}
+	default:
+		e := problem.New(problem.CodeUnsupportedMediaType, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Кодирование тела %q не поддерживается", encoding))
+		e.Extensions = map[string]any{"supported": preference}
+		return e
 	}
+
+	r.Body = decoded
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
 	return nil
 }
 
-// Compresser обрабатывает gzip сжатие
+// Compresser сжимает ответы и распаковывает запросы с параметрами по умолчанию
 func Compresser() gin.HandlerFunc {
+	return CompresserWithOptions(Options{})
+}
+
+// CompresserWithOptions сжимает ответы и распаковывает запросы.
+//
+// Коды ответа:
+//   - 400: тело запроса не распаковывается
+//   - 413: распакованное тело больше MaxDecompressedSize
+//   - 415: кодирование тела запроса не поддерживается
+func CompresserWithOptions(opts Options) gin.HandlerFunc {
+	opts = opts.withDefaults()
+
 	return func(c *gin.Context) {
 		// 1. Распаковка входящего запроса
-		if strings.Contains(strings.ToLower(c.Request.Header.Get("Content-Encoding")), "gzip") {
-			newReader, err := gzip.NewReader(c.Request.Body)
-			if err != nil {
-				c.String(http.StatusBadRequest, "Не удалось распаковать данные")
-				return
-			}
-			c.Request.Body = newReader
-			defer newReader.Close()
+		if err := decodeRequest(c.Request, opts.MaxDecompressedSize); err != nil {
+			problem.Write(c, err)
+			return
 		}
 
 		// 2. Подготовка сжатия ответа
-		acceptEncoding := c.Request.Header.Get("Accept-Encoding")
-		if strings.Contains(strings.ToLower(acceptEncoding), "gzip") && acceptEncoding != "" {
-			gzipResp := &gzipWriter{
-				ResponseWriter: c.Writer,
-				writer:         gzip.NewWriter(c.Writer),
-				compressed:     false,
-			}
-			c.Writer = gzipResp
-			defer gzipResp.Close()
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			opts:           &opts,
+			encoding:       negotiate(c.Request.Header.Get("Accept-Encoding")),
 		}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
 
 		c.Next()
 	}
@@ -6,9 +6,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/Popolzen/shortener/internal/problem"
+	"github.com/andybalholm/brotli"
 	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -20,6 +24,9 @@ func setupRouter() *gin.Engine {
 	return r
 }
 
+// largeJSON JSON длиннее DefaultMinSize, который сжимается
+var largeJSON = `{"message":"` + strings.Repeat("hello ", 300) + `"}`
+
 func gzipCompress(data []byte) []byte {
 	var buf bytes.Buffer
 	w := gzip.NewWriter(&buf)
@@ -65,7 +72,7 @@ func TestCompresser_CompressJSONResponse(t *testing.T) {
 
 	router.GET("/json", func(c *gin.Context) {
 		c.Header("Content-Type", "application/json")
-		c.String(http.StatusOK, `{"message":"hello"}`)
+		c.String(http.StatusOK, largeJSON)
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/json", nil)
@@ -79,7 +86,8 @@ func TestCompresser_CompressJSONResponse(t *testing.T) {
 
 	decompressed, err := gzipDecompress(w.Body.Bytes())
 	require.NoError(t, err)
-	assert.Equal(t, `{"message":"hello"}`, string(decompressed))
+	assert.Equal(t, largeJSON, string(decompressed))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
 }
 
 func TestCompresser_CompressHTMLResponse(t *testing.T) {
@@ -87,7 +95,7 @@ func TestCompresser_CompressHTMLResponse(t *testing.T) {
 
 	router.GET("/html", func(c *gin.Context) {
 		c.Header("Content-Type", "text/html")
-		c.String(http.StatusOK, "<html><body>Hello</body></html>")
+		c.String(http.StatusOK, "<html><body>"+strings.Repeat("<p>Hello</p>", 100)+"</body></html>")
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/html", nil)
@@ -126,7 +134,7 @@ func TestCompresser_NoCompressTextPlain(t *testing.T) {
 
 	router.GET("/text", func(c *gin.Context) {
 		c.Header("Content-Type", "text/plain")
-		c.String(http.StatusOK, "plain text")
+		c.String(http.StatusOK, strings.Repeat("plain text ", 200))
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/text", nil)
@@ -135,8 +143,9 @@ func TestCompresser_NoCompressTextPlain(t *testing.T) {
 
 	router.ServeHTTP(w, req)
 
-	// text/plain не сжимается по твоей логике
+	// text/plain нет в списке сжимаемых типов
 	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Vary"))
 }
 
 func TestCompresser_InvalidGzipRequest(t *testing.T) {
@@ -164,7 +173,7 @@ func TestCompresser_RoundTrip(t *testing.T) {
 		c.String(http.StatusOK, string(body))
 	})
 
-	originalData := `{"test":"roundtrip data"}`
+	originalData := largeJSON
 	compressedReq := gzipCompress([]byte(originalData))
 
 	req := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(compressedReq))
@@ -180,3 +189,237 @@ func TestCompresser_RoundTrip(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, originalData, string(decompressed))
 }
+
+func TestCompresser_SmallResponseNotCompressed(t *testing.T) {
+	router := setupRouter()
+
+	router.GET("/json", func(c *gin.Context) {
+		c.Header("Content-Type", "application/json")
+		c.String(http.StatusOK, `{"message":"hello"}`)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `{"message":"hello"}`, w.Body.String())
+}
+
+func TestCompresser_Options(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CompresserWithOptions(Options{MinSize: 10, ContentTypes: []string{"text/*"}}))
+	router.GET("/text", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.String(http.StatusOK, "plain text body")
+	})
+	router.GET("/json", func(c *gin.Context) {
+		c.Header("Content-Type", "application/json")
+		c.String(http.StatusOK, largeJSON)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/text", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	decompressed, err := gzipDecompress(w.Body.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "plain text body", string(decompressed))
+
+	req = httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "пустой заголовок", acceptEncoding: "", want: ""},
+		{name: "только gzip", acceptEncoding: "gzip", want: EncodingGzip},
+		{name: "равные q — предпочтение zstd", acceptEncoding: "gzip, br, zstd", want: EncodingZstd},
+		{name: "равные q без zstd — br", acceptEncoding: "gzip, deflate, br", want: EncodingBrotli},
+		{name: "q выбирает gzip", acceptEncoding: "br;q=0.5, gzip;q=0.9", want: EncodingGzip},
+		{name: "q=0 запрещает кодирование", acceptEncoding: "zstd;q=0, gzip", want: EncodingGzip},
+		{name: "звёздочка", acceptEncoding: "*", want: EncodingZstd},
+		{name: "звёздочка с запретом", acceptEncoding: "*;q=0.5, zstd;q=0", want: EncodingBrotli},
+		{name: "всё запрещено", acceptEncoding: "gzip;q=0, *;q=0", want: ""},
+		{name: "identity", acceptEncoding: "identity", want: ""},
+		{name: "регистр и пробелы", acceptEncoding: " GZIP ; q=0.8 ", want: EncodingGzip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiate(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestCompresser_ResponseEncodings(t *testing.T) {
+	decoders := map[string]func([]byte) ([]byte, error){
+		EncodingGzip: gzipDecompress,
+		EncodingBrotli: func(data []byte) ([]byte, error) {
+			return io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+		},
+		EncodingZstd: func(data []byte) ([]byte, error) {
+			r, err := zstd.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		},
+	}
+
+	router := setupRouter()
+	router.GET("/json", func(c *gin.Context) {
+		c.Header("Content-Type", "application/json")
+		c.String(http.StatusOK, largeJSON)
+	})
+
+	for encoding, decode := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			// Несколько запросов подряд проверяют повторное использование encoder'ов из пула
+			for range 3 {
+				req := httptest.NewRequest(http.MethodGet, "/json", nil)
+				req.Header.Set("Accept-Encoding", encoding)
+				w := httptest.NewRecorder()
+
+				router.ServeHTTP(w, req)
+
+				require.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+				assert.Empty(t, w.Header().Get("Content-Length"))
+				body, err := decode(w.Body.Bytes())
+				require.NoError(t, err)
+				assert.Equal(t, largeJSON, string(body))
+			}
+		})
+	}
+}
+
+func TestCompresser_NoCompressWithoutBody(t *testing.T) {
+	router := setupRouter()
+	router.GET("/redirect", func(c *gin.Context) {
+		c.Header("Content-Type", "application/json")
+		c.Status(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/redirect", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestCompresser_DecompressRequestEncodings(t *testing.T) {
+	original := []byte(largeJSON)
+
+	var brBuf, zstdBuf bytes.Buffer
+	bw := brotli.NewWriter(&brBuf)
+	bw.Write(original)
+	bw.Close()
+	zw, err := zstd.NewWriter(&zstdBuf)
+	require.NoError(t, err)
+	zw.Write(original)
+	zw.Close()
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{name: "gzip", encoding: "gzip", body: gzipCompress(original)},
+		{name: "brotli", encoding: "br", body: brBuf.Bytes()},
+		{name: "zstd", encoding: "zstd", body: zstdBuf.Bytes()},
+		{name: "identity", encoding: "identity", body: original},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupRouter()
+
+			var receivedBody []byte
+			var receivedEncoding string
+			router.POST("/test", func(c *gin.Context) {
+				receivedBody, _ = io.ReadAll(c.Request.Body)
+				receivedEncoding = c.GetHeader("Content-Encoding")
+				c.String(http.StatusOK, "ok")
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", tt.encoding)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, original, receivedBody)
+			if tt.encoding != "identity" {
+				assert.Empty(t, receivedEncoding, "распакованное тело не должно сохранять Content-Encoding")
+			}
+		})
+	}
+}
+
+func TestCompresser_UnsupportedRequestEncoding(t *testing.T) {
+	router := setupRouter()
+	router.POST("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("data"))
+	req.Header.Set("Content-Encoding", "deflate")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported_media_type")
+}
+
+func TestCompresser_DecompressionBomb(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CompresserWithOptions(Options{MaxDecompressedSize: 1024}))
+
+	var readErr error
+	var received int
+	router.POST("/test", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		received, readErr = len(body), err
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	})
+
+	// 1 МБ нулей сжимается в килобайт
+	bomb := gzipCompress(make([]byte, 1<<20))
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 1024, received)
+	var pe *problem.Error
+	require.ErrorAs(t, readErr, &pe)
+	assert.Equal(t, problem.CodePayloadTooLarge, pe.Code)
+}
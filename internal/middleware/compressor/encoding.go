@@ -0,0 +1,105 @@
+package compressor
+
+import (
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Popolzen/shortener/internal/pool"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Поддерживаемые кодирования тела
+const (
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// preference порядок выбора кодирования при равных q
+var preference = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+
+// Уровни сжатия ответов: быстрые, потому что ответы динамические
+const (
+	gzipLevel   = gzip.DefaultCompression
+	brotliLevel = 5
+	zstdLevel   = zstd.SpeedDefault
+)
+
+// encoder сжимающий writer, который можно перенаправить на другой поток
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pooledEncoder encoder в пуле. Reset отвязывает его от ответа,
+// чтобы пул не держал ссылку на завершённый запрос.
+type pooledEncoder struct {
+	encoder
+}
+
+func (e *pooledEncoder) Reset() {
+	e.encoder.Reset(io.Discard)
+}
+
+// encoders пулы encoder'ов по кодированию
+var encoders = map[string]*pool.Pool[*pooledEncoder]{
+	EncodingGzip: pool.New(func() *pooledEncoder {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzipLevel)
+		return &pooledEncoder{w}
+	}),
+	EncodingBrotli: pool.New(func() *pooledEncoder {
+		return &pooledEncoder{brotli.NewWriterLevel(io.Discard, brotliLevel)}
+	}),
+	EncodingZstd: pool.New(func() *pooledEncoder {
+		w, _ := zstd.NewWriter(io.Discard,
+			zstd.WithEncoderLevel(zstdLevel),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithLowerEncoderMem(true),
+		)
+		return &pooledEncoder{w}
+	}),
+}
+
+// negotiate выбирает кодирование ответа по Accept-Encoding с учётом q.
+// При равных q предпочтение у zstd, затем br, затем gzip. "*" задаёт q
+// для не перечисленных кодирований, q=0 запрещает кодирование.
+// Пустой результат — отвечать без сжатия.
+func negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64, 4)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range preference {
+		q, ok := weights[enc]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
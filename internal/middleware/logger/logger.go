@@ -1,36 +1,160 @@
+// Package logger настраивает общий zap-логгер сервиса и логирует HTTP-запросы.
+//
+// Init заменяет глобальный логгер zap, поэтому остальные пакеты пишут через
+// zap.S() без передачи логгера. Стандартный log перенаправляется в тот же
+// логгер, чтобы сообщения сторонних библиотек не уходили в другом формате.
+// Уровень можно менять на лету через LevelHandler.
 package logger
 
 import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
 	"time"
 
+	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
+	"github.com/Popolzen/shortener/internal/telemetry"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-var sugar *zap.SugaredLogger
+// Значения Options по умолчанию
+const (
+	DefaultLevel    = "info"
+	DefaultEncoding = "json"
+	DefaultOutput   = "stderr"
+)
+
+// Options параметры логгера
+type Options struct {
+	Level    string   // debug, info, warn или error, пусто — info
+	Encoding string   // json или console, пусто — json
+	Output   []string // stdout, stderr или пути к файлам, пусто — stderr
+
+	// Сэмплирование одинаковых сообщений за секунду: первые SamplingInitial
+	// пишутся все, дальше каждое SamplingThereafter. 0 отключает сэмплирование.
+	SamplingInitial    int
+	SamplingThereafter int
+}
+
+var (
+	sugar = zap.NewNop().Sugar()
+	level = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+	restoreStdLog = func() {}
+)
+
+// Init создаёт логгер по параметрам и делает его глобальным
+func Init(opts Options) error {
+	if opts.Level == "" {
+		opts.Level = DefaultLevel
+	}
+	if opts.Encoding == "" {
+		opts.Encoding = DefaultEncoding
+	}
+	if len(opts.Output) == 0 {
+		opts.Output = []string{DefaultOutput}
+	}
+
+	lvl, err := zapcore.ParseLevel(opts.Level)
+	if err != nil {
+		return fmt.Errorf("logger: некорректный уровень %q: %w", opts.Level, err)
+	}
+	if opts.Encoding != "json" && opts.Encoding != "console" {
+		return fmt.Errorf("logger: некорректный формат %q, ожидается json или console", opts.Encoding)
+	}
+	level.SetLevel(lvl)
 
-// Init инициализирует zap логгер
-func Init() error {
 	config := zap.NewProductionConfig()
+	config.Level = level
+	config.Encoding = opts.Encoding
+	config.OutputPaths = opts.Output
+	config.ErrorOutputPaths = opts.Output
 
 	// Настройка формата времени
 	config.EncoderConfig.TimeKey = "timestamp"
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
-	// Настройка уровня логирования
-	config.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+	config.Sampling = nil
+	if opts.SamplingInitial > 0 && opts.SamplingThereafter > 0 {
+		config.Sampling = &zap.SamplingConfig{Initial: opts.SamplingInitial, Thereafter: opts.SamplingThereafter}
+	}
 
 	logger, err := config.Build()
 	if err != nil {
-		return err
+		return fmt.Errorf("logger: %w", err)
 	}
 
+	restoreStdLog()
+	zap.ReplaceGlobals(logger)
+	restoreStdLog = zap.RedirectStdLog(logger)
 	sugar = logger.Sugar()
 	return nil
 }
 
+// Level возвращает текущий уровень логирования
+func Level() zapcore.Level {
+	return level.Level()
+}
+
+// LevelHandler создает обработчик просмотра и смены уровня логирования.
+//
+// Эндпоинт: GET, PUT /api/internal/log/level
+// Доступ ограничен через middleware TrustedSubnetMiddleware.
+//
+// Коды ответа:
+//   - 200: текущий уровень
+//   - 400: некорректный уровень в теле запроса
+//
+// Пример запроса:
+//
+//	PUT /api/internal/log/level HTTP/1.1
+//	Content-Type: application/json
+//
+//	{"level": "debug"}
+//
+// Пример ответа:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//
+//	{"level": "debug"}
+func LevelHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		before := level.Level()
+		level.ServeHTTP(c.Writer, c.Request)
+		if after := level.Level(); after != before {
+			sugar.Warnw("Уровень логирования изменён",
+				"from", before.String(),
+				"to", after.String(),
+				"ip", clientip.FromContext(c),
+				"request_id", requestid.Get(c),
+			)
+		}
+	}
+}
+
+// countingBody считает прочитанные байты тела запроса
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
 // RequestLogger — middleware-логер для входящих HTTP-запросов.
+//
+// Пишет метод, URI, шаблон маршрута, адрес клиента, пользователя,
+// идентификатор запроса, статус, длительность и размеры тела запроса
+// и ответа. Ответы 5xx пишутся с уровнем error, 4xx — warn.
 func RequestLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -38,23 +162,56 @@ func RequestLogger() gin.HandlerFunc {
 		uri := c.Request.RequestURI
 		method := c.Request.Method
 
+		body := &countingBody{ReadCloser: c.Request.Body}
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = body
+		}
+
 		c.Next() // Выполнение следующего handler
 
 		duration := time.Since(start)
 		status := c.Writer.Status()
-		size := c.Writer.Size()
 
-		sugar.Infoln(
+		fields := []any{
 			"uri", uri,
 			"method", method,
+			"route", c.FullPath(),
+			"ip", clientip.FromContext(c),
+			"user_id", c.GetString(string(auth.UserIDKey)),
+			"request_id", requestid.Get(c),
+			"trace_id", telemetry.TraceID(c.Request.Context()),
 			"duration", duration,
 			"status", status,
-			"size", size,
-		)
+			"bytes_in", body.n,
+			"size", c.Writer.Size(),
+		}
 
+		switch {
+		case status >= http.StatusInternalServerError:
+			sugar.Errorw("HTTP запрос", fields...)
+		case status >= http.StatusBadRequest:
+			sugar.Warnw("HTTP запрос", fields...)
+		default:
+			sugar.Infow("HTTP запрос", fields...)
+		}
 	}
 }
 
+// Recovery перехватывает панику обработчика, пишет её со стеком в общий
+// логгер и отвечает 500. Заменяет gin.Recovery, который пишет в stderr.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, rec any) {
+		sugar.Errorw("Паника при обработке запроса",
+			"panic", rec,
+			"method", c.Request.Method,
+			"uri", c.Request.RequestURI,
+			"request_id", requestid.Get(c),
+			"stack", string(debug.Stack()),
+		)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
 func Close() {
 	if sugar != nil {
 		sugar.Sync()
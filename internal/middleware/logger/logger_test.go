@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observe подменяет логгер пакета на записывающий и возвращает записи
+func observe(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	prev := sugar
+	sugar = zap.New(core).Sugar()
+	t.Cleanup(func() { sugar = prev })
+	return logs
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "по умолчанию", opts: Options{Output: []string{filepath.Join(t.TempDir(), "default.log")}}},
+		{name: "console без сэмплирования", opts: Options{Level: "debug", Encoding: "console", Output: []string{filepath.Join(t.TempDir(), "console.log")}}},
+		{name: "некорректный уровень", opts: Options{Level: "verbose"}, wantErr: true},
+		{name: "некорректный формат", opts: Options{Encoding: "xml"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Init(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			want := tt.opts.Level
+			if want == "" {
+				want = DefaultLevel
+			}
+			assert.Equal(t, want, Level().String())
+		})
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	logs := observe(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestLogger())
+	r.Use(func(c *gin.Context) {
+		c.Set(string(auth.UserIDKey), "user-1")
+		c.Next()
+	})
+	r.POST("/api/links/:id", func(c *gin.Context) {
+		body, _ := c.GetRawData()
+		c.String(http.StatusBadRequest, string(body))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/links/abc", strings.NewReader("hello"))
+	req.RemoteAddr = "203.0.113.7:1234"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, zapcore.WarnLevel, entry.Level)
+
+	fields := entry.ContextMap()
+	assert.Equal(t, "/api/links/:id", fields["route"])
+	assert.Equal(t, "/api/links/abc", fields["uri"])
+	assert.Equal(t, "203.0.113.7", fields["ip"])
+	assert.Equal(t, "user-1", fields["user_id"])
+	assert.EqualValues(t, 5, fields["bytes_in"])
+	assert.EqualValues(t, 5, fields["size"])
+	assert.EqualValues(t, http.StatusBadRequest, fields["status"])
+}
+
+func TestLevelHandler(t *testing.T) {
+	logs := observe(t)
+	level.SetLevel(zapcore.InfoLevel)
+	t.Cleanup(func() { level.SetLevel(zapcore.InfoLevel) })
+
+	r := gin.New()
+	r.GET("/level", LevelHandler())
+	r.PUT("/level", LevelHandler())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/level", strings.NewReader(`{"level":"debug"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, zapcore.DebugLevel, Level())
+	assert.Equal(t, 1, logs.FilterMessage("Уровень логирования изменён").Len())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/level", strings.NewReader(`{"level":"loud"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, zapcore.DebugLevel, Level())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/level", nil))
+	assert.JSONEq(t, `{"level":"debug"}`, w.Body.String())
+}
+
+func TestRecovery(t *testing.T) {
+	logs := observe(t)
+
+	r := gin.New()
+	r.Use(Recovery())
+	r.GET("/", func(c *gin.Context) { panic("boom") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	entries := logs.FilterMessage("Паника при обработке запроса").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "boom", entries[0].ContextMap()["panic"])
+}
@@ -0,0 +1,280 @@
+// Package ratelimit ограничивает частоту запросов алгоритмом token bucket.
+//
+// Для каждого класса маршрутов (сокращение, пакет, редирект, удаление,
+// неверные пароли ссылок) задаётся свой бюджет. Запрос расходует по жетону
+// из корзины клиентского IP и, если сессия валидна, из корзины пользователя.
+// Отказ любой из корзин даёт 429 Too Many Requests.
+//
+// Число корзин ограничено: при переполнении вытесняется корзина, к которой
+// дольше всего не обращались. Вытесненная корзина при следующем запросе
+// создаётся полной, поэтому размер таблицы стоит выбирать с запасом
+// относительно числа активных клиентов.
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
+	"github.com/Popolzen/shortener/internal/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMaxKeys размер таблицы корзин по умолчанию
+const DefaultMaxKeys = 100000
+
+// Class класс маршрутов с отдельным бюджетом
+type Class string
+
+// Классы маршрутов
+const (
+	ClassShorten  Class = "shorten"  // POST / и POST /api/shorten
+	ClassBatch    Class = "batch"    // POST /api/shorten/batch
+	ClassRedirect Class = "redirect" // GET /{id}
+	ClassDelete   Class = "delete"   // DELETE /api/user/urls
+	ClassPassword Class = "password" // неверные пароли ссылок, POST /{id} и X-Link-Password
+)
+
+// Limit бюджет класса маршрутов
+type Limit struct {
+	Rate  int // жетонов в минуту, 0 — без ограничения
+	Burst int // ёмкость корзины, 0 — равна Rate
+}
+
+// capacity возвращает ёмкость корзины
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Rate)
+}
+
+// perSecond возвращает скорость пополнения корзины
+func (l Limit) perSecond() float64 {
+	return float64(l.Rate) / 60
+}
+
+// Result решение по запросу
+type Result struct {
+	Allowed    bool
+	Limit      int           // ёмкость корзины
+	Remaining  int           // жетонов осталось после запроса
+	Reset      time.Duration // через сколько корзина наполнится полностью
+	RetryAfter time.Duration // через сколько появится жетон, если запрос отклонён
+}
+
+// bucket состояние одной корзины
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Limiter хранит корзины всех клиентов. Безопасен для конкурентного использования.
+type Limiter struct {
+	limits  map[Class]Limit
+	maxKeys int
+	now     func() time.Time
+
+	mu    sync.Mutex
+	order *list.List // от недавно использованных к давно использованным
+	items map[string]*list.Element
+}
+
+// NewLimiter создаёт ограничитель с бюджетами по классам.
+// maxKeys ограничивает число хранимых корзин, 0 — DefaultMaxKeys.
+func NewLimiter(limits map[Class]Limit, maxKeys int) *Limiter {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	return &Limiter{
+		limits:  limits,
+		maxKeys: maxKeys,
+		now:     time.Now,
+		order:   list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// Enabled сообщает, ограничен ли класс
+func (l *Limiter) Enabled(class Class) bool {
+	return l != nil && l.limits[class].Rate > 0
+}
+
+// Len возвращает число хранимых корзин
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// Allow расходует по жетону из корзин всех ключей класса.
+//
+// Жетоны списываются только если их хватает во всех корзинах, чтобы отказ
+// по IP не расходовал бюджет пользователя и наоборот. В Result попадает
+// состояние самой исчерпанной корзины.
+func (l *Limiter) Allow(class Class, keys ...string) Result {
+	if l == nil {
+		return Result{Allowed: true}
+	}
+	limit := l.limits[class]
+	if limit.Rate <= 0 {
+		return Result{Allowed: true}
+	}
+	capacity, rate := limit.capacity(), limit.perSecond()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	buckets := make([]*bucket, 0, len(keys))
+	for _, key := range keys {
+		b := l.get(string(class)+"|"+key, capacity, now)
+		b.refill(capacity, rate, now)
+		buckets = append(buckets, b)
+	}
+
+	allowed := true
+	minTokens := capacity
+	for _, b := range buckets {
+		if b.tokens < 1 {
+			allowed = false
+		}
+		minTokens = math.Min(minTokens, b.tokens)
+	}
+	if allowed {
+		for _, b := range buckets {
+			b.tokens--
+		}
+		minTokens--
+	}
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(capacity),
+		Remaining: int(math.Max(0, math.Floor(minTokens))),
+		Reset:     seconds((capacity - minTokens) / rate),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - minTokens) / rate)
+	}
+	return res
+}
+
+// Refund возвращает в корзины ключей класса жетон, списанный Allow.
+//
+// Нужен, когда бюджет расходуют только неудачные запросы: жетон
+// списывается до проверки, чтобы параллельные запросы не прошли её
+// разом, и возвращается, если проверка удалась.
+func (l *Limiter) Refund(class Class, keys ...string) {
+	if l == nil {
+		return
+	}
+	limit := l.limits[class]
+	if limit.Rate <= 0 {
+		return
+	}
+	capacity, rate := limit.capacity(), limit.perSecond()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, key := range keys {
+		b := l.get(string(class)+"|"+key, capacity, now)
+		b.refill(capacity, rate, now)
+		b.tokens = math.Min(capacity, b.tokens+1)
+	}
+}
+
+// refill пополняет корзину за время с последнего обращения
+func (b *bucket) refill(capacity, rate float64, now time.Time) {
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// get возвращает корзину по ключу, создавая полную при отсутствии.
+// Вызывается под l.mu.
+func (l *Limiter) get(key string, capacity float64, now time.Time) *bucket {
+	if el, ok := l.items[key]; ok {
+		l.order.MoveToFront(el)
+		return el.Value.(*bucket)
+	}
+
+	for l.order.Len() >= l.maxKeys {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*bucket).key)
+	}
+
+	b := &bucket{key: key, tokens: capacity, last: now}
+	l.items[key] = l.order.PushFront(b)
+	return b
+}
+
+// seconds переводит дробные секунды в длительность
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds округляет длительность вверх до целых секунд для заголовков
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Middleware ограничивает частоту запросов класса по IP и пользователю.
+//
+// Адрес клиента берётся из clientip. Должен стоять после middleware
+// аутентификации: ключ пользователя берётся из auth.UserIDKey только для
+// валидной сессии, иначе клиент мог бы получать новый бюджет, просто не
+// присылая куку.
+//
+// Ответ содержит заголовки RateLimit-Limit, RateLimit-Remaining и
+// RateLimit-Reset, а при отказе — Retry-After.
+//
+// Пример использования:
+//
+//	limiter := ratelimit.NewLimiter(map[ratelimit.Class]ratelimit.Limit{
+//	    ratelimit.ClassShorten: {Rate: 60, Burst: 10},
+//	}, 0)
+//	authed.POST("/", ratelimit.Middleware(limiter, ratelimit.ClassShorten), handler.PostHandler(...))
+func Middleware(l *Limiter, class Class) gin.HandlerFunc {
+	if !l.Enabled(class) {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	return func(c *gin.Context) {
+		keys := []string{"ip:" + clientip.FromContext(c)}
+		if c.GetBool(string(auth.CookieValidKey)) {
+			if userID := c.GetString(string(auth.UserIDKey)); userID != "" {
+				keys = append(keys, "user:"+userID)
+			}
+		}
+
+		res := l.Allow(class, keys...)
+		if !WriteHeaders(c, res) {
+			problem.Write(c, problem.New(problem.CodeRateLimited, http.StatusTooManyRequests, "Слишком много запросов"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// WriteHeaders выставляет заголовки RateLimit-* по решению, а при отказе —
+// Retry-After. Возвращает res.Allowed, ответ на отказ пишет вызывающий.
+func WriteHeaders(c *gin.Context, res Result) bool {
+	c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("RateLimit-Reset", ceilSeconds(res.Reset))
+	if !res.Allowed {
+		c.Header("Retry-After", ceilSeconds(res.RetryAfter))
+	}
+	return res.Allowed
+}
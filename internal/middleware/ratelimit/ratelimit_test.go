@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/middleware/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock управляемое время для тестов
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(limits map[Class]Limit, maxKeys int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := NewLimiter(limits, maxKeys)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiter_BurstAndRefill(t *testing.T) {
+	// 60 в минуту — один жетон в секунду, корзина на 3
+	l, clock := newTestLimiter(map[Class]Limit{ClassShorten: {Rate: 60, Burst: 3}}, 0)
+
+	for i := range 3 {
+		res := l.Allow(ClassShorten, "ip:1.1.1.1")
+		require.True(t, res.Allowed, "запрос %d", i)
+		assert.Equal(t, 2-i, res.Remaining)
+	}
+
+	res := l.Allow(ClassShorten, "ip:1.1.1.1")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, time.Second, res.RetryAfter)
+	assert.Equal(t, 3*time.Second, res.Reset)
+
+	clock.advance(time.Second)
+	assert.True(t, l.Allow(ClassShorten, "ip:1.1.1.1").Allowed)
+	assert.False(t, l.Allow(ClassShorten, "ip:1.1.1.1").Allowed)
+}
+
+func TestLimiter_SeparateBudgets(t *testing.T) {
+	l, _ := newTestLimiter(map[Class]Limit{
+		ClassShorten:  {Rate: 1},
+		ClassRedirect: {Rate: 1},
+	}, 0)
+
+	assert.True(t, l.Allow(ClassShorten, "ip:a").Allowed)
+	assert.False(t, l.Allow(ClassShorten, "ip:a").Allowed)
+
+	// Другой класс и другой клиент со своими корзинами
+	assert.True(t, l.Allow(ClassRedirect, "ip:a").Allowed)
+	assert.True(t, l.Allow(ClassShorten, "ip:b").Allowed)
+
+	// Класс без лимита не ограничен
+	for range 10 {
+		assert.True(t, l.Allow(ClassDelete, "ip:a").Allowed)
+	}
+}
+
+func TestLimiter_AllKeysMustAllow(t *testing.T) {
+	l, _ := newTestLimiter(map[Class]Limit{ClassShorten: {Rate: 2}}, 0)
+
+	assert.True(t, l.Allow(ClassShorten, "ip:a", "user:u1").Allowed)
+	assert.True(t, l.Allow(ClassShorten, "ip:a", "user:u1").Allowed)
+
+	// Пользователь с другого IP всё равно упирается в свой бюджет
+	res := l.Allow(ClassShorten, "ip:b", "user:u1")
+	assert.False(t, res.Allowed)
+
+	// Отказ по пользователю не расходует бюджет IP
+	assert.True(t, l.Allow(ClassShorten, "ip:b").Allowed)
+	assert.True(t, l.Allow(ClassShorten, "ip:b").Allowed)
+}
+
+func TestLimiter_Refund(t *testing.T) {
+	l, _ := newTestLimiter(map[Class]Limit{ClassPassword: {Rate: 2}}, 0)
+
+	// Возвращённый жетон снова доступен
+	for range 5 {
+		require.True(t, l.Allow(ClassPassword, "ip:a").Allowed)
+		l.Refund(ClassPassword, "ip:a")
+	}
+
+	// Возврат не переполняет корзину
+	l.Refund(ClassPassword, "ip:a")
+	assert.True(t, l.Allow(ClassPassword, "ip:a").Allowed)
+	assert.True(t, l.Allow(ClassPassword, "ip:a").Allowed)
+	res := l.Allow(ClassPassword, "ip:a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 30*time.Second, res.RetryAfter)
+
+	// nil ограничитель ничего не ограничивает
+	var disabled *Limiter
+	assert.True(t, disabled.Allow(ClassPassword, "ip:a").Allowed)
+	disabled.Refund(ClassPassword, "ip:a")
+}
+
+func TestLimiter_EvictsLeastRecentlyUsed(t *testing.T) {
+	l, _ := newTestLimiter(map[Class]Limit{ClassShorten: {Rate: 1}}, 3)
+
+	for i := range 10 {
+		l.Allow(ClassShorten, "ip:"+strconv.Itoa(i))
+	}
+	assert.Equal(t, 3, l.Len())
+
+	// Недавний клиент остался в таблице и его бюджет исчерпан
+	assert.False(t, l.Allow(ClassShorten, "ip:9").Allowed)
+	// Вытесненный клиент получает полную корзину
+	assert.True(t, l.Allow(ClassShorten, "ip:0").Allowed)
+	assert.Equal(t, 3, l.Len())
+}
+
+func setupRouter(l *Limiter, userID string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		if userID != "" {
+			c.Set(string(auth.UserIDKey), userID)
+			c.Set(string(auth.CookieValidKey), true)
+		}
+	}, Middleware(l, ClassShorten), func(c *gin.Context) {
+		c.String(http.StatusCreated, "ok")
+	})
+	return r
+}
+
+func TestMiddleware_Headers(t *testing.T) {
+	l, _ := newTestLimiter(map[Class]Limit{ClassShorten: {Rate: 60, Burst: 2}}, 0)
+	router := setupRouter(l, "user-1")
+
+	tests := []struct {
+		name          string
+		wantCode      int
+		wantRemaining string
+		wantRetry     string
+	}{
+		{name: "первый запрос", wantCode: http.StatusCreated, wantRemaining: "1"},
+		{name: "второй запрос", wantCode: http.StatusCreated, wantRemaining: "0"},
+		{name: "бюджет исчерпан", wantCode: http.StatusTooManyRequests, wantRemaining: "0", wantRetry: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, tt.wantRemaining, w.Header().Get("RateLimit-Remaining"))
+			assert.NotEmpty(t, w.Header().Get("RateLimit-Reset"))
+			assert.Equal(t, tt.wantRetry, w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestMiddleware_Disabled(t *testing.T) {
+	router := setupRouter(NewLimiter(nil, 0), "")
+
+	for range 5 {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+}
@@ -0,0 +1,77 @@
+// Package requestid присваивает запросу идентификатор для сквозной корреляции.
+//
+// Идентификатор берётся из заголовка X-Request-ID клиента или прокси, а если
+// его нет или он некорректен — генерируется. Он возвращается в ответе,
+// попадает в логи запросов, события аудита, ответы с ошибками и логи фонового
+// удаления, поэтому по нему можно найти всё, что произошло с запросом.
+package requestid
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Header заголовок с идентификатором запроса
+const Header = "X-Request-ID"
+
+// MaxLen максимальная длина принимаемого от клиента идентификатора
+const MaxLen = 128
+
+type ctxKey string
+
+// RequestIDKey ключ идентификатора в контексте gin и в context.Context запроса
+const RequestIDKey ctxKey = "request_id"
+
+// Middleware принимает или генерирует идентификатор запроса, возвращает его
+// в заголовке ответа и кладёт в контекст gin и в контекст запроса.
+// Должен стоять первым, чтобы идентификатор был у всех следующих middleware.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !valid(id) {
+			id = uuid.NewString()
+		}
+
+		c.Set(string(RequestIDKey), id)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Header(Header, id)
+		c.Next()
+	}
+}
+
+// valid проверяет идентификатор клиента: непустой, не длиннее MaxLen,
+// только видимые ASCII-символы, чтобы его нельзя было использовать
+// для подделки строк лога
+func valid(id string) bool {
+	if id == "" || len(id) > MaxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext возвращает контекст с идентификатором запроса
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, RequestIDKey, id)
+}
+
+// FromContext возвращает идентификатор запроса из контекста, пустой — если его нет
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDKey).(string)
+	return id
+}
+
+// Get возвращает идентификатор запроса gin.
+// Без Middleware используется заголовок X-Request-ID запроса как есть.
+func Get(c *gin.Context) string {
+	if id := c.GetString(string(RequestIDKey)); id != "" {
+		return id
+	}
+	return c.GetHeader(Header)
+}
@@ -0,0 +1,66 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantKeep bool
+	}{
+		{name: "идентификатор клиента", header: "client-req-42", wantKeep: true},
+		{name: "без заголовка"},
+		{name: "пробелы", header: "a b"},
+		{name: "перевод строки", header: "a\nlevel=error"},
+		{name: "слишком длинный", header: strings.Repeat("x", MaxLen+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(Middleware())
+
+			var fromGin, fromCtx string
+			r.GET("/", func(c *gin.Context) {
+				fromGin = Get(c)
+				fromCtx = FromContext(c.Request.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(Header, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(Header)
+			if tt.wantKeep {
+				assert.Equal(t, tt.header, id)
+			} else {
+				_, err := uuid.Parse(id)
+				assert.NoError(t, err, "ожидается сгенерированный UUID")
+			}
+			assert.Equal(t, id, fromGin)
+			assert.Equal(t, id, fromCtx)
+		})
+	}
+}
+
+func TestGet_WithoutMiddleware(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set(Header, "raw")
+
+	assert.Equal(t, "raw", Get(c))
+	assert.Empty(t, FromContext(c.Request.Context()))
+}
@@ -1,76 +1,74 @@
 package subnet
 
 import (
-	"log"
-	"net"
 	"net/http"
 
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
+	"github.com/Popolzen/shortener/internal/problem"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
-// TrustedSubnetMiddleware проверяет, что IP-адрес клиента входит в доверенную подсеть.
+// errForbidden ответ клиенту вне доверенных подсетей. Причина отказа
+// пишется только в лог, чтобы не раскрывать настройку подсетей.
+var errForbidden = problem.New(problem.CodeForbidden, http.StatusForbidden, "Доступ запрещён")
+
+// TrustedSubnetMiddleware проверяет, что IP-адрес клиента входит в одну из доверенных подсетей.
 //
 // Параметры:
-//   - trustedSubnet: строковое представление CIDR (например, "192.168.1.0/24")
+//   - trustedSubnets: подсети IPv4 и IPv6 в CIDR (например, "192.168.1.0/24", "fd00::/8")
 //
 // Логика:
-//   - Если trustedSubnet пустая строка → всегда возвращает 403 Forbidden
-//   - Извлекает IP из заголовка X-Real-IP
-//   - Проверяет вхождение IP в указанную подсеть
-//   - Если IP не входит в подсеть → возвращает 403 Forbidden
+//   - Если список подсетей пуст → всегда возвращает 403 Forbidden
+//   - Если хотя бы одна подсеть невалидна → всегда возвращает 403 Forbidden
+//   - Берёт IP клиента из clientip: X-Real-IP и X-Forwarded-For учитываются
+//     только от доверенных прокси, иначе используется RemoteAddr
+//   - Если IP не входит ни в одну подсеть → возвращает 403 Forbidden
 //
 // Пример использования:
 //
+//	r.Use(clientip.Middleware(resolver))
 //	internal := r.Group("/api/internal")
-//	internal.Use(subnet.TrustedSubnetMiddleware("192.168.1.0/24"))
+//	internal.Use(subnet.TrustedSubnetMiddleware([]string{"192.168.1.0/24", "fd00::/8"}))
 //	{
-//	    internal.GET("/stats", handler.StatsHandler(service))
+//	    internal.GET("/stats", handler.StatsHandler(service, auditPub))
 //	}
-func TrustedSubnetMiddleware(trustedSubnet string) gin.HandlerFunc {
-	// Если подсеть не указана, запрещаем доступ всем
-	if trustedSubnet == "" {
-		return func(c *gin.Context) {
-			log.Println("Доступ запрещен: доверенная подсеть не настроена")
-			c.AbortWithStatus(http.StatusForbidden)
-		}
-	}
-
+func TrustedSubnetMiddleware(trustedSubnets []string) gin.HandlerFunc {
 	// Парсим CIDR один раз при создании middleware
-	_, ipNet, err := net.ParseCIDR(trustedSubnet)
+	prefixes, err := clientip.ParsePrefixes(trustedSubnets)
 	if err != nil {
-		log.Printf("Ошибка парсинга CIDR '%s': %v", trustedSubnet, err)
+		zap.S().Errorf("Ошибка парсинга доверенных подсетей %v: %v", trustedSubnets, err)
 		// Если CIDR невалиден, запрещаем доступ всем
 		return func(c *gin.Context) {
-			log.Printf("Доступ запрещен: невалидный CIDR '%s'", trustedSubnet)
-			c.AbortWithStatus(http.StatusForbidden)
+			zap.S().Warnf("Доступ запрещен: невалидные доверенные подсети %v", trustedSubnets)
+			problem.Write(c, errForbidden)
 		}
 	}
 
-	return func(c *gin.Context) {
-		// Извлекаем IP из заголовка X-Real-IP
-		realIP := c.GetHeader("X-Real-IP")
-		if realIP == "" {
-			log.Println("Доступ запрещен: заголовок X-Real-IP отсутствует")
-			c.AbortWithStatus(http.StatusForbidden)
-			return
+	// Если подсеть не указана, запрещаем доступ всем
+	if len(prefixes) == 0 {
+		return func(c *gin.Context) {
+			zap.S().Warn("Доступ запрещен: доверенная подсеть не настроена")
+			problem.Write(c, errForbidden)
 		}
+	}
 
-		// Парсим IP-адрес
-		ip := net.ParseIP(realIP)
-		if ip == nil {
-			log.Printf("Доступ запрещен: невалидный IP '%s'", realIP)
-			c.AbortWithStatus(http.StatusForbidden)
+	return func(c *gin.Context) {
+		ip := clientip.Addr(c)
+		if !ip.IsValid() {
+			zap.S().Warn("Доступ запрещен: не удалось определить IP клиента")
+			problem.Write(c, errForbidden)
 			return
 		}
 
-		// Проверяем вхождение IP в доверенную подсеть
-		if !ipNet.Contains(ip) {
-			log.Printf("Доступ запрещен: IP %s не входит в доверенную подсеть %s", realIP, trustedSubnet)
-			c.AbortWithStatus(http.StatusForbidden)
+		// Проверяем вхождение IP в доверенные подсети
+		if !clientip.Contains(prefixes, ip) {
+			zap.S().Warnf("Доступ запрещен: IP %s не входит в доверенные подсети %v", ip, trustedSubnets)
+			problem.Write(c, errForbidden)
 			return
 		}
 
-		log.Printf("Доступ разрешен: IP %s входит в доверенную подсеть %s", realIP, trustedSubnet)
+		zap.S().Debugf("Доступ разрешен: IP %s входит в доверенные подсети", ip)
 		c.Next()
 	}
 }
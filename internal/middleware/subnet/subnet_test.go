@@ -0,0 +1,53 @@
+package subnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	resolver, err := clientip.NewResolver([]string{"10.0.0.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		subnets    []string
+		remoteAddr string
+		realIP     string
+		wantCode   int
+	}{
+		{name: "подсеть не настроена", subnets: nil, remoteAddr: "192.168.1.5:80", wantCode: http.StatusForbidden},
+		{name: "невалидный CIDR", subnets: []string{"192.168.1.0/24", "bad"}, remoteAddr: "192.168.1.5:80", wantCode: http.StatusForbidden},
+		{name: "адрес во второй подсети", subnets: []string{"172.16.0.0/12", "192.168.1.0/24"}, remoteAddr: "192.168.1.5:80", wantCode: http.StatusOK},
+		{name: "IPv6 подсеть", subnets: []string{"192.168.1.0/24", "fd00::/8"}, remoteAddr: "[fd12::5]:80", wantCode: http.StatusOK},
+		{name: "подделанный X-Real-IP", subnets: []string{"192.168.1.0/24"}, remoteAddr: "203.0.113.5:80", realIP: "192.168.1.5", wantCode: http.StatusForbidden},
+		{name: "X-Real-IP от доверенного прокси", subnets: []string{"192.168.1.0/24"}, remoteAddr: "10.0.0.1:80", realIP: "192.168.1.5", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(clientip.Middleware(resolver))
+			r.GET("/stats", TrustedSubnetMiddleware(tt.subnets), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Package tracing открывает серверный span OpenTelemetry на каждый HTTP-запрос.
+//
+// Контекст трассировки берётся из заголовка traceparent, если клиент или прокси
+// его передали, иначе начинается новая трасса. Span кладётся в контекст
+// запроса, поэтому span'ы сервиса и хранилища становятся его потомками.
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Popolzen/shortener/internal/middleware/clientip"
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware открывает span запроса с именем "<метод> <маршрут>".
+// Должен стоять после requestid и clientip, чтобы записать их значения.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := telemetry.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		name := c.Request.Method + " " + route
+		if route == "" {
+			// Без шаблона маршрута имя по пути раздуло бы число имён span'ов
+			name = c.Request.Method
+		}
+
+		ctx, span := telemetry.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodOriginal(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(clientip.FromContext(c)),
+				semconv.UserAgentOriginal(c.Request.UserAgent()),
+				attribute.String("request_id", requestid.Get(c)),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+		if err := c.Errors.Last(); err != nil {
+			span.RecordError(err.Err)
+		}
+	}
+}
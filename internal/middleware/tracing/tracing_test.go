@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupRouter(t *testing.T) (*gin.Engine, *tracetest.SpanRecorder) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	return r, rec
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		traceparent string
+		wantCode    codes.Code
+	}{
+		{name: "новая трасса", status: http.StatusTemporaryRedirect, wantCode: codes.Unset},
+		{
+			name:        "продолжает трассу клиента",
+			status:      http.StatusOK,
+			traceparent: "00-4bf92f3577b34ca6a6c7e5f7d8e9f0a1-00f067aa0ba902b7-01",
+			wantCode:    codes.Unset,
+		},
+		{name: "ошибка сервера", status: http.StatusInternalServerError, wantCode: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, rec := setupRouter(t)
+			var handlerSpan trace.SpanContext
+			r.GET("/:id", func(c *gin.Context) {
+				handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+				c.Status(tt.status)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := rec.Ended()
+			require.Len(t, spans, 1)
+			span := spans[0]
+			assert.Equal(t, "GET /:id", span.Name())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.Equal(t, tt.wantCode, span.Status().Code)
+			// Обработчик видит span запроса в контексте
+			assert.Equal(t, span.SpanContext().SpanID(), handlerSpan.SpanID())
+
+			if tt.traceparent != "" {
+				assert.Equal(t, "4bf92f3577b34ca6a6c7e5f7d8e9f0a1", span.SpanContext().TraceID().String())
+				assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+			} else {
+				assert.False(t, span.Parent().IsValid())
+			}
+
+			var status int64
+			for _, attr := range span.Attributes() {
+				if attr.Key == "http.response.status_code" {
+					status = attr.Value.AsInt64()
+				}
+			}
+			assert.EqualValues(t, tt.status, status)
+		})
+	}
+}
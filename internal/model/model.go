@@ -1,9 +1,28 @@
 package model
 
-import "errors"
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 type URL struct {
 	URL string `json:"url"`
+
+	RedirectCode     int  `json:"redirect_code,omitempty"`
+	QueryPassthrough bool `json:"query_passthrough,omitempty"`
+
+	Title string   `json:"title,omitempty"`
+	Note  string   `json:"note,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	Password string `json:"password,omitempty"` // пароль доступа к ссылке, пусто — без пароля
 }
 
 type Result struct {
@@ -14,12 +33,30 @@ type URLRecord struct {
 	UUID        string `json:"uuid"`
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	UserID      string `json:"user_id,omitempty"`
+
+	RedirectCode     int    `json:"redirect_code,omitempty"`
+	QueryPassthrough bool   `json:"query_passthrough,omitempty"`
+	PasswordHash     string `json:"password_hash,omitempty"`
+
+	Clicks  int64         `json:"clicks,omitempty"`
+	History []LinkVersion `json:"history,omitempty"` // версии ссылки, последняя — текущая
+	LinkMeta
 }
 
 // generate:reset
 type URLBatchRequest struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+
+	RedirectCode     int  `json:"redirect_code,omitempty"`
+	QueryPassthrough bool `json:"query_passthrough,omitempty"`
+
+	Title string   `json:"title,omitempty"`
+	Note  string   `json:"note,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	Password string `json:"password,omitempty"` // пароль доступа к ссылке, пусто — без пароля
 }
 
 type URLBatchResponse struct {
@@ -33,17 +70,298 @@ type URLPair struct {
 	OriginalURL string `json:"original_url"`
 }
 
+// UserURL ссылка в списке ссылок пользователя
+type UserURL struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	Clicks      int64     `json:"clicks"`
+	IsDeleted   bool      `json:"is_deleted"`
+	Protected   bool      `json:"protected,omitempty"` // ссылка защищена паролем
+	LinkMeta
+}
+
+// URLSort поле сортировки ссылок пользователя
+type URLSort string
+
+const (
+	SortCreatedAt URLSort = "created_at"
+	SortClicks    URLSort = "clicks"
+)
+
+// URLCursor позиция в выборке: ключ сортировки и короткая ссылка
+// последнего элемента предыдущей страницы
+type URLCursor struct {
+	CreatedAt time.Time
+	Clicks    int64
+	ShortURL  string
+}
+
+// URLListQuery параметры выборки ссылок пользователя.
+// Пустые фильтры выборку не ограничивают.
+type URLListQuery struct {
+	Limit  int
+	Sort   URLSort // по умолчанию SortCreatedAt
+	Desc   bool
+	Cursor string     // непрозрачный курсор от клиента
+	After  *URLCursor // разобранный Cursor, заполняется сервисом; nil — первая страница
+
+	URL         string    // подстрока оригинального URL без учёта регистра
+	Tag         string    // метка ссылки
+	Deleted     *bool     // nil — все ссылки, иначе только удалённые или только активные
+	CreatedFrom time.Time // включительно
+	CreatedTo   time.Time // включительно
+}
+
+// Match сообщает, подходит ли ссылка под фильтры (без учёта курсора)
+func (q URLListQuery) Match(u UserURL) bool {
+	switch {
+	case q.URL != "" && !strings.Contains(strings.ToLower(u.OriginalURL), strings.ToLower(q.URL)):
+		return false
+	case q.Tag != "" && !slices.Contains(u.Tags, q.Tag):
+		return false
+	case q.Deleted != nil && u.IsDeleted != *q.Deleted:
+		return false
+	case !q.CreatedFrom.IsZero() && u.CreatedAt.Before(q.CreatedFrom):
+		return false
+	case !q.CreatedTo.IsZero() && u.CreatedAt.After(q.CreatedTo):
+		return false
+	}
+	return true
+}
+
+// Compare сравнивает ссылки в порядке выдачи: по ключу сортировки,
+// при равенстве — по короткой ссылке
+func (q URLListQuery) Compare(a, b UserURL) int {
+	var c int
+	if q.Sort == SortClicks {
+		c = cmp.Compare(a.Clicks, b.Clicks)
+	} else {
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ShortURL, b.ShortURL)
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+// IsAfter сообщает, идёт ли ссылка после курсора. Без курсора — всегда true.
+func (q URLListQuery) IsAfter(u UserURL) bool {
+	if q.After == nil {
+		return true
+	}
+	last := UserURL{ShortURL: q.After.ShortURL, CreatedAt: q.After.CreatedAt, Clicks: q.After.Clicks}
+	return q.Compare(u, last) > 0
+}
+
+// URLPage страница ссылок пользователя
+type URLPage struct {
+	URLs       []UserURL
+	NextCursor string // пусто на последней странице
+}
+
+// LinkOptions настройки перенаправления по ссылке
+type LinkOptions struct {
+	RedirectCode     int  `json:"redirect_code"`     // 301, 302, 307 или 308, 0 — по умолчанию
+	QueryPassthrough bool `json:"query_passthrough"` // добавлять параметры запроса к оригинальному URL
+
+	// PasswordHash bcrypt-хеш пароля доступа, пусто — ссылка открыта.
+	// В историю версий не попадает.
+	PasswordHash string `json:"-"`
+}
+
+// Protected сообщает, закрыта ли ссылка паролем
+func (o LinkOptions) Protected() bool {
+	return o.PasswordHash != ""
+}
+
+// LinkMeta описание ссылки для владельца. На перенаправление не влияет
+// и в историю версий не попадает.
+type LinkMeta struct {
+	Title string   `json:"title,omitempty"`
+	Note  string   `json:"note,omitempty"`
+	Tags  []string `json:"tags,omitempty"` // в нижнем регистре, без повторов, по алфавиту
+}
+
+// TagCount метка и число активных ссылок пользователя с ней
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// SortTagCounts переводит число ссылок по меткам в список по убыванию
+// числа, при равенстве — по алфавиту
+func SortTagCounts(counts map[string]int) []TagCount {
+	tags := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: n})
+	}
+	slices.SortFunc(tags, func(a, b TagCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Tag, b.Tag))
+	})
+	return tags
+}
+
+// Link сохранённая ссылка с настройками
+type Link struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	UserID      string `json:"-"`
+	Version     int    `json:"version,omitempty"` // номер текущей версии, первая версия — 1
+	LinkOptions
+	LinkMeta
+}
+
+// LinkVersion версия ссылки в истории изменений
+type LinkVersion struct {
+	Version     int       `json:"version"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"` // когда версия стала действующей
+	LinkOptions
+}
+
+// LinkUpdate изменение ссылки. Поле nil не меняется.
+type LinkUpdate struct {
+	OriginalURL      *string `json:"original_url"`
+	RedirectCode     *int    `json:"redirect_code"`
+	QueryPassthrough *bool   `json:"query_passthrough"`
+
+	Title *string   `json:"title"`
+	Note  *string   `json:"note"`
+	Tags  *[]string `json:"tags"` // заменяет все метки, пустой список их удаляет
+
+	Password     *string `json:"password"` // новый пароль доступа, пустая строка снимает пароль
+	PasswordHash *string `json:"-"`        // хеш Password, заполняется сервисом
+}
+
+// IsEmpty сообщает, что изменение не меняет ни одного поля
+func (u LinkUpdate) IsEmpty() bool {
+	return !u.NewVersion() && u.Title == nil && u.Note == nil && u.Tags == nil &&
+		u.Password == nil && u.PasswordHash == nil
+}
+
+// NewVersion сообщает, создаёт ли изменение новую версию ссылки.
+// Версию создают изменения URL и настроек перенаправления, но не описания
+// и не пароля.
+func (u LinkUpdate) NewVersion() bool {
+	return u.OriginalURL != nil || u.RedirectCode != nil || u.QueryPassthrough != nil
+}
+
+// Apply возвращает настройки opts с изменениями u
+func (u LinkUpdate) Apply(opts LinkOptions) LinkOptions {
+	if u.RedirectCode != nil {
+		opts.RedirectCode = *u.RedirectCode
+	}
+	if u.QueryPassthrough != nil {
+		opts.QueryPassthrough = *u.QueryPassthrough
+	}
+	if u.PasswordHash != nil {
+		opts.PasswordHash = *u.PasswordHash
+	}
+	return opts
+}
+
+// ApplyMeta возвращает описание meta с изменениями u
+func (u LinkUpdate) ApplyMeta(meta LinkMeta) LinkMeta {
+	if u.Title != nil {
+		meta.Title = *u.Title
+	}
+	if u.Note != nil {
+		meta.Note = *u.Note
+	}
+	if u.Tags != nil {
+		meta.Tags = *u.Tags
+	}
+	return meta
+}
+
 // DeleteTask стурктура таски для удаления
 type DeleteTask struct {
-	UserID   string
-	ShortURL string
+	UserID    string
+	ShortURL  string
+	RequestID string // запрос, из которого пришло удаление, для логов и аудита
+
+	// SpanContext span запроса, span батча удаления ссылается на него
+	SpanContext trace.SpanContext
 }
 
 // Простая кастомная ошибка
 var ErrURLDeleted = errors.New("URL has been deleted")
 
+// ErrURLNotFound короткая ссылка не найдена
+var ErrURLNotFound = errors.New("URL not found")
+
+// ErrDeleteQueueFull очередь удаления переполнена, часть задач не принята
+var ErrDeleteQueueFull = errors.New("delete queue is full")
+
+// ValidationError некорректные входные данные
+type ValidationError struct {
+	Field   string // поле запроса, например original_url
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ErrURLConflictError URL уже сокращён, ExistingShortURL — его короткая ссылка
+type ErrURLConflictError struct {
+	ExistingShortURL string
+}
+
+func (e ErrURLConflictError) Error() string {
+	return fmt.Sprintf("URL уже существует с коротким URL: %s", e.ExistingShortURL)
+}
+
+// QuotaKind вид нарушенной квоты
+type QuotaKind string
+
+// Виды квот
+const (
+	QuotaLinks     QuotaKind = "links"      // активных ссылок у пользователя
+	QuotaBatch     QuotaKind = "batch"      // элементов в пакетном запросе
+	QuotaBodyBytes QuotaKind = "body_bytes" // байт в теле запроса
+)
+
+// QuotaError ошибка превышения квоты
+type QuotaError struct {
+	Kind      QuotaKind `json:"quota"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used,omitempty"`      // уже израсходовано, для QuotaLinks
+	Requested int64     `json:"requested,omitempty"` // запрошено этим запросом
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("превышена квота %s: лимит %d", e.Kind, e.Limit)
+}
+
+// NewLinkQuotaError возвращает ошибку превышения квоты на число ссылок:
+// у пользователя used активных ссылок, запрошено ещё requested
+func NewLinkQuotaError(limit, used, requested int) *QuotaError {
+	return &QuotaError{Kind: QuotaLinks, Limit: int64(limit), Used: int64(used), Requested: int64(requested)}
+}
+
+// StatusCode возвращает HTTP-статус для ошибки: 403 для числа ссылок,
+// 413 для размеров запроса
+func (e *QuotaError) StatusCode() int {
+	if e.Kind == QuotaLinks {
+		return http.StatusForbidden
+	}
+	return http.StatusRequestEntityTooLarge
+}
+
 // Stats представляет статистику сервиса
 type Stats struct {
 	URLs  int `json:"urls"`
 	Users int `json:"users"`
 }
+
+// Quota ограничения пользователя. Значение 0 или меньше — без ограничения.
+type Quota struct {
+	MaxLinks     int   `json:"max_links"`      // активных ссылок
+	MaxBatch     int   `json:"max_batch"`      // элементов в одном пакетном запросе
+	MaxBodyBytes int64 `json:"max_body_bytes"` // байт в теле запроса
+}
@@ -0,0 +1,104 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ContentType тип тела ответа с ошибкой
+const ContentType = "application/problem+json"
+
+// Details тело ответа RFC 7807
+type Details struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      Code   `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// Extensions дополнительные поля на верхнем уровне тела
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON кладёт расширения на верхний уровень тела, как требует RFC 7807.
+// Стандартные поля расширения не перекрывают.
+func (d Details) MarshalJSON() ([]byte, error) {
+	type plain Details
+	base, err := json.Marshal(plain(d))
+	if err != nil || len(d.Extensions) == 0 {
+		return base, err
+	}
+
+	fields := make(map[string]any, len(d.Extensions)+7)
+	for k, v := range d.Extensions {
+		fields[k] = v
+	}
+	var std map[string]any
+	if err := json.Unmarshal(base, &std); err != nil {
+		return nil, err
+	}
+	for k, v := range std {
+		fields[k] = v
+	}
+	return json.Marshal(fields)
+}
+
+// NewDetails собирает тело ответа для ошибки запроса c
+func NewDetails(c *gin.Context, e *Error) Details {
+	return Details{
+		Type:       TypePrefix + string(e.Code),
+		Title:      http.StatusText(e.Status),
+		Status:     e.Status,
+		Detail:     e.Detail,
+		Instance:   c.Request.URL.Path,
+		Code:       e.Code,
+		RequestID:  requestid.Get(c),
+		Extensions: e.Extensions,
+	}
+}
+
+// Write отвечает ошибкой err и прерывает цепочку обработчиков.
+//
+// Ошибка приводится к *Error через From. Внутренние ошибки пишутся в лог
+// вместе с исходной причиной, клиент видит только общее описание.
+// Если клиент предпочёл text/plain, тело — одна строка описания.
+func Write(c *gin.Context, err error) {
+	e := From(err)
+	if e.Status >= http.StatusInternalServerError && e.Err != nil {
+		zap.S().Errorw("Ошибка обработки запроса",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"request_id", requestid.Get(c),
+			"code", e.Code,
+			"error", e,
+		)
+		trace.SpanFromContext(c.Request.Context()).RecordError(e)
+	}
+
+	if WantsText(c) {
+		c.Abort()
+		c.String(e.Status, e.Detail)
+		return
+	}
+
+	body, err := json.Marshal(NewDetails(c, e))
+	if err != nil {
+		zap.S().Errorf("problem: ошибка сериализации: %v", err)
+		c.AbortWithStatus(e.Status)
+		return
+	}
+	c.Abort()
+	c.Data(e.Status, ContentType, body)
+}
+
+// WantsText сообщает, что клиент явно предпочёл text/plain формату JSON
+func WantsText(c *gin.Context) bool {
+	return c.NegotiateFormat(ContentType, gin.MIMEJSON, gin.MIMEPlain) == gin.MIMEPlain
+}
@@ -0,0 +1,151 @@
+// Package problem отвечает на ошибки в формате RFC 7807 (application/problem+json).
+//
+// Ошибки сервиса и хранилища приводятся к *Error со стабильным машинным
+// кодом и HTTP-статусом: по коду клиент различает ошибки, не разбирая текст.
+// Тело ответа содержит код, статус, описание и идентификатор запроса:
+//
+//	HTTP/1.1 404 Not Found
+//	Content-Type: application/problem+json
+//
+//	{
+//	  "type": "urn:shortener:problem:not_found",
+//	  "title": "Not Found",
+//	  "status": 404,
+//	  "detail": "Короткая ссылка не найдена",
+//	  "instance": "/abc123",
+//	  "code": "not_found",
+//	  "request_id": "0f8fad5b-d9cb-469f-a165-70867728950e"
+//	}
+//
+// Текстовый ответ отдаётся, только если клиент явно предпочёл text/plain
+// в заголовке Accept.
+package problem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Popolzen/shortener/internal/model"
+)
+
+// Code машинный код ошибки, стабильный между версиями
+type Code string
+
+// Коды ошибок
+const (
+	CodeBadRequest           Code = "bad_request"
+	CodeValidation           Code = "validation_failed"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeForbidden            Code = "forbidden"
+	CodeNotFound             Code = "not_found"
+	CodeGone                 Code = "gone"
+	CodeConflict             Code = "conflict"
+	CodePayloadTooLarge      Code = "payload_too_large"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	CodeQuotaExceeded        Code = "quota_exceeded"
+	CodeRateLimited          Code = "rate_limited"
+	CodeInternal             Code = "internal"
+	CodeNotImplemented       Code = "not_implemented"
+	CodeTimeout              Code = "timeout"
+	CodeUnavailable          Code = "unavailable"
+)
+
+// TypePrefix префикс поля type: к нему добавляется код ошибки
+const TypePrefix = "urn:shortener:problem:"
+
+// Error ошибка с кодом и HTTP-статусом
+type Error struct {
+	Code   Code
+	Status int
+	Detail string // описание для клиента
+	Err    error  // исходная ошибка, клиенту не показывается
+
+	// Extensions дополнительные поля тела ответа
+	Extensions map[string]any
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Detail, e.Err)
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New создаёт ошибку с кодом, статусом и описанием
+func New(code Code, status int, detail string) *Error {
+	return &Error{Code: code, Status: status, Detail: detail}
+}
+
+// Wrap создаёт ошибку, сохраняя исходную для логов
+func Wrap(err error, code Code, status int, detail string) *Error {
+	return &Error{Code: code, Status: status, Detail: detail, Err: err}
+}
+
+// BadRequest ошибка разбора запроса
+func BadRequest(detail string) *Error {
+	return New(CodeBadRequest, http.StatusBadRequest, detail)
+}
+
+// Internal внутренняя ошибка: подробности уходят в лог, а не клиенту
+func Internal(err error) *Error {
+	return Wrap(err, CodeInternal, http.StatusInternalServerError, "Внутренняя ошибка сервера")
+}
+
+// NotImplemented возможность не настроена в этой конфигурации
+func NotImplemented(detail string) *Error {
+	return New(CodeNotImplemented, http.StatusNotImplemented, detail)
+}
+
+// From приводит ошибку сервиса или хранилища к *Error.
+// Неизвестные ошибки становятся внутренними.
+func From(err error) *Error {
+	var (
+		pe          *Error
+		validation  *model.ValidationError
+		quota       *model.QuotaError
+		conflict    model.ErrURLConflictError
+		maxBytesErr *http.MaxBytesError
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &pe):
+		return pe
+	case errors.As(err, &validation):
+		e := Wrap(err, CodeValidation, http.StatusBadRequest, err.Error())
+		e.Extensions = map[string]any{"field": validation.Field}
+		return e
+	case errors.As(err, &quota):
+		e := Wrap(err, CodeQuotaExceeded, quota.StatusCode(), quota.Error())
+		e.Extensions = map[string]any{"quota": quota.Kind, "limit": quota.Limit}
+		if quota.Used != 0 {
+			e.Extensions["used"] = quota.Used
+		}
+		if quota.Requested != 0 {
+			e.Extensions["requested"] = quota.Requested
+		}
+		return e
+	case errors.As(err, &conflict):
+		e := Wrap(err, CodeConflict, http.StatusConflict, "URL уже сокращён")
+		e.Extensions = map[string]any{"short_url": conflict.ExistingShortURL}
+		return e
+	case errors.Is(err, model.ErrURLNotFound):
+		return Wrap(err, CodeNotFound, http.StatusNotFound, "Короткая ссылка не найдена")
+	case errors.Is(err, model.ErrURLDeleted):
+		return Wrap(err, CodeGone, http.StatusGone, "Ссылка удалена пользователем")
+	case errors.Is(err, model.ErrDeleteQueueFull):
+		return Wrap(err, CodeUnavailable, http.StatusServiceUnavailable, "Очередь удаления переполнена, повторите запрос позже")
+	case errors.As(err, &maxBytesErr):
+		return Wrap(err, CodePayloadTooLarge, http.StatusRequestEntityTooLarge, "Тело запроса слишком большое")
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(err, CodeTimeout, http.StatusGatewayTimeout, "Превышено время выполнения запроса")
+	default:
+		return Internal(err)
+	}
+}
@@ -0,0 +1,121 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   Code
+		wantStatus int
+	}{
+		{name: "не найдена", err: fmt.Errorf("get: %w", model.ErrURLNotFound), wantCode: CodeNotFound, wantStatus: http.StatusNotFound},
+		{name: "удалена", err: model.ErrURLDeleted, wantCode: CodeGone, wantStatus: http.StatusGone},
+		{name: "конфликт", err: model.ErrURLConflictError{ExistingShortURL: "abc"}, wantCode: CodeConflict, wantStatus: http.StatusConflict},
+		{name: "валидация", err: &model.ValidationError{Field: "original_url", Message: "плохой URL"}, wantCode: CodeValidation, wantStatus: http.StatusBadRequest},
+		{name: "квота ссылок", err: &model.QuotaError{Kind: model.QuotaLinks, Limit: 1}, wantCode: CodeQuotaExceeded, wantStatus: http.StatusForbidden},
+		{name: "квота пакета", err: &model.QuotaError{Kind: model.QuotaBatch, Limit: 1}, wantCode: CodeQuotaExceeded, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "большое тело", err: &http.MaxBytesError{Limit: 10}, wantCode: CodePayloadTooLarge, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "таймаут", err: fmt.Errorf("query: %w", context.DeadlineExceeded), wantCode: CodeTimeout, wantStatus: http.StatusGatewayTimeout},
+		{name: "готовая ошибка", err: BadRequest("плохо"), wantCode: CodeBadRequest, wantStatus: http.StatusBadRequest},
+		{name: "неизвестная", err: errors.New("boom"), wantCode: CodeInternal, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			assert.Equal(t, tt.wantCode, e.Code)
+			assert.Equal(t, tt.wantStatus, e.Status)
+			assert.NotEmpty(t, e.Detail)
+		})
+	}
+	assert.Nil(t, From(nil))
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+	}{
+		{name: "без Accept", wantContentType: ContentType},
+		{name: "любой формат", accept: "*/*", wantContentType: ContentType},
+		{name: "JSON", accept: "application/json", wantContentType: ContentType},
+		{name: "текст", accept: "text/plain", wantContentType: "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/:id", func(c *gin.Context) {
+				Write(c, &model.QuotaError{Kind: model.QuotaLinks, Limit: 2, Used: 2, Requested: 1})
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			req.Header.Set("Accept", tt.accept)
+			req.Header.Set(requestid.Header, "req-1")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"))
+			if tt.wantContentType != ContentType {
+				assert.Equal(t, "превышена квота links: лимит 2", w.Body.String())
+				return
+			}
+
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, TypePrefix+"quota_exceeded", body["type"])
+			assert.Equal(t, "Forbidden", body["title"])
+			assert.EqualValues(t, http.StatusForbidden, body["status"])
+			assert.Equal(t, "quota_exceeded", body["code"])
+			assert.Equal(t, "/abc", body["instance"])
+			assert.Equal(t, "req-1", body["request_id"])
+			// Поля квоты — расширения на верхнем уровне
+			assert.Equal(t, "links", body["quota"])
+			assert.EqualValues(t, 2, body["used"])
+		})
+	}
+}
+
+func TestWrite_HidesInternalCause(t *testing.T) {
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		Write(c, errors.New("pq: password authentication failed"))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "password")
+}
+
+func TestDetails_ExtensionsDoNotOverrideStandardFields(t *testing.T) {
+	data, err := json.Marshal(Details{
+		Type: TypePrefix + "bad_request", Status: 400, Code: CodeBadRequest,
+		Extensions: map[string]any{"status": 200, "field": "url"},
+	})
+	require.NoError(t, err)
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(data, &body))
+	assert.EqualValues(t, 400, body["status"])
+	assert.Equal(t, "url", body["field"])
+}
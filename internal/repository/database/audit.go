@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// AuditObserver наблюдатель аудита, пишущий события в таблицу audit_events.
+//
+// Пул соединений принадлежит репозиторию, поэтому Close его не закрывает.
+type AuditObserver struct {
+	DB *sql.DB
+}
+
+// NewAuditObserver создаёт наблюдателя для записи аудита в БД
+func NewAuditObserver(db *sql.DB) *AuditObserver {
+	return &AuditObserver{DB: db}
+}
+
+// Health проверяет соединение с БД
+func (o *AuditObserver) Health(ctx context.Context) error {
+	return o.DB.PingContext(ctx)
+}
+
+// String возвращает описание наблюдателя
+func (o *AuditObserver) String() string {
+	return "database"
+}
+
+// Notify сохраняет событие в audit_events.
+// Span записи попадает в трассу запроса, породившего событие.
+func (o *AuditObserver) Notify(event audit.Event) {
+	if err := o.insert(event.TraceContext(), event); err != nil {
+		zap.S().Errorw("audit db: ошибка записи события", "event_id", event.ID, "request_id", event.RequestID, "error", err)
+	}
+}
+
+// DeliverBatch сохраняет события в audit_events по порядку и возвращает
+// число сохранённых до первой ошибки. Контекст ограничивает время записи,
+// span попадает в трассу запроса, породившего событие.
+func (o *AuditObserver) DeliverBatch(ctx context.Context, events []audit.Event) (int, error) {
+	for i, event := range events {
+		eventCtx := ctx
+		if event.SpanContext.IsValid() {
+			eventCtx = trace.ContextWithSpanContext(ctx, event.SpanContext)
+		}
+		if err := o.insert(eventCtx, event); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// insert добавляет событие в audit_events. Повторная доставка события
+// с тем же ID не создаёт второй строки.
+func (o *AuditObserver) insert(ctx context.Context, event audit.Event) (err error) {
+	query := `
+        INSERT INTO audit_events (event_id, ts, action, user_id, url, short_code, client_ip, user_agent, request_id)
+        VALUES ($1, to_timestamp($2), $3, $4, $5, $6, $7, $8, $9)
+        ON CONFLICT (event_id) WHERE event_id <> '' DO NOTHING
+    `
+	ctx, span := startQuery(ctx, "INSERT", "audit_events", query)
+	defer telemetry.End(span, &err)
+
+	_, err = o.DB.ExecContext(ctx, query,
+		event.ID, event.Timestamp, event.Action, event.UserID, event.URL,
+		event.ShortCode, event.ClientIP, event.UserAgent, event.RequestID,
+	)
+	return err
+}
+
+// Close ничего не делает: соединение закрывает репозиторий
+func (o *AuditObserver) Close() error {
+	return nil
+}
+
+// Query выбирает события из audit_events. Курсором служит id строки.
+func (o *AuditObserver) Query(q audit.Query) (audit.Page, error) {
+	limit := q.PageSize()
+	query, args := buildAuditQuery(q, limit+1)
+
+	rows, err := o.DB.Query(query, args...)
+	if err != nil {
+		return audit.Page{}, fmt.Errorf("ошибка выборки аудита: %w", err)
+	}
+	defer rows.Close()
+
+	var page audit.Page
+	for rows.Next() {
+		var (
+			id uint64
+			e  audit.Event
+		)
+		err := rows.Scan(&id, &e.ID, &e.Timestamp, &e.Action, &e.UserID, &e.URL,
+			&e.ShortCode, &e.ClientIP, &e.UserAgent, &e.RequestID)
+		if err != nil {
+			return audit.Page{}, fmt.Errorf("ошибка чтения события аудита: %w", err)
+		}
+		if len(page.Events) == limit {
+			// Лишняя строка означает, что есть следующая страница
+			return page, nil
+		}
+		page.Events = append(page.Events, e)
+		page.Next = id
+	}
+	if err := rows.Err(); err != nil {
+		return audit.Page{}, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
+
+	page.Next = 0
+	return page, nil
+}
+
+// buildAuditQuery строит SELECT по фильтру с limit строками
+func buildAuditQuery(q audit.Query, limit int) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if q.After > 0 {
+		add("id > ?", q.After)
+	}
+	if q.UserID != "" {
+		add("user_id = ?", q.UserID)
+	}
+	if q.Action != "" {
+		add("action = ?", string(q.Action))
+	}
+	if q.URL != "" {
+		// strpos вместо LIKE, чтобы % и _ в подстроке не были шаблоном
+		add("strpos(url, ?) > 0", q.URL)
+	}
+	if q.From != 0 {
+		add("ts >= to_timestamp(?)", q.From)
+	}
+	if q.To != 0 {
+		add("ts <= to_timestamp(?)", q.To)
+	}
+
+	query := `SELECT id, event_id, EXTRACT(EPOCH FROM ts)::BIGINT, action, user_id, url, short_code, client_ip, user_agent, request_id FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	query += " ORDER BY id LIMIT $" + strconv.Itoa(len(args))
+	return query, args
+}
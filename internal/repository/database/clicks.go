@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// clickFlushInterval как часто накопленные переходы записываются в БД
+const clickFlushInterval = time.Second
+
+// clickBuffer копит переходы по ссылкам, чтобы перенаправление
+// не ждало UPDATE на каждый клик
+type clickBuffer struct {
+	mu     sync.Mutex
+	counts map[string]int64 // короткая ссылка -> переходы с прошлой записи
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// RecordClick учитывает переход по ссылке. Переход попадает в БД
+// при следующей записи буфера, но не позже остановки репозитория.
+func (r *URLRepository) RecordClick(ctx context.Context, shortURL string) {
+	if r.clicks == nil {
+		if err := r.addClicks(ctx, map[string]int64{shortURL: 1}); err != nil {
+			zap.S().Errorw("ошибка записи перехода", "short_url", shortURL, "error", err)
+		}
+		return
+	}
+	r.clicks.mu.Lock()
+	r.clicks.counts[shortURL]++
+	r.clicks.mu.Unlock()
+}
+
+// startClickFlusher запускает периодическую запись переходов
+func (r *URLRepository) startClickFlusher() {
+	r.clicks = &clickBuffer{
+		counts: map[string]int64{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.runClickFlusher()
+}
+
+// stopClickFlusher записывает оставшиеся переходы и останавливает запись
+func (r *URLRepository) stopClickFlusher() {
+	if r.clicks == nil {
+		return
+	}
+	close(r.clicks.stop)
+	<-r.clicks.done
+}
+
+func (r *URLRepository) runClickFlusher() {
+	defer close(r.clicks.done)
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.clicks.stop:
+			r.flushClicks()
+			return
+		case <-ticker.C:
+			r.flushClicks()
+		}
+	}
+}
+
+// flushClicks записывает накопленные переходы. При ошибке они возвращаются
+// в буфер и будут записаны при следующей попытке.
+func (r *URLRepository) flushClicks() {
+	r.clicks.mu.Lock()
+	counts := r.clicks.counts
+	r.clicks.counts = map[string]int64{}
+	r.clicks.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+	if err := r.addClicks(context.Background(), counts); err != nil {
+		zap.S().Errorw("ошибка записи переходов", "links", len(counts), "error", err)
+		r.clicks.mu.Lock()
+		for shortURL, n := range counts {
+			r.clicks.counts[shortURL] += n
+		}
+		r.clicks.mu.Unlock()
+	}
+}
+
+// addClicks прибавляет переходы к счётчикам ссылок одним запросом
+func (r *URLRepository) addClicks(ctx context.Context, counts map[string]int64) (err error) {
+	query := `
+        UPDATE shortened_urls AS s
+        SET clicks = s.clicks + c.n
+        FROM unnest($1::text[], $2::bigint[]) AS c(short_url, n)
+        WHERE s.short_url = c.short_url
+    `
+	ctx, span := startQuery(ctx, "UPDATE", "shortened_urls", query)
+	defer telemetry.End(span, &err)
+
+	shortURLs := make([]string, 0, len(counts))
+	ns := make([]int64, 0, len(counts))
+	for shortURL, n := range counts {
+		shortURLs = append(shortURLs, shortURL)
+		ns = append(ns, n)
+	}
+	_, err = r.DB.ExecContext(ctx, query, pq.Array(shortURLs), pq.Array(ns))
+	return err
+}
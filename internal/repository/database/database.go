@@ -1,119 +1,380 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/middleware/requestid"
 	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/telemetry"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
-type ErrURLConflictError struct {
-	ExistingShortURL string
-}
-
-func (e ErrURLConflictError) Error() string {
-	return fmt.Sprintf("URL уже существует с коротким URL: %s", e.ExistingShortURL)
-}
-
 type URLRepository struct {
 	DB            *sql.DB
 	DeleteChannel chan model.DeleteTask
 	WG            sync.WaitGroup
+	shutdownOnce  sync.Once
+
+	// auditPub получает события из outbox, может быть nil — тогда outbox не ведётся
+	auditPub *audit.Publisher
+	relay    *outboxRelay
+
+	// clicks копит переходы до записи в БД, nil — переходы пишутся сразу
+	clicks *clickBuffer
 }
 
-// Get получает длинный URL по короткому с проверкой удаления
-func (r *URLRepository) Get(shortURL string) (string, error) {
-	var longURL string
+// startQuery открывает span запроса к БД с именем "<операция> <таблица>"
+func startQuery(ctx context.Context, op, table, query string) (context.Context, trace.Span) {
+	return telemetry.Start(ctx, op+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(op),
+			semconv.DBCollectionName(table),
+			semconv.DBQueryText(strings.Join(strings.Fields(query), " ")),
+		),
+	)
+}
+
+// Get получает ссылку с настройками и описанием по короткому URL с проверкой удаления
+func (r *URLRepository) Get(ctx context.Context, shortURL string) (_ model.Link, err error) {
+	link := model.Link{ShortURL: shortURL}
 	var isDeleted bool
+	var tags pq.StringArray
 
 	query := `
-        SELECT long_url, COALESCE(is_deleted, false) 
+        SELECT long_url, user_id, version, redirect_code, query_passthrough, password_hash, title, note, ` + tagsColumn + `, COALESCE(is_deleted, false) 
         FROM shortened_urls 
         WHERE short_url = $1
     `
+	ctx, span := startQuery(ctx, "SELECT", "shortened_urls", query)
+	defer telemetry.End(span, &err)
 
-	err := r.DB.QueryRow(query, shortURL).Scan(&longURL, &isDeleted)
+	err = r.DB.QueryRowContext(ctx, query, shortURL).Scan(
+		&link.OriginalURL, &link.UserID, &link.Version, &link.RedirectCode, &link.QueryPassthrough, &link.PasswordHash,
+		&link.Title, &link.Note, &tags, &isDeleted)
+	link.Tags = tagList(tags)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("URL not found")
+			return model.Link{}, model.ErrURLNotFound
 		}
-		return "", fmt.Errorf("ошибка при получении URL: %w", err)
+		return model.Link{}, fmt.Errorf("ошибка при получении URL: %w", err)
 	}
 
 	if isDeleted {
-		return "", model.ErrURLDeleted
+		return model.Link{}, model.ErrURLDeleted
 	}
 
-	return longURL, nil
+	return link, nil
+}
+
+// UpdateLink меняет неудалённую ссылку пользователя. Изменение URL или
+// настроек записывает новую версию в link_history.
+func (r *URLRepository) UpdateLink(ctx context.Context, userID, shortURL string, update model.LinkUpdate) (model.Link, error) {
+	return r.updateLink(ctx, userID, shortURL, update, nil)
+}
+
+// updateLink изменяет ссылку, её метки и версию в одной транзакции.
+// Если event не nil, в той же транзакции событие попадает в outbox.
+func (r *URLRepository) updateLink(ctx context.Context, userID, shortURL string, update model.LinkUpdate, event *audit.Event) (model.Link, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Link{}, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	link, err := r.updateURL(ctx, tx, userID, shortURL, update)
+	if err != nil {
+		return model.Link{}, err
+	}
+	if update.NewVersion() {
+		if err := insertVersion(ctx, tx, link); err != nil {
+			return model.Link{}, err
+		}
+	}
+	if update.Tags != nil {
+		err = setTags(ctx, tx, shortURL, *update.Tags)
+		link.Tags = tagList(*update.Tags)
+	} else {
+		link.Tags, err = getTags(ctx, tx, shortURL)
+	}
+	if err != nil {
+		return model.Link{}, err
+	}
+	if event != nil {
+		event.URL = link.OriginalURL
+		if err := insertOutbox(ctx, tx, *event); err != nil {
+			return model.Link{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Link{}, fmt.Errorf("ошибка фиксации транзакции: %w", err)
+	}
+	if event != nil {
+		r.wakeRelay()
+	}
+	return link, nil
+}
+
+// updateURL меняет строку ссылки и увеличивает её версию, если изменение
+// затрагивает URL или настройки
+func (r *URLRepository) updateURL(ctx context.Context, ex execer, userID, shortURL string, update model.LinkUpdate) (_ model.Link, err error) {
+	link := model.Link{ShortURL: shortURL, UserID: userID}
+
+	query := `
+        UPDATE shortened_urls
+        SET long_url = COALESCE($3::text, long_url),
+            redirect_code = COALESCE($4::smallint, redirect_code),
+            query_passthrough = COALESCE($5::bool, query_passthrough),
+            title = COALESCE($6::text, title),
+            note = COALESCE($7::text, note),
+            password_hash = COALESCE($9::text, password_hash),
+            version = version + CASE WHEN $8::bool THEN 1 ELSE 0 END
+        WHERE short_url = $1 AND user_id = $2 AND is_deleted = false
+        RETURNING long_url, version, redirect_code, query_passthrough, password_hash, title, note
+    `
+	ctx, span := startQuery(ctx, "UPDATE", "shortened_urls", query)
+	defer telemetry.End(span, &err)
+
+	err = ex.QueryRowContext(ctx, query, shortURL, userID, update.OriginalURL, update.RedirectCode, update.QueryPassthrough,
+		update.Title, update.Note, update.NewVersion(), update.PasswordHash).Scan(
+		&link.OriginalURL, &link.Version, &link.RedirectCode, &link.QueryPassthrough, &link.PasswordHash, &link.Title, &link.Note)
+	if err == nil {
+		return link, nil
+	}
+	if err != sql.ErrNoRows {
+		if update.OriginalURL != nil {
+			if conflict := r.conflictError(ctx, err, *update.OriginalURL); conflict != nil {
+				return model.Link{}, conflict
+			}
+		}
+		return model.Link{}, fmt.Errorf("ошибка при изменении URL: %w", err)
+	}
+
+	// Ссылки нет, она чужая или удалена: удалённую ссылку владелец видит как удалённую
+	var isDeleted bool
+	checkQuery := `SELECT COALESCE(is_deleted, false) FROM shortened_urls WHERE short_url = $1 AND user_id = $2`
+	err = ex.QueryRowContext(ctx, checkQuery, shortURL, userID).Scan(&isDeleted)
+	switch {
+	case err == sql.ErrNoRows:
+		return model.Link{}, model.ErrURLNotFound
+	case err != nil:
+		return model.Link{}, fmt.Errorf("ошибка при проверке URL: %w", err)
+	case isDeleted:
+		return model.Link{}, model.ErrURLDeleted
+	}
+	return model.Link{}, model.ErrURLNotFound
+}
+
+// insertVersion добавляет состояние ссылки в link_history. Хеш пароля
+// в историю не попадает.
+func insertVersion(ctx context.Context, ex execer, link model.Link) (err error) {
+	query := `
+        INSERT INTO link_history (short_url, version, long_url, redirect_code, query_passthrough)
+        VALUES ($1, $2, $3, $4, $5)
+    `
+	ctx, span := startQuery(ctx, "INSERT", "link_history", query)
+	defer telemetry.End(span, &err)
+
+	_, err = ex.ExecContext(ctx, query, link.ShortURL, link.Version, link.OriginalURL, link.RedirectCode, link.QueryPassthrough)
+	if err != nil {
+		return fmt.Errorf("ошибка при сохранении версии URL: %w", err)
+	}
+	return nil
+}
+
+// GetLinkHistory возвращает версии ссылки пользователя по возрастанию номера
+func (r *URLRepository) GetLinkHistory(ctx context.Context, userID, shortURL string) (_ []model.LinkVersion, err error) {
+	query := `
+        SELECT h.version, h.long_url, h.redirect_code, h.query_passthrough, h.created_at
+        FROM link_history h
+        JOIN shortened_urls s ON s.short_url = h.short_url
+        WHERE h.short_url = $1 AND s.user_id = $2
+        ORDER BY h.version
+    `
+	ctx, span := startQuery(ctx, "SELECT", "link_history", query)
+	defer telemetry.End(span, &err)
+
+	rows, err := r.DB.QueryContext(ctx, query, shortURL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении истории URL: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []model.LinkVersion
+	for rows.Next() {
+		var v model.LinkVersion
+		if err := rows.Scan(&v.Version, &v.OriginalURL, &v.RedirectCode, &v.QueryPassthrough, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка при чтении версии URL: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
+	if len(versions) == 0 {
+		return nil, model.ErrURLNotFound
+	}
+	return versions, nil
 }
 
 // getByLongURL получает короткий URL по длинному
-func (r *URLRepository) getByLongURL(longURL string) (string, error) {
+func (r *URLRepository) getByLongURL(ctx context.Context, longURL string) (_ string, err error) {
 	var shortURL string
 	query := `SELECT short_url FROM shortened_urls WHERE long_url = $1`
-	err := r.DB.QueryRow(query, longURL).Scan(&shortURL)
+	ctx, span := startQuery(ctx, "SELECT", "shortened_urls", query)
+	defer telemetry.End(span, &err)
+
+	err = r.DB.QueryRowContext(ctx, query, longURL).Scan(&shortURL)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("URL not found")
+			return "", model.ErrURLNotFound
 		}
 		return "", fmt.Errorf("ошибка при получении короткого URL: %w", err)
 	}
 	return shortURL, nil
 }
 
-// Store сохраняет соответствие короткого и длинного URL
-func (r *URLRepository) Store(shortURL, longURL, id string) error {
+// execer общий интерфейс *sql.DB и *sql.Tx для выполнения запросов
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Store сохраняет соответствие короткого и длинного URL с настройками перенаправления и описанием
+func (r *URLRepository) Store(ctx context.Context, shortURL, longURL, id string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkLinkQuota(ctx, tx, id, maxLinks); err != nil {
+		return err
+	}
+	if err := r.insertURL(ctx, tx, shortURL, longURL, id, opts, meta); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации транзакции: %w", err)
+	}
+	return nil
+}
+
+// userLinksLockClass первый ключ advisory-блокировки ссылок пользователя,
+// второй — хеш идентификатора пользователя
+const userLinksLockClass = 1
+
+// checkLinkQuota берёт до конца транзакции блокировку ссылок пользователя
+// и проверяет, что у него меньше maxLinks активных ссылок. Параллельная
+// транзакция того же пользователя ждёт фиксации и видит новую ссылку,
+// поэтому квота не превышается. При maxLinks <= 0 ничего не делает.
+func checkLinkQuota(ctx context.Context, ex execer, userID string, maxLinks int) (err error) {
+	if maxLinks <= 0 || userID == "" {
+		return nil
+	}
+
+	query := `SELECT pg_advisory_xact_lock($1, hashtext($2))`
+	lockCtx, span := startQuery(ctx, "SELECT", "shortened_urls", query)
+	_, err = ex.ExecContext(lockCtx, query, userLinksLockClass, userID)
+	telemetry.End(span, &err)
+	if err != nil {
+		return fmt.Errorf("ошибка блокировки ссылок пользователя: %w", err)
+	}
+
+	var used int
+	query = `SELECT COUNT(*) FROM shortened_urls WHERE user_id = $1 AND is_deleted = false`
+	countCtx, span := startQuery(ctx, "SELECT", "shortened_urls", query)
+	err = ex.QueryRowContext(countCtx, query, userID).Scan(&used)
+	telemetry.End(span, &err)
+	if err != nil {
+		return fmt.Errorf("ошибка подсчёта URL пользователя: %w", err)
+	}
+	if used >= maxLinks {
+		return model.NewLinkQuotaError(maxLinks, used, 1)
+	}
+	return nil
+}
+
+// conflictError возвращает ErrURLConflictError, если err — нарушение
+// уникальности, и nil для остальных ошибок
+func (r *URLRepository) conflictError(ctx context.Context, err error, longURL string) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		return nil
+	}
+	existingShortURL, getErr := r.getByLongURL(ctx, longURL)
+	if getErr != nil {
+		return fmt.Errorf("ошибка при получении существующего URL: %w", getErr)
+	}
+	return model.ErrURLConflictError{ExistingShortURL: existingShortURL}
+}
+
+// insertURL добавляет ссылку, её метки и первую версию через db или транзакцию
+func (r *URLRepository) insertURL(ctx context.Context, ex execer, shortURL, longURL, id string, opts model.LinkOptions, meta model.LinkMeta) (err error) {
 	query := `
-    INSERT INTO shortened_urls (short_url, long_url, created_at, user_id)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO shortened_urls (short_url, long_url, created_at, user_id, redirect_code, query_passthrough, password_hash, title, note)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 `
+	ctx, span := startQuery(ctx, "INSERT", "shortened_urls", query)
+	defer telemetry.End(span, &err)
 
 	now := time.Now()
-	_, err := r.DB.Exec(query, shortURL, longURL, now, id)
+	_, err = ex.ExecContext(ctx, query, shortURL, longURL, now, id, opts.RedirectCode, opts.QueryPassthrough, opts.PasswordHash, meta.Title, meta.Note)
 	if err != nil {
-
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			existingShortURL, getErr := r.getByLongURL(longURL)
-			if getErr != nil {
-				return fmt.Errorf("ошибка при получении существующего URL: %w", getErr)
-			}
-			return ErrURLConflictError{ExistingShortURL: existingShortURL}
+		if conflict := r.conflictError(ctx, err, longURL); conflict != nil {
+			return conflict
 		}
-
 		return fmt.Errorf("ошибка при сохранении URL: %w", err)
 	}
 
-	return nil
+	if err := insertTags(ctx, ex, shortURL, meta.Tags); err != nil {
+		return err
+	}
+	return insertVersion(ctx, ex, model.Link{
+		ShortURL:    shortURL,
+		OriginalURL: longURL,
+		Version:     1,
+		LinkOptions: opts,
+	})
 }
 
-// GetUserURLs - возвращает все URLs для конкретного пользователя
-func (r *URLRepository) GetUserURLs(userID string) ([]model.URLPair, error) {
-	query := `SELECT short_url, long_url FROM shortened_urls WHERE user_id = $1 ORDER BY created_at DESC`
-	rows, err := r.DB.Query(query, userID)
+// GetUserURLs возвращает страницу ссылок пользователя по фильтрам и курсору
+func (r *URLRepository) GetUserURLs(ctx context.Context, userID string, q model.URLListQuery) (_ []model.UserURL, err error) {
+	query, args := buildUserURLsQuery(userID, q)
+	ctx, span := startQuery(ctx, "SELECT", "shortened_urls", query)
+	defer telemetry.End(span, &err)
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка при получении URL пользователя: %w", err)
 	}
 	defer rows.Close()
 
-	var urls []model.URLPair
+	var urls []model.UserURL
 	for rows.Next() {
-		var pair model.URLPair
-		err := rows.Scan(&pair.ShortURL, &pair.OriginalURL)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return nil, fmt.Errorf("URL not found")
-			}
+		var u model.UserURL
+		var tags pq.StringArray
+		if err := rows.Scan(&u.ShortURL, &u.OriginalURL, &u.CreatedAt, &u.Clicks, &u.IsDeleted, &u.Protected, &u.Title, &u.Note, &tags); err != nil {
 			return nil, fmt.Errorf("ошибка при получении короткого URL: %w", err)
 		}
-		urls = append(urls, pair)
+		u.Tags = tagList(tags)
+		urls = append(urls, u)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -123,11 +384,94 @@ func (r *URLRepository) GetUserURLs(userID string) ([]model.URLPair, error) {
 	return urls, nil
 }
 
+// buildUserURLsQuery строит выборку ссылок пользователя. Курсор превращается
+// в сравнение пары (ключ сортировки, short_url), что позволяет идти по индексу
+// без OFFSET.
+func buildUserURLsQuery(userID string, q model.URLListQuery) (string, []any) {
+	where := []string{"user_id = $1"}
+	args := []any{userID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	key := "created_at"
+	var after any
+	if q.After != nil {
+		after = q.After.CreatedAt
+	}
+	if q.Sort == model.SortClicks {
+		key = "clicks"
+		if q.After != nil {
+			after = q.After.Clicks
+		}
+	}
+	dir, op := "ASC", ">"
+	if q.Desc {
+		dir, op = "DESC", "<"
+	}
+
+	if q.URL != "" {
+		// strpos вместо LIKE, чтобы % и _ в подстроке не были шаблоном
+		add("strpos(lower(long_url), lower(?)) > 0", q.URL)
+	}
+	if q.Tag != "" {
+		add("short_url IN (SELECT tag_filter.short_url FROM link_tags tag_filter WHERE tag_filter.tag = ?)", q.Tag)
+	}
+	if q.Deleted != nil {
+		add("COALESCE(is_deleted, false) = ?", *q.Deleted)
+	}
+	if !q.CreatedFrom.IsZero() {
+		add("created_at >= ?", q.CreatedFrom)
+	}
+	if !q.CreatedTo.IsZero() {
+		add("created_at <= ?", q.CreatedTo)
+	}
+	if q.After != nil {
+		args = append(args, after, q.After.ShortURL)
+		where = append(where, fmt.Sprintf("(%s, short_url) %s ($%d, $%d)", key, op, len(args)-1, len(args)))
+	}
+
+	query := `SELECT short_url, long_url, created_at, clicks, COALESCE(is_deleted, false), password_hash <> '', title, note, ` + tagsColumn + ` FROM shortened_urls` +
+		" WHERE " + strings.Join(where, " AND ") +
+		fmt.Sprintf(" ORDER BY %s %s, short_url %s", key, dir, dir)
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	return query, args
+}
+
+// CountUserURLs возвращает число активных ссылок пользователя
+func (r *URLRepository) CountUserURLs(ctx context.Context, userID string) (_ int, err error) {
+	var n int
+	query := `SELECT COUNT(*) FROM shortened_urls WHERE user_id = $1 AND is_deleted = false`
+	ctx, span := startQuery(ctx, "SELECT", "shortened_urls", query)
+	defer telemetry.End(span, &err)
+
+	if err = r.DB.QueryRowContext(ctx, query, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("ошибка подсчёта URL пользователя: %w", err)
+	}
+	return n, nil
+}
+
 func NewURLRepository(db *sql.DB) *URLRepository {
+	return NewURLRepositoryWithAudit(db, nil)
+}
+
+// NewURLRepositoryWithAudit создаёт репозиторий с outbox аудита: события
+// сохранения и удаления ссылок пишутся в audit_outbox в одной транзакции
+// с изменением данных, а relay публикует их в auditPub
+func NewURLRepositoryWithAudit(db *sql.DB, auditPub *audit.Publisher) *URLRepository {
 	repo := &URLRepository{
-		DB: db,
+		DB:       db,
+		auditPub: auditPub,
 	}
 	repo.initDeleteSystem()
+	repo.startClickFlusher()
+	if auditPub != nil {
+		repo.startRelay()
+	}
 	return repo
 }
 
@@ -138,7 +482,7 @@ func (r *URLRepository) initDeleteSystem() {
 		r.WG.Add(1)
 		go func(id int) {
 			defer r.WG.Done()
-			log.Printf("Worker %d поднялся и готов к работе!", id)
+			zap.S().Debugf("Worker %d поднялся и готов к работе!", id)
 			r.deleteWorker()
 		}(i)
 	}
@@ -181,48 +525,169 @@ func (r *URLRepository) processBatch(tasks []model.DeleteTask) {
 		return
 	}
 	// Группируем по userID
-	groups := make(map[string][]string)
+	groups := make(map[string][]model.DeleteTask)
 	for _, task := range tasks {
-		groups[task.UserID] = append(groups[task.UserID], task.ShortURL)
+		groups[task.UserID] = append(groups[task.UserID], task)
 	}
 	// Для каждой группы
-	for userID, shortURLs := range groups {
-		err := r.batchDeleteURLs(userID, shortURLs)
+	for userID, group := range groups {
+		shortURLs := make([]string, 0, len(group))
+		requestIDs := make(map[string]string, len(group)) // короткая ссылка -> запрос
+		for _, task := range group {
+			shortURLs = append(shortURLs, task.ShortURL)
+			requestIDs[task.ShortURL] = task.RequestID
+		}
+
+		deleted, err := r.deleteGroup(userID, group, shortURLs, requestIDs)
 		if err != nil {
-			log.Printf("Ошибка в батче для user %s: %v", userID, err)
+			zap.S().Errorw("Ошибка в батче удаления",
+				"user_id", userID,
+				"request_id", joinRequestIDs(group),
+				"error", err,
+			)
+			continue
+		}
+		zap.S().Infow("Батч удаления обработан",
+			"user_id", userID,
+			"request_id", joinRequestIDs(group),
+			"requested", len(shortURLs),
+			"deleted", deleted,
+		)
+	}
+}
+
+// deleteGroup удаляет ссылки одного пользователя в отдельном span'е.
+// Батч собирает задачи разных запросов, поэтому span начинает свою трассу
+// и ссылается на span'ы этих запросов.
+func (r *URLRepository) deleteGroup(userID string, group []model.DeleteTask, shortURLs []string, requestIDs map[string]string) (deleted int, err error) {
+	ctx, span := telemetry.Start(context.Background(), "URLRepository.deleteBatch",
+		trace.WithNewRoot(),
+		trace.WithLinks(spanLinks(group)...),
+		trace.WithAttributes(
+			attribute.String("user_id", userID),
+			attribute.String("request_id", joinRequestIDs(group)),
+			attribute.Int("requested", len(shortURLs)),
+		),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("deleted", deleted))
+		telemetry.End(span, &err)
+	}()
+
+	if r.auditPub != nil {
+		return r.deleteWithOutbox(ctx, userID, shortURLs, requestIDs)
+	}
+	pairs, err := r.batchDeleteURLs(ctx, userID, shortURLs)
+	return len(pairs), err
+}
+
+// spanLinks возвращает ссылки на различные span'ы запросов задач
+func spanLinks(tasks []model.DeleteTask) []trace.Link {
+	links := make([]trace.Link, 0, 1)
+	seen := make(map[trace.SpanID]bool, 1)
+	for _, task := range tasks {
+		sc := task.SpanContext
+		if !sc.IsValid() || seen[sc.SpanID()] {
+			continue
+		}
+		seen[sc.SpanID()] = true
+		links = append(links, trace.Link{SpanContext: sc})
+	}
+	return links
+}
+
+// joinRequestIDs перечисляет через запятую различные идентификаторы запросов задач
+func joinRequestIDs(tasks []model.DeleteTask) string {
+	ids := make([]string, 0, 1)
+	seen := make(map[string]bool, 1)
+	for _, task := range tasks {
+		if task.RequestID == "" || seen[task.RequestID] {
+			continue
 		}
+		seen[task.RequestID] = true
+		ids = append(ids, task.RequestID)
+	}
+	if len(ids) == 0 {
+		return "-"
 	}
+	return strings.Join(ids, ",")
 }
 
-func (r *URLRepository) batchDeleteURLs(userID string, shortURLs []string) error {
+// batchDeleteURLs помечает ссылки удалёнными и возвращает те, что действительно изменились
+func (r *URLRepository) batchDeleteURLs(ctx context.Context, userID string, shortURLs []string) ([]model.URLPair, error) {
+	return r.batchDeleteURLsWith(ctx, r.DB, userID, shortURLs)
+}
+
+// batchDeleteURLsWith выполняет batchDeleteURLs через db или транзакцию
+func (r *URLRepository) batchDeleteURLsWith(ctx context.Context, ex execer, userID string, shortURLs []string) (_ []model.URLPair, err error) {
 	if len(shortURLs) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	query := `
         UPDATE shortened_urls 
         SET is_deleted = true 
         WHERE user_id = $1 AND short_url = ANY($2) AND is_deleted = false
+        RETURNING short_url, long_url
     `
+	ctx, span := startQuery(ctx, "UPDATE", "shortened_urls", query)
+	defer telemetry.End(span, &err)
+
+	rows, err := ex.QueryContext(ctx, query, userID, pq.Array(shortURLs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	_, err := r.DB.Exec(query, userID, pq.Array(shortURLs))
-	return err
+	var deleted []model.URLPair
+	for rows.Next() {
+		var pair model.URLPair
+		if err := rows.Scan(&pair.ShortURL, &pair.OriginalURL); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, pair)
+	}
+	return deleted, rows.Err()
 }
 
-// Асинхронное удаление - отправка в канал
-func (r *URLRepository) DeleteURLs(userID string, urlIDs []string) {
-	for _, shortURL := range urlIDs {
+// Асинхронное удаление - отправка в канал.
+// Идентификатор запроса и span из ctx сохраняются в задаче для логов
+// и трассировки воркеров. Если канал заполнен, оставшиеся задачи не
+// ставятся, а возвращается model.ErrDeleteQueueFull.
+func (r *URLRepository) DeleteURLs(ctx context.Context, userID string, urlIDs []string) ([]string, error) {
+	requestID := requestid.FromContext(ctx)
+	sc := trace.SpanContextFromContext(ctx)
+	for i, shortURL := range urlIDs {
 		select {
-		case r.DeleteChannel <- model.DeleteTask{UserID: userID, ShortURL: shortURL}:
+		case r.DeleteChannel <- model.DeleteTask{UserID: userID, ShortURL: shortURL, RequestID: requestID, SpanContext: sc}:
 		default:
-			log.Printf("Delete channel full, task dropped: %s", shortURL)
+			zap.S().Warnw("Delete channel full, tasks rejected", "rejected", len(urlIDs)-i, "request_id", requestID)
+			return urlIDs[:i], model.ErrDeleteQueueFull
 		}
 	}
+	return urlIDs, nil
 }
 
+// Shutdown останавливает воркеров удаления, дождавшись обработки очереди.
+// Повторный вызов ничего не делает.
 func (r *URLRepository) Shutdown() {
-	close(r.DeleteChannel)
-	r.WG.Wait()
+	r.shutdownOnce.Do(func() {
+		close(r.DeleteChannel)
+		r.WG.Wait()
+		r.stopClickFlusher()
+		// Relay останавливается последним, чтобы отправить события удалений
+		r.stopRelay()
+	})
+}
+
+// Ping проверяет соединение с БД через пул
+func (r *URLRepository) Ping(ctx context.Context) error {
+	return r.DB.PingContext(ctx)
+}
+
+// DeleteQueueLoad возвращает длину и ёмкость очереди удаления
+func (r *URLRepository) DeleteQueueLoad() (queued, capacity int) {
+	return len(r.DeleteChannel), cap(r.DeleteChannel)
 }
 
 func (r *URLRepository) Close() error {
@@ -231,20 +696,27 @@ func (r *URLRepository) Close() error {
 }
 
 // GetStats возвращает статистику сервиса
-func (r *URLRepository) GetStats() (urls int, users int, err error) {
+func (r *URLRepository) GetStats(ctx context.Context) (urls int, users int, err error) {
 	// Подсчет количества активных URL
 	urlQuery := `SELECT COUNT(*) FROM shortened_urls WHERE is_deleted = false`
-	err = r.DB.QueryRow(urlQuery).Scan(&urls)
-	if err != nil {
+	if urls, err = r.count(ctx, urlQuery); err != nil {
 		return 0, 0, fmt.Errorf("ошибка при подсчете URL: %w", err)
 	}
 
 	// Подсчет количества уникальных пользователей
 	userQuery := `SELECT COUNT(DISTINCT user_id) FROM shortened_urls`
-	err = r.DB.QueryRow(userQuery).Scan(&users)
-	if err != nil {
+	if users, err = r.count(ctx, userQuery); err != nil {
 		return 0, 0, fmt.Errorf("ошибка при подсчете пользователей: %w", err)
 	}
 
 	return urls, users, nil
 }
+
+// count выполняет запрос с одним числом в результате
+func (r *URLRepository) count(ctx context.Context, query string) (n int, err error) {
+	ctx, span := startQuery(ctx, "SELECT", "shortened_urls", query)
+	defer telemetry.End(span, &err)
+
+	err = r.DB.QueryRowContext(ctx, query).Scan(&n)
+	return n, err
+}
@@ -3,9 +3,13 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Popolzen/shortener/internal/audit"
 	"github.com/Popolzen/shortener/internal/model"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +17,7 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // === Setup ===
@@ -75,14 +80,65 @@ func createSchema(t *testing.T, db *sql.DB) {
 			short_url VARCHAR(20) UNIQUE NOT NULL,
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 			is_deleted BOOL DEFAULT FALSE,
+			redirect_code SMALLINT NOT NULL DEFAULT 0,
+			query_passthrough BOOL NOT NULL DEFAULT FALSE,
+			version INT NOT NULL DEFAULT 1,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			title TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT '',
+			password_hash TEXT NOT NULL DEFAULT '',
 			
-			CONSTRAINT chk_short_url_length CHECK (length(short_url) >= 4)
+			CONSTRAINT chk_short_url_length CHECK (length(short_url) >= 4),
+			CONSTRAINT chk_redirect_code CHECK (redirect_code IN (0, 301, 302, 307, 308))
 		);
 		
 		CREATE UNIQUE INDEX IF NOT EXISTS idx_shortened_urls_short_url 
 			ON shortened_urls(short_url);
 		CREATE INDEX IF NOT EXISTS idx_shortened_urls_user_id 
 			ON shortened_urls(user_id);
+
+		CREATE TABLE IF NOT EXISTS link_history (
+			id BIGSERIAL PRIMARY KEY,
+			short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+			version INT NOT NULL,
+			long_url TEXT NOT NULL,
+			redirect_code SMALLINT NOT NULL DEFAULT 0,
+			query_passthrough BOOL NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			CONSTRAINT uq_link_history_version UNIQUE (short_url, version)
+		);
+
+		CREATE TABLE IF NOT EXISTS link_tags (
+			short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+			tag VARCHAR(64) NOT NULL,
+
+			PRIMARY KEY (short_url, tag)
+		);
+
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id BIGSERIAL PRIMARY KEY,
+			event_id TEXT NOT NULL DEFAULT '',
+			ts TIMESTAMP WITH TIME ZONE NOT NULL,
+			action VARCHAR(32) NOT NULL,
+			user_id TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL DEFAULT '',
+			short_code VARCHAR(20) NOT NULL DEFAULT '',
+			client_ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS uq_audit_events_event_id ON audit_events(event_id) WHERE event_id <> '';
+
+		CREATE TABLE IF NOT EXISTS audit_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			payload JSONB NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			sent_at TIMESTAMP WITH TIME ZONE,
+			claimed_until TIMESTAMP WITH TIME ZONE,
+			delivered_to TEXT[] NOT NULL DEFAULT '{}'
+		);
 	`)
 	require.NoError(t, err)
 }
@@ -90,7 +146,7 @@ func createSchema(t *testing.T, db *sql.DB) {
 // cleanupTable очищает таблицу между тестами
 func cleanupTable(t *testing.T, db *sql.DB) {
 	t.Helper()
-	_, err := db.Exec("TRUNCATE shortened_urls RESTART IDENTITY")
+	_, err := db.Exec("TRUNCATE shortened_urls, link_history, link_tags RESTART IDENTITY")
 	require.NoError(t, err)
 }
 
@@ -111,7 +167,7 @@ func TestStore_Success(t *testing.T) {
 	db := setupTestDB(t)
 	repo := createTestRepo(t, db)
 
-	err := repo.Store("abcd12", "https://example.com", "550e8400-e29b-41d4-a716-446655440000")
+	err := repo.Store(context.Background(), "abcd12", "https://example.com", "550e8400-e29b-41d4-a716-446655440000", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	require.NoError(t, err)
 
@@ -127,9 +183,9 @@ func TestStore_MultipleURLs(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	repo.Store("aaaa11", "https://one.com", userID)
-	repo.Store("bbbb22", "https://two.com", userID)
-	repo.Store("cccc33", "https://three.com", userID)
+	repo.Store(context.Background(), "aaaa11", "https://one.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "bbbb22", "https://two.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "cccc33", "https://three.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	var count int
 	db.QueryRow("SELECT COUNT(*) FROM shortened_urls").Scan(&count)
@@ -141,10 +197,10 @@ func TestStore_DuplicateShortURL_Error(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	err1 := repo.Store("dupl12", "https://first.com", userID)
+	err1 := repo.Store(context.Background(), "dupl12", "https://first.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	require.NoError(t, err1)
 
-	err2 := repo.Store("dupl12", "https://second.com", userID)
+	err2 := repo.Store(context.Background(), "dupl12", "https://second.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	assert.Error(t, err2)
 }
 
@@ -157,12 +213,12 @@ func TestStore_DuplicateLongURL_ReturnsConflict(t *testing.T) {
 	// или добавляем его в схему. Смотри свою миграцию.
 	// В твоей миграции long_url UNIQUE, поэтому:
 
-	err1 := repo.Store("first1", "https://duplicate.com", userID)
+	err1 := repo.Store(context.Background(), "first1", "https://duplicate.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	require.NoError(t, err1)
 
-	err2 := repo.Store("second", "https://duplicate.com", userID)
+	err2 := repo.Store(context.Background(), "second", "https://duplicate.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	var conflictErr ErrURLConflictError
+	var conflictErr model.ErrURLConflictError
 	assert.ErrorAs(t, err2, &conflictErr)
 	assert.Equal(t, "first1", conflictErr.ExistingShortURL)
 }
@@ -173,7 +229,7 @@ func TestStore_ShortURLTooShort_Error(t *testing.T) {
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
 	// Constraint: length(short_url) >= 4
-	err := repo.Store("abc", "https://example.com", userID)
+	err := repo.Store(context.Background(), "abc", "https://example.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	assert.Error(t, err)
 }
@@ -185,19 +241,19 @@ func TestGet_Success(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	repo.Store("test12", "https://example.com", userID)
+	repo.Store(context.Background(), "test12", "https://example.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	longURL, err := repo.Get("test12")
+	longURL, err := repo.Get(context.Background(), "test12")
 
 	require.NoError(t, err)
-	assert.Equal(t, "https://example.com", longURL)
+	assert.Equal(t, "https://example.com", longURL.OriginalURL)
 }
 
 func TestGet_NotFound(t *testing.T) {
 	db := setupTestDB(t)
 	repo := createTestRepo(t, db)
 
-	_, err := repo.Get("notfound")
+	_, err := repo.Get(context.Background(), "notfound")
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not found")
@@ -208,17 +264,140 @@ func TestGet_DeletedURL_ReturnsError(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	repo.Store("delt12", "https://example.com", userID)
+	repo.Store(context.Background(), "delt12", "https://example.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	// Помечаем как удалённый
 	_, err := db.Exec("UPDATE shortened_urls SET is_deleted = true WHERE short_url = $1", "delt12")
 	require.NoError(t, err)
 
-	_, err = repo.Get("delt12")
+	_, err = repo.Get(context.Background(), "delt12")
 
 	assert.ErrorIs(t, err, model.ErrURLDeleted)
 }
 
+func TestGet_ReturnsLinkOptions(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+	opts := model.LinkOptions{RedirectCode: 308, QueryPassthrough: true}
+
+	require.NoError(t, repo.Store(context.Background(), "opts12", "https://example.com", userID, opts, model.LinkMeta{}, 0))
+
+	link, err := repo.Get(context.Background(), "opts12")
+	require.NoError(t, err)
+	assert.Equal(t, model.Link{ShortURL: "opts12", OriginalURL: "https://example.com", UserID: userID, Version: 1, LinkOptions: opts}, link)
+}
+
+// === UpdateLink ===
+
+func TestUpdateLink(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	owner := "550e8400-e29b-41d4-a716-446655440000"
+	stranger := "660e8400-e29b-41d4-a716-446655440000"
+	ctx := context.Background()
+
+	require.NoError(t, repo.Store(ctx, "upd123", "https://example.com", owner, model.LinkOptions{RedirectCode: 302}, model.LinkMeta{}, 0))
+	require.NoError(t, repo.Store(ctx, "del123", "https://deleted.com", owner, model.LinkOptions{}, model.LinkMeta{}, 0))
+	_, err := db.Exec("UPDATE shortened_urls SET is_deleted = true WHERE short_url = $1", "del123")
+	require.NoError(t, err)
+
+	passthrough := true
+	link, err := repo.UpdateLink(ctx, owner, "upd123", model.LinkUpdate{QueryPassthrough: &passthrough})
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkOptions{RedirectCode: 302, QueryPassthrough: true}, link.LinkOptions)
+	assert.Equal(t, "https://example.com", link.OriginalURL)
+
+	code := 301
+	_, err = repo.UpdateLink(ctx, stranger, "upd123", model.LinkUpdate{RedirectCode: &code})
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+
+	_, err = repo.UpdateLink(ctx, owner, "del123", model.LinkUpdate{RedirectCode: &code})
+	assert.ErrorIs(t, err, model.ErrURLDeleted)
+
+	_, err = repo.UpdateLink(ctx, owner, "none12", model.LinkUpdate{RedirectCode: &code})
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+
+	stored, err := repo.Get(ctx, "upd123")
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkOptions{RedirectCode: 302, QueryPassthrough: true}, stored.LinkOptions)
+}
+
+func TestUpdateLink_OriginalURLAndHistory(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	owner := "550e8400-e29b-41d4-a716-446655440000"
+	stranger := "660e8400-e29b-41d4-a716-446655440000"
+	ctx := context.Background()
+
+	require.NoError(t, repo.Store(ctx, "hist12", "https://old.com", owner, model.LinkOptions{}, model.LinkMeta{}, 0))
+	require.NoError(t, repo.Store(ctx, "other1", "https://taken.com", owner, model.LinkOptions{}, model.LinkMeta{}, 0))
+
+	newURL := "https://new.com"
+	code := 308
+	link, err := repo.UpdateLink(ctx, owner, "hist12", model.LinkUpdate{OriginalURL: &newURL, RedirectCode: &code})
+	require.NoError(t, err)
+	assert.Equal(t, "https://new.com", link.OriginalURL)
+	assert.Equal(t, 2, link.Version)
+
+	// Занятый URL — конфликт, версия не добавляется
+	taken := "https://taken.com"
+	_, err = repo.UpdateLink(ctx, owner, "hist12", model.LinkUpdate{OriginalURL: &taken})
+	var conflictErr model.ErrURLConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	assert.Equal(t, "other1", conflictErr.ExistingShortURL)
+
+	versions, err := repo.GetLinkHistory(ctx, owner, "hist12")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, "https://old.com", versions[0].OriginalURL)
+	assert.Equal(t, 2, versions[1].Version)
+	assert.Equal(t, "https://new.com", versions[1].OriginalURL)
+	assert.Equal(t, 308, versions[1].RedirectCode)
+	assert.False(t, versions[1].CreatedAt.IsZero())
+
+	_, err = repo.GetLinkHistory(ctx, stranger, "hist12")
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+
+	stored, err := repo.Get(ctx, "hist12")
+	require.NoError(t, err)
+	assert.Equal(t, "https://new.com", stored.OriginalURL)
+	assert.Equal(t, 2, stored.Version)
+}
+
+func TestUpdateLinkWithEvent_Outbox(t *testing.T) {
+	db := setupTestDB(t)
+	pub := audit.NewPublisher()
+	obs := &recordingObserver{}
+	pub.Subscribe(obs)
+
+	repo := createTestRepo(t, db)
+	repo.auditPub = pub
+	owner := "550e8400-e29b-41d4-a716-446655440000"
+	ctx := context.Background()
+	require.NoError(t, repo.Store(ctx, "evt123", "https://old.com", owner, model.LinkOptions{}, model.LinkMeta{}, 0))
+
+	newURL := "https://new.com"
+	event := audit.NewEvent(audit.ActionUpdate, owner, "")
+	event.ShortCode = "evt123"
+	_, err := repo.UpdateLinkWithEvent(ctx, owner, "evt123", model.LinkUpdate{OriginalURL: &newURL}, event)
+	require.NoError(t, err)
+
+	// Неудачное изменение не оставляет события
+	_, err = repo.UpdateLinkWithEvent(ctx, owner, "none12", model.LinkUpdate{OriginalURL: &newURL}, event)
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+
+	n, err := repo.relayOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, pub.Close())
+
+	require.Len(t, obs.events, 1)
+	assert.Equal(t, audit.ActionUpdate, obs.events[0].Action)
+	assert.Equal(t, "https://new.com", obs.events[0].URL)
+}
+
 // === GetUserURLs ===
 
 func TestGetUserURLs_Success(t *testing.T) {
@@ -226,10 +405,10 @@ func TestGetUserURLs_Success(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	repo.Store("usr111", "https://one.com", userID)
-	repo.Store("usr222", "https://two.com", userID)
+	repo.Store(context.Background(), "usr111", "https://one.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "usr222", "https://two.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	urls, err := repo.GetUserURLs(userID)
+	urls, err := repo.GetUserURLs(context.Background(), userID, model.URLListQuery{Limit: 10, Desc: true})
 
 	require.NoError(t, err)
 	assert.Len(t, urls, 2)
@@ -239,7 +418,7 @@ func TestGetUserURLs_Empty(t *testing.T) {
 	db := setupTestDB(t)
 	repo := createTestRepo(t, db)
 
-	urls, err := repo.GetUserURLs("550e8400-e29b-41d4-a716-446655440000")
+	urls, err := repo.GetUserURLs(context.Background(), "550e8400-e29b-41d4-a716-446655440000", model.URLListQuery{Limit: 10, Desc: true})
 
 	require.NoError(t, err)
 	assert.Empty(t, urls)
@@ -251,11 +430,11 @@ func TestGetUserURLs_OnlyOwnURLs(t *testing.T) {
 	user1 := "550e8400-e29b-41d4-a716-446655440001"
 	user2 := "550e8400-e29b-41d4-a716-446655440002"
 
-	repo.Store("u1url1", "https://user1-one.com", user1)
-	repo.Store("u1url2", "https://user1-two.com", user1)
-	repo.Store("u2url1", "https://user2-one.com", user2)
+	repo.Store(context.Background(), "u1url1", "https://user1-one.com", user1, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "u1url2", "https://user1-two.com", user1, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "u2url1", "https://user2-one.com", user2, model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	urls, err := repo.GetUserURLs(user1)
+	urls, err := repo.GetUserURLs(context.Background(), user1, model.URLListQuery{Limit: 10, Desc: true})
 
 	require.NoError(t, err)
 	assert.Len(t, urls, 2)
@@ -270,11 +449,11 @@ func TestGetUserURLs_OrderByCreatedAt(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	repo.Store("old111", "https://old.com", userID)
+	repo.Store(context.Background(), "old111", "https://old.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	time.Sleep(10 * time.Millisecond) // небольшая задержка
-	repo.Store("new111", "https://new.com", userID)
+	repo.Store(context.Background(), "new111", "https://new.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	urls, err := repo.GetUserURLs(userID)
+	urls, err := repo.GetUserURLs(context.Background(), userID, model.URLListQuery{Limit: 10, Desc: true})
 
 	require.NoError(t, err)
 	require.Len(t, urls, 2)
@@ -283,6 +462,134 @@ func TestGetUserURLs_OrderByCreatedAt(t *testing.T) {
 	assert.Equal(t, "old111", urls[1].ShortURL)
 }
 
+func TestGetUserURLs_FiltersAndKeyset(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	ctx := context.Background()
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+
+	repo.Store(ctx, "aaaa11", "https://one.com/Docs", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(ctx, "bbbb22", "https://two.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(ctx, "cccc33", "https://three.com/docs", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	_, err := db.Exec(`UPDATE shortened_urls SET is_deleted = TRUE WHERE short_url = 'cccc33'`)
+	require.NoError(t, err)
+	repo.RecordClick(ctx, "bbbb22")
+	repo.RecordClick(ctx, "bbbb22")
+	repo.RecordClick(ctx, "aaaa11")
+
+	notDeleted := false
+	urls, err := repo.GetUserURLs(ctx, userID, model.URLListQuery{Limit: 10, URL: "DOCS", Deleted: &notDeleted})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "aaaa11", urls[0].ShortURL)
+
+	byClicks := model.URLListQuery{Limit: 1, Sort: model.SortClicks, Desc: true}
+	urls, err = repo.GetUserURLs(ctx, userID, byClicks)
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "bbbb22", urls[0].ShortURL)
+	assert.Equal(t, int64(2), urls[0].Clicks)
+
+	byClicks.Limit = 10
+	byClicks.After = &model.URLCursor{Clicks: urls[0].Clicks, ShortURL: urls[0].ShortURL}
+	urls, err = repo.GetUserURLs(ctx, userID, byClicks)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, "aaaa11", urls[0].ShortURL)
+	assert.Equal(t, "cccc33", urls[1].ShortURL)
+	assert.True(t, urls[1].IsDeleted)
+}
+
+func TestRecordClick_Buffered(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	ctx := context.Background()
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+	repo.Store(ctx, "clck11", "https://clicks.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+
+	repo.startClickFlusher()
+	repo.RecordClick(ctx, "clck11")
+	repo.RecordClick(ctx, "clck11")
+	repo.stopClickFlusher()
+
+	var clicks int64
+	require.NoError(t, db.QueryRow(`SELECT clicks FROM shortened_urls WHERE short_url = 'clck11'`).Scan(&clicks))
+	assert.Equal(t, int64(2), clicks)
+}
+
+func TestLinkMeta_StoreUpdateAndTags(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	ctx := context.Background()
+	user1 := "550e8400-e29b-41d4-a716-446655440001"
+	user2 := "550e8400-e29b-41d4-a716-446655440002"
+
+	require.NoError(t, repo.Store(ctx, "meta11", "https://one.com", user1, model.LinkOptions{}, model.LinkMeta{Title: "Один", Tags: []string{"docs", "work"}}, 0))
+	require.NoError(t, repo.Store(ctx, "meta22", "https://two.com", user1, model.LinkOptions{}, model.LinkMeta{Tags: []string{"work"}}, 0))
+	require.NoError(t, repo.Store(ctx, "meta33", "https://three.com", user2, model.LinkOptions{}, model.LinkMeta{Tags: []string{"docs"}}, 0))
+
+	link, err := repo.Get(ctx, "meta11")
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkMeta{Title: "Один", Tags: []string{"docs", "work"}}, link.LinkMeta)
+
+	urls, err := repo.GetUserURLs(ctx, user1, model.URLListQuery{Limit: 10, Tag: "docs"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "meta11", urls[0].ShortURL)
+	assert.Equal(t, []string{"docs", "work"}, urls[0].Tags)
+
+	tags, err := repo.GetUserTags(ctx, user1)
+	require.NoError(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "work", Count: 2}, {Tag: "docs", Count: 1}}, tags)
+
+	// Изменение описания не создаёт версию
+	note := "заметка"
+	link, err = repo.UpdateLink(ctx, user1, "meta11", model.LinkUpdate{Note: &note, Tags: &[]string{}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, link.Version)
+	assert.Equal(t, model.LinkMeta{Title: "Один", Note: "заметка"}, link.LinkMeta)
+
+	versions, err := repo.GetLinkHistory(ctx, user1, "meta11")
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	// Метки удалённых ссылок не считаются
+	_, err = db.Exec(`UPDATE shortened_urls SET is_deleted = TRUE WHERE short_url = 'meta22'`)
+	require.NoError(t, err)
+	tags, err = repo.GetUserTags(ctx, user1)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+}
+
+func TestPasswordHash_StoreAndUpdate(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	ctx := context.Background()
+	userID := "550e8400-e29b-41d4-a716-446655440001"
+
+	require.NoError(t, repo.Store(ctx, "secret1", "https://docs.internal/report", userID, model.LinkOptions{PasswordHash: "hash-1"}, model.LinkMeta{}, 0))
+
+	link, err := repo.Get(ctx, "secret1")
+	require.NoError(t, err)
+	assert.Equal(t, "hash-1", link.PasswordHash)
+
+	urls, err := repo.GetUserURLs(ctx, userID, model.URLListQuery{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.True(t, urls[0].Protected)
+
+	// Смена пароля не создаёт версию, пустой хеш снимает пароль
+	empty := ""
+	link, err = repo.UpdateLink(ctx, userID, "secret1", model.LinkUpdate{PasswordHash: &empty})
+	require.NoError(t, err)
+	assert.Equal(t, 1, link.Version)
+	assert.False(t, link.Protected())
+
+	versions, err := repo.GetLinkHistory(ctx, userID, "secret1")
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+}
+
 // === DeleteURLs ===
 
 func TestDeleteURLs_SendsToChannel(t *testing.T) {
@@ -290,8 +597,10 @@ func TestDeleteURLs_SendsToChannel(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	repo.DeleteURLs(userID, []string{"abc123", "def456"})
+	accepted, err := repo.DeleteURLs(context.Background(), userID, []string{"abc123", "def456"})
 
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc123", "def456"}, accepted)
 	assert.Len(t, repo.DeleteChannel, 2)
 
 	task1 := <-repo.DeleteChannel
@@ -302,11 +611,23 @@ func TestDeleteURLs_SendsToChannel(t *testing.T) {
 	assert.Equal(t, "def456", task2.ShortURL)
 }
 
+func TestDeleteURLs_QueueFull(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	repo.DeleteChannel = make(chan model.DeleteTask, 1)
+
+	accepted, err := repo.DeleteURLs(context.Background(), "user", []string{"abc123", "def456"})
+
+	assert.ErrorIs(t, err, model.ErrDeleteQueueFull)
+	assert.Equal(t, []string{"abc123"}, accepted)
+	assert.Len(t, repo.DeleteChannel, 1)
+}
+
 func TestDeleteURLs_EmptySlice(t *testing.T) {
 	db := setupTestDB(t)
 	repo := createTestRepo(t, db)
 
-	repo.DeleteURLs("user", []string{})
+	repo.DeleteURLs(context.Background(), "user", []string{})
 
 	assert.Empty(t, repo.DeleteChannel)
 }
@@ -318,23 +639,27 @@ func TestBatchDeleteURLs_Success(t *testing.T) {
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
-	repo.Store("del111", "https://one.com", userID)
-	repo.Store("del222", "https://two.com", userID)
-	repo.Store("keep11", "https://keep.com", userID)
+	repo.Store(context.Background(), "del111", "https://one.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "del222", "https://two.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "keep11", "https://keep.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	err := repo.batchDeleteURLs(userID, []string{"del111", "del222"})
+	deleted, err := repo.batchDeleteURLs(context.Background(), userID, []string{"del111", "del222"})
 
 	require.NoError(t, err)
+	assert.ElementsMatch(t, []model.URLPair{
+		{ShortURL: "del111", OriginalURL: "https://one.com"},
+		{ShortURL: "del222", OriginalURL: "https://two.com"},
+	}, deleted)
 
 	// Проверяем что удалённые помечены
-	_, err1 := repo.Get("del111")
-	_, err2 := repo.Get("del222")
-	url3, err3 := repo.Get("keep11")
+	_, err1 := repo.Get(context.Background(), "del111")
+	_, err2 := repo.Get(context.Background(), "del222")
+	url3, err3 := repo.Get(context.Background(), "keep11")
 
 	assert.ErrorIs(t, err1, model.ErrURLDeleted)
 	assert.ErrorIs(t, err2, model.ErrURLDeleted)
 	require.NoError(t, err3)
-	assert.Equal(t, "https://keep.com", url3)
+	assert.Equal(t, "https://keep.com", url3.OriginalURL)
 }
 
 func TestBatchDeleteURLs_OnlyOwnURLs(t *testing.T) {
@@ -343,43 +668,330 @@ func TestBatchDeleteURLs_OnlyOwnURLs(t *testing.T) {
 	user1 := "550e8400-e29b-41d4-a716-446655440001"
 	user2 := "550e8400-e29b-41d4-a716-446655440002"
 
-	repo.Store("u1only", "https://user1.com", user1)
-	repo.Store("u2only", "https://user2.com", user2)
+	repo.Store(context.Background(), "u1only", "https://user1.com", user1, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "u2only", "https://user2.com", user2, model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	// user2 пытается удалить URL user1
-	err := repo.batchDeleteURLs(user2, []string{"u1only"})
+	deleted, err := repo.batchDeleteURLs(context.Background(), user2, []string{"u1only"})
 
 	require.NoError(t, err) // Ошибки нет, просто ничего не удалилось
+	assert.Empty(t, deleted)
 
 	// URL user1 не удалён
-	url, err := repo.Get("u1only")
+	url, err := repo.Get(context.Background(), "u1only")
 	require.NoError(t, err)
-	assert.Equal(t, "https://user1.com", url)
+	assert.Equal(t, "https://user1.com", url.OriginalURL)
 }
 
 func TestBatchDeleteURLs_EmptySlice(t *testing.T) {
 	db := setupTestDB(t)
 	repo := createTestRepo(t, db)
 
-	err := repo.batchDeleteURLs("user", []string{})
+	deleted, err := repo.batchDeleteURLs(context.Background(), "user", []string{})
+
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+}
+
+func TestProcessBatch_PublishesDeleteApplied(t *testing.T) {
+	db := setupTestDB(t)
+	pub := audit.NewPublisher()
+	obs := &recordingObserver{}
+	pub.Subscribe(obs)
+
+	repo := createTestRepo(t, db)
+	repo.auditPub = pub
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+
+	repo.Store(context.Background(), "aud111", "https://audit-one.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "aud222", "https://audit-two.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+
+	// Повторное удаление уже удалённой ссылки не должно давать событие
+	repo.processBatch([]model.DeleteTask{
+		{UserID: userID, ShortURL: "aud111", RequestID: "req-del"},
+		{UserID: userID, ShortURL: "missing", RequestID: "req-del"},
+	})
+	repo.processBatch([]model.DeleteTask{{UserID: userID, ShortURL: "aud111"}})
+
+	// До работы relay событие лежит только в outbox
+	pending, err := repo.OutboxPending()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pending)
+
+	n, err := repo.relayOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, pub.Close())
+
+	require.Len(t, obs.events, 1)
+	assert.Equal(t, audit.ActionDeleteApplied, obs.events[0].Action)
+	assert.Equal(t, "aud111", obs.events[0].ShortCode)
+	assert.Equal(t, "https://audit-one.com", obs.events[0].URL)
+	assert.Equal(t, userID, obs.events[0].UserID)
+	assert.Equal(t, "req-del", obs.events[0].RequestID)
+}
+
+func TestCountUserURLs(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+
+	repo.Store(context.Background(), "cnt111", "https://count-one.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "cnt222", "https://count-two.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "cnt333", "https://count-three.com", "660e8400-e29b-41d4-a716-446655440000", model.LinkOptions{}, model.LinkMeta{}, 0)
+	_, err := repo.batchDeleteURLs(context.Background(), userID, []string{"cnt222"})
+	require.NoError(t, err)
+
+	// Удалённые ссылки не занимают квоту
+	n, err := repo.CountUserURLs(context.Background(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestStore_LinkQuotaConcurrent(t *testing.T) {
+	db := setupTestDB(t)
+	repo := createTestRepo(t, db)
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+	ctx := context.Background()
+
+	// Параллельные сохранения одного пользователя не превышают квоту
+	errs := make([]error, 32)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = repo.Store(ctx, fmt.Sprintf("q%05d", i), fmt.Sprintf("https://quota-%d.com", i), userID, model.LinkOptions{}, model.LinkMeta{}, 5)
+		}()
+	}
+	wg.Wait()
+
+	stored := 0
+	for _, err := range errs {
+		var quotaErr *model.QuotaError
+		if errors.As(err, &quotaErr) {
+			assert.Equal(t, int64(5), quotaErr.Limit)
+			continue
+		}
+		require.NoError(t, err)
+		stored++
+	}
+	assert.Equal(t, 5, stored)
+
+	n, err := repo.CountUserURLs(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+}
+
+func TestStoreWithEvent_Outbox(t *testing.T) {
+	db := setupTestDB(t)
+	pub := audit.NewPublisher()
+	obs := &recordingObserver{}
+	pub.Subscribe(obs)
+
+	repo := createTestRepo(t, db)
+	repo.auditPub = pub
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+
+	event := audit.NewEvent(audit.ActionShorten, userID, "https://outbox.com")
+	event.ShortCode = "box111"
+	require.NoError(t, repo.StoreWithEvent(context.Background(), "box111", "https://outbox.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0, event))
+
+	// Конфликт откатывает транзакцию вместе с событием
+	err := repo.StoreWithEvent(context.Background(), "box222", "https://outbox.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0, event)
+	var conflictErr model.ErrURLConflictError
+	require.ErrorAs(t, err, &conflictErr)
+
+	pending, err := repo.OutboxPending()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pending)
+
+	n, err := repo.relayOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Отправленные строки повторно не публикуются
+	n, err = repo.relayOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, pub.Close())
+
+	require.Len(t, obs.events, 1)
+	assert.Equal(t, "box111", obs.events[0].ShortCode)
+	assert.NotEmpty(t, obs.events[0].ID)
+}
+
+func TestRelayOnce_RetriesFailedDelivery(t *testing.T) {
+	db := setupTestDB(t)
+	pub := audit.NewPublisher()
+	obs := &flakyDeliverer{name: "flaky", failures: 1}
+	stable := &flakyDeliverer{name: "stable"}
+	pub.Subscribe(obs)
+	pub.Subscribe(stable)
+	defer pub.Close()
+
+	repo := createTestRepo(t, db)
+	repo.auditPub = pub
+	userID := "550e8400-e29b-41d4-a716-446655440000"
+
+	event := audit.NewEvent(audit.ActionShorten, userID, "https://retry.com")
+	require.NoError(t, repo.StoreWithEvent(context.Background(), "rty111", "https://retry.com", userID, model.LinkOptions{}, model.LinkMeta{}, 0, event))
+
+	// Неподтверждённая доставка оставляет строку неотправленной
+	n, err := repo.relayOnce()
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+	pending, err := repo.OutboxPending()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pending)
+
+	// До истечения claimed_until строку повторно не забирают
+	n, err = repo.relayOnce()
+	require.NoError(t, err)
+	assert.Zero(t, n)
 
+	_, err = db.Exec(`UPDATE audit_outbox SET claimed_until = NOW() - INTERVAL '1 second'`)
 	require.NoError(t, err)
+	n, err = repo.relayOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	pending, err = repo.OutboxPending()
+	require.NoError(t, err)
+	assert.Zero(t, pending)
+	require.Len(t, obs.delivered, 1)
+	assert.Equal(t, "rty111", obs.delivered[0].ShortCode)
+	// Подтвердивший с первого раза наблюдатель повтор не получил
+	require.Len(t, stable.delivered, 1)
+	assert.Equal(t, obs.delivered[0].ID, stable.delivered[0].ID)
+}
+
+// flakyDeliverer подтверждает доставку после failures неудач
+type flakyDeliverer struct {
+	name      string
+	failures  int
+	delivered []audit.Event
+}
+
+func (o *flakyDeliverer) DeliverBatch(_ context.Context, events []audit.Event) (int, error) {
+	if o.failures > 0 {
+		o.failures--
+		return 0, errors.New("получатель недоступен")
+	}
+	o.delivered = append(o.delivered, events...)
+	return len(events), nil
+}
+
+func (o *flakyDeliverer) String() string { return o.name }
+
+func (o *flakyDeliverer) Notify(audit.Event) {}
+
+func (o *flakyDeliverer) Close() error { return nil }
+
+// recordingObserver запоминает полученные события аудита
+type recordingObserver struct {
+	events []audit.Event
 }
 
+func (o *recordingObserver) Notify(event audit.Event) { o.events = append(o.events, event) }
+
+func (o *recordingObserver) Close() error { return nil }
+
 // === Edge cases ===
 
+// === Audit ===
+
+func TestAuditObserver_NotifyAndQuery(t *testing.T) {
+	db := setupTestDB(t)
+	obs := NewAuditObserver(db)
+
+	events := []audit.Event{
+		{ID: "e1", Timestamp: 1000, Action: audit.ActionShorten, UserID: "u1", URL: "https://example.com/a", ShortCode: "aaaa"},
+		{Timestamp: 2000, Action: audit.ActionFollow, URL: "https://example.com/a", ShortCode: "aaaa", ClientIP: "10.0.0.1"},
+		{Timestamp: 3000, Action: audit.ActionShorten, UserID: "u2", URL: "https://other.org/100%"},
+		{Timestamp: 4000, Action: audit.ActionShorten, UserID: "u1", URL: "https://example.com/b", RequestID: "req-1"},
+	}
+	for _, e := range events {
+		obs.Notify(e)
+	}
+
+	page, err := obs.Query(audit.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, events, page.Events)
+	assert.Zero(t, page.Next)
+
+	page, err = obs.Query(audit.Query{UserID: "u1", Action: audit.ActionShorten})
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Event{events[0], events[3]}, page.Events)
+
+	page, err = obs.Query(audit.Query{URL: "100%"})
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Event{events[2]}, page.Events)
+
+	page, err = obs.Query(audit.Query{From: 2000, To: 3000})
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Event{events[1], events[2]}, page.Events)
+
+	// Постраничный обход
+	page, err = obs.Query(audit.Query{Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 3)
+	require.NotZero(t, page.Next)
+
+	page, err = obs.Query(audit.Query{Limit: 3, After: page.Next})
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Event{events[3]}, page.Events)
+	assert.Zero(t, page.Next)
+}
+
+func TestAuditObserver_DeliverDeduplicates(t *testing.T) {
+	db := setupTestDB(t)
+	obs := NewAuditObserver(db)
+
+	event := audit.Event{ID: "dup-1", Timestamp: 1000, Action: audit.ActionShorten, URL: "https://example.com"}
+	for range 2 {
+		n, err := obs.DeliverBatch(context.Background(), []audit.Event{event})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+
+	page, err := obs.Query(audit.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Event{event}, page.Events)
+}
+
+func TestBuildAuditQuery(t *testing.T) {
+	query, args := buildAuditQuery(audit.Query{
+		UserID: "u1",
+		Action: audit.ActionFollow,
+		URL:    "example",
+		From:   10,
+		To:     20,
+		After:  5,
+	}, 51)
+
+	assert.Contains(t, query, "WHERE id > $1 AND user_id = $2 AND action = $3 AND strpos(url, $4) > 0 AND ts >= to_timestamp($5) AND ts <= to_timestamp($6)")
+	assert.Contains(t, query, "ORDER BY id LIMIT $7")
+	assert.Equal(t, []any{uint64(5), "u1", "follow", "example", int64(10), int64(20), 51}, args)
+
+	query, args = buildAuditQuery(audit.Query{}, 101)
+	assert.NotContains(t, query, "WHERE")
+	assert.Contains(t, query, "LIMIT $1")
+	assert.Equal(t, []any{101}, args)
+}
+
 func TestStore_SpecialCharactersInURL(t *testing.T) {
 	db := setupTestDB(t)
 	repo := createTestRepo(t, db)
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
 	specialURL := "https://example.com/path?q=hello%20world&foo=bar#section"
-	err := repo.Store("spec12", specialURL, userID)
+	err := repo.Store(context.Background(), "spec12", specialURL, userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	require.NoError(t, err)
 
-	got, err := repo.Get("spec12")
+	got, err := repo.Get(context.Background(), "spec12")
 	require.NoError(t, err)
-	assert.Equal(t, specialURL, got)
+	assert.Equal(t, specialURL, got.OriginalURL)
 }
 
 func TestStore_UnicodeInURL(t *testing.T) {
@@ -388,10 +1000,42 @@ func TestStore_UnicodeInURL(t *testing.T) {
 	userID := "550e8400-e29b-41d4-a716-446655440000"
 
 	unicodeURL := "https://example.com/путь/到/chemin"
-	err := repo.Store("unic12", unicodeURL, userID)
+	err := repo.Store(context.Background(), "unic12", unicodeURL, userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	require.NoError(t, err)
 
-	got, err := repo.Get("unic12")
+	got, err := repo.Get(context.Background(), "unic12")
 	require.NoError(t, err)
-	assert.Equal(t, unicodeURL, got)
+	assert.Equal(t, unicodeURL, got.OriginalURL)
+}
+
+func TestJoinRequestIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []model.DeleteTask
+		want  string
+	}{
+		{name: "без идентификаторов", tasks: []model.DeleteTask{{ShortURL: "a"}}, want: "-"},
+		{name: "повторы схлопываются", tasks: []model.DeleteTask{{RequestID: "r1"}, {RequestID: "r1"}, {RequestID: "r2"}}, want: "r1,r2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, joinRequestIDs(tt.tasks))
+		})
+	}
+}
+
+func TestSpanLinks(t *testing.T) {
+	sc1 := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}})
+	sc2 := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{2}, SpanID: trace.SpanID{2}})
+
+	links := spanLinks([]model.DeleteTask{
+		{SpanContext: sc1},
+		{SpanContext: sc1},
+		{}, // задача без трассировки
+		{SpanContext: sc2},
+	})
+	require.Len(t, links, 2)
+	assert.Equal(t, sc1, links[0].SpanContext)
+	assert.Equal(t, sc2, links[1].SpanContext)
 }
@@ -0,0 +1,392 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const (
+	// outboxBatchSize сколько строк outbox relay забирает за один проход
+	outboxBatchSize = 100
+	// outboxPollInterval как часто relay проверяет outbox без пробуждения
+	outboxPollInterval = time.Second
+	// outboxRetention сколько хранятся отправленные строки
+	outboxRetention = 7 * 24 * time.Hour
+	// outboxPruneInterval как часто удаляются старые отправленные строки
+	outboxPruneInterval = time.Hour
+	// outboxClaimTTL на сколько relay забирает строки для доставки.
+	// Недоставленные строки забираются снова по истечении срока.
+	outboxClaimTTL = 30 * time.Second
+	// outboxDeliverTimeout время на доставку батча одному наблюдателю.
+	// Меньше outboxClaimTTL, чтобы отметка успела до истечения захвата.
+	outboxDeliverTimeout = 20 * time.Second
+)
+
+// outboxRelay фоновый воркер, доставляющий события из audit_outbox через Publisher
+type outboxRelay struct {
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StoreWithEvent сохраняет ссылку и событие аудита в одной транзакции.
+//
+// Если репозиторий создан без Publisher, событие не сохраняется.
+// Событию без ID назначается UUID, чтобы повторная публикация после
+// сбоя relay имела тот же ID.
+func (r *URLRepository) StoreWithEvent(ctx context.Context, shortURL, longURL, userID string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int, event audit.Event) error {
+	if r.auditPub == nil {
+		return r.Store(ctx, shortURL, longURL, userID, opts, meta, maxLinks)
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkLinkQuota(ctx, tx, userID, maxLinks); err != nil {
+		return err
+	}
+	if err := r.insertURL(ctx, tx, shortURL, longURL, userID, opts, meta); err != nil {
+		return err
+	}
+	if err := insertOutbox(ctx, tx, event); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации транзакции: %w", err)
+	}
+	r.wakeRelay()
+	return nil
+}
+
+// UpdateLinkWithEvent изменяет ссылку, записывает её версию и событие
+// аудита в одной транзакции. URL события — оригинальный URL после изменения.
+//
+// Если репозиторий создан без Publisher, событие не сохраняется.
+func (r *URLRepository) UpdateLinkWithEvent(ctx context.Context, userID, shortURL string, update model.LinkUpdate, event audit.Event) (model.Link, error) {
+	if r.auditPub == nil {
+		return r.UpdateLink(ctx, userID, shortURL, update)
+	}
+	return r.updateLink(ctx, userID, shortURL, update, &event)
+}
+
+// deleteWithOutbox помечает ссылки удалёнными и в той же транзакции
+// пишет delete_applied для каждой фактически удалённой ссылки.
+// requestIDs сопоставляет короткой ссылке запрос, из которого пришло удаление.
+// Возвращает число фактически удалённых ссылок.
+func (r *URLRepository) deleteWithOutbox(ctx context.Context, userID string, shortURLs []string, requestIDs map[string]string) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := r.batchDeleteURLsWith(ctx, tx, userID, shortURLs)
+	if err != nil {
+		return 0, err
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	events := make([]audit.Event, 0, len(deleted))
+	for _, pair := range deleted {
+		event := audit.NewEvent(audit.ActionDeleteApplied, userID, pair.OriginalURL)
+		event.ShortCode = pair.ShortURL
+		event.RequestID = requestIDs[pair.ShortURL]
+		events = append(events, event)
+	}
+	if err := insertOutbox(ctx, tx, events...); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ошибка фиксации транзакции: %w", err)
+	}
+	r.wakeRelay()
+	return len(deleted), nil
+}
+
+// insertOutbox добавляет события в audit_outbox
+func insertOutbox(ctx context.Context, ex execer, events ...audit.Event) (err error) {
+	const query = `INSERT INTO audit_outbox (payload) VALUES ($1)`
+	ctx, span := startQuery(ctx, "INSERT", "audit_outbox", query)
+	defer telemetry.End(span, &err)
+
+	for _, event := range events {
+		if event.ID == "" {
+			event.ID = uuid.NewString()
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("ошибка сериализации события аудита: %w", err)
+		}
+		if _, err := ex.ExecContext(ctx, query, payload); err != nil {
+			return fmt.Errorf("ошибка записи в outbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// startRelay запускает relay outbox
+func (r *URLRepository) startRelay() {
+	r.relay = &outboxRelay{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go r.runRelay()
+}
+
+// wakeRelay будит relay после фиксации транзакции, не блокируясь
+func (r *URLRepository) wakeRelay() {
+	if r.relay == nil {
+		return
+	}
+	select {
+	case r.relay.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stopRelay отправляет оставшиеся события и останавливает relay
+func (r *URLRepository) stopRelay() {
+	if r.relay == nil {
+		return
+	}
+	close(r.relay.stop)
+	<-r.relay.done
+}
+
+// runRelay доставляет outbox по пробуждению и по таймеру
+func (r *URLRepository) runRelay() {
+	defer close(r.relay.done)
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-r.relay.stop:
+			r.drainOutbox()
+			return
+		case <-r.relay.wake:
+		case <-ticker.C:
+		}
+		r.drainOutbox()
+
+		if time.Since(lastPrune) >= outboxPruneInterval {
+			if err := r.pruneOutbox(outboxRetention); err != nil {
+				zap.S().Errorf("audit outbox: ошибка очистки: %v", err)
+			}
+			lastPrune = time.Now()
+		}
+	}
+}
+
+// drainOutbox доставляет outbox батчами, пока в нём есть неотправленные строки
+func (r *URLRepository) drainOutbox() {
+	for {
+		n, err := r.relayOnce()
+		if err != nil {
+			zap.S().Errorf("audit outbox: ошибка доставки: %v", err)
+			return
+		}
+		if n < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// outboxRow строка outbox, забранная relay для доставки
+type outboxRow struct {
+	id          int64
+	event       audit.Event
+	broken      bool     // payload не разбирается
+	deliveredTo []string // наблюдатели, уже подтвердившие доставку
+}
+
+// relayOnce доставляет один батч неотправленных событий и помечает
+// отправленными строки, доставку которых подтвердили все наблюдатели.
+// Запросы relay не трассируются: опрос раз в секунду засорил бы трассы
+// пустыми span'ами.
+//
+// Строки забираются одним запросом с FOR UPDATE SKIP LOCKED, который
+// выставляет claimed_until: несколько экземпляров сервиса не доставляют
+// одно событие одновременно, а блокировки не держатся во время доставки.
+//
+// Каждый наблюдатель получает батч одним вызовом Publisher.DeliverTo
+// со своим таймаутом, наблюдатели обслуживаются параллельно. Подтвердившие
+// наблюдатели записываются в delivered_to, и при повторе строки после
+// claimed_until событие получают только остальные — недоступный webhook
+// не размножает записи в аудит-файле. Если процесс упадёт между доставкой
+// и отметкой, событие будет доставлено повторно с тем же ID — доставка
+// как минимум один раз, audit_events отбрасывает повтор по event_id.
+func (r *URLRepository) relayOnce() (int, error) {
+	claimed, err := r.claimOutbox()
+	if err != nil || len(claimed) == 0 {
+		return 0, err
+	}
+
+	sinks := r.auditPub.Sinks()
+	delivered := make([][]int64, len(sinks))
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, sink := range sinks {
+		var pending []outboxRow
+		for _, row := range claimed {
+			if !row.broken && !slices.Contains(row.deliveredTo, sink) {
+				pending = append(pending, row)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delivered[i], errs[i] = r.deliverOutbox(sink, pending)
+		}()
+	}
+	wg.Wait()
+
+	done := make(map[int64][]string, len(claimed))
+	for i, ids := range delivered {
+		if len(ids) == 0 {
+			continue
+		}
+		if _, err := r.DB.Exec(
+			`UPDATE audit_outbox SET delivered_to = array_append(delivered_to, $1) WHERE id = ANY($2)`,
+			sinks[i], pq.Array(ids),
+		); err != nil {
+			return len(claimed), err
+		}
+		for _, id := range ids {
+			done[id] = append(done[id], sinks[i])
+		}
+	}
+
+	sent := make([]int64, 0, len(claimed))
+	failed := 0
+	for _, row := range claimed {
+		// Битую строку не повторяем бесконечно: помечаем отправленной
+		if row.broken || deliveredToAll(slices.Concat(row.deliveredTo, done[row.id]), sinks) {
+			sent = append(sent, row.id)
+		} else {
+			failed++
+		}
+	}
+	if len(sent) > 0 {
+		if _, err := r.DB.Exec(`UPDATE audit_outbox SET sent_at = NOW() WHERE id = ANY($1)`, pq.Array(sent)); err != nil {
+			return len(claimed), err
+		}
+	}
+	if failed > 0 {
+		return len(claimed), fmt.Errorf("не доставлено %d из %d событий: %w", failed, len(claimed), errors.Join(errs...))
+	}
+	return len(claimed), nil
+}
+
+// deliverOutbox доставляет события строк наблюдателю sink и возвращает
+// id строк, доставку которых он подтвердил
+func (r *URLRepository) deliverOutbox(sink string, rows []outboxRow) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxDeliverTimeout)
+	defer cancel()
+
+	events := make([]audit.Event, len(rows))
+	for i, row := range rows {
+		events[i] = row.event
+	}
+	n, err := r.auditPub.DeliverTo(ctx, sink, events)
+	ids := make([]int64, n)
+	for i := range n {
+		ids[i] = rows[i].id
+	}
+	return ids, err
+}
+
+// deliveredToAll сообщает, что событие доставлено всем наблюдателям sinks
+func deliveredToAll(deliveredTo, sinks []string) bool {
+	for _, sink := range sinks {
+		if !slices.Contains(deliveredTo, sink) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimOutbox забирает батч неотправленных строк на outboxClaimTTL
+// и возвращает их в порядке записи
+func (r *URLRepository) claimOutbox() ([]outboxRow, error) {
+	rows, err := r.DB.Query(`
+        UPDATE audit_outbox SET claimed_until = NOW() + make_interval(secs => $2)
+        WHERE id IN (
+            SELECT id FROM audit_outbox
+            WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < NOW())
+            ORDER BY id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, payload, delivered_to
+    `, outboxBatchSize, outboxClaimTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []outboxRow
+	for rows.Next() {
+		var (
+			row     outboxRow
+			payload []byte
+		)
+		if err := rows.Scan(&row.id, &payload, pq.Array(&row.deliveredTo)); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &row.event); err != nil {
+			zap.S().Errorf("audit outbox: строка %d не разбирается: %v", row.id, err)
+			row.broken = true
+		}
+		claimed = append(claimed, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// RETURNING не сохраняет порядок подзапроса
+	slices.SortFunc(claimed, func(a, b outboxRow) int { return cmp.Compare(a.id, b.id) })
+	return claimed, nil
+}
+
+// pruneOutbox удаляет отправленные строки старше retention
+func (r *URLRepository) pruneOutbox(retention time.Duration) error {
+	_, err := r.DB.Exec(
+		`DELETE FROM audit_outbox WHERE sent_at < $1`,
+		time.Now().Add(-retention),
+	)
+	return err
+}
+
+// OutboxPending возвращает число неотправленных событий в outbox
+func (r *URLRepository) OutboxPending() (int, error) {
+	var n int
+	err := r.DB.QueryRow(`SELECT COUNT(*) FROM audit_outbox WHERE sent_at IS NULL`).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"github.com/lib/pq"
+)
+
+// tagsColumn выбирает метки ссылки из link_tags массивом по алфавиту
+const tagsColumn = `ARRAY(SELECT t.tag FROM link_tags t WHERE t.short_url = shortened_urls.short_url ORDER BY t.tag)`
+
+// tagList возвращает метки из массива БД, пустой массив — nil
+func tagList(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// setTags заменяет метки ссылки
+func setTags(ctx context.Context, ex execer, shortURL string, tags []string) error {
+	if err := deleteTags(ctx, ex, shortURL); err != nil {
+		return err
+	}
+	return insertTags(ctx, ex, shortURL, tags)
+}
+
+// deleteTags удаляет все метки ссылки
+func deleteTags(ctx context.Context, ex execer, shortURL string) (err error) {
+	query := `DELETE FROM link_tags WHERE short_url = $1`
+	ctx, span := startQuery(ctx, "DELETE", "link_tags", query)
+	defer telemetry.End(span, &err)
+
+	if _, err = ex.ExecContext(ctx, query, shortURL); err != nil {
+		return fmt.Errorf("ошибка при удалении меток URL: %w", err)
+	}
+	return nil
+}
+
+// insertTags добавляет метки ссылке одним запросом
+func insertTags(ctx context.Context, ex execer, shortURL string, tags []string) (err error) {
+	if len(tags) == 0 {
+		return nil
+	}
+	query := `
+        INSERT INTO link_tags (short_url, tag)
+        SELECT $1, unnest($2::text[])
+        ON CONFLICT DO NOTHING
+    `
+	ctx, span := startQuery(ctx, "INSERT", "link_tags", query)
+	defer telemetry.End(span, &err)
+
+	if _, err = ex.ExecContext(ctx, query, shortURL, pq.Array(tags)); err != nil {
+		return fmt.Errorf("ошибка при сохранении меток URL: %w", err)
+	}
+	return nil
+}
+
+// getTags возвращает метки ссылки по алфавиту
+func getTags(ctx context.Context, ex execer, shortURL string) (_ []string, err error) {
+	query := `SELECT ` + tagsColumn + ` FROM shortened_urls WHERE short_url = $1`
+	ctx, span := startQuery(ctx, "SELECT", "link_tags", query)
+	defer telemetry.End(span, &err)
+
+	var tags pq.StringArray
+	if err = ex.QueryRowContext(ctx, query, shortURL).Scan(&tags); err != nil {
+		return nil, fmt.Errorf("ошибка при получении меток URL: %w", err)
+	}
+	return tagList(tags), nil
+}
+
+// GetUserTags возвращает метки активных ссылок пользователя с числом ссылок
+func (r *URLRepository) GetUserTags(ctx context.Context, userID string) (_ []model.TagCount, err error) {
+	query := `
+        SELECT t.tag, COUNT(*)
+        FROM link_tags t
+        JOIN shortened_urls s ON s.short_url = t.short_url
+        WHERE s.user_id = $1 AND COALESCE(s.is_deleted, false) = false
+        GROUP BY t.tag
+        ORDER BY COUNT(*) DESC, t.tag
+    `
+	ctx, span := startQuery(ctx, "SELECT", "link_tags", query)
+	defer telemetry.End(span, &err)
+
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении меток пользователя: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []model.TagCount{}
+	for rows.Next() {
+		var tc model.TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, fmt.Errorf("ошибка при чтении метки: %w", err)
+		}
+		tags = append(tags, tc)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по строкам: %w", err)
+	}
+	return tags, nil
+}
@@ -1,33 +1,127 @@
 package filestorage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/Popolzen/shortener/internal/model"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type URLRepository struct {
-	urls map[string]string
-	path string
+	// mu защищает карты: переходы пишут счётчики на пути чтения
+	mu        sync.RWMutex
+	urls      map[string]string
+	owners    map[string]string              // короткая ссылка -> пользователь
+	userLinks map[string]int                 // число ссылок пользователя
+	options   map[string]model.LinkOptions   // короткая ссылка -> настройки перенаправления
+	history   map[string][]model.LinkVersion // короткая ссылка -> версии от первой к последней
+	clicks    map[string]int64               // короткая ссылка -> число переходов
+	meta      map[string]model.LinkMeta      // короткая ссылка -> описание
+	path      string
 }
 
-func (r URLRepository) Get(shortURL string) (string, error) {
+func (r *URLRepository) Get(ctx context.Context, shortURL string) (model.Link, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(shortURL)
+}
 
+// get возвращает ссылку, вызывается под r.mu
+func (r *URLRepository) get(shortURL string) (model.Link, error) {
 	if longURL, exists := r.urls[shortURL]; exists {
-		return longURL, nil
+		return model.Link{
+			ShortURL:    shortURL,
+			OriginalURL: longURL,
+			UserID:      r.owners[shortURL],
+			Version:     len(r.history[shortURL]),
+			LinkOptions: r.options[shortURL],
+			LinkMeta:    cloneMeta(r.meta[shortURL]),
+		}, nil
+	}
+	return model.Link{}, model.ErrURLNotFound
+}
+
+func (r *URLRepository) Store(ctx context.Context, shortURL, longURL, userID string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.urls[shortURL]; !exists {
+		if used := r.userLinks[userID]; maxLinks > 0 && userID != "" && used >= maxLinks {
+			return model.NewLinkQuotaError(maxLinks, used, 1)
+		}
+		r.setOwner(shortURL, userID)
 	}
-	return "", fmt.Errorf("URL not found")
+	r.setLink(shortURL, longURL, opts)
+	r.meta[shortURL] = cloneMeta(meta)
+	r.save()
+	return nil
 }
 
-func (r *URLRepository) Store(shortURL, longURL, _ string) error {
+// cloneMeta копирует описание, чтобы метки не разделялись с вызывающим.
+// Пустой список меток становится nil.
+func cloneMeta(meta model.LinkMeta) model.LinkMeta {
+	if len(meta.Tags) == 0 {
+		meta.Tags = nil
+	}
+	meta.Tags = slices.Clone(meta.Tags)
+	return meta
+}
 
+// setLink сохраняет состояние ссылки и добавляет его в историю новой версией.
+// Хеш пароля в историю не попадает.
+func (r *URLRepository) setLink(shortURL, longURL string, opts model.LinkOptions) {
 	r.urls[shortURL] = longURL
-	r.SaveURLToFile()
-	return nil
+	r.options[shortURL] = opts
+	opts.PasswordHash = ""
+	r.history[shortURL] = append(r.history[shortURL], model.LinkVersion{
+		Version:     len(r.history[shortURL]) + 1,
+		OriginalURL: longURL,
+		CreatedAt:   time.Now(),
+		LinkOptions: opts,
+	})
+}
+
+// UpdateLink меняет ссылку пользователя и сохраняет файл. Изменение URL
+// или настроек добавляет версию в историю.
+func (r *URLRepository) UpdateLink(ctx context.Context, userID, shortURL string, update model.LinkUpdate) (model.Link, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	longURL, exists := r.urls[shortURL]
+	if !exists || r.owners[shortURL] != userID {
+		return model.Link{}, model.ErrURLNotFound
+	}
+	if update.OriginalURL != nil {
+		longURL = *update.OriginalURL
+	}
+	opts := update.Apply(r.options[shortURL])
+	if update.NewVersion() {
+		r.setLink(shortURL, longURL, opts)
+	} else {
+		r.options[shortURL] = opts
+	}
+	r.meta[shortURL] = cloneMeta(update.ApplyMeta(r.meta[shortURL]))
+	if err := r.save(); err != nil {
+		return model.Link{}, err
+	}
+	return r.get(shortURL)
+}
+
+// GetLinkHistory возвращает версии ссылки пользователя
+func (r *URLRepository) GetLinkHistory(ctx context.Context, userID, shortURL string) ([]model.LinkVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, exists := r.urls[shortURL]; !exists || r.owners[shortURL] != userID {
+		return nil, model.ErrURLNotFound
+	}
+	return slices.Clone(r.history[shortURL]), nil
 }
 
 func NewURLRepository(path string) *URLRepository {
@@ -35,13 +129,25 @@ func NewURLRepository(path string) *URLRepository {
 
 	repo.path = path
 	repo.urls = map[string]string{}
+	repo.owners = map[string]string{}
+	repo.userLinks = map[string]int{}
+	repo.options = map[string]model.LinkOptions{}
+	repo.history = map[string][]model.LinkVersion{}
+	repo.clicks = map[string]int64{}
+	repo.meta = map[string]model.LinkMeta{}
 
 	err := repo.loadURLs(path)
 
 	if err != nil {
 		return &URLRepository{
-			urls: map[string]string{},
-			path: path,
+			urls:      map[string]string{},
+			owners:    map[string]string{},
+			userLinks: map[string]int{},
+			options:   map[string]model.LinkOptions{},
+			history:   map[string][]model.LinkVersion{},
+			clicks:    map[string]int64{},
+			meta:      map[string]model.LinkMeta{},
+			path:      path,
 		}
 	}
 	return &repo
@@ -66,18 +172,65 @@ func (r *URLRepository) loadURLs(path string) error {
 		return fmt.Errorf("ошибка десериализации JSON: %w", err)
 	}
 	for i := range urlRecord {
+		opts := model.LinkOptions{
+			RedirectCode:     urlRecord[i].RedirectCode,
+			QueryPassthrough: urlRecord[i].QueryPassthrough,
+			PasswordHash:     urlRecord[i].PasswordHash,
+		}
 		r.urls[urlRecord[i].ShortURL] = urlRecord[i].OriginalURL
+		r.options[urlRecord[i].ShortURL] = opts
+		r.history[urlRecord[i].ShortURL] = urlRecord[i].History
+		if len(urlRecord[i].History) == 0 {
+			// Записи старого формата без истории: текущее состояние — первая
+			// версия, время её создания неизвестно
+			r.history[urlRecord[i].ShortURL] = []model.LinkVersion{{
+				Version:     1,
+				OriginalURL: urlRecord[i].OriginalURL,
+				LinkOptions: opts,
+			}}
+		}
+		r.clicks[urlRecord[i].ShortURL] = urlRecord[i].Clicks
+		r.meta[urlRecord[i].ShortURL] = urlRecord[i].LinkMeta
+		r.setOwner(urlRecord[i].ShortURL, urlRecord[i].UserID)
 	}
 
 	return nil
 }
 
+// setOwner запоминает владельца ссылки. Записи старого формата без владельца пропускаются.
+func (r *URLRepository) setOwner(shortURL, userID string) {
+	if userID == "" {
+		return
+	}
+	r.owners[shortURL] = userID
+	r.userLinks[userID]++
+}
+
 // SaveURLToFile  запись по url в файл
 func (r *URLRepository) SaveURLToFile() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.save()
+}
+
+// save записывает ссылки в файл, вызывается под r.mu
+func (r *URLRepository) save() error {
 	urls := make([]model.URLRecord, 0, len(r.urls))
 
 	for key, value := range r.urls {
-		urls = append(urls, model.URLRecord{UUID: uuid.New().String(), OriginalURL: value, ShortURL: key})
+		opts := r.options[key]
+		urls = append(urls, model.URLRecord{
+			UUID:             uuid.New().String(),
+			OriginalURL:      value,
+			ShortURL:         key,
+			UserID:           r.owners[key],
+			RedirectCode:     opts.RedirectCode,
+			QueryPassthrough: opts.QueryPassthrough,
+			PasswordHash:     opts.PasswordHash,
+			Clicks:           r.clicks[key],
+			History:          r.history[key],
+			LinkMeta:         r.meta[key],
+		})
 	}
 
 	data, err := json.Marshal(urls)
@@ -85,7 +238,7 @@ func (r *URLRepository) SaveURLToFile() error {
 		return fmt.Errorf("ошибка сериализации JSON: %w", err)
 	}
 
-	file, err := os.OpenFile(r.path, os.O_RDWR|os.O_CREATE, 0644) // создаем файл если его нет
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644) // создаем файл если его нет
 	if err != nil {
 		return fmt.Errorf("ошибка открытия файла: %w", err)
 	}
@@ -96,14 +249,80 @@ func (r *URLRepository) SaveURLToFile() error {
 	return nil
 }
 
-// FileStorage Repository - заглушки для GetUserURLs
-func (r *URLRepository) GetUserURLs(userID string) ([]model.URLPair, error) {
-	return nil, fmt.Errorf("GetUserURLs not implemented for file storage")
+// GetUserURLs возвращает страницу ссылок пользователя.
+// Удаление в файловом хранилище не поддерживается, поэтому все ссылки активные.
+func (r *URLRepository) GetUserURLs(ctx context.Context, userID string, q model.URLListQuery) ([]model.UserURL, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var urls []model.UserURL
+	for shortURL, owner := range r.owners {
+		if owner != userID {
+			continue
+		}
+		u := model.UserURL{
+			ShortURL:    shortURL,
+			OriginalURL: r.urls[shortURL],
+			Clicks:      r.clicks[shortURL],
+			Protected:   r.options[shortURL].Protected(),
+			LinkMeta:    cloneMeta(r.meta[shortURL]),
+		}
+		if versions := r.history[shortURL]; len(versions) > 0 {
+			u.CreatedAt = versions[0].CreatedAt
+		}
+		if q.Match(u) && q.IsAfter(u) {
+			urls = append(urls, u)
+		}
+	}
+	slices.SortFunc(urls, q.Compare)
+	if q.Limit > 0 && len(urls) > q.Limit {
+		urls = urls[:q.Limit]
+	}
+	return urls, nil
+}
+
+// GetUserTags возвращает метки ссылок пользователя с числом ссылок
+func (r *URLRepository) GetUserTags(ctx context.Context, userID string) ([]model.TagCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	counts := map[string]int{}
+	for shortURL, owner := range r.owners {
+		if owner != userID {
+			continue
+		}
+		for _, tag := range r.meta[shortURL].Tags {
+			counts[tag]++
+		}
+	}
+	return model.SortTagCounts(counts), nil
+}
+
+// RecordClick увеличивает счётчик переходов по ссылке. Файл при этом
+// не перезаписывается: счётчики сохраняются при следующей записи или закрытии.
+func (r *URLRepository) RecordClick(ctx context.Context, shortURL string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.urls[shortURL]; exists {
+		r.clicks[shortURL]++
+	}
 }
 
 // FileStorage Repository - заглушки для DeleteURLs
-func (r *URLRepository) DeleteURLs(userID string, urlIDs []string) {
-	fmt.Print("DeteleUrls not implemented for in-memory storage")
+func (r *URLRepository) DeleteURLs(ctx context.Context, userID string, urlIDs []string) ([]string, error) {
+	zap.S().Warn("DeleteURLs не поддерживается этим хранилищем")
+	return nil, nil
+}
+
+// CheckWritable проверяет, что в каталог хранилища можно писать.
+// Данные сохраняются только при закрытии, поэтому проблему с диском
+// лучше увидеть заранее.
+func (r *URLRepository) CheckWritable() error {
+	f, err := os.CreateTemp(filepath.Dir(r.path), ".shortener-health-*")
+	if err != nil {
+		return fmt.Errorf("каталог хранилища недоступен для записи: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
 }
 
 func (r *URLRepository) Close() error {
@@ -111,8 +330,17 @@ func (r *URLRepository) Close() error {
 }
 
 // GetStats возвращает статистику (для filestorage - упрощенная версия)
-func (r *URLRepository) GetStats() (urls int, users int, err error) {
+func (r *URLRepository) GetStats(ctx context.Context) (urls int, users int, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	// В file storage репозитории у нас нет информации о пользователях
 	// Возвращаем количество URL и 0 пользователей
 	return len(r.urls), 0, nil
 }
+
+// CountUserURLs возвращает число ссылок пользователя
+func (r *URLRepository) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.userLinks[userID], nil
+}
@@ -1,9 +1,13 @@
 package filestorage
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/Popolzen/shortener/internal/model"
@@ -92,7 +96,7 @@ func TestStore_Success(t *testing.T) {
 	path := createTempFile(t, "")
 
 	repo := NewURLRepository(path)
-	err := repo.Store("test123", "https://example.com", "user-1")
+	err := repo.Store(context.Background(), "test123", "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	require.NoError(t, err)
 	assert.Equal(t, "https://example.com", repo.urls["test123"])
@@ -102,7 +106,7 @@ func TestStore_PersistsToFile(t *testing.T) {
 	path := createTempFile(t, "")
 
 	repo := NewURLRepository(path)
-	repo.Store("persisted", "https://persisted.com", "user-1")
+	repo.Store(context.Background(), "persisted", "https://persisted.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	content, err := os.ReadFile(path)
 	require.NoError(t, err)
@@ -114,9 +118,9 @@ func TestStore_MultipleURLs(t *testing.T) {
 	path := createTempFile(t, "")
 
 	repo := NewURLRepository(path)
-	repo.Store("a", "https://a.com", "user-1")
-	repo.Store("b", "https://b.com", "user-2")
-	repo.Store("c", "https://c.com", "user-1")
+	repo.Store(context.Background(), "a", "https://a.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "b", "https://b.com", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "c", "https://c.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	assert.Len(t, repo.urls, 3)
 
@@ -130,11 +134,11 @@ func TestStore_Overwrite(t *testing.T) {
 	path := createTempFile(t, "")
 
 	repo := NewURLRepository(path)
-	repo.Store("key", "https://old.com", "user")
-	repo.Store("key", "https://new.com", "user")
+	repo.Store(context.Background(), "key", "https://old.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "key", "https://new.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	longURL, _ := repo.Get("key")
-	assert.Equal(t, "https://new.com", longURL)
+	longURL, _ := repo.Get(context.Background(), "key")
+	assert.Equal(t, "https://new.com", longURL.OriginalURL)
 }
 
 func TestStore_CreatesFileIfNotExists(t *testing.T) {
@@ -142,7 +146,7 @@ func TestStore_CreatesFileIfNotExists(t *testing.T) {
 	path := filepath.Join(dir, "newfile.json")
 
 	repo := NewURLRepository(path)
-	err := repo.Store("new", "https://new.com", "user")
+	err := repo.Store(context.Background(), "new", "https://new.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	require.NoError(t, err)
 
@@ -158,10 +162,10 @@ func TestGet_Success(t *testing.T) {
 	repo := NewURLRepository(path)
 	repo.urls["found"] = "https://found.com"
 
-	longURL, err := repo.Get("found")
+	longURL, err := repo.Get(context.Background(), "found")
 
 	require.NoError(t, err)
-	assert.Equal(t, "https://found.com", longURL)
+	assert.Equal(t, "https://found.com", longURL.OriginalURL)
 }
 
 func TestGet_NotFound(t *testing.T) {
@@ -169,7 +173,7 @@ func TestGet_NotFound(t *testing.T) {
 
 	repo := NewURLRepository(path)
 
-	_, err := repo.Get("missing")
+	_, err := repo.Get(context.Background(), "missing")
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not found")
@@ -179,12 +183,12 @@ func TestGet_AfterStore(t *testing.T) {
 	path := createTempFile(t, "")
 
 	repo := NewURLRepository(path)
-	repo.Store("abc", "https://abc.com", "user")
+	repo.Store(context.Background(), "abc", "https://abc.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	longURL, err := repo.Get("abc")
+	longURL, err := repo.Get(context.Background(), "abc")
 
 	require.NoError(t, err)
-	assert.Equal(t, "https://abc.com", longURL)
+	assert.Equal(t, "https://abc.com", longURL.OriginalURL)
 }
 
 // === Persistence ===
@@ -194,33 +198,33 @@ func TestPersistence_ReloadAfterRestart(t *testing.T) {
 
 	// Первый "запуск"
 	repo1 := NewURLRepository(path)
-	repo1.Store("key1", "https://one.com", "user")
-	repo1.Store("key2", "https://two.com", "user")
+	repo1.Store(context.Background(), "key1", "https://one.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo1.Store(context.Background(), "key2", "https://two.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	// "Перезапуск" — новый репо с тем же файлом
 	repo2 := NewURLRepository(path)
 
-	url1, err1 := repo2.Get("key1")
-	url2, err2 := repo2.Get("key2")
+	url1, err1 := repo2.Get(context.Background(), "key1")
+	url2, err2 := repo2.Get(context.Background(), "key2")
 
 	require.NoError(t, err1)
 	require.NoError(t, err2)
-	assert.Equal(t, "https://one.com", url1)
-	assert.Equal(t, "https://two.com", url2)
+	assert.Equal(t, "https://one.com", url1.OriginalURL)
+	assert.Equal(t, "https://two.com", url2.OriginalURL)
 }
 
 func TestPersistence_OverwritePreserved(t *testing.T) {
 	path := createTempFile(t, "")
 
 	repo1 := NewURLRepository(path)
-	repo1.Store("key", "https://old.com", "user")
-	repo1.Store("key", "https://new.com", "user")
+	repo1.Store(context.Background(), "key", "https://old.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo1.Store(context.Background(), "key", "https://new.com", "user", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	repo2 := NewURLRepository(path)
-	longURL, err := repo2.Get("key")
+	longURL, err := repo2.Get(context.Background(), "key")
 
 	require.NoError(t, err)
-	assert.Equal(t, "https://new.com", longURL)
+	assert.Equal(t, "https://new.com", longURL.OriginalURL)
 }
 
 func TestPersistence_ManyURLs(t *testing.T) {
@@ -229,7 +233,7 @@ func TestPersistence_ManyURLs(t *testing.T) {
 	repo1 := NewURLRepository(path)
 	for i := 0; i < 100; i++ {
 		key := string(rune('a'+i%26)) + string(rune('0'+i%10))
-		repo1.Store(key, "https://example.com/"+key, "user")
+		repo1.Store(context.Background(), key, "https://example.com/"+key, "user", model.LinkOptions{}, model.LinkMeta{}, 0)
 	}
 
 	repo2 := NewURLRepository(path)
@@ -270,16 +274,44 @@ func TestSaveURLToFile_EmptyURLs(t *testing.T) {
 
 // === GetUserURLs ===
 
-func TestGetUserURLs_NotImplemented(t *testing.T) {
+func TestGetUserURLs_FiltersAndSorts(t *testing.T) {
 	path := createTempFile(t, "")
+	ctx := context.Background()
 
 	repo := NewURLRepository(path)
+	repo.Store(ctx, "aaaa", "https://one.com/docs", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(ctx, "bbbb", "https://two.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(ctx, "cccc", "https://three.com", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.RecordClick(ctx, "bbbb")
 
-	urls, err := repo.GetUserURLs("user-1")
+	urls, err := repo.GetUserURLs(ctx, "user-1", model.URLListQuery{Limit: 10, Sort: model.SortClicks, Desc: true})
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, "bbbb", urls[0].ShortURL)
+	assert.Equal(t, int64(1), urls[0].Clicks)
+	assert.Equal(t, "aaaa", urls[1].ShortURL)
 
-	assert.Error(t, err)
-	assert.Nil(t, urls)
-	assert.Contains(t, err.Error(), "not implemented")
+	urls, err = repo.GetUserURLs(ctx, "user-1", model.URLListQuery{Limit: 10, URL: "docs"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "aaaa", urls[0].ShortURL)
+}
+
+func TestRecordClick_SurvivesRestart(t *testing.T) {
+	path := createTempFile(t, "")
+	ctx := context.Background()
+
+	repo1 := NewURLRepository(path)
+	require.NoError(t, repo1.Store(ctx, "key1", "https://one.com", "owner", model.LinkOptions{}, model.LinkMeta{}, 0))
+	repo1.RecordClick(ctx, "key1")
+	repo1.RecordClick(ctx, "key1")
+	require.NoError(t, repo1.Close())
+
+	repo2 := NewURLRepository(path)
+	urls, err := repo2.GetUserURLs(ctx, "owner", model.URLListQuery{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, int64(2), urls[0].Clicks)
 }
 
 // === DeleteURLs ===
@@ -290,7 +322,7 @@ func TestDeleteURLs_NotPanics(t *testing.T) {
 	repo := NewURLRepository(path)
 
 	assert.NotPanics(t, func() {
-		repo.DeleteURLs("user-1", []string{"abc"})
+		repo.DeleteURLs(context.Background(), "user-1", []string{"abc"})
 	})
 }
 
@@ -298,14 +330,14 @@ func TestDeleteURLs_DoesNotDeleteAnything(t *testing.T) {
 	path := createTempFile(t, "")
 
 	repo := NewURLRepository(path)
-	repo.Store("abc", "https://example.com", "user-1")
+	repo.Store(context.Background(), "abc", "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	repo.DeleteURLs("user-1", []string{"abc"})
+	repo.DeleteURLs(context.Background(), "user-1", []string{"abc"})
 
 	// В file реализации Delete не работает
-	longURL, err := repo.Get("abc")
+	longURL, err := repo.Get(context.Background(), "abc")
 	require.NoError(t, err)
-	assert.Equal(t, "https://example.com", longURL)
+	assert.Equal(t, "https://example.com", longURL.OriginalURL)
 }
 
 // === Edge cases ===
@@ -315,13 +347,13 @@ func TestStore_SpecialCharactersInURL(t *testing.T) {
 
 	repo := NewURLRepository(path)
 	specialURL := "https://example.com/path?q=hello world&foo=bar#section"
-	repo.Store("special", specialURL, "user")
+	repo.Store(context.Background(), "special", specialURL, "user", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	repo2 := NewURLRepository(path)
-	got, err := repo2.Get("special")
+	got, err := repo2.Get(context.Background(), "special")
 
 	require.NoError(t, err)
-	assert.Equal(t, specialURL, got)
+	assert.Equal(t, specialURL, got.OriginalURL)
 }
 
 func TestStore_UnicodeInURL(t *testing.T) {
@@ -329,11 +361,240 @@ func TestStore_UnicodeInURL(t *testing.T) {
 
 	repo := NewURLRepository(path)
 	unicodeURL := "https://example.com/путь/到/chemin"
-	repo.Store("unicode", unicodeURL, "user")
+	repo.Store(context.Background(), "unicode", unicodeURL, "user", model.LinkOptions{}, model.LinkMeta{}, 0)
+
+	repo2 := NewURLRepository(path)
+	got, err := repo2.Get(context.Background(), "unicode")
+
+	require.NoError(t, err)
+	assert.Equal(t, unicodeURL, got.OriginalURL)
+}
+
+func TestCountUserURLs_SurvivesRestart(t *testing.T) {
+	path := createTempFile(t, "")
+
+	repo1 := NewURLRepository(path)
+	repo1.Store(context.Background(), "key1", "https://one.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo1.Store(context.Background(), "key2", "https://two.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo1.Store(context.Background(), "key3", "https://three.com", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0)
+
+	repo2 := NewURLRepository(path)
+	n, err := repo2.CountUserURLs(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	repo := NewURLRepository(filepath.Join(dir, "storage.json"))
+	require.NoError(t, repo.CheckWritable())
+
+	// Временный файл проверки не остаётся в каталоге
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), "health")
+	}
+
+	missing := NewURLRepository(filepath.Join(dir, "missing", "storage.json"))
+	assert.Error(t, missing.CheckWritable())
+}
+
+func TestLinkOptions_SurviveRestart(t *testing.T) {
+	path := createTempFile(t, "")
+	ctx := context.Background()
+
+	repo1 := NewURLRepository(path)
+	require.NoError(t, repo1.Store(ctx, "perm", "https://perm.com", "user", model.LinkOptions{RedirectCode: 301}, model.LinkMeta{}, 0))
+	require.NoError(t, repo1.Store(ctx, "pass", "https://pass.com", "user", model.LinkOptions{QueryPassthrough: true}, model.LinkMeta{}, 0))
+
+	repo2 := NewURLRepository(path)
+	perm, err := repo2.Get(ctx, "perm")
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkOptions{RedirectCode: 301}, perm.LinkOptions)
+	pass, err := repo2.Get(ctx, "pass")
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkOptions{QueryPassthrough: true}, pass.LinkOptions)
+}
+
+func TestUpdateLink_Persists(t *testing.T) {
+	path := createTempFile(t, "")
+	ctx := context.Background()
+
+	repo1 := NewURLRepository(path)
+	require.NoError(t, repo1.Store(ctx, "key", "https://key.com", "owner", model.LinkOptions{RedirectCode: 308, QueryPassthrough: true}, model.LinkMeta{}, 0))
+
+	code, passthrough := 302, false
+	link, err := repo1.UpdateLink(ctx, "owner", "key", model.LinkUpdate{RedirectCode: &code, QueryPassthrough: &passthrough})
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkOptions{RedirectCode: 302}, link.LinkOptions)
+
+	_, err = repo1.UpdateLink(ctx, "stranger", "key", model.LinkUpdate{RedirectCode: &code})
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+
+	repo2 := NewURLRepository(path)
+	reloaded, err := repo2.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkOptions{RedirectCode: 302}, reloaded.LinkOptions)
+}
+
+func TestLinkHistory_SurvivesRestart(t *testing.T) {
+	path := createTempFile(t, "")
+	ctx := context.Background()
+
+	repo1 := NewURLRepository(path)
+	require.NoError(t, repo1.Store(ctx, "key", "https://old.com", "owner", model.LinkOptions{}, model.LinkMeta{}, 0))
+	newURL := "https://new.com"
+	link, err := repo1.UpdateLink(ctx, "owner", "key", model.LinkUpdate{OriginalURL: &newURL})
+	require.NoError(t, err)
+	assert.Equal(t, 2, link.Version)
+
+	repo2 := NewURLRepository(path)
+	versions, err := repo2.GetLinkHistory(ctx, "owner", "key")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "https://old.com", versions[0].OriginalURL)
+	assert.Equal(t, "https://new.com", versions[1].OriginalURL)
+	assert.Equal(t, 2, versions[1].Version)
+
+	reloaded, err := repo2.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "https://new.com", reloaded.OriginalURL)
+	assert.Equal(t, 2, reloaded.Version)
+
+	_, err = repo2.GetLinkHistory(ctx, "stranger", "key")
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+}
+
+func TestLinkHistory_LegacyRecord(t *testing.T) {
+	records := []model.URLRecord{{UUID: "1", ShortURL: "old", OriginalURL: "https://old.com", UserID: "owner"}}
+	content, err := json.Marshal(records)
+	require.NoError(t, err)
+	path := createTempFile(t, string(content))
+
+	repo := NewURLRepository(path)
+	versions, err := repo.GetLinkHistory(context.Background(), "owner", "old")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, "https://old.com", versions[0].OriginalURL)
+}
+
+func TestLinkMeta_SurvivesRestart(t *testing.T) {
+	path := createTempFile(t, "")
+	ctx := context.Background()
+
+	repo1 := NewURLRepository(path)
+	require.NoError(t, repo1.Store(ctx, "key1", "https://one.com", "owner", model.LinkOptions{}, model.LinkMeta{Title: "Один", Tags: []string{"docs"}}, 0))
+	require.NoError(t, repo1.Store(ctx, "key2", "https://two.com", "owner", model.LinkOptions{}, model.LinkMeta{}, 0))
+	note := "заметка"
+	link, err := repo1.UpdateLink(ctx, "owner", "key2", model.LinkUpdate{Note: &note, Tags: &[]string{"docs", "work"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, link.Version)
+
+	repo2 := NewURLRepository(path)
+	link, err = repo2.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkMeta{Title: "Один", Tags: []string{"docs"}}, link.LinkMeta)
+
+	tags, err := repo2.GetUserTags(ctx, "owner")
+	require.NoError(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "docs", Count: 2}, {Tag: "work", Count: 1}}, tags)
+
+	urls, err := repo2.GetUserURLs(ctx, "owner", model.URLListQuery{Limit: 10, Tag: "work"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "key2", urls[0].ShortURL)
+	assert.Equal(t, "заметка", urls[0].Note)
+}
+
+func TestPasswordHash_SurvivesRestart(t *testing.T) {
+	path := createTempFile(t, "")
+	ctx := context.Background()
+
+	repo1 := NewURLRepository(path)
+	require.NoError(t, repo1.Store(ctx, "key1", "https://one.com", "owner", model.LinkOptions{PasswordHash: "hash-1"}, model.LinkMeta{}, 0))
+	hash := "hash-2"
+	link, err := repo1.UpdateLink(ctx, "owner", "key1", model.LinkUpdate{PasswordHash: &hash})
+	require.NoError(t, err)
+	assert.Equal(t, 1, link.Version)
 
 	repo2 := NewURLRepository(path)
-	got, err := repo2.Get("unicode")
+	link, err = repo2.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "hash-2", link.PasswordHash)
+
+	versions, err := repo2.GetLinkHistory(ctx, "owner", "key1")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Empty(t, versions[0].PasswordHash)
+}
+
+// === Concurrency ===
+
+func TestConcurrentRedirects(t *testing.T) {
+	path := createTempFile(t, "")
+	ctx := context.Background()
+	repo := NewURLRepository(path)
+	require.NoError(t, repo.Store(ctx, "abc123", "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0))
+
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_, err := repo.Get(ctx, "abc123")
+				assert.NoError(t, err)
+				repo.RecordClick(ctx, "abc123")
+			}
+			if i%8 == 0 {
+				repo.Store(ctx, fmt.Sprintf("key%d", i), "https://other.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, repo.Close())
+
+	reloaded := NewURLRepository(path)
+	urls, err := reloaded.GetUserURLs(ctx, "user-1", model.URLListQuery{Limit: 100, URL: "example"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, int64(64*100), urls[0].Clicks)
+	n, err := reloaded.CountUserURLs(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 9, n)
+}
+
+func TestStore_LinkQuotaConcurrent(t *testing.T) {
+	repo := NewURLRepository(createTempFile(t, ""))
+	ctx := context.Background()
+
+	// Параллельные сохранения одного пользователя не превышают квоту
+	errs := make([]error, 32)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = repo.Store(ctx, fmt.Sprintf("q%05d", i), fmt.Sprintf("https://quota-%d.com", i), "user-1", model.LinkOptions{}, model.LinkMeta{}, 5)
+		}()
+	}
+	wg.Wait()
+
+	stored := 0
+	for _, err := range errs {
+		var quotaErr *model.QuotaError
+		if errors.As(err, &quotaErr) {
+			assert.Equal(t, int64(5), quotaErr.Limit)
+			continue
+		}
+		require.NoError(t, err)
+		stored++
+	}
+	assert.Equal(t, 5, stored)
 
+	n, err := repo.CountUserURLs(ctx, "user-1")
 	require.NoError(t, err)
-	assert.Equal(t, unicodeURL, got)
+	assert.Equal(t, 5, n)
 }
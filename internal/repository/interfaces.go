@@ -4,14 +4,20 @@
 // источниками данных: in-memory хранилище, файловое хранилище, базы данных.
 package repository
 
-import "github.com/Popolzen/shortener/internal/model"
+import (
+	"context"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/model"
+)
 
 // URLRepository определяет интерфейс для работы с хранилищем URL.
 //
 // Интерфейс предоставляет методы для:
 //   - сохранения новых URL
 //   - получения URL по идентификатору
-//   - получения всех URL пользователя
+//   - постраничной выборки URL пользователя
+//   - учёта переходов
 //   - удаления URL
 //
 // Реализации:
@@ -23,66 +29,134 @@ import "github.com/Popolzen/shortener/internal/model"
 //
 //	var repo repository.URLRepository
 //	repo = memory.NewURLRepository()
-//	err := repo.Store("abc123", "https://example.com", "user123")
+//	err := repo.Store(ctx, "abc123", "https://example.com", "user123", model.LinkOptions{}, model.LinkMeta{}, 0)
 type URLRepository interface {
 	// Store сохраняет связь между короткой и длинной ссылкой.
 	//
 	// Параметры:
+	//   - ctx: контекст запроса
 	//   - shortURL: идентификатор короткой ссылки
 	//   - longURL: оригинальный URL
 	//   - userID: идентификатор пользователя-владельца
+	//   - opts: настройки перенаправления, сохраняются как есть
+	//   - meta: название, заметка и метки, сохраняются как есть
+	//   - maxLinks: квота на число активных ссылок пользователя, 0 или меньше — без ограничения;
+	//     проверяется атомарно с сохранением
 	//
 	// Возвращает:
-	//   - error: ошибку при сохранении или database.ErrURLConflictError если URL уже существует
+	//   - error: ошибку при сохранении, model.ErrURLConflictError если URL уже существует
+	//     или *model.QuotaError если у пользователя уже maxLinks активных ссылок
 	//
 	// Пример:
-	//   err := repo.Store("abc123", "https://example.com", "user123")
-	Store(shortURL, longURL, userID string) error
+	//   err := repo.Store(ctx, "abc123", "https://example.com", "user123", model.LinkOptions{RedirectCode: 308}, model.LinkMeta{Tags: []string{"docs"}}, 100)
+	Store(ctx context.Context, shortURL, longURL, userID string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int) error
 
-	// Get возвращает оригинальный URL по короткой ссылке.
+	// Get возвращает ссылку с оригинальным URL и настройками по короткой ссылке.
 	//
 	// Параметры:
+	//   - ctx: контекст запроса
 	//   - shortURL: идентификатор короткой ссылки
 	//
 	// Возвращает:
-	//   - string: оригинальный URL
-	//   - error: ошибку если ссылка не найдена или model.ErrURLDeleted если ссылка удалена
+	//   - model.Link: ссылка
+	//   - error: model.ErrURLNotFound если ссылка не найдена или model.ErrURLDeleted если ссылка удалена
 	//
 	// Пример:
-	//   longURL, err := repo.Get("abc123")
+	//   link, err := repo.Get(ctx, "abc123")
 	//   if errors.Is(err, model.ErrURLDeleted) {
 	//       // Обработка удаленной ссылки
 	//   }
-	Get(shortURL string) (string, error)
+	Get(ctx context.Context, shortURL string) (model.Link, error)
+
+	// UpdateLink меняет оригинальный URL, настройки и описание ссылки
+	// пользователя и возвращает её новое состояние.
+	//
+	// Каждое изменение URL или настроек создаёт новую версию ссылки в истории,
+	// номер версии увеличивается на единицу, даже если значения полей
+	// не поменялись. Изменение только описания или хеша пароля версию
+	// не создаёт, хеш пароля в историю не попадает.
+	//
+	// Параметры:
+	//   - ctx: контекст запроса
+	//   - userID: идентификатор пользователя-владельца
+	//   - shortURL: идентификатор короткой ссылки
+	//   - update: изменения, поля nil не меняются
+	//
+	// Возвращает:
+	//   - model.Link: ссылка после изменения
+	//   - error: model.ErrURLNotFound если ссылки нет или она принадлежит другому
+	//     пользователю, model.ErrURLDeleted если ссылка удалена,
+	//     model.ErrURLConflictError если новый URL уже сокращён
+	//
+	// Пример:
+	//   code := http.StatusPermanentRedirect
+	//   link, err := repo.UpdateLink(ctx, "user123", "abc123", model.LinkUpdate{RedirectCode: &code})
+	UpdateLink(ctx context.Context, userID, shortURL string, update model.LinkUpdate) (model.Link, error)
+
+	// GetLinkHistory возвращает версии ссылки пользователя от первой к последней.
+	//
+	// Историю удалённой ссылки владелец тоже может получить.
+	//
+	// Возвращает:
+	//   - []model.LinkVersion: версии ссылки
+	//   - error: model.ErrURLNotFound если ссылки нет или она принадлежит другому пользователю
+	//
+	// Пример:
+	//   versions, err := repo.GetLinkHistory(ctx, "user123", "abc123")
+	GetLinkHistory(ctx context.Context, userID, shortURL string) ([]model.LinkVersion, error)
 
-	// GetUserURLs возвращает все URL пользователя.
+	// GetUserURLs возвращает страницу ссылок пользователя.
+	//
+	// Ссылки отбираются фильтрами q, упорядочиваются по q.Sort и q.Desc
+	// (при равных ключах — по короткой ссылке) и начинаются сразу после q.After.
 	//
 	// Параметры:
+	//   - ctx: контекст запроса
 	//   - userID: идентификатор пользователя
+	//   - q: фильтры, сортировка, курсор и размер страницы
 	//
 	// Возвращает:
-	//   - []model.URLPair: массив пар коротких и оригинальных URL
+	//   - []model.UserURL: не больше q.Limit ссылок
 	//   - error: ошибку при получении данных
 	//
-	// Примечание: для in-memory и файлового хранилища возвращает ошибку "not implemented"
+	// Пример:
+	//   urls, err := repo.GetUserURLs(ctx, "user123", model.URLListQuery{Limit: 100, Sort: model.SortClicks, Desc: true})
+	GetUserURLs(ctx context.Context, userID string, q model.URLListQuery) ([]model.UserURL, error)
+
+	// GetUserTags возвращает метки активных ссылок пользователя с числом
+	// ссылок, по убыванию числа, при равенстве — по алфавиту.
 	//
 	// Пример:
-	//   urls, err := repo.GetUserURLs("user123")
-	GetUserURLs(userID string) ([]model.URLPair, error)
+	//   tags, err := repo.GetUserTags(ctx, "user123")
+	GetUserTags(ctx context.Context, userID string) ([]model.TagCount, error)
+
+	// RecordClick учитывает переход по короткой ссылке.
+	//
+	// Примечание: database.URLRepository копит счётчики в памяти и записывает
+	// их пачкой, поэтому в выборке они появляются с задержкой до секунды
+	RecordClick(ctx context.Context, shortURL string)
 
 	// DeleteURLs выполняет удаление URL (для БД - асинхронно).
 	//
 	// Параметры:
+	//   - ctx: контекст запроса, из него берутся идентификатор запроса для логов
+	//     воркеров и контекст трассировки для связи span'а батча с запросом
 	//   - userID: идентификатор пользователя-владельца
 	//   - urlIDs: массив идентификаторов коротких ссылок для удаления
 	//
+	// Возвращает:
+	//   - []string: идентификаторы, принятые к удалению
+	//   - error: model.ErrDeleteQueueFull, если очередь переполнена и часть
+	//     задач не принята
+	//
 	// Примечание:
-	//   - Для database.URLRepository удаление происходит асинхронно через систему воркеров
-	//   - Для memory и filestorage реализации это заглушка
+	//   - Для database.URLRepository удаление происходит асинхронно через систему воркеров,
+	//     ctx не ограничивает время удаления
+	//   - Для memory и filestorage реализации это заглушка, ничего не принимается
 	//
 	// Пример:
-	//   repo.DeleteURLs("user123", []string{"abc123", "def456"})
-	DeleteURLs(userID string, urlIDs []string)
+	//   accepted, err := repo.DeleteURLs(ctx, "user123", []string{"abc123", "def456"})
+	DeleteURLs(ctx context.Context, userID string, urlIDs []string) ([]string, error)
 
 	// GetStats возвращает статистику сервиса.
 	//
@@ -94,8 +168,36 @@ type URLRepository interface {
 	// Примечание: для memory и filestorage может возвращать неполную статистику
 	//
 	// Пример:
-	//   urls, users, err := repo.GetStats()
-	GetStats() (urls int, users int, err error)
+	//   urls, users, err := repo.GetStats(ctx)
+	GetStats(ctx context.Context) (urls int, users int, err error)
+
+	// CountUserURLs возвращает число активных (не удалённых) ссылок пользователя.
+	//
+	// Используется для проверки квоты на число ссылок.
+	//
+	// Пример:
+	//   n, err := repo.CountUserURLs(ctx, "user123")
+	CountUserURLs(ctx context.Context, userID string) (int, error)
 
 	Close() error
 }
+
+// AuditOutbox реализуют хранилища, которые умеют сохранять ссылку и событие
+// аудита атомарно (transactional outbox).
+//
+// Событие попадает в Publisher только после фиксации транзакции, поэтому
+// аудит не теряется при падении процесса и не содержит записей о ссылках,
+// которые не были сохранены.
+//
+// Реализации:
+//   - database.URLRepository
+type AuditOutbox interface {
+	// StoreWithEvent сохраняет ссылку и событие в одной транзакции.
+	// Квота maxLinks и ошибки такие же, как у URLRepository.Store.
+	StoreWithEvent(ctx context.Context, shortURL, longURL, userID string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int, event audit.Event) error
+
+	// UpdateLinkWithEvent изменяет ссылку и сохраняет событие в одной транзакции.
+	// URL события заполняется оригинальным URL после изменения.
+	// Ошибки такие же, как у URLRepository.UpdateLink.
+	UpdateLinkWithEvent(ctx context.Context, userID, shortURL string, update model.LinkUpdate, event audit.Event) (model.Link, error)
+}
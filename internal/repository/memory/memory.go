@@ -1,33 +1,130 @@
 package memory
 
 import (
-	"fmt"
+	"context"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/Popolzen/shortener/internal/model"
+	"go.uber.org/zap"
 )
 
 type URLRepository struct {
+	// mu защищает карты: переходы пишут счётчики на пути чтения
+	mu           sync.RWMutex
 	urls         map[string]string
 	correlations map[string]string
+	owners       map[string]string              // короткая ссылка -> пользователь
+	userLinks    map[string]int                 // число ссылок пользователя
+	options      map[string]model.LinkOptions   // короткая ссылка -> настройки перенаправления
+	history      map[string][]model.LinkVersion // короткая ссылка -> версии от первой к последней
+	clicks       map[string]int64               // короткая ссылка -> число переходов
+	meta         map[string]model.LinkMeta      // короткая ссылка -> описание
 }
 
-func (r URLRepository) Get(shortURL string) (string, error) {
+func (r *URLRepository) Get(ctx context.Context, shortURL string) (model.Link, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(shortURL)
+}
 
+// get возвращает ссылку, вызывается под r.mu
+func (r *URLRepository) get(shortURL string) (model.Link, error) {
 	if longURL, exists := r.urls[shortURL]; exists {
-		return longURL, nil
+		return model.Link{
+			ShortURL:    shortURL,
+			OriginalURL: longURL,
+			UserID:      r.owners[shortURL],
+			Version:     len(r.history[shortURL]),
+			LinkOptions: r.options[shortURL],
+			LinkMeta:    cloneMeta(r.meta[shortURL]),
+		}, nil
 	}
-	return "", fmt.Errorf("URL not found")
+	return model.Link{}, model.ErrURLNotFound
 }
 
-func (r *URLRepository) Store(shortURL, longURL, _ string) error {
-	r.urls[shortURL] = longURL
+func (r *URLRepository) Store(ctx context.Context, shortURL, longURL, userID string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.urls[shortURL]; !exists && userID != "" {
+		if used := r.userLinks[userID]; maxLinks > 0 && used >= maxLinks {
+			return model.NewLinkQuotaError(maxLinks, used, 1)
+		}
+		r.owners[shortURL] = userID
+		r.userLinks[userID]++
+	}
+	r.setLink(shortURL, longURL, opts)
+	r.meta[shortURL] = cloneMeta(meta)
 	return nil
 }
 
+// cloneMeta копирует описание, чтобы метки не разделялись с вызывающим.
+// Пустой список меток становится nil.
+func cloneMeta(meta model.LinkMeta) model.LinkMeta {
+	if len(meta.Tags) == 0 {
+		meta.Tags = nil
+	}
+	meta.Tags = slices.Clone(meta.Tags)
+	return meta
+}
+
+// setLink сохраняет состояние ссылки и добавляет его в историю новой версией.
+// Хеш пароля в историю не попадает.
+func (r *URLRepository) setLink(shortURL, longURL string, opts model.LinkOptions) {
+	r.urls[shortURL] = longURL
+	r.options[shortURL] = opts
+	opts.PasswordHash = ""
+	r.history[shortURL] = append(r.history[shortURL], model.LinkVersion{
+		Version:     len(r.history[shortURL]) + 1,
+		OriginalURL: longURL,
+		CreatedAt:   time.Now(),
+		LinkOptions: opts,
+	})
+}
+
+// UpdateLink меняет ссылку пользователя. Изменение URL или настроек
+// добавляет версию в историю.
+func (r *URLRepository) UpdateLink(ctx context.Context, userID, shortURL string, update model.LinkUpdate) (model.Link, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	longURL, exists := r.urls[shortURL]
+	if !exists || r.owners[shortURL] != userID {
+		return model.Link{}, model.ErrURLNotFound
+	}
+	if update.OriginalURL != nil {
+		longURL = *update.OriginalURL
+	}
+	opts := update.Apply(r.options[shortURL])
+	if update.NewVersion() {
+		r.setLink(shortURL, longURL, opts)
+	} else {
+		r.options[shortURL] = opts
+	}
+	r.meta[shortURL] = cloneMeta(update.ApplyMeta(r.meta[shortURL]))
+	return r.get(shortURL)
+}
+
+// GetLinkHistory возвращает версии ссылки пользователя
+func (r *URLRepository) GetLinkHistory(ctx context.Context, userID, shortURL string) ([]model.LinkVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, exists := r.urls[shortURL]; !exists || r.owners[shortURL] != userID {
+		return nil, model.ErrURLNotFound
+	}
+	return slices.Clone(r.history[shortURL]), nil
+}
+
 func NewURLRepository() *URLRepository {
 	return &URLRepository{
 		urls:         map[string]string{},
 		correlations: map[string]string{},
+		owners:       map[string]string{},
+		userLinks:    map[string]int{},
+		options:      map[string]model.LinkOptions{},
+		history:      map[string][]model.LinkVersion{},
+		clicks:       map[string]int64{},
+		meta:         map[string]model.LinkMeta{},
 	}
 }
 
@@ -35,14 +132,66 @@ func (r *URLRepository) StoreBatch() {
 
 }
 
-// memory Repository - заглушки для GetUserURLs
-func (r *URLRepository) GetUserURLs(userID string) ([]model.URLPair, error) {
-	return nil, fmt.Errorf("GetUserURLs not implemented for in-memory storage")
+// GetUserURLs возвращает страницу ссылок пользователя.
+// Удаление в памяти не поддерживается, поэтому все ссылки активные.
+func (r *URLRepository) GetUserURLs(ctx context.Context, userID string, q model.URLListQuery) ([]model.UserURL, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var urls []model.UserURL
+	for shortURL, owner := range r.owners {
+		if owner != userID {
+			continue
+		}
+		u := model.UserURL{
+			ShortURL:    shortURL,
+			OriginalURL: r.urls[shortURL],
+			Clicks:      r.clicks[shortURL],
+			Protected:   r.options[shortURL].Protected(),
+			LinkMeta:    cloneMeta(r.meta[shortURL]),
+		}
+		if versions := r.history[shortURL]; len(versions) > 0 {
+			u.CreatedAt = versions[0].CreatedAt
+		}
+		if q.Match(u) && q.IsAfter(u) {
+			urls = append(urls, u)
+		}
+	}
+	slices.SortFunc(urls, q.Compare)
+	if q.Limit > 0 && len(urls) > q.Limit {
+		urls = urls[:q.Limit]
+	}
+	return urls, nil
+}
+
+// GetUserTags возвращает метки ссылок пользователя с числом ссылок
+func (r *URLRepository) GetUserTags(ctx context.Context, userID string) ([]model.TagCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	counts := map[string]int{}
+	for shortURL, owner := range r.owners {
+		if owner != userID {
+			continue
+		}
+		for _, tag := range r.meta[shortURL].Tags {
+			counts[tag]++
+		}
+	}
+	return model.SortTagCounts(counts), nil
+}
+
+// RecordClick увеличивает счётчик переходов по ссылке
+func (r *URLRepository) RecordClick(ctx context.Context, shortURL string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.urls[shortURL]; exists {
+		r.clicks[shortURL]++
+	}
 }
 
 // memory Repository - заглушки для DeleteURLs
-func (r *URLRepository) DeleteURLs(userID string, urlIDs []string) {
-	fmt.Print("DeteleUrls not implemented for in-memory storage")
+func (r *URLRepository) DeleteURLs(ctx context.Context, userID string, urlIDs []string) ([]string, error) {
+	zap.S().Warn("DeleteURLs не поддерживается этим хранилищем")
+	return nil, nil
 }
 
 func (r *URLRepository) Close() error {
@@ -50,8 +199,17 @@ func (r *URLRepository) Close() error {
 }
 
 // GetStats возвращает статистику (для memory - упрощенная версия)
-func (r *URLRepository) GetStats() (urls int, users int, err error) {
+func (r *URLRepository) GetStats(ctx context.Context) (urls int, users int, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	// В memory репозитории у нас нет информации о пользователях
 	// Возвращаем количество URL и 0 пользователей
 	return len(r.urls), 0, nil
 }
+
+// CountUserURLs возвращает число ссылок пользователя
+func (r *URLRepository) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.userLinks[userID], nil
+}
@@ -1,8 +1,14 @@
 package memory
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Popolzen/shortener/internal/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,61 +24,98 @@ func TestNewURLRepository(t *testing.T) {
 func TestStore_AndGet(t *testing.T) {
 	repo := NewURLRepository()
 
-	err := repo.Store("abc123", "https://example.com", "user-1")
+	err := repo.Store(context.Background(), "abc123", "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
 	require.NoError(t, err)
 
-	longURL, err := repo.Get("abc123")
+	longURL, err := repo.Get(context.Background(), "abc123")
 	require.NoError(t, err)
-	assert.Equal(t, "https://example.com", longURL)
+	assert.Equal(t, "https://example.com", longURL.OriginalURL)
 }
 
 func TestStore_MultipleURLs(t *testing.T) {
 	repo := NewURLRepository()
 
-	repo.Store("a", "https://one.com", "user-1")
-	repo.Store("b", "https://two.com", "user-2")
-	repo.Store("c", "https://three.com", "user-1")
+	repo.Store(context.Background(), "a", "https://one.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "b", "https://two.com", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "c", "https://three.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	url1, err1 := repo.Get("a")
-	url2, err2 := repo.Get("b")
-	url3, err3 := repo.Get("c")
+	url1, err1 := repo.Get(context.Background(), "a")
+	url2, err2 := repo.Get(context.Background(), "b")
+	url3, err3 := repo.Get(context.Background(), "c")
 
 	require.NoError(t, err1)
 	require.NoError(t, err2)
 	require.NoError(t, err3)
 
-	assert.Equal(t, "https://one.com", url1)
-	assert.Equal(t, "https://two.com", url2)
-	assert.Equal(t, "https://three.com", url3)
+	assert.Equal(t, "https://one.com", url1.OriginalURL)
+	assert.Equal(t, "https://two.com", url2.OriginalURL)
+	assert.Equal(t, "https://three.com", url3.OriginalURL)
 }
 
 func TestStore_Overwrite(t *testing.T) {
 	repo := NewURLRepository()
 
-	repo.Store("key", "https://old.com", "user-1")
-	repo.Store("key", "https://new.com", "user-1")
+	repo.Store(context.Background(), "key", "https://old.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "key", "https://new.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
 
-	longURL, _ := repo.Get("key")
-	assert.Equal(t, "https://new.com", longURL)
+	longURL, _ := repo.Get(context.Background(), "key")
+	assert.Equal(t, "https://new.com", longURL.OriginalURL)
 }
 
 func TestGet_NotFound(t *testing.T) {
 	repo := NewURLRepository()
 
-	_, err := repo.Get("notexists")
+	_, err := repo.Get(context.Background(), "notexists")
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not found")
 }
 
-func TestGetUserURLs_NotImplemented(t *testing.T) {
+func TestGetUserURLs(t *testing.T) {
+	ctx := context.Background()
 	repo := NewURLRepository()
+	repo.Store(ctx, "a", "https://one.com/Docs", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(ctx, "b", "https://two.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(ctx, "c", "https://three.com/docs", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(ctx, "d", "https://other.com/docs", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.RecordClick(ctx, "b")
+	repo.RecordClick(ctx, "b")
+	repo.RecordClick(ctx, "c")
+	repo.RecordClick(ctx, "missing")
 
-	urls, err := repo.GetUserURLs("user-1")
+	notDeleted, deleted := false, true
+	tests := []struct {
+		name string
+		q    model.URLListQuery
+		want []string
+	}{
+		{name: "все ссылки пользователя", q: model.URLListQuery{Limit: 10}, want: []string{"a", "b", "c"}},
+		{name: "по переходам по убыванию", q: model.URLListQuery{Limit: 10, Sort: model.SortClicks, Desc: true}, want: []string{"b", "c", "a"}},
+		{name: "ограничение limit", q: model.URLListQuery{Limit: 2, Sort: model.SortClicks, Desc: true}, want: []string{"b", "c"}},
+		{name: "после курсора", q: model.URLListQuery{Limit: 10, Sort: model.SortClicks, Desc: true, After: &model.URLCursor{Clicks: 1, ShortURL: "c"}}, want: []string{"a"}},
+		{name: "поиск по URL без учёта регистра", q: model.URLListQuery{Limit: 10, URL: "DOCS"}, want: []string{"a", "c"}},
+		{name: "только активные", q: model.URLListQuery{Limit: 10, Deleted: &notDeleted}, want: []string{"a", "b", "c"}},
+		{name: "только удалённые", q: model.URLListQuery{Limit: 10, Deleted: &deleted}, want: nil},
+		{name: "диапазон дат в будущем", q: model.URLListQuery{Limit: 10, CreatedFrom: time.Now().Add(time.Hour)}, want: nil},
+	}
 
-	assert.Error(t, err)
-	assert.Nil(t, urls)
-	assert.Contains(t, err.Error(), "not implemented")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls, err := repo.GetUserURLs(ctx, "user-1", tt.q)
+			require.NoError(t, err)
+			var got []string
+			for _, u := range urls {
+				got = append(got, u.ShortURL)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	urls, err := repo.GetUserURLs(ctx, "user-1", model.URLListQuery{Limit: 1, Sort: model.SortClicks, Desc: true})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, int64(2), urls[0].Clicks)
+	assert.False(t, urls[0].CreatedAt.IsZero())
 }
 
 func TestDeleteURLs_NotPanics(t *testing.T) {
@@ -80,7 +123,7 @@ func TestDeleteURLs_NotPanics(t *testing.T) {
 
 	// Не должно паниковать
 	assert.NotPanics(t, func() {
-		repo.DeleteURLs("user-1", []string{"abc", "def"})
+		repo.DeleteURLs(context.Background(), "user-1", []string{"abc", "def"})
 	})
 }
 
@@ -88,13 +131,173 @@ func TestStore_IgnoresUserID(t *testing.T) {
 	repo := NewURLRepository()
 
 	// userID игнорируется в memory реализации
-	repo.Store("x", "https://x.com", "user-1")
-	repo.Store("y", "https://y.com", "user-2")
+	repo.Store(context.Background(), "x", "https://x.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "y", "https://y.com", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0)
 
 	// Оба URL доступны без привязки к пользователю
-	url1, _ := repo.Get("x")
-	url2, _ := repo.Get("y")
+	url1, _ := repo.Get(context.Background(), "x")
+	url2, _ := repo.Get(context.Background(), "y")
+
+	assert.Equal(t, "https://x.com", url1.OriginalURL)
+	assert.Equal(t, "https://y.com", url2.OriginalURL)
+}
+
+func TestCountUserURLs(t *testing.T) {
+	repo := NewURLRepository()
+
+	repo.Store(context.Background(), "a", "https://one.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "b", "https://two.com", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "c", "https://three.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+	repo.Store(context.Background(), "c", "https://three.com/new", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+
+	n, err := repo.CountUserURLs(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = repo.CountUserURLs(context.Background(), "unknown")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestStore_KeepsLinkOptions(t *testing.T) {
+	repo := NewURLRepository()
+	opts := model.LinkOptions{RedirectCode: 308, QueryPassthrough: true}
+	require.NoError(t, repo.Store(context.Background(), "abc", "https://abc.com", "user", opts, model.LinkMeta{}, 0))
+
+	link, err := repo.Get(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, opts, link.LinkOptions)
+	assert.Equal(t, "user", link.UserID)
+}
+
+func TestUpdateLink(t *testing.T) {
+	repo := NewURLRepository()
+	repo.Store(context.Background(), "abc", "https://abc.com", "owner", model.LinkOptions{RedirectCode: 302}, model.LinkMeta{}, 0)
+
+	passthrough := true
+	link, err := repo.UpdateLink(context.Background(), "owner", "abc", model.LinkUpdate{QueryPassthrough: &passthrough})
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkOptions{RedirectCode: 302, QueryPassthrough: true}, link.LinkOptions)
+
+	_, err = repo.UpdateLink(context.Background(), "stranger", "abc", model.LinkUpdate{QueryPassthrough: &passthrough})
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+
+	_, err = repo.UpdateLink(context.Background(), "owner", "missing", model.LinkUpdate{})
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+}
+
+func TestGetLinkHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := NewURLRepository()
+	repo.Store(ctx, "abc", "https://abc.com", "owner", model.LinkOptions{}, model.LinkMeta{}, 0)
+
+	newURL := "https://abc.com/new"
+	code := 308
+	link, err := repo.UpdateLink(ctx, "owner", "abc", model.LinkUpdate{OriginalURL: &newURL, RedirectCode: &code})
+	require.NoError(t, err)
+	assert.Equal(t, newURL, link.OriginalURL)
+	assert.Equal(t, 2, link.Version)
 
-	assert.Equal(t, "https://x.com", url1)
-	assert.Equal(t, "https://y.com", url2)
+	versions, err := repo.GetLinkHistory(ctx, "owner", "abc")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, model.LinkVersion{Version: 1, OriginalURL: "https://abc.com", CreatedAt: versions[0].CreatedAt}, versions[0])
+	assert.Equal(t, model.LinkVersion{Version: 2, OriginalURL: newURL, CreatedAt: versions[1].CreatedAt, LinkOptions: model.LinkOptions{RedirectCode: 308}}, versions[1])
+
+	_, err = repo.GetLinkHistory(ctx, "stranger", "abc")
+	assert.ErrorIs(t, err, model.ErrURLNotFound)
+}
+
+func TestLinkMeta(t *testing.T) {
+	ctx := context.Background()
+	repo := NewURLRepository()
+	repo.Store(ctx, "a", "https://one.com", "user-1", model.LinkOptions{}, model.LinkMeta{Title: "Один", Tags: []string{"docs", "work"}}, 0)
+	repo.Store(ctx, "b", "https://two.com", "user-1", model.LinkOptions{}, model.LinkMeta{Tags: []string{"work"}}, 0)
+	repo.Store(ctx, "c", "https://three.com", "user-2", model.LinkOptions{}, model.LinkMeta{Tags: []string{"docs"}}, 0)
+
+	link, err := repo.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, model.LinkMeta{Title: "Один", Tags: []string{"docs", "work"}}, link.LinkMeta)
+
+	tags, err := repo.GetUserTags(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "work", Count: 2}, {Tag: "docs", Count: 1}}, tags)
+
+	urls, err := repo.GetUserURLs(ctx, "user-1", model.URLListQuery{Limit: 10, Tag: "docs"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "a", urls[0].ShortURL)
+	assert.Equal(t, "Один", urls[0].Title)
+
+	// Изменение описания не создаёт версию
+	note := "заметка"
+	link, err = repo.UpdateLink(ctx, "user-1", "a", model.LinkUpdate{Note: &note, Tags: &[]string{}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, link.Version)
+	assert.Equal(t, model.LinkMeta{Title: "Один", Note: "заметка"}, link.LinkMeta)
+
+	tags, err = repo.GetUserTags(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "work", Count: 1}}, tags)
+}
+
+func TestConcurrentRedirects(t *testing.T) {
+	repo := NewURLRepository()
+	ctx := context.Background()
+	require.NoError(t, repo.Store(ctx, "abc123", "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0))
+
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_, err := repo.Get(ctx, "abc123")
+				assert.NoError(t, err)
+				repo.RecordClick(ctx, "abc123")
+			}
+			if i%8 == 0 {
+				repo.Store(ctx, fmt.Sprintf("key%d", i), "https://other.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, 0)
+			}
+		}()
+	}
+	wg.Wait()
+
+	urls, err := repo.GetUserURLs(ctx, "user-1", model.URLListQuery{Limit: 100, URL: "example"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, int64(64*100), urls[0].Clicks)
+}
+
+func TestStore_LinkQuotaConcurrent(t *testing.T) {
+	repo := NewURLRepository()
+	ctx := context.Background()
+
+	// Параллельные сохранения одного пользователя не превышают квоту
+	errs := make([]error, 32)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = repo.Store(ctx, fmt.Sprintf("q%05d", i), fmt.Sprintf("https://quota-%d.com", i), "user-1", model.LinkOptions{}, model.LinkMeta{}, 5)
+		}()
+	}
+	wg.Wait()
+
+	stored := 0
+	for _, err := range errs {
+		var quotaErr *model.QuotaError
+		if errors.As(err, &quotaErr) {
+			assert.Equal(t, int64(5), quotaErr.Limit)
+			continue
+		}
+		require.NoError(t, err)
+		stored++
+	}
+	assert.Equal(t, 5, stored)
+
+	n, err := repo.CountUserURLs(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
 }
@@ -10,6 +10,7 @@
 package mocks
 
 import (
+	context "context"
 	reflect "reflect"
 
 	model "github.com/Popolzen/shortener/internal/model"
@@ -54,37 +55,70 @@ func (mr *MockURLRepositoryMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockURLRepository)(nil).Close))
 }
 
+// CountUserURLs mocks base method.
+func (m *MockURLRepository) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountUserURLs", ctx, userID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountUserURLs indicates an expected call of CountUserURLs.
+func (mr *MockURLRepositoryMockRecorder) CountUserURLs(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountUserURLs", reflect.TypeOf((*MockURLRepository)(nil).CountUserURLs), ctx, userID)
+}
+
 // DeleteURLs mocks base method.
-func (m *MockURLRepository) DeleteURLs(userID string, urlIDs []string) {
+func (m *MockURLRepository) DeleteURLs(ctx context.Context, userID string, urlIDs []string) ([]string, error) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "DeleteURLs", userID, urlIDs)
+	ret := m.ctrl.Call(m, "DeleteURLs", ctx, userID, urlIDs)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // DeleteURLs indicates an expected call of DeleteURLs.
-func (mr *MockURLRepositoryMockRecorder) DeleteURLs(userID, urlIDs any) *gomock.Call {
+func (mr *MockURLRepositoryMockRecorder) DeleteURLs(ctx, userID, urlIDs any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteURLs", reflect.TypeOf((*MockURLRepository)(nil).DeleteURLs), userID, urlIDs)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteURLs", reflect.TypeOf((*MockURLRepository)(nil).DeleteURLs), ctx, userID, urlIDs)
 }
 
 // Get mocks base method.
-func (m *MockURLRepository) Get(shortURL string) (string, error) {
+func (m *MockURLRepository) Get(ctx context.Context, shortURL string) (model.Link, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Get", shortURL)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Get", ctx, shortURL)
+	ret0, _ := ret[0].(model.Link)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Get indicates an expected call of Get.
-func (mr *MockURLRepositoryMockRecorder) Get(shortURL any) *gomock.Call {
+func (mr *MockURLRepositoryMockRecorder) Get(ctx, shortURL any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockURLRepository)(nil).Get), shortURL)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockURLRepository)(nil).Get), ctx, shortURL)
+}
+
+// GetLinkHistory mocks base method.
+func (m *MockURLRepository) GetLinkHistory(ctx context.Context, userID, shortURL string) ([]model.LinkVersion, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLinkHistory", ctx, userID, shortURL)
+	ret0, _ := ret[0].([]model.LinkVersion)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLinkHistory indicates an expected call of GetLinkHistory.
+func (mr *MockURLRepositoryMockRecorder) GetLinkHistory(ctx, userID, shortURL any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLinkHistory", reflect.TypeOf((*MockURLRepository)(nil).GetLinkHistory), ctx, userID, shortURL)
 }
 
 // GetStats mocks base method.
-func (m *MockURLRepository) GetStats() (int, int, error) {
+func (m *MockURLRepository) GetStats(ctx context.Context) (int, int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetStats")
+	ret := m.ctrl.Call(m, "GetStats", ctx)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(int)
 	ret2, _ := ret[2].(error)
@@ -92,36 +126,78 @@ func (m *MockURLRepository) GetStats() (int, int, error) {
 }
 
 // GetStats indicates an expected call of GetStats.
-func (mr *MockURLRepositoryMockRecorder) GetStats() *gomock.Call {
+func (mr *MockURLRepositoryMockRecorder) GetStats(ctx any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStats", reflect.TypeOf((*MockURLRepository)(nil).GetStats))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStats", reflect.TypeOf((*MockURLRepository)(nil).GetStats), ctx)
+}
+
+// GetUserTags mocks base method.
+func (m *MockURLRepository) GetUserTags(ctx context.Context, userID string) ([]model.TagCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserTags", ctx, userID)
+	ret0, _ := ret[0].([]model.TagCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserTags indicates an expected call of GetUserTags.
+func (mr *MockURLRepositoryMockRecorder) GetUserTags(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserTags", reflect.TypeOf((*MockURLRepository)(nil).GetUserTags), ctx, userID)
 }
 
 // GetUserURLs mocks base method.
-func (m *MockURLRepository) GetUserURLs(userID string) ([]model.URLPair, error) {
+func (m *MockURLRepository) GetUserURLs(ctx context.Context, userID string, q model.URLListQuery) ([]model.UserURL, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserURLs", userID)
-	ret0, _ := ret[0].([]model.URLPair)
+	ret := m.ctrl.Call(m, "GetUserURLs", ctx, userID, q)
+	ret0, _ := ret[0].([]model.UserURL)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUserURLs indicates an expected call of GetUserURLs.
-func (mr *MockURLRepositoryMockRecorder) GetUserURLs(userID any) *gomock.Call {
+func (mr *MockURLRepositoryMockRecorder) GetUserURLs(ctx, userID, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLs", reflect.TypeOf((*MockURLRepository)(nil).GetUserURLs), ctx, userID, q)
+}
+
+// RecordClick mocks base method.
+func (m *MockURLRepository) RecordClick(ctx context.Context, shortURL string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordClick", ctx, shortURL)
+}
+
+// RecordClick indicates an expected call of RecordClick.
+func (mr *MockURLRepositoryMockRecorder) RecordClick(ctx, shortURL any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLs", reflect.TypeOf((*MockURLRepository)(nil).GetUserURLs), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordClick", reflect.TypeOf((*MockURLRepository)(nil).RecordClick), ctx, shortURL)
 }
 
 // Store mocks base method.
-func (m *MockURLRepository) Store(shortURL, longURL, userID string) error {
+func (m *MockURLRepository) Store(ctx context.Context, shortURL, longURL, userID string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Store", shortURL, longURL, userID)
+	ret := m.ctrl.Call(m, "Store", ctx, shortURL, longURL, userID, opts, meta, maxLinks)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Store indicates an expected call of Store.
-func (mr *MockURLRepositoryMockRecorder) Store(shortURL, longURL, userID any) *gomock.Call {
+func (mr *MockURLRepositoryMockRecorder) Store(ctx, shortURL, longURL, userID, opts, meta, maxLinks any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockURLRepository)(nil).Store), ctx, shortURL, longURL, userID, opts, meta, maxLinks)
+}
+
+// UpdateLink mocks base method.
+func (m *MockURLRepository) UpdateLink(ctx context.Context, userID, shortURL string, update model.LinkUpdate) (model.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateLink", ctx, userID, shortURL, update)
+	ret0, _ := ret[0].(model.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateLink indicates an expected call of UpdateLink.
+func (mr *MockURLRepositoryMockRecorder) UpdateLink(ctx, userID, shortURL, update any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockURLRepository)(nil).Store), shortURL, longURL, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLink", reflect.TypeOf((*MockURLRepository)(nil).UpdateLink), ctx, userID, shortURL, update)
 }
@@ -0,0 +1,169 @@
+package shortener
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/telemetry"
+)
+
+const (
+	// DefaultPageLimit размер страницы ссылок пользователя по умолчанию
+	DefaultPageLimit = 100
+	// MaxPageLimit наибольший размер страницы, больший limit уменьшается до него
+	MaxPageLimit = 1000
+)
+
+// cursorPayload содержимое курсора. Сортировка сохраняется в курсоре,
+// чтобы курсор одной сортировки не применялся к другой.
+type cursorPayload struct {
+	Sort      model.URLSort `json:"s"`
+	Desc      bool          `json:"d,omitempty"`
+	CreatedAt int64         `json:"t,omitempty"` // unix-время в наносекундах
+	Clicks    int64         `json:"c,omitempty"`
+	ShortURL  string        `json:"id"`
+}
+
+// GetUserURLs возвращает страницу ссылок пользователя.
+//
+// Параметры:
+//   - ctx: контекст запроса
+//   - userID: идентификатор пользователя
+//   - q: фильтры, сортировка, Cursor из предыдущей страницы и Limit
+//
+// Limit 0 заменяется на DefaultPageLimit, больший MaxPageLimit — на MaxPageLimit.
+// Курсор опирается на ключ сортировки последней ссылки страницы, поэтому
+// при сортировке по переходам ссылки, число переходов которых изменилось
+// между запросами, могут пропасть или повториться.
+//
+// Возвращает:
+//   - model.URLPage: ссылки и курсор следующей страницы
+//   - error: *model.ValidationError для некорректной сортировки или курсора
+//
+// Пример использования:
+//
+//	page, err := service.GetUserURLs(ctx, "user123", model.URLListQuery{Sort: model.SortClicks, Desc: true})
+//	next, err := service.GetUserURLs(ctx, "user123", model.URLListQuery{Sort: model.SortClicks, Desc: true, Cursor: page.NextCursor})
+func (s URLService) GetUserURLs(ctx context.Context, userID string, q model.URLListQuery) (_ model.URLPage, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.GetUserURLs")
+	defer telemetry.End(span, &err)
+
+	q, err = normalizeListQuery(q)
+	if err != nil {
+		return model.URLPage{}, err
+	}
+
+	// Лишняя ссылка показывает, что есть следующая страница
+	limit := q.Limit
+	q.Limit++
+	urls, err := s.repo.GetUserURLs(ctx, userID, q)
+	if err != nil {
+		return model.URLPage{}, err
+	}
+
+	page := model.URLPage{URLs: urls}
+	if len(urls) > limit {
+		page.URLs = urls[:limit]
+		page.NextCursor = encodeCursor(q, page.URLs[limit-1])
+	}
+	return page, nil
+}
+
+// GetFormattedUserURLs возвращает страницу ссылок пользователя с полными
+// короткими ссылками.
+//
+// Параметры и ошибки такие же, как у GetUserURLs, baseURL — базовый URL
+// сервиса (например, "http://localhost:8080").
+//
+// Пример использования:
+//
+//	page, err := service.GetFormattedUserURLs(ctx, "user123", "http://localhost:8080", model.URLListQuery{})
+//	for _, url := range page.URLs {
+//	    fmt.Printf("%s -> %s\n", url.ShortURL, url.OriginalURL)
+//	}
+func (s URLService) GetFormattedUserURLs(ctx context.Context, userID string, baseURL string, q model.URLListQuery) (model.URLPage, error) {
+	page, err := s.GetUserURLs(ctx, userID, q)
+	if err != nil {
+		return model.URLPage{}, err
+	}
+	for i := range page.URLs {
+		page.URLs[i].ShortURL = baseURL + "/" + page.URLs[i].ShortURL
+	}
+	return page, nil
+}
+
+// normalizeListQuery проверяет сортировку, подставляет размер страницы
+// и разбирает курсор
+func normalizeListQuery(q model.URLListQuery) (model.URLListQuery, error) {
+	switch q.Sort {
+	case "":
+		q.Sort = model.SortCreatedAt
+	case model.SortCreatedAt, model.SortClicks:
+	default:
+		return q, &model.ValidationError{Field: "sort", Message: "ожидается created_at или clicks"}
+	}
+
+	switch {
+	case q.Limit < 0:
+		return q, &model.ValidationError{Field: "limit", Message: "ожидается положительное число"}
+	case q.Limit == 0:
+		q.Limit = DefaultPageLimit
+	case q.Limit > MaxPageLimit:
+		q.Limit = MaxPageLimit
+	}
+
+	q.Tag = normalizeTag(q.Tag)
+
+	q.After = nil
+	if q.Cursor != "" {
+		after, err := decodeCursor(q)
+		if err != nil {
+			return q, err
+		}
+		q.After = &after
+	}
+	return q, nil
+}
+
+// encodeCursor возвращает курсор страницы, следующей за u
+func encodeCursor(q model.URLListQuery, u model.UserURL) string {
+	payload := cursorPayload{
+		Sort:     q.Sort,
+		Desc:     q.Desc,
+		ShortURL: u.ShortURL,
+	}
+	if q.Sort == model.SortClicks {
+		payload.Clicks = u.Clicks
+	} else if !u.CreatedAt.IsZero() {
+		// У записей старого формата время создания неизвестно
+		payload.CreatedAt = u.CreatedAt.UnixNano()
+	}
+	data, _ := json.Marshal(payload)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor разбирает q.Cursor и проверяет, что он выдан для той же сортировки
+func decodeCursor(q model.URLListQuery) (model.URLCursor, error) {
+	invalid := &model.ValidationError{Field: "cursor", Message: "некорректный курсор"}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return model.URLCursor{}, invalid
+	}
+	var payload cursorPayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.ShortURL == "" {
+		return model.URLCursor{}, invalid
+	}
+	if payload.Sort != q.Sort || payload.Desc != q.Desc {
+		return model.URLCursor{}, &model.ValidationError{Field: "cursor", Message: "курсор выдан для другой сортировки"}
+	}
+
+	cursor := model.URLCursor{Clicks: payload.Clicks, ShortURL: payload.ShortURL}
+	if payload.CreatedAt != 0 {
+		cursor.CreatedAt = time.Unix(0, payload.CreatedAt)
+	}
+	return cursor, nil
+}
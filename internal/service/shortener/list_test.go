@@ -0,0 +1,149 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/repository/memory"
+	"github.com/Popolzen/shortener/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNormalizeListQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		q         model.URLListQuery
+		wantLimit int
+		wantSort  model.URLSort
+		wantField string
+	}{
+		{name: "по умолчанию", q: model.URLListQuery{}, wantLimit: DefaultPageLimit, wantSort: model.SortCreatedAt},
+		{name: "большой limit уменьшается", q: model.URLListQuery{Limit: 5000, Sort: model.SortClicks}, wantLimit: MaxPageLimit, wantSort: model.SortClicks},
+		{name: "отрицательный limit", q: model.URLListQuery{Limit: -1}, wantField: "limit"},
+		{name: "неизвестная сортировка", q: model.URLListQuery{Sort: "title"}, wantField: "sort"},
+		{name: "битый курсор", q: model.URLListQuery{Cursor: "!!!"}, wantField: "cursor"},
+		{name: "курсор не JSON", q: model.URLListQuery{Cursor: "bm90LWpzb24"}, wantField: "cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := normalizeListQuery(tt.q)
+			if tt.wantField != "" {
+				var validation *model.ValidationError
+				require.ErrorAs(t, err, &validation)
+				assert.Equal(t, tt.wantField, validation.Field)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLimit, q.Limit)
+			assert.Equal(t, tt.wantSort, q.Sort)
+		})
+	}
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	created := time.Date(2025, 3, 1, 10, 0, 0, 123456789, time.UTC)
+	u := model.UserURL{ShortURL: "abc123", CreatedAt: created, Clicks: 42}
+
+	byCreated := model.URLListQuery{Sort: model.SortCreatedAt, Desc: true}
+	byCreated.Cursor = encodeCursor(byCreated, u)
+	cursor, err := decodeCursor(byCreated)
+	require.NoError(t, err)
+	assert.True(t, created.Equal(cursor.CreatedAt))
+	assert.Equal(t, "abc123", cursor.ShortURL)
+
+	byClicks := model.URLListQuery{Sort: model.SortClicks}
+	byClicks.Cursor = encodeCursor(byClicks, u)
+	cursor, err = decodeCursor(byClicks)
+	require.NoError(t, err)
+	assert.Equal(t, model.URLCursor{Clicks: 42, ShortURL: "abc123"}, cursor)
+
+	// Курсор одной сортировки не подходит к другой
+	byClicks.Desc = true
+	_, err = decodeCursor(byClicks)
+	var validation *model.ValidationError
+	require.ErrorAs(t, err, &validation)
+	assert.Equal(t, "cursor", validation.Field)
+}
+
+func TestGetUserURLs_Page(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	repo.EXPECT().GetUserURLs(gomock.Any(), "user-1", gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, q model.URLListQuery) ([]model.UserURL, error) {
+			assert.Equal(t, 3, q.Limit)
+			return []model.UserURL{
+				{ShortURL: "c", CreatedAt: created.Add(2 * time.Hour)},
+				{ShortURL: "b", CreatedAt: created.Add(time.Hour)},
+				{ShortURL: "a", CreatedAt: created},
+			}, nil
+		})
+
+	service := NewURLService(repo)
+	page, err := service.GetUserURLs(context.Background(), "user-1", model.URLListQuery{Limit: 2, Desc: true})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 2)
+	require.NotEmpty(t, page.NextCursor)
+
+	cursor, err := decodeCursor(model.URLListQuery{Sort: model.SortCreatedAt, Desc: true, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, "b", cursor.ShortURL)
+	assert.True(t, created.Add(time.Hour).Equal(cursor.CreatedAt))
+}
+
+func TestGetUserURLs_WalkAllPagesInMemory(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewURLRepository()
+	for i := range 7 {
+		require.NoError(t, repo.Store(ctx, fmt.Sprintf("link%02d", i), fmt.Sprintf("https://example.com/%d", i), "user-1", model.LinkOptions{}, model.LinkMeta{}, 0))
+		for range i % 3 {
+			repo.RecordClick(ctx, fmt.Sprintf("link%02d", i))
+		}
+	}
+	require.NoError(t, repo.Store(ctx, "other1", "https://example.com/other", "user-2", model.LinkOptions{}, model.LinkMeta{}, 0))
+	service := NewURLService(repo)
+
+	tests := []struct {
+		name string
+		q    model.URLListQuery
+	}{
+		{name: "по времени создания", q: model.URLListQuery{Limit: 3, Desc: true}},
+		{name: "по переходам", q: model.URLListQuery{Limit: 2, Sort: model.SortClicks, Desc: true}},
+		{name: "по переходам по возрастанию", q: model.URLListQuery{Limit: 4, Sort: model.SortClicks}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var all []model.UserURL
+			q := tt.q
+			for pages := 0; ; pages++ {
+				require.Less(t, pages, 10, "пагинация не завершилась")
+				page, err := service.GetUserURLs(ctx, "user-1", q)
+				require.NoError(t, err)
+				all = append(all, page.URLs...)
+				if page.NextCursor == "" {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+
+			require.Len(t, all, 7)
+			seen := map[string]bool{}
+			for i, u := range all {
+				assert.False(t, seen[u.ShortURL], "ссылка %s повторилась", u.ShortURL)
+				seen[u.ShortURL] = true
+				if i > 0 {
+					nq, err := normalizeListQuery(tt.q)
+					require.NoError(t, err)
+					assert.Negative(t, nq.Compare(all[i-1], u), "нарушен порядок выдачи")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/telemetry"
+)
+
+const (
+	// MaxTitleLength наибольшая длина названия ссылки в символах
+	MaxTitleLength = 256
+	// MaxNoteLength наибольшая длина заметки к ссылке в символах
+	MaxNoteLength = 4096
+	// MaxTags наибольшее число меток у ссылки
+	MaxTags = 20
+	// MaxTagLength наибольшая длина метки в символах
+	MaxTagLength = 64
+)
+
+// NormalizeLinkMeta обрезает пробелы по краям названия, заметки и меток,
+// приводит метки к нижнему регистру, убирает повторы и сортирует их.
+//
+// Возвращает *model.ValidationError с полем title, note или tags, если
+// значения превышают ограничения или метка пустая либо содержит
+// управляющие символы.
+func NormalizeLinkMeta(meta model.LinkMeta) (model.LinkMeta, error) {
+	meta.Title = strings.TrimSpace(meta.Title)
+	if utf8.RuneCountInString(meta.Title) > MaxTitleLength {
+		return model.LinkMeta{}, &model.ValidationError{Field: "title", Message: fmt.Sprintf("ожидается не больше %d символов", MaxTitleLength)}
+	}
+	meta.Note = strings.TrimSpace(meta.Note)
+	if utf8.RuneCountInString(meta.Note) > MaxNoteLength {
+		return model.LinkMeta{}, &model.ValidationError{Field: "note", Message: fmt.Sprintf("ожидается не больше %d символов", MaxNoteLength)}
+	}
+	tags, err := normalizeTags(meta.Tags)
+	if err != nil {
+		return model.LinkMeta{}, err
+	}
+	meta.Tags = tags
+	return meta, nil
+}
+
+// normalizeTags приводит метки к виду, в котором они хранятся
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		switch {
+		case tag == "":
+			return nil, &model.ValidationError{Field: "tags", Message: "метка не может быть пустой"}
+		case utf8.RuneCountInString(tag) > MaxTagLength:
+			return nil, &model.ValidationError{Field: "tags", Message: fmt.Sprintf("ожидается метка не длиннее %d символов", MaxTagLength)}
+		case strings.ContainsFunc(tag, unicode.IsControl):
+			return nil, &model.ValidationError{Field: "tags", Message: "метка содержит управляющие символы"}
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+	if len(normalized) > MaxTags {
+		return nil, &model.ValidationError{Field: "tags", Message: fmt.Sprintf("ожидается не больше %d меток", MaxTags)}
+	}
+	return normalized, nil
+}
+
+// normalizeTag приводит метку к нижнему регистру без пробелов по краям
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalizeLinkUpdate нормализует изменённые поля описания, как NormalizeLinkMeta.
+// Пустой список меток остаётся непустым указателем, чтобы метки удалились.
+func normalizeLinkUpdate(update model.LinkUpdate) (model.LinkUpdate, error) {
+	if update.Title == nil && update.Note == nil && update.Tags == nil {
+		return update, nil
+	}
+	meta, err := NormalizeLinkMeta(update.ApplyMeta(model.LinkMeta{}))
+	if err != nil {
+		return update, err
+	}
+	if update.Title != nil {
+		update.Title = &meta.Title
+	}
+	if update.Note != nil {
+		update.Note = &meta.Note
+	}
+	if update.Tags != nil {
+		tags := meta.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		update.Tags = &tags
+	}
+	return update, nil
+}
+
+// GetUserTags возвращает метки активных ссылок пользователя с числом ссылок,
+// по убыванию числа, при равенстве — по алфавиту.
+//
+// Пример использования:
+//
+//	tags, err := service.GetUserTags(ctx, "user123")
+//	for _, t := range tags {
+//	    fmt.Printf("%s: %d\n", t.Tag, t.Count)
+//	}
+func (s URLService) GetUserTags(ctx context.Context, userID string) (_ []model.TagCount, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.GetUserTags")
+	defer telemetry.End(span, &err)
+
+	return s.repo.GetUserTags(ctx, userID)
+}
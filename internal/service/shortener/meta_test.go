@@ -0,0 +1,139 @@
+package shortener
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/repository/memory"
+	"github.com/Popolzen/shortener/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNormalizeLinkMeta(t *testing.T) {
+	manyTags := make([]string, MaxTags+1)
+	for i := range manyTags {
+		manyTags[i] = strings.Repeat("t", i+1)
+	}
+
+	tests := []struct {
+		name      string
+		meta      model.LinkMeta
+		want      model.LinkMeta
+		wantField string
+	}{
+		{name: "пустое описание", meta: model.LinkMeta{}, want: model.LinkMeta{}},
+		{
+			name: "пробелы, регистр и повторы",
+			meta: model.LinkMeta{Title: "  Отчёт ", Note: "\tзаметка\n", Tags: []string{"Work", " docs", "work", "DOCS"}},
+			want: model.LinkMeta{Title: "Отчёт", Note: "заметка", Tags: []string{"docs", "work"}},
+		},
+		{name: "повторы не считаются в лимит", meta: model.LinkMeta{Tags: append(manyTags[:MaxTags:MaxTags], manyTags[0])}, want: model.LinkMeta{Tags: manyTags[:MaxTags]}},
+		{name: "длинное название", meta: model.LinkMeta{Title: strings.Repeat("я", MaxTitleLength+1)}, wantField: "title"},
+		{name: "длинная заметка", meta: model.LinkMeta{Note: strings.Repeat("n", MaxNoteLength+1)}, wantField: "note"},
+		{name: "пустая метка", meta: model.LinkMeta{Tags: []string{"docs", "  "}}, wantField: "tags"},
+		{name: "длинная метка", meta: model.LinkMeta{Tags: []string{strings.Repeat("t", MaxTagLength+1)}}, wantField: "tags"},
+		{name: "управляющий символ", meta: model.LinkMeta{Tags: []string{"a\x00b"}}, wantField: "tags"},
+		{name: "слишком много меток", meta: model.LinkMeta{Tags: manyTags}, wantField: "tags"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := NormalizeLinkMeta(tt.meta)
+			if tt.wantField != "" {
+				var validation *model.ValidationError
+				require.ErrorAs(t, err, &validation)
+				assert.Equal(t, tt.wantField, validation.Field)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, meta)
+		})
+	}
+}
+
+func TestShortenAudited_StoresNormalizedMeta(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{Title: "Пример", Tags: []string{"docs", "work"}}, gomock.Any()).
+		Return(nil)
+
+	service := NewURLService(repo)
+	_, err := service.ShortenAudited(context.Background(), "https://example.com", "user-1", model.LinkOptions{},
+		model.LinkMeta{Title: " Пример ", Tags: []string{"Work", "docs"}}, audit.Event{}, nil)
+	require.NoError(t, err)
+}
+
+func TestShortenBatch_InvalidMeta(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+
+	service := NewURLService(repo)
+	links := []model.Link{
+		{OriginalURL: "https://one.com"},
+		{OriginalURL: "https://two.com", LinkMeta: model.LinkMeta{Tags: []string{""}}},
+	}
+	_, err := service.ShortenBatch(context.Background(), links, "user-1", audit.Event{}, nil)
+
+	var validation *model.ValidationError
+	require.ErrorAs(t, err, &validation)
+	assert.Equal(t, "tags", validation.Field)
+	assert.Contains(t, err.Error(), "элемент 1")
+}
+
+func TestUpdateLink_NormalizesMeta(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	title := "Отчёт"
+	want := model.LinkUpdate{Title: &title, Tags: &[]string{}}
+	repo.EXPECT().UpdateLink(gomock.Any(), "user-1", "abc123", want).
+		Return(model.Link{ShortURL: "abc123", LinkMeta: model.LinkMeta{Title: title}}, nil)
+
+	service := NewURLService(repo)
+	rawTitle := "  Отчёт  "
+	link, err := service.UpdateLink(context.Background(), "user-1", "abc123", model.LinkUpdate{Title: &rawTitle, Tags: &[]string{}})
+	require.NoError(t, err)
+	assert.Equal(t, title, link.Title)
+}
+
+func TestMetaInMemory(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewURLRepository()
+	service := NewURLService(repo)
+
+	first, err := service.ShortenAudited(ctx, "https://one.com", "user-1", model.LinkOptions{},
+		model.LinkMeta{Title: "Один", Tags: []string{"Work", "docs"}}, audit.Event{}, nil)
+	require.NoError(t, err)
+	_, err = service.ShortenAudited(ctx, "https://two.com", "user-1", model.LinkOptions{},
+		model.LinkMeta{Tags: []string{"work"}}, audit.Event{}, nil)
+	require.NoError(t, err)
+	_, err = service.ShortenAudited(ctx, "https://three.com", "user-2", model.LinkOptions{},
+		model.LinkMeta{Tags: []string{"docs"}}, audit.Event{}, nil)
+	require.NoError(t, err)
+
+	tags, err := service.GetUserTags(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "work", Count: 2}, {Tag: "docs", Count: 1}}, tags)
+
+	page, err := service.GetUserURLs(ctx, "user-1", model.URLListQuery{Tag: " DOCS "})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	assert.Equal(t, first, page.URLs[0].ShortURL)
+	assert.Equal(t, "Один", page.URLs[0].Title)
+
+	// Изменение описания не создаёт версию
+	note := "заметка"
+	link, err := service.UpdateLink(ctx, "user-1", first, model.LinkUpdate{Note: &note, Tags: &[]string{}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, link.Version)
+	assert.Equal(t, model.LinkMeta{Title: "Один", Note: "заметка"}, link.LinkMeta)
+
+	versions, err := service.GetLinkHistory(ctx, "user-1", first)
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+}
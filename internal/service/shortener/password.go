@@ -0,0 +1,78 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxPasswordLength наибольшая длина пароля ссылки в байтах: bcrypt
+// учитывает только первые 72 байта
+const MaxPasswordLength = 72
+
+// passwordCost стоимость bcrypt, тесты её уменьшают
+var passwordCost = bcrypt.DefaultCost
+
+// HashPassword возвращает bcrypt-хеш пароля ссылки с солью.
+// Пустой пароль даёт пустой хеш — ссылка без пароля.
+//
+// Возвращает *model.ValidationError с полем password, если пароль длиннее
+// MaxPasswordLength.
+//
+// Пример использования:
+//
+//	hash, err := shortener.HashPassword("s3cret")
+//	opts := model.LinkOptions{PasswordHash: hash}
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) > MaxPasswordLength {
+		return "", &model.ValidationError{Field: "password", Message: fmt.Sprintf("ожидается не больше %d байт", MaxPasswordLength)}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", fmt.Errorf("ошибка хеширования пароля: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckLinkPassword сообщает, подходит ли пароль к ссылке.
+// Для ссылки без пароля возвращает true.
+//
+// Пример использования:
+//
+//	if !service.CheckLinkPassword(ctx, link, c.PostForm("password")) {
+//	    // неверный пароль
+//	}
+func (s URLService) CheckLinkPassword(ctx context.Context, link model.Link, password string) bool {
+	_, span := telemetry.Start(ctx, "URLService.CheckLinkPassword")
+	defer span.End()
+
+	if !link.Protected() {
+		return true
+	}
+	// bcrypt сравнивает только первые 72 байта, более длинный пароль
+	// совпал бы с хешем по своему началу
+	if len(password) > MaxPasswordLength {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) == nil
+}
+
+// hashLinkUpdate заменяет новый пароль изменения его хешем
+func hashLinkUpdate(update model.LinkUpdate) (model.LinkUpdate, error) {
+	if update.Password == nil {
+		return update, nil
+	}
+	hash, err := HashPassword(*update.Password)
+	if err != nil {
+		return update, err
+	}
+	update.Password = nil
+	update.PasswordHash = &hash
+	return update, nil
+}
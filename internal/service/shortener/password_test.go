@@ -0,0 +1,108 @@
+package shortener
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	passwordCost = bcrypt.MinCost
+}
+
+func TestHashPassword(t *testing.T) {
+	service := NewURLService(nil)
+
+	tests := []struct {
+		name      string
+		password  string
+		wantEmpty bool
+		wantField string
+	}{
+		{name: "пустой пароль — без пароля", password: "", wantEmpty: true},
+		{name: "обычный пароль", password: "s3cret"},
+		{name: "пароль на пределе", password: strings.Repeat("p", MaxPasswordLength)},
+		{name: "слишком длинный пароль", password: strings.Repeat("p", MaxPasswordLength+1), wantField: "password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := HashPassword(tt.password)
+			if tt.wantField != "" {
+				var validation *model.ValidationError
+				require.ErrorAs(t, err, &validation)
+				assert.Equal(t, tt.wantField, validation.Field)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantEmpty {
+				assert.Empty(t, hash)
+				return
+			}
+			assert.NotEqual(t, tt.password, hash)
+
+			link := model.Link{LinkOptions: model.LinkOptions{PasswordHash: hash}}
+			assert.True(t, service.CheckLinkPassword(context.Background(), link, tt.password))
+			assert.False(t, service.CheckLinkPassword(context.Background(), link, tt.password+"x"))
+		})
+	}
+
+	// Соль делает хеши одного пароля разными
+	first, _ := HashPassword("s3cret")
+	second, _ := HashPassword("s3cret")
+	assert.NotEqual(t, first, second)
+}
+
+func TestPasswordInMemory(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewURLRepository()
+	service := NewURLService(repo)
+
+	hash, err := HashPassword("s3cret")
+	require.NoError(t, err)
+	shortURL, err := service.ShortenAudited(ctx, "https://docs.internal/report", "user-1",
+		model.LinkOptions{PasswordHash: hash}, model.LinkMeta{}, audit.Event{}, nil)
+	require.NoError(t, err)
+
+	link, err := service.GetLink(ctx, shortURL)
+	require.NoError(t, err)
+	assert.True(t, link.Protected())
+	assert.True(t, service.CheckLinkPassword(ctx, link, "s3cret"))
+
+	page, err := service.GetUserURLs(ctx, "user-1", model.URLListQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	assert.True(t, page.URLs[0].Protected)
+
+	// Смена пароля не создаёт версию и не попадает в историю
+	password := "n3w"
+	link, err = service.UpdateLink(ctx, "user-1", shortURL, model.LinkUpdate{Password: &password})
+	require.NoError(t, err)
+	assert.Equal(t, 1, link.Version)
+	assert.False(t, service.CheckLinkPassword(ctx, link, "s3cret"))
+	assert.True(t, service.CheckLinkPassword(ctx, link, "n3w"))
+
+	versions, err := service.GetLinkHistory(ctx, "user-1", shortURL)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Empty(t, versions[0].PasswordHash)
+
+	// Пустая строка снимает пароль
+	empty := ""
+	link, err = service.UpdateLink(ctx, "user-1", shortURL, model.LinkUpdate{Password: &empty})
+	require.NoError(t, err)
+	assert.False(t, link.Protected())
+
+	tooLong := strings.Repeat("p", MaxPasswordLength+1)
+	_, err = service.UpdateLink(ctx, "user-1", shortURL, model.LinkUpdate{Password: &tooLong})
+	var validation *model.ValidationError
+	require.ErrorAs(t, err, &validation)
+	assert.Equal(t, "password", validation.Field)
+}
@@ -0,0 +1,134 @@
+package shortener
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Popolzen/shortener/internal/model"
+)
+
+// Quotas квоты по умолчанию и переопределения для отдельных пользователей.
+// Безопасен для конкурентного использования; nil Quotas ничего не ограничивает.
+//
+// В переопределении поле 0 берётся из квоты по умолчанию, а отрицательное
+// снимает ограничение.
+type Quotas struct {
+	mu        sync.RWMutex
+	def       model.Quota
+	overrides map[string]model.Quota
+}
+
+// NewQuotas создаёт квоты с переопределениями из конфигурации
+func NewQuotas(def model.Quota, overrides map[string]model.Quota) *Quotas {
+	q := &Quotas{def: def, overrides: make(map[string]model.Quota, len(overrides))}
+	for userID, o := range overrides {
+		q.overrides[userID] = o
+	}
+	return q
+}
+
+// For возвращает действующие квоты пользователя
+func (q *Quotas) For(userID string) model.Quota {
+	if q == nil {
+		return model.Quota{}
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	o, ok := q.overrides[userID]
+	if !ok {
+		return q.def
+	}
+	return model.Quota{
+		MaxLinks:     pick(o.MaxLinks, q.def.MaxLinks),
+		MaxBatch:     pick(o.MaxBatch, q.def.MaxBatch),
+		MaxBodyBytes: pick(o.MaxBodyBytes, q.def.MaxBodyBytes),
+	}
+}
+
+// Override возвращает переопределение пользователя, если оно задано
+func (q *Quotas) Override(userID string) (model.Quota, bool) {
+	if q == nil {
+		return model.Quota{}, false
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	o, ok := q.overrides[userID]
+	return o, ok
+}
+
+// SetOverride задаёт переопределение квот пользователя
+func (q *Quotas) SetOverride(userID string, o model.Quota) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.overrides[userID] = o
+}
+
+// DeleteOverride возвращает пользователю квоты по умолчанию
+func (q *Quotas) DeleteOverride(userID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.overrides, userID)
+}
+
+// pick выбирает значение переопределения или значение по умолчанию
+func pick[T int | int64](override, def T) T {
+	if override == 0 {
+		return def
+	}
+	return override
+}
+
+// Quota возвращает действующие квоты пользователя
+func (s URLService) Quota(userID string) model.Quota {
+	return s.quotas.For(userID)
+}
+
+// Quotas возвращает квоты сервиса для администрирования, может быть nil
+func (s URLService) Quotas() *Quotas {
+	return s.quotas
+}
+
+// CountUserURLs возвращает число активных ссылок пользователя
+func (s URLService) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	return s.repo.CountUserURLs(ctx, userID)
+}
+
+// CheckLinkQuota проверяет, что пользователь может создать ещё n ссылок.
+//
+// Возвращает *model.QuotaError, если после создания активных ссылок станет больше MaxLinks.
+// Проверка предварительная: окончательно квоту проверяет репозиторий
+// при сохранении каждой ссылки.
+func (s URLService) CheckLinkQuota(ctx context.Context, userID string, n int) error {
+	limit := s.Quota(userID).MaxLinks
+	if limit <= 0 {
+		return nil
+	}
+	used, err := s.CountUserURLs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if used+n > limit {
+		return model.NewLinkQuotaError(limit, used, n)
+	}
+	return nil
+}
+
+// CheckBatchQuota проверяет размер пакетного запроса пользователя
+func (s URLService) CheckBatchQuota(userID string, n int) error {
+	limit := s.Quota(userID).MaxBatch
+	if limit > 0 && n > limit {
+		return &model.QuotaError{Kind: model.QuotaBatch, Limit: int64(limit), Requested: int64(n)}
+	}
+	return nil
+}
+
+// BodyLimit возвращает допустимый размер тела запроса пользователя, 0 — без ограничения
+func (s URLService) BodyLimit(userID string) int64 {
+	return max(s.Quota(userID).MaxBodyBytes, 0)
+}
+
+// BodyQuotaError возвращает ошибку превышения размера тела запроса
+func (s URLService) BodyQuotaError(userID string) error {
+	return &model.QuotaError{Kind: model.QuotaBodyBytes, Limit: s.BodyLimit(userID)}
+}
@@ -0,0 +1,86 @@
+package shortener
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Popolzen/shortener/internal/audit"
+	"github.com/Popolzen/shortener/internal/model"
+	"github.com/Popolzen/shortener/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestQuotas_For(t *testing.T) {
+	quotas := NewQuotas(
+		model.Quota{MaxLinks: 10, MaxBatch: 5, MaxBodyBytes: 1024},
+		map[string]model.Quota{"vip": {MaxLinks: 1000, MaxBatch: -1}},
+	)
+
+	tests := []struct {
+		name   string
+		userID string
+		want   model.Quota
+	}{
+		{name: "квоты по умолчанию", userID: "user", want: model.Quota{MaxLinks: 10, MaxBatch: 5, MaxBodyBytes: 1024}},
+		{name: "переопределение с наследованием", userID: "vip", want: model.Quota{MaxLinks: 1000, MaxBatch: -1, MaxBodyBytes: 1024}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, quotas.For(tt.userID))
+		})
+	}
+
+	var none *Quotas
+	assert.Equal(t, model.Quota{}, none.For("user"))
+}
+
+func TestCheckLinkQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	repo.EXPECT().CountUserURLs(gomock.Any(), "user-1").Return(9, nil).AnyTimes()
+
+	service := NewURLServiceWithQuotas(repo, NewQuotas(model.Quota{MaxLinks: 10}, nil))
+
+	assert.NoError(t, service.CheckLinkQuota(context.Background(), "user-1", 1))
+
+	err := service.CheckLinkQuota(context.Background(), "user-1", 2)
+	var quotaErr *model.QuotaError
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, model.QuotaLinks, quotaErr.Kind)
+	assert.Equal(t, int64(9), quotaErr.Used)
+	assert.Equal(t, http.StatusForbidden, quotaErr.StatusCode())
+}
+
+func TestShortenBatch_RejectsWholeBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	repo.EXPECT().CountUserURLs(gomock.Any(), "user-1").Return(1, nil)
+
+	// Store не ожидается: пакет не укладывается в квоту целиком
+	service := NewURLServiceWithQuotas(repo, NewQuotas(model.Quota{MaxLinks: 2}, nil))
+	_, err := service.ShortenBatch(context.Background(), []model.Link{{OriginalURL: "https://a.com"}, {OriginalURL: "https://b.com"}}, "user-1", audit.Event{}, nil)
+
+	var quotaErr *model.QuotaError
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, int64(2), quotaErr.Requested)
+}
+
+func TestShorten_PassesLinkQuotaToRepository(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+	// Квота проверяется при сохранении, а не отдельным подсчётом заранее
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://a.com", "user-1", gomock.Any(), gomock.Any(), 2).
+		Return(model.NewLinkQuotaError(2, 2, 1))
+
+	service := NewURLServiceWithQuotas(repo, NewQuotas(model.Quota{MaxLinks: 2}, nil))
+	_, err := service.Shorten(context.Background(), "https://a.com", "user-1")
+
+	var quotaErr *model.QuotaError
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, int64(2), quotaErr.Used)
+}
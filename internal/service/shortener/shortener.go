@@ -9,23 +9,34 @@
 package shortener
 
 import (
+	"context"
 	"fmt"
 	"math/rand/v2"
+	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 
+	"github.com/Popolzen/shortener/internal/audit"
 	"github.com/Popolzen/shortener/internal/model"
 	"github.com/Popolzen/shortener/internal/repository"
+	"github.com/Popolzen/shortener/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// DefaultRedirectCode код перенаправления для ссылок без своего кода
+const DefaultRedirectCode = http.StatusTemporaryRedirect
+
 // URLService предоставляет методы для работы с сокращенными URL.
 //
 // Сервис является слоем бизнес-логики между обработчиками HTTP-запросов
 // и репозиторием хранения данных.
 type URLService struct {
-	repo repository.URLRepository
+	repo   repository.URLRepository
+	quotas *Quotas
 }
 
 // NewURLService создает новый экземпляр URLService.
@@ -44,11 +55,19 @@ func NewURLService(repo repository.URLRepository) URLService {
 	return URLService{repo: repo}
 }
 
+// NewURLServiceWithQuotas создает сервис, проверяющий квоты пользователей.
+//
+// Квота MaxLinks передаётся в репозиторий и проверяется вместе с сохранением,
+// поэтому параллельные запросы одного пользователя её не превышают.
+func NewURLServiceWithQuotas(repo repository.URLRepository, quotas *Quotas) URLService {
+	return URLService{repo: repo, quotas: quotas}
+}
+
 // isUniq проверяет уникальность короткой ссылки.
 //
 // Возвращает true, если короткая ссылка еще не используется.
-func (s URLService) isUniq(shortURL string) bool {
-	_, err := s.repo.Get(shortURL)
+func (s URLService) isUniq(ctx context.Context, shortURL string) bool {
+	_, err := s.repo.Get(ctx, shortURL)
 	return err != nil
 }
 
@@ -59,72 +78,200 @@ func (s URLService) isUniq(shortURL string) bool {
 // При коллизии выполняется до 1000 попыток генерации.
 //
 // Параметры:
+//   - ctx: контекст запроса
 //   - longURL: оригинальный URL для сокращения
 //   - id: идентификатор пользователя
 //
 // Возвращает:
 //   - string: короткий идентификатор URL (без базового адреса)
-//   - error: ошибка при генерации или сохранении
+//   - error: ошибка при генерации или сохранении, *model.QuotaError при превышении квоты
 //
 // Пример использования:
 //
-//	shortURL, err := service.Shorten("https://example.com", "user123")
+//	shortURL, err := service.Shorten(ctx, "https://example.com", "user123")
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
 //	fmt.Println("Короткая ссылка:", shortURL) // Выведет что-то вроде: "abc123"
-func (s URLService) Shorten(longURL string, id string) (string, error) {
-	const length = 6
-	const maxAttempts = 1000
+func (s URLService) Shorten(ctx context.Context, longURL string, id string) (_ string, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.Shorten")
+	defer telemetry.End(span, &err)
 
-	for range maxAttempts {
-		su := shortURL(length)
-		if s.isUniq(su) {
-			err := s.repo.Store(su, longURL, id)
-			if err != nil {
-				return "", err
-			}
-			return su, nil
-		}
+	return s.store(ctx, longURL, id, model.LinkOptions{}, model.LinkMeta{})
+}
+
+// ValidateLinkOptions проверяет код перенаправления: 0 или один из 301, 302, 307, 308.
+// Возвращает *model.ValidationError.
+func ValidateLinkOptions(opts model.LinkOptions) error {
+	switch opts.RedirectCode {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return &model.ValidationError{Field: "redirect_code", Message: "ожидается 301, 302, 307 или 308"}
+}
+
+// RedirectCode возвращает код перенаправления ссылки с учётом значения по умолчанию
+func RedirectCode(opts model.LinkOptions) int {
+	if opts.RedirectCode == 0 {
+		return DefaultRedirectCode
 	}
+	return opts.RedirectCode
+}
 
-	return "", fmt.Errorf("не удалось создать уникальную ссылку за %d попыток", maxAttempts)
+// RedirectTarget возвращает адрес перенаправления по ссылке.
+//
+// Если у ссылки включён QueryPassthrough, параметры query добавляются
+// к оригинальному URL; одноимённые параметры оригинального URL заменяются
+// значениями из запроса. Фрагмент оригинального URL сохраняется.
+func RedirectTarget(link model.Link, query url.Values) string {
+	if !link.QueryPassthrough || len(query) == 0 {
+		return link.OriginalURL
+	}
+	u, err := url.Parse(link.OriginalURL)
+	if err != nil {
+		return link.OriginalURL
+	}
+	merged := u.Query()
+	for key, values := range query {
+		merged[key] = values
+	}
+	u.RawQuery = merged.Encode()
+	return u.String()
 }
 
-// GetFormattedUserURLs возвращает отформатированные URL пользователя.
+// store сохраняет ссылку. Квоту MaxLinks проверяет репозиторий при сохранении.
+func (s URLService) store(ctx context.Context, longURL string, id string, opts model.LinkOptions, meta model.LinkMeta) (string, error) {
+	maxLinks := s.Quota(id).MaxLinks
+	return s.generate(ctx, func(su string) error {
+		return s.repo.Store(ctx, su, longURL, id, opts, meta, maxLinks)
+	})
+}
+
+// ShortenAudited создает короткую ссылку и публикует событие аудита.
 //
-// Метод получает все URL пользователя из репозитория и добавляет
-// базовый URL к каждой короткой ссылке.
+// Если репозиторий реализует repository.AuditOutbox, событие сохраняется
+// в одной транзакции со ссылкой и доставляется в Publisher фоновым relay.
+// Иначе событие публикуется сразу после успешного сохранения.
+// ShortCode события заполняется сгенерированным идентификатором.
 //
 // Параметры:
-//   - userID: идентификатор пользователя
-//   - baseURL: базовый URL сервиса (например, "http://localhost:8080")
-//
-// Возвращает:
-//   - []model.URLPair: массив пар коротких и оригинальных URL
-//   - error: ошибка при получении данных
+//   - ctx: контекст запроса
+//   - longURL: оригинальный URL для сокращения
+//   - id: идентификатор пользователя
+//   - opts: настройки перенаправления, пароль — хешем из HashPassword
+//   - meta: название, заметка и метки, нормализуются NormalizeLinkMeta
+//   - event: событие аудита без ShortCode
+//   - pub: издатель аудита, может быть nil
 //
 // Пример использования:
 //
-//	urls, err := service.GetFormattedUserURLs("user123", "http://localhost:8080")
-//	for _, url := range urls {
-//	    fmt.Printf("%s -> %s\n", url.ShortURL, url.OriginalURL)
-//	}
-func (s URLService) GetFormattedUserURLs(userID string, baseURL string) ([]model.URLPair, error) {
-	urls, err := s.GetUserURLs(userID)
+//	event := audit.NewEvent(audit.ActionShorten, "user123", "https://example.com")
+//	shortURL, err := service.ShortenAudited(ctx, "https://example.com", "user123", model.LinkOptions{}, model.LinkMeta{}, event, pub)
+func (s URLService) ShortenAudited(ctx context.Context, longURL string, id string, opts model.LinkOptions, meta model.LinkMeta, event audit.Event, pub *audit.Publisher) (_ string, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.ShortenAudited")
+	defer telemetry.End(span, &err)
+
+	if err := ValidateLinkOptions(opts); err != nil {
+		return "", err
+	}
+	meta, err = NormalizeLinkMeta(meta)
 	if err != nil {
+		return "", err
+	}
+	return s.storeAudited(ctx, longURL, id, opts, meta, event, pub)
+}
+
+// ShortenBatch создает короткие ссылки для пакета URL с публикацией аудита.
+//
+// Квоты на размер пакета и число ссылок проверяются один раз до сохранения,
+// поэтому пакет, не укладывающийся в квоту, отклоняется целиком. Квоту
+// MaxLinks репозиторий проверяет и при сохранении каждой ссылки: если
+// параллельные запросы исчерпали её во время пакета, возвращается
+// *model.QuotaError, а уже сохранённые ссылки пакета остаются.
+// Для каждой ссылки публикуется копия event с её URL и коротким кодом.
+// У ссылок пакета учитываются OriginalURL, LinkOptions и LinkMeta,
+// остальные поля не используются.
+//
+// Возвращает короткие идентификаторы в порядке links.
+func (s URLService) ShortenBatch(ctx context.Context, links []model.Link, id string, event audit.Event, pub *audit.Publisher) (_ []string, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.ShortenBatch",
+		trace.WithAttributes(attribute.Int("batch.size", len(links))))
+	defer telemetry.End(span, &err)
+
+	metas := make([]model.LinkMeta, len(links))
+	for i, link := range links {
+		if err := ValidateLinkOptions(link.LinkOptions); err != nil {
+			return nil, fmt.Errorf("элемент %d: %w", i, err)
+		}
+		if metas[i], err = NormalizeLinkMeta(link.LinkMeta); err != nil {
+			return nil, fmt.Errorf("элемент %d: %w", i, err)
+		}
+	}
+	if err := s.CheckBatchQuota(id, len(links)); err != nil {
+		return nil, err
+	}
+	if err := s.CheckLinkQuota(ctx, id, len(links)); err != nil {
 		return nil, err
 	}
-	for i := range urls {
-		fullShortURL := baseURL + "/" + urls[i].ShortURL
-		urls[i].ShortURL = fullShortURL
+
+	shortURLs := make([]string, 0, len(links))
+	for i, link := range links {
+		event.URL = link.OriginalURL
+		su, err := s.storeAudited(ctx, link.OriginalURL, id, link.LinkOptions, metas[i], event, pub)
+		if err != nil {
+			return nil, err
+		}
+		shortURLs = append(shortURLs, su)
+	}
+	return shortURLs, nil
+}
+
+// storeAudited сохраняет ссылку с событием аудита.
+// Квоту MaxLinks проверяет репозиторий при сохранении.
+func (s URLService) storeAudited(ctx context.Context, longURL string, id string, opts model.LinkOptions, meta model.LinkMeta, event audit.Event, pub *audit.Publisher) (string, error) {
+	if outbox, ok := s.repo.(repository.AuditOutbox); ok {
+		maxLinks := s.Quota(id).MaxLinks
+		return s.generate(ctx, func(su string) error {
+			event.ShortCode = su
+			return outbox.StoreWithEvent(ctx, su, longURL, id, opts, meta, maxLinks, event)
+		})
+	}
+
+	su, err := s.store(ctx, longURL, id, opts, meta)
+	if err != nil {
+		return "", err
+	}
+	if pub != nil {
+		event.ShortCode = su
+		pub.Publish(event)
 	}
-	return urls, nil
+	return su, nil
+}
+
+// generate подбирает свободный идентификатор и сохраняет его через store.
+//
+// При коллизии выполняется до 1000 попыток генерации.
+func (s URLService) generate(ctx context.Context, store func(shortURL string) error) (string, error) {
+	const length = 6
+	const maxAttempts = 1000
+
+	for range maxAttempts {
+		su := shortURL(length)
+		if s.isUniq(ctx, su) {
+			if err := store(su); err != nil {
+				return "", err
+			}
+			return su, nil
+		}
+	}
+
+	return "", fmt.Errorf("не удалось создать уникальную ссылку за %d попыток", maxAttempts)
 }
 
 // GetLongURL возвращает оригинальный URL по короткой ссылке.
 //
 // Параметры:
+//   - ctx: контекст запроса
 //   - shortURL: идентификатор короткой ссылки
 //
 // Возвращает:
@@ -133,26 +280,152 @@ func (s URLService) GetFormattedUserURLs(userID string, baseURL string) ([]model
 //
 // Пример использования:
 //
-//	longURL, err := service.GetLongURL("abc123")
+//	longURL, err := service.GetLongURL(ctx, "abc123")
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
 //	fmt.Println("Оригинальный URL:", longURL)
-func (s URLService) GetLongURL(shortURL string) (string, error) {
-	value, err := s.repo.Get(shortURL)
-	return value, err
+func (s URLService) GetLongURL(ctx context.Context, shortURL string) (_ string, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.GetLongURL")
+	defer telemetry.End(span, &err)
+
+	link, err := s.repo.Get(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+	return link.OriginalURL, nil
+}
+
+// GetLink возвращает ссылку с настройками перенаправления.
+//
+// Возвращает model.ErrURLNotFound или model.ErrURLDeleted, как GetLongURL.
+//
+// Пример использования:
+//
+//	link, err := service.GetLink(ctx, "abc123")
+//	if err != nil {
+//	    return err
+//	}
+//	target := shortener.RedirectTarget(link, r.URL.Query())
+func (s URLService) GetLink(ctx context.Context, shortURL string) (_ model.Link, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.GetLink")
+	defer telemetry.End(span, &err)
+
+	return s.repo.Get(ctx, shortURL)
 }
 
-// GetUserURLs возвращает все URL конкретного пользователя.
+// RecordClick учитывает переход по короткой ссылке.
+// Переходы нужны для сортировки ссылок пользователя.
+func (s URLService) RecordClick(ctx context.Context, shortURL string) {
+	s.repo.RecordClick(ctx, shortURL)
+}
+
+// UpdateLink меняет оригинальный URL, настройки и описание ссылки пользователя.
+//
+// Каждое изменение URL или настроек сохраняется новой версией ссылки,
+// историю возвращает GetLinkHistory. Описание нормализуется как
+// в NormalizeLinkMeta и в историю не попадает. Новый пароль сохраняется
+// хешем HashPassword, пустая строка снимает пароль.
 //
 // Параметры:
-//   - userID: идентификатор пользователя
+//   - ctx: контекст запроса
+//   - userID: идентификатор пользователя-владельца
+//   - shortURL: идентификатор короткой ссылки
+//   - update: изменения, поля nil не меняются
 //
 // Возвращает:
-//   - []model.URLPair: массив пар коротких и оригинальных URL
-//   - error: ошибка при получении данных
-func (s *URLService) GetUserURLs(userID string) ([]model.URLPair, error) {
-	return s.repo.GetUserURLs(userID)
+//   - model.Link: ссылка после изменения
+//   - error: *model.ValidationError для пустого изменения, пустого URL,
+//     кода, описания или пароля, model.ErrURLNotFound если ссылки нет или она чужая,
+//     model.ErrURLDeleted если ссылка удалена
+//
+// Пример использования:
+//
+//	code := http.StatusMovedPermanently
+//	link, err := service.UpdateLink(ctx, "user123", "abc123", model.LinkUpdate{RedirectCode: &code})
+func (s URLService) UpdateLink(ctx context.Context, userID, shortURL string, update model.LinkUpdate) (_ model.Link, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.UpdateLink")
+	defer telemetry.End(span, &err)
+
+	if update, err = normalizeLinkUpdate(update); err != nil {
+		return model.Link{}, err
+	}
+	if err := ValidateLinkUpdate(update); err != nil {
+		return model.Link{}, err
+	}
+	if update, err = hashLinkUpdate(update); err != nil {
+		return model.Link{}, err
+	}
+	return s.repo.UpdateLink(ctx, userID, shortURL, update)
+}
+
+// UpdateLinkAudited меняет ссылку пользователя и публикует событие аудита.
+//
+// Как и в ShortenAudited, репозиторий с repository.AuditOutbox сохраняет
+// событие в одной транзакции с изменением, иначе событие публикуется после
+// успешного изменения. URL события — оригинальный URL после изменения,
+// ShortCode заполняется идентификатором ссылки.
+//
+// Пример использования:
+//
+//	event := audit.NewEvent(audit.ActionUpdate, "user123", "")
+//	link, err := service.UpdateLinkAudited(ctx, "user123", "abc123", update, event, pub)
+func (s URLService) UpdateLinkAudited(ctx context.Context, userID, shortURL string, update model.LinkUpdate, event audit.Event, pub *audit.Publisher) (_ model.Link, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.UpdateLinkAudited")
+	defer telemetry.End(span, &err)
+
+	if update, err = normalizeLinkUpdate(update); err != nil {
+		return model.Link{}, err
+	}
+	if err := ValidateLinkUpdate(update); err != nil {
+		return model.Link{}, err
+	}
+	if update, err = hashLinkUpdate(update); err != nil {
+		return model.Link{}, err
+	}
+	event.ShortCode = shortURL
+	if outbox, ok := s.repo.(repository.AuditOutbox); ok {
+		return outbox.UpdateLinkWithEvent(ctx, userID, shortURL, update, event)
+	}
+
+	link, err := s.repo.UpdateLink(ctx, userID, shortURL, update)
+	if err != nil {
+		return model.Link{}, err
+	}
+	if pub != nil {
+		event.URL = link.OriginalURL
+		pub.Publish(event)
+	}
+	return link, nil
+}
+
+// ValidateLinkUpdate проверяет, что изменение непустое, новый URL не пуст,
+// а код перенаправления и описание корректны. Возвращает *model.ValidationError.
+func ValidateLinkUpdate(update model.LinkUpdate) error {
+	if update.IsEmpty() {
+		return &model.ValidationError{Field: "body", Message: "ожидается хотя бы одно из полей original_url, redirect_code, query_passthrough, title, note, tags, password"}
+	}
+	if update.OriginalURL != nil && strings.TrimSpace(*update.OriginalURL) == "" {
+		return &model.ValidationError{Field: "original_url", Message: "не может быть пустым"}
+	}
+	if _, err := NormalizeLinkMeta(update.ApplyMeta(model.LinkMeta{})); err != nil {
+		return err
+	}
+	if update.Password != nil && len(*update.Password) > MaxPasswordLength {
+		return &model.ValidationError{Field: "password", Message: fmt.Sprintf("ожидается не больше %d байт", MaxPasswordLength)}
+	}
+	return ValidateLinkOptions(update.Apply(model.LinkOptions{}))
+}
+
+// GetLinkHistory возвращает версии ссылки пользователя от первой к последней.
+//
+// Возвращает model.ErrURLNotFound, если ссылки нет или она чужая.
+// История удалённой ссылки доступна владельцу.
+func (s URLService) GetLinkHistory(ctx context.Context, userID, shortURL string) (_ []model.LinkVersion, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.GetLinkHistory")
+	defer telemetry.End(span, &err)
+
+	return s.repo.GetLinkHistory(ctx, userID, shortURL)
 }
 
 // DeleteURLsAsync выполняет асинхронное удаление URL.
@@ -161,15 +434,23 @@ func (s *URLService) GetUserURLs(userID string) ([]model.URLPair, error) {
 // Фактическое удаление выполняется фоновыми воркерами.
 //
 // Параметры:
+//   - ctx: контекст запроса, передаёт воркерам идентификатор запроса
 //   - userID: идентификатор пользователя
 //   - shortURLs: массив идентификаторов коротких ссылок для удаления
 //
+// Возвращает принятые к удалению идентификаторы и model.ErrDeleteQueueFull,
+// если очередь переполнена и часть задач не принята.
+//
 // Пример использования:
 //
-//	service.DeleteURLsAsync("user123", []string{"abc123", "def456"})
+//	accepted, err := service.DeleteURLsAsync(ctx, "user123", []string{"abc123", "def456"})
 //	// Метод вернется немедленно, удаление произойдет в фоне
-func (s *URLService) DeleteURLsAsync(userID string, shortURLs []string) {
-	s.repo.DeleteURLs(userID, shortURLs)
+func (s *URLService) DeleteURLsAsync(ctx context.Context, userID string, shortURLs []string) (_ []string, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.DeleteURLsAsync",
+		trace.WithAttributes(attribute.Int("batch.size", len(shortURLs))))
+	defer telemetry.End(span, &err)
+
+	return s.repo.DeleteURLs(ctx, userID, shortURLs)
 }
 
 var builderPool = sync.Pool{
@@ -215,11 +496,14 @@ func shortURL(length int) string {
 //
 // Пример использования:
 //
-//	urls, users, err := service.GetStats()
+//	urls, users, err := service.GetStats(ctx)
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
 //	fmt.Printf("URLs: %d, Users: %d\n", urls, users)
-func (s *URLService) GetStats() (urls int, users int, err error) {
-	return s.repo.GetStats()
+func (s *URLService) GetStats(ctx context.Context) (urls int, users int, err error) {
+	ctx, span := telemetry.Start(ctx, "URLService.GetStats")
+	defer telemetry.End(span, &err)
+
+	return s.repo.GetStats(ctx)
 }
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Popolzen/shortener/internal/model"
 	"github.com/Popolzen/shortener/internal/repository/database"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/testcontainers/testcontainers-go"
@@ -59,10 +60,32 @@ func setupBenchDB(b *testing.B) {
 				short_url VARCHAR(20) UNIQUE NOT NULL,
 				created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 				is_deleted BOOL DEFAULT FALSE,
+				redirect_code SMALLINT NOT NULL DEFAULT 0,
+				query_passthrough BOOL NOT NULL DEFAULT FALSE,
+				version INT NOT NULL DEFAULT 1,
+				clicks BIGINT NOT NULL DEFAULT 0,
+				title TEXT NOT NULL DEFAULT '',
+				note TEXT NOT NULL DEFAULT '',
+				password_hash TEXT NOT NULL DEFAULT '',
 				CONSTRAINT chk_short_url_length CHECK (length(short_url) >= 4)
 			);
 			CREATE UNIQUE INDEX IF NOT EXISTS idx_shortened_urls_short_url ON shortened_urls(short_url);
 			CREATE INDEX IF NOT EXISTS idx_shortened_urls_user_id ON shortened_urls(user_id);
+			CREATE TABLE IF NOT EXISTS link_history (
+				id BIGSERIAL PRIMARY KEY,
+				short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+				version INT NOT NULL,
+				long_url TEXT NOT NULL,
+				redirect_code SMALLINT NOT NULL DEFAULT 0,
+				query_passthrough BOOL NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				UNIQUE (short_url, version)
+			);
+			CREATE TABLE IF NOT EXISTS link_tags (
+				short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+				tag VARCHAR(64) NOT NULL,
+				PRIMARY KEY (short_url, tag)
+			);
 		`)
 		if err != nil {
 			b.Fatalf("Failed to create schema: %v", err)
@@ -93,7 +116,7 @@ func BenchmarkShorten(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		userID := "550e8400-e29b-41d4-a716-446655440000"
 		longURL := "https://example.com/very/long/url/path/" + string(rune(i%1000))
-		_, _ = service.Shorten(longURL, userID)
+		_, _ = service.Shorten(context.Background(), longURL, userID)
 	}
 }
 
@@ -104,13 +127,13 @@ func BenchmarkGetLongURL(b *testing.B) {
 
 	// Подготовка данных
 	userID := "550e8400-e29b-41d4-a716-446655440001"
-	shortURL, _ := service.Shorten("https://example.com/gettest", userID)
+	shortURL, _ := service.Shorten(context.Background(), "https://example.com/gettest", userID)
 
 	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _ = service.GetLongURL(shortURL)
+		_, _ = service.GetLongURL(context.Background(), shortURL)
 	}
 }
 
@@ -123,14 +146,14 @@ func BenchmarkGetFormattedUserURLs(b *testing.B) {
 
 	// Подготовка: создаем 10 URL для пользователя
 	for i := 0; i < 10; i++ {
-		_, _ = service.Shorten("https://example.com/formatted/"+string(rune(i)), userID)
+		_, _ = service.Shorten(context.Background(), "https://example.com/formatted/"+string(rune(i)), userID)
 	}
 
 	// b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _ = service.GetFormattedUserURLs(userID, baseURL)
+		_, _ = service.GetFormattedUserURLs(context.Background(), userID, baseURL, model.URLListQuery{})
 	}
 }
 
@@ -142,13 +165,13 @@ func BenchmarkIsUniq(b *testing.B) {
 
 	// Заполняем репозиторий данными
 	for i := 0; i < 100; i++ {
-		_ = benchRepo.Store(shortURL(6), "https://example.com/uniq/"+string(rune(i)), userID)
+		_ = benchRepo.Store(context.Background(), shortURL(6), "https://example.com/uniq/"+string(rune(i)), userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	}
 
 	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = service.isUniq(shortURL(6))
+		_ = service.isUniq(context.Background(), shortURL(6))
 	}
 }
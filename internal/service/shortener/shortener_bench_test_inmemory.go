@@ -1,8 +1,10 @@
 package shortener
 
 import (
+	"context"
 	"testing"
 
+	"github.com/Popolzen/shortener/internal/model"
 	"github.com/Popolzen/shortener/internal/repository/memory"
 )
 
@@ -17,7 +19,7 @@ func BenchmarkShortenInMemory(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		longURL := "https://example.com/path/" + string(rune(i%10000))
-		_, _ = service.Shorten(longURL, userID)
+		_, _ = service.Shorten(context.Background(), longURL, userID)
 	}
 }
 
@@ -27,13 +29,13 @@ func BenchmarkGetLongURLInMemory(b *testing.B) {
 	service := NewURLService(repo)
 	userID := "test-user-123"
 
-	shortURL, _ := service.Shorten("https://example.com/test", userID)
+	shortURL, _ := service.Shorten(context.Background(), "https://example.com/test", userID)
 
 	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _ = service.GetLongURL(shortURL)
+		_, _ = service.GetLongURL(context.Background(), shortURL)
 	}
 }
 
@@ -45,13 +47,13 @@ func BenchmarkIsUniqInMemory(b *testing.B) {
 
 	// Заполняем репозиторий
 	for i := 0; i < 100; i++ {
-		_ = repo.Store(shortURL(6), "https://example.com/"+string(rune(i)), userID)
+		_ = repo.Store(context.Background(), shortURL(6), "https://example.com/"+string(rune(i)), userID, model.LinkOptions{}, model.LinkMeta{}, 0)
 	}
 
 	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = service.isUniq(shortURL(6))
+		_ = service.isUniq(context.Background(), shortURL(6))
 	}
 }
@@ -1,9 +1,13 @@
 package shortener
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"net/url"
 	"testing"
 
+	"github.com/Popolzen/shortener/internal/audit"
 	"github.com/Popolzen/shortener/internal/model"
 	"github.com/Popolzen/shortener/internal/repository/mocks"
 	"github.com/stretchr/testify/assert"
@@ -19,11 +23,11 @@ func TestShorten_Success(t *testing.T) {
 
 	repo := mocks.NewMockURLRepository(ctrl)
 
-	repo.EXPECT().Get(gomock.Any()).Return("", errors.New("not found"))
-	repo.EXPECT().Store(gomock.Any(), "https://example.com", "user-123").Return(nil)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found"))
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "user-123", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	service := NewURLService(repo)
-	shortURL, err := service.Shorten("https://example.com", "user-123")
+	shortURL, err := service.Shorten(context.Background(), "https://example.com", "user-123")
 
 	require.NoError(t, err)
 	assert.Len(t, shortURL, 6)
@@ -35,11 +39,11 @@ func TestShorten_StoreError(t *testing.T) {
 
 	repo := mocks.NewMockURLRepository(ctrl)
 
-	repo.EXPECT().Get(gomock.Any()).Return("", errors.New("not found"))
-	repo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("db error"))
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found"))
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("db error"))
 
 	service := NewURLService(repo)
-	_, err := service.Shorten("https://example.com", "user-123")
+	_, err := service.Shorten(context.Background(), "https://example.com", "user-123")
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "db error")
@@ -53,29 +57,104 @@ func TestShorten_RetryOnCollision(t *testing.T) {
 
 	// Первые 2 раза URL существует, третий — свободен
 	gomock.InOrder(
-		repo.EXPECT().Get(gomock.Any()).Return("exists", nil),
-		repo.EXPECT().Get(gomock.Any()).Return("exists", nil),
-		repo.EXPECT().Get(gomock.Any()).Return("", errors.New("not found")),
+		repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{OriginalURL: "exists"}, nil),
+		repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{OriginalURL: "exists"}, nil),
+		repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found")),
 	)
 
-	repo.EXPECT().Store(gomock.Any(), "https://example.com", "user-1").Return(nil)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "user-1", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	service := NewURLService(repo)
-	shortURL, err := service.Shorten("https://example.com", "user-1")
+	shortURL, err := service.Shorten(context.Background(), "https://example.com", "user-1")
 
 	require.NoError(t, err)
 	assert.Len(t, shortURL, 6)
 }
 
+// recordingObserver запоминает полученные события аудита
+type recordingObserver struct {
+	events []audit.Event
+}
+
+func (o *recordingObserver) Notify(event audit.Event) { o.events = append(o.events, event) }
+
+func (o *recordingObserver) Close() error { return nil }
+
+// outboxRepo мок репозитория с поддержкой repository.AuditOutbox
+type outboxRepo struct {
+	*mocks.MockURLRepository
+	stored []audit.Event
+}
+
+func (r *outboxRepo) StoreWithEvent(ctx context.Context, shortURL, longURL, userID string, opts model.LinkOptions, meta model.LinkMeta, maxLinks int, event audit.Event) error {
+	r.stored = append(r.stored, event)
+	return nil
+}
+
+func (r *outboxRepo) UpdateLinkWithEvent(ctx context.Context, userID, shortURL string, update model.LinkUpdate, event audit.Event) (model.Link, error) {
+	event.URL = *update.OriginalURL
+	r.stored = append(r.stored, event)
+	return model.Link{ShortURL: shortURL, OriginalURL: *update.OriginalURL, Version: 2}, nil
+}
+
+func TestShortenAudited_PublishesAfterStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found")).Times(2)
+	gomock.InOrder(
+		repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "user-1", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil),
+		repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://fail.com", "user-1", gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("db error")),
+	)
+
+	pub := audit.NewPublisher()
+	obs := &recordingObserver{}
+	pub.Subscribe(obs)
+
+	service := NewURLService(repo)
+	event := audit.NewEvent(audit.ActionShorten, "user-1", "https://example.com")
+	shortURL, err := service.ShortenAudited(context.Background(), "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, event, pub)
+	require.NoError(t, err)
+
+	// При ошибке сохранения событие не публикуется
+	_, err = service.ShortenAudited(context.Background(), "https://fail.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, event, pub)
+	require.Error(t, err)
+	require.NoError(t, pub.Close())
+
+	require.Len(t, obs.events, 1)
+	assert.Equal(t, shortURL, obs.events[0].ShortCode)
+}
+
+func TestShortenAudited_UsesOutbox(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mock := mocks.NewMockURLRepository(ctrl)
+	mock.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, errors.New("not found"))
+	repo := &outboxRepo{MockURLRepository: mock}
+
+	pub := audit.NewPublisher()
+	obs := &recordingObserver{}
+	pub.Subscribe(obs)
+
+	service := NewURLService(repo)
+	event := audit.NewEvent(audit.ActionShorten, "user-1", "https://example.com")
+	shortURL, err := service.ShortenAudited(context.Background(), "https://example.com", "user-1", model.LinkOptions{}, model.LinkMeta{}, event, pub)
+	require.NoError(t, err)
+	require.NoError(t, pub.Close())
+
+	// Событие уходит в outbox, а не напрямую в Publisher
+	assert.Empty(t, obs.events)
+	require.Len(t, repo.stored, 1)
+	assert.Equal(t, shortURL, repo.stored[0].ShortCode)
+}
+
 func TestGetLongURL_Success(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().Get("abc123").Return("https://example.com", nil)
+	repo.EXPECT().Get(gomock.Any(), "abc123").Return(model.Link{OriginalURL: "https://example.com"}, nil)
 
 	service := NewURLService(repo)
-	longURL, err := service.GetLongURL("abc123")
+	longURL, err := service.GetLongURL(context.Background(), "abc123")
 
 	require.NoError(t, err)
 	assert.Equal(t, "https://example.com", longURL)
@@ -86,10 +165,10 @@ func TestGetLongURL_NotFound(t *testing.T) {
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().Get("missing").Return("", errors.New("not found"))
+	repo.EXPECT().Get(gomock.Any(), "missing").Return(model.Link{}, errors.New("not found"))
 
 	service := NewURLService(repo)
-	_, err := service.GetLongURL("missing")
+	_, err := service.GetLongURL(context.Background(), "missing")
 
 	assert.Error(t, err)
 }
@@ -99,10 +178,10 @@ func TestGetLongURL_Deleted(t *testing.T) {
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().Get("deleted").Return("", model.ErrURLDeleted)
+	repo.EXPECT().Get(gomock.Any(), "deleted").Return(model.Link{}, model.ErrURLDeleted)
 
 	service := NewURLService(repo)
-	_, err := service.GetLongURL("deleted")
+	_, err := service.GetLongURL(context.Background(), "deleted")
 
 	assert.ErrorIs(t, err, model.ErrURLDeleted)
 }
@@ -112,16 +191,18 @@ func TestGetFormattedUserURLs_Success(t *testing.T) {
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().GetUserURLs("user-1").Return([]model.URLPair{
+	repo.EXPECT().GetUserURLs(gomock.Any(), "user-1", gomock.Any()).Return([]model.UserURL{
 		{ShortURL: "abc", OriginalURL: "https://one.com"},
 		{ShortURL: "def", OriginalURL: "https://two.com"},
 	}, nil)
 
 	service := NewURLService(repo)
-	urls, err := service.GetFormattedUserURLs("user-1", "http://localhost:8080")
+	page, err := service.GetFormattedUserURLs(context.Background(), "user-1", "http://localhost:8080", model.URLListQuery{})
 
 	require.NoError(t, err)
+	urls := page.URLs
 	require.Len(t, urls, 2)
+	assert.Empty(t, page.NextCursor)
 	assert.Equal(t, "http://localhost:8080/abc", urls[0].ShortURL)
 	assert.Equal(t, "http://localhost:8080/def", urls[1].ShortURL)
 	// OriginalURL не меняется
@@ -133,13 +214,13 @@ func TestGetFormattedUserURLs_Empty(t *testing.T) {
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().GetUserURLs("unknown").Return(nil, nil)
+	repo.EXPECT().GetUserURLs(gomock.Any(), "unknown", gomock.Any()).Return(nil, nil)
 
 	service := NewURLService(repo)
-	urls, err := service.GetFormattedUserURLs("unknown", "http://localhost")
+	page, err := service.GetFormattedUserURLs(context.Background(), "unknown", "http://localhost", model.URLListQuery{})
 
 	require.NoError(t, err)
-	assert.Empty(t, urls)
+	assert.Empty(t, page.URLs)
 }
 
 func TestGetFormattedUserURLs_Error(t *testing.T) {
@@ -147,10 +228,10 @@ func TestGetFormattedUserURLs_Error(t *testing.T) {
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().GetUserURLs("user-1").Return(nil, errors.New("db error"))
+	repo.EXPECT().GetUserURLs(gomock.Any(), "user-1", gomock.Any()).Return(nil, errors.New("db error"))
 
 	service := NewURLService(repo)
-	_, err := service.GetFormattedUserURLs("user-1", "http://localhost")
+	_, err := service.GetFormattedUserURLs(context.Background(), "user-1", "http://localhost", model.URLListQuery{})
 
 	assert.Error(t, err)
 }
@@ -160,10 +241,13 @@ func TestDeleteURLsAsync(t *testing.T) {
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().DeleteURLs("user-123", []string{"a", "b", "c"})
+	repo.EXPECT().DeleteURLs(gomock.Any(), "user-123", []string{"a", "b", "c"}).
+		Return([]string{"a", "b", "c"}, nil)
 
 	service := NewURLService(repo)
-	service.DeleteURLsAsync("user-123", []string{"a", "b", "c"})
+	accepted, err := service.DeleteURLsAsync(context.Background(), "user-123", []string{"a", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, accepted)
 }
 
 func TestDeleteURLsAsync_EmptyList(t *testing.T) {
@@ -171,10 +255,10 @@ func TestDeleteURLsAsync_EmptyList(t *testing.T) {
 	defer ctrl.Finish()
 
 	repo := mocks.NewMockURLRepository(ctrl)
-	repo.EXPECT().DeleteURLs("user-123", []string{})
+	repo.EXPECT().DeleteURLs(gomock.Any(), "user-123", []string{})
 
 	service := NewURLService(repo)
-	service.DeleteURLsAsync("user-123", []string{})
+	service.DeleteURLsAsync(context.Background(), "user-123", []string{})
 }
 
 // === Тесты без моков (чистая логика генератора) ===
@@ -212,3 +296,244 @@ func TestShortURL_ZeroLength(t *testing.T) {
 	result := shortURL(0)
 	assert.Empty(t, result)
 }
+
+func TestValidateLinkOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		wantErr bool
+	}{
+		{name: "по умолчанию", code: 0},
+		{name: "301", code: http.StatusMovedPermanently},
+		{name: "302", code: http.StatusFound},
+		{name: "307", code: http.StatusTemporaryRedirect},
+		{name: "308", code: http.StatusPermanentRedirect},
+		{name: "200", code: http.StatusOK, wantErr: true},
+		{name: "303", code: http.StatusSeeOther, wantErr: true},
+		{name: "отрицательный", code: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLinkOptions(model.LinkOptions{RedirectCode: tt.code})
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var validation *model.ValidationError
+			require.ErrorAs(t, err, &validation)
+			assert.Equal(t, "redirect_code", validation.Field)
+		})
+	}
+}
+
+func TestRedirectCode(t *testing.T) {
+	assert.Equal(t, DefaultRedirectCode, RedirectCode(model.LinkOptions{}))
+	assert.Equal(t, http.StatusPermanentRedirect, RedirectCode(model.LinkOptions{RedirectCode: http.StatusPermanentRedirect}))
+}
+
+func TestRedirectTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		original    string
+		passthrough bool
+		query       string
+		want        string
+	}{
+		{
+			name:     "без passthrough параметры отбрасываются",
+			original: "https://example.com/landing",
+			query:    "utm_source=ads",
+			want:     "https://example.com/landing",
+		},
+		{
+			name:        "passthrough без параметров",
+			original:    "https://example.com/landing?a=1",
+			passthrough: true,
+			want:        "https://example.com/landing?a=1",
+		},
+		{
+			name:        "параметры добавляются",
+			original:    "https://example.com/landing",
+			passthrough: true,
+			query:       "utm_source=ads&utm_medium=cpc",
+			want:        "https://example.com/landing?utm_medium=cpc&utm_source=ads",
+		},
+		{
+			name:        "объединение с параметрами ссылки",
+			original:    "https://example.com/landing?ref=link",
+			passthrough: true,
+			query:       "utm_source=ads",
+			want:        "https://example.com/landing?ref=link&utm_source=ads",
+		},
+		{
+			name:        "параметр запроса заменяет одноимённый",
+			original:    "https://example.com/landing?utm_source=link",
+			passthrough: true,
+			query:       "utm_source=ads&utm_source=mail",
+			want:        "https://example.com/landing?utm_source=ads&utm_source=mail",
+		},
+		{
+			name:        "фрагмент сохраняется",
+			original:    "https://example.com/docs#install",
+			passthrough: true,
+			query:       "lang=ru",
+			want:        "https://example.com/docs?lang=ru#install",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			link := model.Link{
+				OriginalURL: tt.original,
+				LinkOptions: model.LinkOptions{QueryPassthrough: tt.passthrough},
+			}
+			assert.Equal(t, tt.want, RedirectTarget(link, query))
+		})
+	}
+}
+
+func TestShortenAudited_InvalidRedirectCode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+
+	service := NewURLService(repo)
+	_, err := service.ShortenAudited(context.Background(), "https://example.com", "user-1",
+		model.LinkOptions{RedirectCode: http.StatusOK}, model.LinkMeta{}, audit.Event{}, nil)
+
+	var validation *model.ValidationError
+	require.ErrorAs(t, err, &validation)
+	assert.Equal(t, "redirect_code", validation.Field)
+}
+
+func TestShortenAudited_StoresOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	opts := model.LinkOptions{RedirectCode: http.StatusMovedPermanently, QueryPassthrough: true}
+	repo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(model.Link{}, model.ErrURLNotFound)
+	repo.EXPECT().Store(gomock.Any(), gomock.Any(), "https://example.com", "user-1", opts, model.LinkMeta{}, gomock.Any()).Return(nil)
+
+	service := NewURLService(repo)
+	_, err := service.ShortenAudited(context.Background(), "https://example.com", "user-1", opts, model.LinkMeta{}, audit.Event{}, nil)
+	require.NoError(t, err)
+}
+
+func TestUpdateLink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	code := http.StatusPermanentRedirect
+	update := model.LinkUpdate{RedirectCode: &code}
+	updated := model.Link{ShortURL: "abc123", OriginalURL: "https://example.com", LinkOptions: model.LinkOptions{RedirectCode: code}}
+	repo.EXPECT().UpdateLink(gomock.Any(), "user-1", "abc123", update).Return(updated, nil)
+
+	service := NewURLService(repo)
+	link, err := service.UpdateLink(context.Background(), "user-1", "abc123", update)
+	require.NoError(t, err)
+	assert.Equal(t, updated, link)
+}
+
+func TestUpdateLink_InvalidRedirectCode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	code := http.StatusNotFound
+
+	service := NewURLService(repo)
+	_, err := service.UpdateLink(context.Background(), "user-1", "abc123", model.LinkUpdate{RedirectCode: &code})
+
+	var validation *model.ValidationError
+	assert.ErrorAs(t, err, &validation)
+}
+
+func TestValidateLinkUpdate(t *testing.T) {
+	validURL := "https://example.com/new"
+	emptyURL := " "
+	code := http.StatusMovedPermanently
+	badCode := http.StatusOK
+	passthrough := true
+
+	tests := []struct {
+		name      string
+		update    model.LinkUpdate
+		wantField string
+	}{
+		{name: "новый URL", update: model.LinkUpdate{OriginalURL: &validURL}},
+		{name: "код и passthrough", update: model.LinkUpdate{RedirectCode: &code, QueryPassthrough: &passthrough}},
+		{name: "пустое изменение", update: model.LinkUpdate{}, wantField: "body"},
+		{name: "пустой URL", update: model.LinkUpdate{OriginalURL: &emptyURL}, wantField: "original_url"},
+		{name: "недопустимый код", update: model.LinkUpdate{RedirectCode: &badCode}, wantField: "redirect_code"},
+		{name: "только метки", update: model.LinkUpdate{Tags: &[]string{"docs"}}},
+		{name: "удаление меток", update: model.LinkUpdate{Tags: &[]string{}}},
+		{name: "пустая метка", update: model.LinkUpdate{Tags: &[]string{" "}}, wantField: "tags"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLinkUpdate(tt.update)
+			if tt.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var validation *model.ValidationError
+			require.ErrorAs(t, err, &validation)
+			assert.Equal(t, tt.wantField, validation.Field)
+		})
+	}
+}
+
+func TestUpdateLinkAudited_PublishesAfterUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	newURL := "https://example.com/new"
+	update := model.LinkUpdate{OriginalURL: &newURL}
+	repo.EXPECT().UpdateLink(gomock.Any(), "user-1", "abc123", update).
+		Return(model.Link{ShortURL: "abc123", OriginalURL: newURL, Version: 2}, nil)
+
+	pub := audit.NewPublisher()
+	obs := &recordingObserver{}
+	pub.Subscribe(obs)
+
+	service := NewURLService(repo)
+	link, err := service.UpdateLinkAudited(context.Background(), "user-1", "abc123", update, audit.NewEvent(audit.ActionUpdate, "user-1", ""), pub)
+	require.NoError(t, err)
+	require.NoError(t, pub.Close())
+
+	assert.Equal(t, 2, link.Version)
+	require.Len(t, obs.events, 1)
+	assert.Equal(t, newURL, obs.events[0].URL)
+	assert.Equal(t, "abc123", obs.events[0].ShortCode)
+}
+
+func TestUpdateLinkAudited_UsesOutbox(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := &outboxRepo{MockURLRepository: mocks.NewMockURLRepository(ctrl)}
+	newURL := "https://example.com/new"
+
+	pub := audit.NewPublisher()
+	obs := &recordingObserver{}
+	pub.Subscribe(obs)
+
+	service := NewURLService(repo)
+	_, err := service.UpdateLinkAudited(context.Background(), "user-1", "abc123", model.LinkUpdate{OriginalURL: &newURL}, audit.NewEvent(audit.ActionUpdate, "user-1", ""), pub)
+	require.NoError(t, err)
+	require.NoError(t, pub.Close())
+
+	// Событие уходит в outbox, а не напрямую в Publisher
+	assert.Empty(t, obs.events)
+	require.Len(t, repo.stored, 1)
+	assert.Equal(t, "abc123", repo.stored[0].ShortCode)
+	assert.Equal(t, newURL, repo.stored[0].URL)
+}
+
+func TestGetLinkHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockURLRepository(ctrl)
+	versions := []model.LinkVersion{{Version: 1, OriginalURL: "https://example.com"}}
+	repo.EXPECT().GetLinkHistory(gomock.Any(), "user-1", "abc123").Return(versions, nil)
+
+	service := NewURLService(repo)
+	got, err := service.GetLinkHistory(context.Background(), "user-1", "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, versions, got)
+}
@@ -0,0 +1,173 @@
+// Package telemetry настраивает трассировку OpenTelemetry.
+//
+// Init устанавливает глобальный TracerProvider и пропагатор W3C Trace Context,
+// поэтому остальные пакеты открывают span'ы через Start без передачи
+// провайдера. Без Init или с выключенным экспортёром span'ы ничего не пишут,
+// но заголовок traceparent входящего запроса всё равно передаётся дальше.
+//
+// Экспортёры stdout и file пишут span'ы в JSON и не требуют коллектора,
+// поэтому трассировку можно проверить локально.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Экспортёры span'ов
+const (
+	ExporterNone   = "none"   // трассировка выключена
+	ExporterStdout = "stdout" // JSON в стандартный вывод
+	ExporterFile   = "file"   // JSON в файл, по строке на span
+)
+
+// DefaultServiceName имя сервиса в ресурсе span'ов по умолчанию
+const DefaultServiceName = "shortener"
+
+// instrumentation имя библиотеки инструментирования в span'ах
+const instrumentation = "github.com/Popolzen/shortener"
+
+// propagator передаёт контекст трассировки в заголовках traceparent,
+// tracestate и baggage. Не зависит от Init, чтобы трасса клиента
+// продолжалась и при выключенном экспортёре.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Options параметры трассировки
+type Options struct {
+	Exporter    string  // none, stdout или file, пусто — none
+	FilePath    string  // файл для экспортёра file
+	ServiceName string  // пусто — DefaultServiceName
+	SampleRatio float64 // доля корневых трасс от 0 до 1, 0 — все
+}
+
+// Init настраивает трассировку и возвращает функцию остановки, которая
+// отправляет накопленные span'ы и закрывает экспортёр. Повторный вызов
+// функции остановки ничего не делает.
+func Init(opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var (
+		w      io.Writer
+		closer io.Closer
+	)
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w = os.Stdout
+	case ExporterFile:
+		if opts.FilePath == "" {
+			return nil, errors.New("telemetry: для экспортёра file не задан путь")
+		}
+		f, err := os.OpenFile(opts.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("telemetry: %w", err)
+		}
+		w, closer = f, f
+	default:
+		return nil, fmt.Errorf("telemetry: неизвестный экспортёр %q, ожидается none, stdout или file", opts.Exporter)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("telemetry: %w", err)
+	}
+
+	provider := NewProvider(exporter, opts)
+	otel.SetTracerProvider(provider)
+
+	var (
+		once    sync.Once
+		stopErr error
+	)
+	return func(ctx context.Context) error {
+		once.Do(func() {
+			stopErr = provider.Shutdown(ctx)
+			if closer != nil {
+				stopErr = errors.Join(stopErr, closer.Close())
+			}
+		})
+		return stopErr
+	}, nil
+}
+
+// NewProvider создаёт провайдер с ресурсом сервиса и сэмплированием из opts.
+// Решение о сэмплировании дочерних span'ов берётся у родителя.
+func NewProvider(exporter sdktrace.SpanExporter, opts Options) *sdktrace.TracerProvider {
+	name := opts.ServiceName
+	if name == "" {
+		name = DefaultServiceName
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if opts.SampleRatio > 0 && opts.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(opts.SampleRatio)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(name))),
+	)
+}
+
+// Tracer возвращает трассировщик сервиса из глобального провайдера
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Start открывает span с именем name дочерним к span'у из ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// End записывает в span ошибку *errp, если она есть, и закрывает его.
+// Рассчитан на defer с именованным результатом:
+//
+//	ctx, span := telemetry.Start(ctx, "URLService.Shorten")
+//	defer telemetry.End(span, &err)
+func End(span trace.Span, errp *error) {
+	if errp != nil && *errp != nil {
+		span.RecordError(*errp)
+		span.SetStatus(codes.Error, (*errp).Error())
+	}
+	span.End()
+}
+
+// Inject записывает контекст трассировки ctx в заголовки исходящего запроса
+func Inject(ctx context.Context, header propagation.TextMapCarrier) {
+	propagator.Inject(ctx, header)
+}
+
+// Extract возвращает ctx с контекстом трассировки из заголовков входящего запроса
+func Extract(ctx context.Context, header propagation.TextMapCarrier) context.Context {
+	return propagator.Extract(ctx, header)
+}
+
+// TraceID возвращает идентификатор трассы из ctx, пустой — если трассы нет
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
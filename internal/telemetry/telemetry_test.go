@@ -0,0 +1,101 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInit(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "выключена по умолчанию", opts: Options{}},
+		{name: "none", opts: Options{Exporter: ExporterNone}},
+		{name: "stdout", opts: Options{Exporter: ExporterStdout}},
+		{name: "неизвестный экспортёр", opts: Options{Exporter: "jaeger"}, wantErr: true},
+		{name: "file без пути", opts: Options{Exporter: ExporterFile}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop, err := Init(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NoError(t, stop(context.Background()))
+		})
+	}
+}
+
+func TestInit_FileExporter(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	stop, err := Init(Options{Exporter: ExporterFile, FilePath: path, ServiceName: "test-service"})
+	require.NoError(t, err)
+
+	_, span := Start(context.Background(), "test-span")
+	span.End()
+	require.NoError(t, stop(context.Background()))
+	// Повторная остановка не закрывает файл второй раз
+	require.NoError(t, stop(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"test-span"`)
+	assert.Contains(t, string(data), "test-service")
+}
+
+func TestEnd(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+
+	_, ok := tracer.Start(context.Background(), "ok")
+	var noErr error
+	End(ok, &noErr)
+
+	_, failed := tracer.Start(context.Background(), "failed")
+	err := errors.New("db error")
+	End(failed, &err)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "db error", spans[1].Status().Description)
+}
+
+func TestInjectExtract(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34ca6a6c7e5f7d8e9f0a1-00f067aa0ba902b7-01"
+
+	header := http.Header{}
+	header.Set("traceparent", traceparent)
+	ctx := Extract(context.Background(), propagation.HeaderCarrier(header))
+	assert.Equal(t, "4bf92f3577b34ca6a6c7e5f7d8e9f0a1", TraceID(ctx))
+
+	out := http.Header{}
+	Inject(ctx, propagation.HeaderCarrier(out))
+	assert.Equal(t, traceparent, out.Get("traceparent"))
+
+	assert.Empty(t, TraceID(context.Background()))
+}
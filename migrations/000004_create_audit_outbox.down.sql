@@ -0,0 +1 @@
+DROP TABLE IF EXISTS audit_outbox;
\ No newline at end of file
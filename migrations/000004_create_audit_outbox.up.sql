@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS audit_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Индексы
+CREATE INDEX IF NOT EXISTS idx_audit_outbox_unsent ON audit_outbox(id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_audit_outbox_sent_at ON audit_outbox(sent_at);
@@ -0,0 +1,4 @@
+ALTER TABLE shortened_urls
+    DROP CONSTRAINT IF EXISTS chk_redirect_code,
+    DROP COLUMN IF EXISTS query_passthrough,
+    DROP COLUMN IF EXISTS redirect_code;
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- Настройки перенаправления по ссылке. redirect_code 0 — код по умолчанию сервиса.
+ALTER TABLE shortened_urls
+    ADD COLUMN IF NOT EXISTS redirect_code SMALLINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS query_passthrough BOOL NOT NULL DEFAULT FALSE;
+
+ALTER TABLE shortened_urls
+    ADD CONSTRAINT chk_redirect_code CHECK (redirect_code IN (0, 301, 302, 307, 308));
\ No newline at end of file
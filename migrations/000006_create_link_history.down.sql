@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS link_history;
+
+ALTER TABLE shortened_urls
+    DROP COLUMN IF EXISTS version;
\ No newline at end of file
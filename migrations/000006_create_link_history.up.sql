@@ -0,0 +1,22 @@
+-- Номер текущей версии ссылки, увеличивается при каждом изменении
+ALTER TABLE shortened_urls
+    ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+-- Все версии ссылки, включая текущую
+CREATE TABLE IF NOT EXISTS link_history (
+    id BIGSERIAL PRIMARY KEY,
+    short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+    version INT NOT NULL,
+    long_url TEXT NOT NULL,
+    redirect_code SMALLINT NOT NULL DEFAULT 0,
+    query_passthrough BOOL NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT uq_link_history_version UNIQUE (short_url, version)
+);
+
+-- Существующие ссылки получают первую версию с текущим состоянием
+INSERT INTO link_history (short_url, version, long_url, redirect_code, query_passthrough, created_at)
+SELECT short_url, 1, long_url, redirect_code, query_passthrough, COALESCE(created_at, NOW())
+FROM shortened_urls
+ON CONFLICT (short_url, version) DO NOTHING;
\ No newline at end of file
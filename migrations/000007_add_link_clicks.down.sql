@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_shortened_urls_user_clicks;
+DROP INDEX IF EXISTS idx_shortened_urls_user_created;
+
+ALTER TABLE shortened_urls
+    DROP COLUMN IF EXISTS clicks;
\ No newline at end of file
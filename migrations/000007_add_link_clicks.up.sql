@@ -0,0 +1,7 @@
+-- Число переходов по ссылке
+ALTER TABLE shortened_urls
+    ADD COLUMN IF NOT EXISTS clicks BIGINT NOT NULL DEFAULT 0;
+
+-- Индексы для постраничной выборки ссылок пользователя по курсору
+CREATE INDEX IF NOT EXISTS idx_shortened_urls_user_created ON shortened_urls(user_id, created_at, short_url);
+CREATE INDEX IF NOT EXISTS idx_shortened_urls_user_clicks ON shortened_urls(user_id, clicks, short_url);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS link_tags;
+
+ALTER TABLE shortened_urls
+    DROP COLUMN IF EXISTS note,
+    DROP COLUMN IF EXISTS title;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- Описание ссылки для владельца
+ALTER TABLE shortened_urls
+    ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS note TEXT NOT NULL DEFAULT '';
+
+-- Метки ссылок, в нижнем регистре
+CREATE TABLE IF NOT EXISTS link_tags (
+    short_url VARCHAR(20) NOT NULL REFERENCES shortened_urls(short_url) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL,
+
+    PRIMARY KEY (short_url, tag)
+);
+
+-- Индекс для фильтра ссылок по метке
+CREATE INDEX IF NOT EXISTS idx_link_tags_tag ON link_tags(tag, short_url);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE shortened_urls
+    DROP COLUMN IF EXISTS password_hash;
\ No newline at end of file
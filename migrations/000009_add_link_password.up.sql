@@ -0,0 +1,3 @@
+-- bcrypt-хеш пароля доступа к ссылке, пустая строка — ссылка открыта
+ALTER TABLE shortened_urls
+    ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
\ No newline at end of file
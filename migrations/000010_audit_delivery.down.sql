@@ -0,0 +1,4 @@
+ALTER TABLE audit_outbox
+    DROP COLUMN IF EXISTS claimed_until;
+
+DROP INDEX IF EXISTS uq_audit_events_event_id;
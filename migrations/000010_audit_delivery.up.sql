@@ -0,0 +1,11 @@
+-- Повторно доставленные события аудита отбрасываются по event_id.
+-- Дубликаты, записанные до ограничения, удаляются, остаётся первая строка.
+DELETE FROM audit_events a
+    USING audit_events b
+    WHERE a.event_id <> '' AND a.event_id = b.event_id AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_audit_events_event_id ON audit_events(event_id) WHERE event_id <> '';
+
+-- Срок, до которого строку outbox доставляет один из экземпляров relay
+ALTER TABLE audit_outbox
+    ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE audit_outbox
+    DROP COLUMN IF EXISTS delivered_to;
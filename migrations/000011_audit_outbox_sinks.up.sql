@@ -0,0 +1,4 @@
+-- Наблюдатели аудита, которым событие outbox уже доставлено.
+-- При повторе строки событие получают только остальные наблюдатели.
+ALTER TABLE audit_outbox
+    ADD COLUMN IF NOT EXISTS delivered_to TEXT[] NOT NULL DEFAULT '{}';